@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
-	"strings"
+	"syscall"
 	"time"
 
 	"github.com/regionless-storage-service/pkg/constants"
@@ -22,15 +24,28 @@ import (
 
 	"k8s.io/klog"
 
+	"github.com/regionless-storage-service/pkg/antientropy"
 	"github.com/regionless-storage-service/pkg/config"
 	ca "github.com/regionless-storage-service/pkg/consistent"
+	"github.com/regionless-storage-service/pkg/fsck"
 	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/membership"
+	"github.com/regionless-storage-service/pkg/monitor"
+	"github.com/regionless-storage-service/pkg/orphan"
 	"github.com/regionless-storage-service/pkg/partition/consistent"
 	"github.com/regionless-storage-service/pkg/piping"
+	"github.com/regionless-storage-service/pkg/placement"
+	"github.com/regionless-storage-service/pkg/replication"
 	"github.com/regionless-storage-service/pkg/revision"
 	"github.com/regionless-storage-service/pkg/tracer"
 )
 
+const (
+	// consistencyHeader and consistencyParam let a client choose the consistency level per request
+	consistencyHeader = "X-Rkv-Consistency"
+	consistencyParam  = "consistency"
+)
+
 func main() {
 	// For now, we use the current time as seed for each configuration. However, we might notice that
 	// it will give a deterministic sequence of pseudo-random numbers as the code shows according to
@@ -53,25 +68,57 @@ func main() {
 		panic(fmt.Errorf("error setting gateway agent configuration: %v", err))
 	}
 
-	// create all backend storages
+	// create all backend storages, each behind a circuit breaker and a bulkhead
+	database.EnableGuards(guardOptions(config.RKVConfig))
 	for _, store := range config.RKVConfig.Stores {
 		db, err := database.Factory(config.RKVConfig.StoreType, &store)
 		if err != nil {
 			klog.Warningf("storage creation fails with %s: %v", store.Name, err)
 			continue
 		}
-		database.Storages[store.Name] = db
+		database.RegisterDatabase(store.Name, database.Guard(config.RKVConfig.NodeName(store), db))
 	}
 
-	http.Handle("/kv", NewKeyValueHandler(config.RKVConfig))
-	klog.Fatal(http.ListenAndServe(*url, nil))
+	handler := NewKeyValueHandler(config.RKVConfig)
+	http.Handle("/kv", handler)
+	http.HandleFunc("/replication", handler.replicationStats)
+	http.HandleFunc("/latency", handler.latencyStats)
+	http.HandleFunc("/stores/health", handler.storeHealth)
+	http.HandleFunc("/stores", handler.stores)
+	http.HandleFunc("/stores/drain", handler.drainStore)
+	http.HandleFunc("/migrations", handler.migrations)
+	http.HandleFunc("/antientropy", handler.antiEntropyStatus)
+	http.HandleFunc("/orphans", handler.orphanCollection)
+	http.HandleFunc("/placement/audit", handler.auditResidency)
+	http.HandleFunc("/fsck", handler.fsck)
+
+	server := &http.Server{Addr: *url}
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			klog.Warningf("failed to shut down the http server: %v", err)
+		}
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		klog.Fatal(err)
+	}
+	handler.Close()
 }
 
 type KeyValueHandler struct {
-	hm        consistent.HashingManager
-	conf      *config.KVConfiguration
-	indexTree index.Index
-	piping    piping.Piping
+	hm          consistent.HashingManager
+	conf        *config.KVConfiguration
+	indexTree   index.Index
+	piping      piping.Piping
+	replication *replication.Manager
+	monitor     *monitor.LatencyMonitor
+	membership  *membership.Manager
+	antiEntropy *antientropy.Service
+	orphans     *orphan.Collector
 }
 
 func NewKeyValueHandler(conf *config.KVConfiguration) *KeyValueHandler {
@@ -79,30 +126,315 @@ func NewKeyValueHandler(conf *config.KVConfiguration) *KeyValueHandler {
 	if err != nil {
 		panic(fmt.Errorf("error in get replications: %v", err))
 	}
-	var hm consistent.HashingManager
-	var pp piping.Piping
-	switch conf.HashingManagerType {
-	case constants.Sync:
-		stores := make([]consistent.RkvNode, 0)
-		for _, localStore := range localStores {
-			stores = append(stores, localStore...)
-		}
-		hm = consistent.NewSyncHashingManager(conf.ConsistentHash, stores, conf.LocalReplicaNum)
-	case constants.SyncAsync:
-		hm = consistent.NewSyncAsyncHashingManager(conf.ConsistentHash, localStores, conf.LocalReplicaNum, remoteStores, conf.RemoteReplicaNum)
-	default:
-		hm = consistent.NewSyncAsyncHashingManager(conf.ConsistentHash, localStores, conf.LocalReplicaNum, remoteStores, conf.RemoteReplicaNum)
+	defaultConsistency, err := conf.ResolveConsistency("")
+	if err != nil {
+		panic(fmt.Errorf("error in consistency configuration: %v", err))
+	}
+	ack := piping.AckPolicy{Policy: conf.WriteAckPolicy, Count: conf.WriteAckCount}
+	if err := ack.Validate(); err != nil {
+		panic(fmt.Errorf("error in write ack configuration: %v", err))
+	}
+	if err := conf.ValidatePlacementPolicy(); err != nil {
+		panic(err)
 	}
+	if err := placement.ValidateMode(conf); err != nil {
+		panic(err)
+	}
+	if err := conf.ValidateErasureCoding(); err != nil {
+		panic(err)
+	}
+	if err := conf.ValidateReadHedging(); err != nil {
+		panic(err)
+	}
+	hm := placement.NewHashingManager(conf, localStores, remoteStores)
+	var pp piping.Piping
+	var rm *replication.Manager
 	switch conf.PipingType {
 	case constants.Chain:
-		pp = piping.NewChainPiping(conf.StoreType, ca.LINEARIZABLE, conf.Concurrent)
+		rm = newReplicationManager(conf)
+		pp = piping.NewChainPipingWithReplicator(conf.StoreType, defaultConsistency, conf.Concurrent, rm, ack)
+	case constants.ErasureCoded:
+		ep, err := piping.NewErasurePiping(conf.StoreType, conf.ErasureDataShards, conf.ErasureParityShards, ack)
+		if err != nil {
+			panic(fmt.Errorf("error in erasure coding configuration: %v", err))
+		}
+		pp = ep
 	case constants.LocalSyncRemoteAsync:
-		pp = piping.NewSyncAsyncPiping(conf.StoreType)
+		rm = newReplicationManager(conf)
+		sap := piping.NewSyncAsyncPipingWithReplicator(conf.StoreType, defaultConsistency, rm, ack)
+		sap.SetReadHedgePercentile(conf.ReadHedgePercentile)
+		pp = sap
+	default:
+		rm = newReplicationManager(conf)
+		sap := piping.NewSyncAsyncPipingWithReplicator(conf.StoreType, defaultConsistency, rm, ack)
+		sap.SetReadHedgePercentile(conf.ReadHedgePercentile)
+		pp = sap
+	}
+
+	lm := monitor.NewLatencyMonitor(conf, hm, localStores, remoteStores, time.Duration(conf.LatencyProbeIntervalInSec)*time.Second, conf.LatencyProbeWindow)
+	lm.Start()
+
+	indexTree := index.NewTreeIndex()
+	mm := membership.NewManager(conf, hm, lm, indexTree, func(name string) (database.Database, error) {
+		return database.FactoryWithNameAndLatency(conf.StoreType, name, 0)
+	})
+	// the writes still queued for a removed store are dropped, its revisions having moved to the other stores
+	if rm != nil {
+		mm.OnRemove(rm.Remove)
+	}
+	// a store failing the requests is left out of the placement until its circuit closes again
+	database.OnCircuitChange(func(name string, state database.CircuitState) {
+		lm.SetCircuitOpen(name, state != database.CircuitClosed)
+	})
+	// the revisions written around a store while it was unhealthy go back to it once it recovers; the shards of
+	// the erasure-coded revisions stay where they were written, as the moves copy whole values
+	if conf.PipingType != constants.ErasureCoded {
+		lm.OnRecovery(func(name string) error {
+			_, err := mm.HandOff(name)
+			return err
+		})
+	}
+
+	ae := antientropy.NewService(indexTree, func(name string) (database.Database, error) {
+		return database.FactoryWithNameAndLatency(conf.StoreType, name, 0)
+	}, antientropy.Options{
+		OpsPerSecond: conf.AntiEntropyOpsPerSecond,
+		Interval:     time.Duration(conf.AntiEntropyIntervalInSec) * time.Second,
+	})
+	if conf.PipingType != constants.ErasureCoded {
+		ae.Start()
+	}
+
+	oc := orphan.NewCollector(indexTree, func() []string {
+		names := make([]string, 0)
+		for _, store := range conf.StoreList() {
+			names = append(names, conf.NodeName(store))
+		}
+		return names
+	}, func(name string) (database.Database, error) {
+		return database.FactoryWithNameAndLatency(conf.StoreType, name, 0)
+	}, orphan.Options{
+		GracePeriod: time.Duration(conf.OrphanGracePeriodInSec) * time.Second,
+		Interval:    time.Duration(conf.OrphanCollectionIntervalInSec) * time.Second,
+	})
+	oc.Start()
+
+	return &KeyValueHandler{hm: hm, conf: conf, indexTree: indexTree, piping: pp, replication: rm, monitor: lm, membership: mm, antiEntropy: ae, orphans: oc}
+}
+
+func guardOptions(conf *config.KVConfiguration) database.GuardOptions {
+	return database.GuardOptions{
+		FailureThreshold:   conf.CircuitBreakerFailures,
+		ErrorRateThreshold: conf.CircuitBreakerErrorRate,
+		OpenTimeout:        time.Duration(conf.CircuitBreakerOpenTimeoutInMilliSec) * time.Millisecond,
+		MaxInFlight:        conf.MaxInFlightPerStore,
+	}
+}
+
+func newReplicationManager(conf *config.KVConfiguration) *replication.Manager {
+	opts := replication.Options{
+		Dir:            conf.ReplicationQueueDir,
+		MaxPending:     conf.ReplicationQueueMaxPending,
+		InitialBackoff: time.Duration(conf.ReplicationRetryBackoffInMilliSec) * time.Millisecond,
+		MaxBackoff:     time.Duration(conf.ReplicationMaxBackoffInMilliSec) * time.Millisecond,
+	}
+	rm, err := replication.NewManager(opts, func(name string) (database.Database, error) {
+		return database.FactoryWithNameAndLatency(conf.StoreType, name, 0)
+	})
+	if err != nil {
+		panic(fmt.Errorf("error in creating replication queues: %v", err))
+	}
+	return rm
+}
+
+// Close stops the background replication, keeping the pending entries for the next start, and the latency probes
+func (handler *KeyValueHandler) Close() {
+	if handler.monitor != nil {
+		handler.monitor.Stop()
+	}
+	if handler.antiEntropy != nil {
+		handler.antiEntropy.Stop()
+	}
+	if handler.orphans != nil {
+		handler.orphans.Stop()
+	}
+	if handler.replication != nil {
+		if err := handler.replication.Close(); err != nil {
+			klog.Warningf("failed to close the replication queues: %v", err)
+		}
+	}
+}
+
+// replicationStats reports the backlog and the lag of the async replication per remote store
+func (handler *KeyValueHandler) replicationStats(w http.ResponseWriter, r *http.Request) {
+	stats := make([]replication.Stats, 0)
+	if handler.replication != nil {
+		stats = handler.replication.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// latencyStats reports the measured latency of each store and whether it is currently treated as remote
+func (handler *KeyValueHandler) latencyStats(w http.ResponseWriter, r *http.Request) {
+	stats := make([]monitor.StoreStats, 0)
+	if handler.monitor != nil {
+		stats = handler.monitor.Snapshot()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// storeHealth reports the circuit state, error rate and latency of the requests to each store
+func (handler *KeyValueHandler) storeHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, database.Guards())
+}
+
+// stores lists the stores on GET, adds the store in the body on POST and removes the store named by the query
+// string on DELETE; the revisions affected by a change are moved in the background
+func (handler *KeyValueHandler) stores(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && !handler.replicated(w) {
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, handler.membership.Stores())
+	case "POST":
+		var store config.KVStore
+		if err := json.NewDecoder(r.Body).Decode(&store); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		migration, err := handler.membership.AddStore(store)
+		writeMigration(w, migration, err)
+	case "DELETE":
+		migration, err := handler.membership.RemoveStore(r.URL.Query().Get("name"))
+		writeMigration(w, migration, err)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// drainStore stops placing new revisions on the store named by the query string and moves its revisions away
+func (handler *KeyValueHandler) drainStore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !handler.replicated(w) {
+		return
+	}
+	migration, err := handler.membership.DrainStore(r.URL.Query().Get("name"))
+	writeMigration(w, migration, err)
+}
+
+// migrations reports the progress of the store changes
+func (handler *KeyValueHandler) migrations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, handler.membership.Migrations())
+}
+
+// antiEntropyStatus reports the progress of the anti-entropy on GET and starts a round on POST
+func (handler *KeyValueHandler) antiEntropyStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, handler.antiEntropy.Status())
+	case "POST":
+		if !handler.replicated(w) {
+			return
+		}
+		if err := handler.antiEntropy.Trigger(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, handler.antiEntropy.Status())
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// orphanCollection reports the progress of the orphan collection on GET and starts a round on POST
+func (handler *KeyValueHandler) orphanCollection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, handler.orphans.Status())
+	case "POST":
+		if err := handler.orphans.Trigger(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, handler.orphans.Status())
 	default:
-		pp = piping.NewSyncAsyncPiping(conf.StoreType)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 	}
+}
 
-	return &KeyValueHandler{hm: hm, conf: conf, indexTree: index.NewTreeIndex(), piping: pp}
+// auditResidency checks that every revision is stored in the regions its key may be stored in
+func (handler *KeyValueHandler) auditResidency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, placement.AuditResidency(r.Context(), handler.conf, handler.indexTree))
+}
+
+// fsck checks the replicas of every revision on GET, and repairs them from the healthy ones on POST
+func (handler *KeyValueHandler) fsck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !handler.replicated(w) {
+		return
+	}
+	opts := fsck.Options{Repair: r.Method == "POST"}
+	if r.URL.Query().Get("orphans") == "true" {
+		for _, store := range handler.conf.StoreList() {
+			opts.Stores = append(opts.Stores, handler.conf.NodeName(store))
+		}
+	}
+	report, err := fsck.Check(r.Context(), handler.indexTree, func(name string) (database.Database, error) {
+		return database.FactoryWithNameAndLatency(handler.conf.StoreType, name, 0)
+	}, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// replicated fails the request with 501 unless the revisions are stored as full replicas, as the moves, the
+// anti-entropy and fsck compare and copy whole values, which the shards of the erasureCoded piping are not
+func (handler *KeyValueHandler) replicated(w http.ResponseWriter) bool {
+	if handler.conf.PipingType == constants.ErasureCoded {
+		http.Error(w, fmt.Sprintf("not supported with the %s piping", constants.ErasureCoded), http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+func writeMigration(w http.ResponseWriter, migration membership.Migration, err error) {
+	switch {
+	case errors.Is(err, membership.ErrStoreNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, membership.ErrMigrationInProgress):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeJSON(w, http.StatusAccepted, migration)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		klog.Warningf("failed to write the response: %v", err)
+	}
 }
 
 func (handler *KeyValueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -112,7 +444,13 @@ func (handler *KeyValueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	}
 	var result string
 	var statusCode int
-	var err error
+
+	ctx, err := handler.withRequestConsistency(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r = r.WithContext(ctx)
 
 	switch r.Method {
 	case "GET":
@@ -132,8 +470,7 @@ func (handler *KeyValueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		statusCode = http.StatusNotImplemented
 	}
 	if err != nil {
-		internalError := http.StatusInternalServerError
-		http.Error(w, err.Error(), internalError)
+		http.Error(w, err.Error(), statusOf(err))
 	} else if result != "" {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(statusCode)
@@ -141,6 +478,35 @@ func (handler *KeyValueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// statusOf tells a missing key or revision, unavailable replicas and writes the placement policy rejects apart
+// from other failures
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, index.ErrRevisionNotFound), database.IsNotFound(err):
+		return http.StatusNotFound
+	case errors.Is(err, piping.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, consistent.ErrPolicyUnsatisfied):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// withRequestConsistency attaches the consistency level asked by the request header or query string to the
+// request context, falling back to the configured default and raising it to the configured floor
+func (handler *KeyValueHandler) withRequestConsistency(r *http.Request) (context.Context, error) {
+	requested := r.Header.Get(consistencyHeader)
+	if len(requested) == 0 {
+		requested = r.URL.Query().Get(consistencyParam)
+	}
+	level, err := handler.conf.ResolveConsistency(requested)
+	if err != nil {
+		return nil, err
+	}
+	return ca.WithConsistency(r.Context(), level), nil
+}
+
 func (handler *KeyValueHandler) getKV(w http.ResponseWriter, r *http.Request) (string, error) {
 	// tracing getkv op
 	ctx, span := otel.Tracer(config.TraceName).Start(r.Context(), "getKV")
@@ -160,17 +526,13 @@ func (handler *KeyValueHandler) getKV(w http.ResponseWriter, r *http.Request) (s
 			{
 				_, span := otel.Tracer(config.TraceName).Start(ctx, "get kv", trace.WithSpanKind(trace.SpanKindClient))
 				defer span.End()
-				rets, err := handler.getValuesByRevs(ctx, revs)
+				events, err := handler.getEventsByRevs(ctx, revs)
 				if err != nil {
 					span.RecordError(err)
 					span.SetStatus(codes.Error, err.Error())
 					return "", err
 				}
-				m := make(map[string]string, 0)
-				for i := 0; i < len(revs); i++ {
-					m[revs[i].String()] = rets[i]
-				}
-				return fmt.Sprintf("The values are %s from the revision %d\n", fmt.Sprint(m), fromRev), nil
+				return fmt.Sprintf("The events are %s from the revision %d\n", fmt.Sprint(events), fromRev), nil
 			}
 		} else {
 			rev, _, _, err := handler.indexTree.Get(ctx, []byte(key[0]), 0)
@@ -210,21 +572,36 @@ func (handler *KeyValueHandler) getValueByRev(ctx context.Context, rev index.Rev
 	return ret, nil
 }
 
-func (handler *KeyValueHandler) getValuesByRevs(ctx context.Context, revs []index.Revision) ([]string, error) {
-	ctx, span := otel.Tracer(config.TraceName).Start(ctx, "getValuesByRevs")
+// event is a revision of a key as the history reads report it; a DELETE is a tombstone and has no value
+type event struct {
+	Type     string
+	Revision string
+	Value    string
+}
+
+const (
+	eventPut    = "PUT"
+	eventDelete = "DELETE"
+)
+
+func (handler *KeyValueHandler) getEventsByRevs(ctx context.Context, revs []index.Revision) ([]event, error) {
+	ctx, span := otel.Tracer(config.TraceName).Start(ctx, "getEventsByRevs")
 	defer span.End()
-	n := len(revs)
-	res := make([]string, n)
-	for i := 0; i < n; i++ {
-		if ret, err := handler.getValueByRev(ctx, revs[i]); err != nil {
+	events := make([]event, len(revs))
+	for i, rev := range revs {
+		if rev.IsTombstone() {
+			events[i] = event{Type: eventDelete, Revision: rev.String()}
+			continue
+		}
+		ret, err := handler.getValueByRev(ctx, rev)
+		if err != nil {
 			span.RecordError(err)
 			span.SetStatus(codes.Error, err.Error())
 			return nil, err
-		} else {
-			res[i] = string(ret)
 		}
+		events[i] = event{Type: eventPut, Revision: rev.String(), Value: ret}
 	}
-	return res, nil
+	return events, nil
 }
 
 func (handler *KeyValueHandler) createKV(w http.ResponseWriter, r *http.Request) (string, error) {
@@ -243,14 +620,6 @@ func (handler *KeyValueHandler) createKV(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	rev := revision.GetGlobalIncreasingRevision()
-	newRev := index.NewRevision(int64(rev), 0, nil)
-	primRev := handler.getPrimaryRevBytesWithBucket(newRev)
-	nodes, err := handler.hm.GetNodes(primRev)
-	if err != nil {
-		return "", err
-	}
-	newRev.SetNodes(nodes)
 	byteValue, err := ioutil.ReadAll(r.Body)
 
 	if err != nil {
@@ -268,6 +637,19 @@ func (handler *KeyValueHandler) createKV(w http.ResponseWriter, r *http.Request)
 		return "", err
 	}
 
+	// the key is placed within the regions its residency rule allows, or the write is rejected
+	rev := revision.GetGlobalIncreasingRevision()
+	newRev := index.NewRevision(handler.indexTree.Placements(), int64(rev), 0, consistent.Placement{})
+	placementKey := placement.Key(handler.conf, []byte(payload["key"]), newRev)
+	replicas, err := handler.hm.GetPlacementForKey([]byte(payload["key"]), placementKey)
+	if err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	newRev.SetPlacement(replicas)
+	newRev.SetChecksum(payload["value"])
+
 	{
 		_, span := otel.Tracer(config.TraceName).Start(ctx, "set kv", trace.WithSpanKind(trace.SpanKindClient))
 		defer span.End()
@@ -286,10 +668,15 @@ func (handler *KeyValueHandler) createKV(w http.ResponseWriter, r *http.Request)
 	}
 
 	if err != nil {
-		// todo: cleanup writes on nodes
+		// the revision is not indexed, so its value written on the nodes is taken back
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		if cleanupErr := handler.piping.Delete(ctx, newRev); cleanupErr != nil {
+			klog.Errorf("failed to clean up the unindexed revision %s at %v: %v", newRev.String(), newRev.GetPlacement(), cleanupErr)
+		}
 		return "", err
 	}
-	return fmt.Sprintf("The key value pair (%s,%s) has been saved as revision %s at %s\n", payload["key"], payload["value"], strconv.FormatUint(rev, 10), strings.Join(newRev.GetNodes(), ",")), err
+	return fmt.Sprintf("The key value pair (%s,%s) has been saved as revision %s at %s\n", payload["key"], payload["value"], strconv.FormatUint(rev, 10), newRev.GetPlacement()), err
 }
 
 func (handler *KeyValueHandler) deleteKV(w http.ResponseWriter, r *http.Request) (string, error) {
@@ -299,33 +686,16 @@ func (handler *KeyValueHandler) deleteKV(w http.ResponseWriter, r *http.Request)
 
 	key, ok := r.URL.Query()["key"]
 	if ok {
-		rev, _, _, err := handler.indexTree.Get(ctx, []byte(key[0]), 0)
-		if err != nil {
+		// the delete only writes a tombstone, the values of the earlier revisions are kept for the history reads;
+		// a key missing or deleted already has no generation to close and is not found
+		tombstone := index.NewRevision(handler.indexTree.Placements(), int64(revision.GetGlobalIncreasingRevision()), 0, consistent.Placement{})
+		if err := handler.indexTree.Tombstone(ctx, []byte(key[0]), tombstone); err != nil {
 			rootSpan.RecordError(err)
 			rootSpan.SetStatus(codes.Error, err.Error())
 			return "", err
 		}
 
-		{
-			_, span := otel.Tracer(config.TraceName).Start(ctx, "delete kv", trace.WithSpanKind(trace.SpanKindClient))
-			defer span.End()
-			err = handler.piping.Delete(ctx, rev)
-			if err != nil {
-				span.RecordError(err)
-				span.SetStatus(codes.Error, err.Error())
-			}
-		}
-
-		handler.indexTree.Tombstone(ctx, []byte(key[0]), index.NewRevision(int64(revision.GetGlobalIncreasingRevision()), rev.GetSub(), nil))
-
-		return fmt.Sprintf("The key %s has been removed at %s\n", key, rev.GetNodes()), err
+		return fmt.Sprintf("The key %s has been removed at the revision %s\n", key[0], tombstone.String()), nil
 	}
 	return "", fmt.Errorf("the key is missing at the query %v", r.URL.Query())
 }
-
-func (handler *KeyValueHandler) getPrimaryRevBytesWithBucket(rev index.Revision) []byte {
-	primaryRev := rev.GetMain() / handler.conf.BucketSize
-	primaryRevBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(primaryRevBytes, uint64(primaryRev))
-	return primaryRevBytes
-}
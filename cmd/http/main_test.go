@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+)
+
+func newTestHandler(t *testing.T) *KeyValueHandler {
+	conf := &config.KVConfiguration{
+		ConsistentHash:     constants.Rendezvous,
+		HashingManagerType: constants.SyncAsync,
+		PipingType:         constants.LocalSyncRemoteAsync,
+		BucketSize:         10,
+		LocalReplicaNum:    2,
+		StoreType:          constants.Memory,
+		Stores: []config.KVStore{
+			{Region: "us-west-1", AvailabilityZone: "us-west-1a", Name: "handler-store1", Host: "handler-store1"},
+			{Region: "us-west-1", AvailabilityZone: "us-west-1b", Name: "handler-store2", Host: "handler-store2"},
+			{Region: "us-west-1", AvailabilityZone: "us-west-1c", Name: "handler-store3", Host: "handler-store3"},
+		},
+		RemoteStoreLatencyThresholdInMilliSec: 100,
+	}
+	handler := NewKeyValueHandler(conf)
+	t.Cleanup(handler.Close)
+	return handler
+}
+
+func serve(t *testing.T, handler http.Handler, method, target, body string) string {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	res, _ := ioutil.ReadAll(w.Result().Body)
+	if w.Code >= http.StatusBadRequest {
+		t.Fatalf("%s %s failed with %d: %s", method, target, w.Code, res)
+	}
+	return string(res)
+}
+
+func TestHistoryAcrossDelete(t *testing.T) {
+	handler := newTestHandler(t)
+	serve(t, handler, "PUT", "/kv", `{"key":"history", "value":"v1"}`)
+	serve(t, handler, "PUT", "/kv", `{"key":"history", "value":"v2"}`)
+	serve(t, handler, "DELETE", "/kv?key=history", "")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/kv?key=history", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("the deleted key is expected not to be found, got %d", w.Code)
+	}
+
+	history := serve(t, handler, "GET", "/kv?key=history&fromRev=0", "")
+	if !regexp.MustCompile(`\[\{PUT \S+ v1\} \{PUT \S+ v2\} \{DELETE \S+ \}\]`).MatchString(history) {
+		t.Fatalf("expected the 2 PUT events with their values then a DELETE event, got %s", history)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/placement"
+)
+
+// runAudit asks a running rkv service to check its revisions against the residency rules, and fails if any
+// revision is stored outside the regions its key may be stored in
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	server := fs.String("server", "http://localhost:8090", "rkv service endpoint")
+	timeout := fs.Duration("timeout", time.Minute, "how long to wait for the audit")
+	asJSON := fs.Bool("json", false, "print the audit as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get(strings.TrimRight(*server, "/") + "/placement/audit")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the audit failed with %s", resp.Status)
+	}
+	var audit placement.Audit
+	if err := json.NewDecoder(resp.Body).Decode(&audit); err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(audit); err != nil {
+			return err
+		}
+	} else {
+		audit.Write(os.Stdout)
+	}
+	if len(audit.Violations) > 0 {
+		return fmt.Errorf("%d replicas violate the placement policy", len(audit.Violations))
+	}
+	return nil
+}
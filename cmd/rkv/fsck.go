@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/fsck"
+)
+
+// runFsck asks a running rkv service to check the replicas of every revision, and fails if any revision is
+// under-replicated, lost, unreadable or left on a store it is no longer placed on
+func runFsck(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	server := fs.String("server", "http://localhost:8090", "rkv service endpoint")
+	timeout := fs.Duration("timeout", 10*time.Minute, "how long to wait for the check")
+	repair := fs.Bool("repair", false, "copy the value of the healthy replicas to the missing and mismatched ones")
+	orphans := fs.Bool("orphans", false, "look for the values on the stores out of their placement as well")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	url := strings.TrimRight(*server, "/") + "/fsck"
+	if *orphans {
+		url += "?orphans=true"
+	}
+	method := http.MethodGet
+	if *repair {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the check failed with %s", resp.Status)
+	}
+	var report fsck.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		report.Write(os.Stdout)
+	}
+	if !report.Healthy() {
+		return fmt.Errorf("%d revisions under-replicated, %d lost, %d replicas unreadable, %d orphaned", report.UnderReplicated, report.Lost, report.Unreadable, report.Orphaned)
+	}
+	return nil
+}
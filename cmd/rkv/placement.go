@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/placement"
+)
+
+func runPlacement(args []string) error {
+	fs := flag.NewFlagSet("placement", flag.ContinueOnError)
+	configPath := fs.String("config", "cmd/http/config.json", "rkv configuration to simulate")
+	buckets := fs.Int("buckets", 1000000, "number of revision buckets to place")
+	addStore := fs.String("add-store", "", "store to add, as name,zone[,latencyInMs[,weight]], to report the placements moving to it")
+	removeStore := fs.String("remove-store", "", "name of the store to remove, to report the placements moving away from it")
+	hashing := fs.String("hashing", "", "consistent hashing to simulate instead of the configured one")
+	mode := fs.String("mode", "", "placement mode to simulate instead of the configured one: revision, key or prefix")
+	keys := fs.Int("keys", 1000, "number of keys whose history is simulated with -versions")
+	versions := fs.Int("versions", 0, "number of revisions written per key to report how spread the history of a key is")
+	measure := fs.Bool("measure", false, "measure the latency to the stores instead of using their ArtificialLatencyInMs")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	conf, err := config.LoadKVConfiguration(*configPath)
+	if err != nil {
+		return err
+	}
+	if *hashing != "" {
+		conf.ConsistentHash = constants.ConsistentHashingType(*hashing)
+	}
+	if *mode != "" {
+		conf.PlacementMode = constants.PlacementMode(*mode)
+	}
+	opts := placement.Options{Buckets: *buckets, Keys: *keys, Versions: *versions, RemoveStore: *removeStore}
+	if *addStore != "" {
+		store, err := parseStore(*addStore)
+		if err != nil {
+			return err
+		}
+		opts.AddStore = &store
+	}
+
+	// an unsatisfiable policy is still analyzed, its failures show which buckets it cannot place
+	if err := conf.ValidatePlacementPolicy(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	localStores, remoteStores := placement.SimulatedStores(conf)
+	if *measure {
+		if localStores, remoteStores, err = conf.GetReplications(); err != nil {
+			return err
+		}
+	}
+	report, err := placement.Analyze(conf, localStores, remoteStores, opts)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	report.Write(os.Stdout)
+	return nil
+}
+
+// parseStore reads name,zone[,latencyInMs[,weight]]
+func parseStore(s string) (config.KVStore, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 || len(parts) > 4 {
+		return config.KVStore{}, fmt.Errorf("the store %q is not name,zone[,latencyInMs[,weight]]", s)
+	}
+	store := config.KVStore{Name: parts[0], AvailabilityZone: constants.AvailabilityZone(parts[1])}
+	var err error
+	if len(parts) > 2 {
+		if store.ArtificialLatencyInMs, err = strconv.Atoi(parts[2]); err != nil {
+			return store, fmt.Errorf("invalid latency in %q: %v", s, err)
+		}
+	}
+	if len(parts) > 3 {
+		if store.Weight, err = strconv.Atoi(parts[3]); err != nil {
+			return store, fmt.Errorf("invalid weight in %q: %v", s, err)
+		}
+	}
+	return store, nil
+}
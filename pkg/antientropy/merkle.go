@@ -0,0 +1,88 @@
+package antientropy
+
+import (
+	"sort"
+
+	"github.com/cespare/xxhash"
+)
+
+// MerkleTree spreads a set of keys over 2^depth leaves by their hash. A node of the tree stands for the keys
+// of the leaves below it, and the stores hash the values they hold for those keys on their side, so that two
+// stores are compared with a digest per node and the leaves where they differ are found by descending only
+// into the differing subtrees.
+type MerkleTree struct {
+	depth int
+	// keys are sorted by leaf, then by name
+	keys []string
+	// bounds[i] is the index in keys of the first key of the leaf i, bounds[2^depth] the number of keys
+	bounds []int
+}
+
+func NewMerkleTree(depth int, keys []string) *MerkleTree {
+	if depth < 0 {
+		depth = 0
+	}
+	leaves := make([]int, len(keys))
+	sorted := make([]int, len(keys))
+	for i, key := range keys {
+		leaves[i], sorted[i] = Leaf(depth, key), i
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if leaves[a] != leaves[b] {
+			return leaves[a] < leaves[b]
+		}
+		return keys[a] < keys[b]
+	})
+	t := &MerkleTree{depth: depth, keys: make([]string, len(keys)), bounds: make([]int, 1<<uint(depth)+1)}
+	for i, k := range sorted {
+		t.keys[i] = keys[k]
+		t.bounds[leaves[k]+1]++
+	}
+	for leaf := 1; leaf < len(t.bounds); leaf++ {
+		t.bounds[leaf] += t.bounds[leaf-1]
+	}
+	return t
+}
+
+// Leaf is the leaf of the key in a tree of the given depth
+func Leaf(depth int, key string) int {
+	return int(xxhash.Sum64String(key) & (1<<uint(depth) - 1))
+}
+
+// Keys returns the keys of the node i of the level, level 0 being the leaves and level depth the root
+func (t *MerkleTree) Keys(level, i int) []string {
+	return t.keys[t.bounds[i<<uint(level)]:t.bounds[(i+1)<<uint(level)]]
+}
+
+// Diff returns the leaves where the stores differ, in order. differ tells whether the stores hold different
+// values for the keys of a node; it is not asked about the nodes without keys, nor about a node holding the
+// same keys as its differing parent.
+func (t *MerkleTree) Diff(differ func(keys []string) (bool, error)) ([]int, error) {
+	leaves := make([]int, 0)
+	var descend func(level, i int, parent int) error
+	descend = func(level, i int, parent int) error {
+		keys := t.Keys(level, i)
+		if len(keys) == 0 {
+			return nil
+		}
+		if len(keys) != parent {
+			differs, err := differ(keys)
+			if err != nil || !differs {
+				return err
+			}
+		}
+		if level == 0 {
+			leaves = append(leaves, i)
+			return nil
+		}
+		if err := descend(level-1, 2*i, len(keys)); err != nil {
+			return err
+		}
+		return descend(level-1, 2*i+1, len(keys))
+	}
+	if err := descend(t.depth, 0, -1); err != nil {
+		return nil, err
+	}
+	return leaves, nil
+}
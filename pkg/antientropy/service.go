@@ -0,0 +1,447 @@
+package antientropy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/index"
+	"k8s.io/klog"
+)
+
+var ErrRoundInProgress = errors.New("an anti-entropy round is in progress")
+
+// DefaultDepth gives the Merkle trees 1024 leaves
+const DefaultDepth = 10
+
+// Resolver returns the backend database of the named node
+type Resolver func(name string) (database.Database, error)
+
+type Options struct {
+	// Depth is the depth of the Merkle trees; DefaultDepth if 0
+	Depth int
+	// OpsPerSecond caps the reads and the writes to the stores; 0 means no limit
+	OpsPerSecond int
+	// Interval is the time between two rounds started by Start; 0 only runs the rounds asked for
+	Interval time.Duration
+}
+
+// Status reports the progress of the running round, or the outcome of the last one
+type Status struct {
+	Running    bool
+	Rounds     int
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Groups is the number of sets of replicas to compare, the revisions placed on the same stores making a group
+	Groups     int
+	GroupsDone int
+	Revisions  int
+	// DivergentLeaves is the number of leaves of the Merkle trees where the replicas of a group differ
+	DivergentLeaves int
+	// Repaired is the number of values written to the replicas missing them or holding another value
+	Repaired int
+	// Unrecoverable is the number of divergent revisions none of the replicas holds any longer
+	Unrecoverable int
+	Failed        int
+	LastError     string
+}
+
+// Service repairs the replicas which missed writes, e.g. the async ones. A round groups the revisions of the
+// index by the stores they are placed on, spreads the revisions of a group over a Merkle tree and compares
+// the digests the stores compute for the revisions of its nodes, from the root down to the leaves where they
+// differ. Replicas in sync cost a request each per group. The revisions of the divergent leaves are read from
+// all the replicas, and the value held by most of them is written to the others.
+type Service struct {
+	mu        sync.Mutex
+	indexTree index.Index
+	resolve   Resolver
+	opts      Options
+	limiter   *limiter
+	status    Status
+	// stop and done are guarded by mu, as Start and Stop may be called concurrently
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewService(indexTree index.Index, resolve Resolver, opts Options) *Service {
+	if opts.Depth <= 0 {
+		opts.Depth = DefaultDepth
+	}
+	return &Service{indexTree: indexTree, resolve: resolve, opts: opts, limiter: newLimiter(opts.OpsPerSecond)}
+}
+
+// Status reports the progress of the anti-entropy
+func (s *Service) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Start runs a round every interval until Stop is called
+func (s *Service) Start() {
+	if s.opts.Interval <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.stop, s.done = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.opts.Interval)
+		defer ticker.Stop()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := s.RunOnce(ctx); err != nil && err != ErrRoundInProgress {
+					klog.Warningf("the anti-entropy round failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop cancels the running round and waits for it
+func (s *Service) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Trigger starts a round in the background
+func (s *Service) Trigger() error {
+	if !s.begin() {
+		return ErrRoundInProgress
+	}
+	go func() {
+		s.finish(s.round(context.Background()))
+	}()
+	return nil
+}
+
+// RunOnce runs a round and returns its outcome
+func (s *Service) RunOnce(ctx context.Context) (Status, error) {
+	if !s.begin() {
+		return s.Status(), ErrRoundInProgress
+	}
+	err := s.round(ctx)
+	s.finish(err)
+	return s.Status(), err
+}
+
+func (s *Service) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.Running {
+		return false
+	}
+	s.status = Status{Running: true, Rounds: s.status.Rounds + 1, StartedAt: time.Now()}
+	return true
+}
+
+func (s *Service) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = false
+	s.status.FinishedAt = time.Now()
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	klog.Infof("anti-entropy round %d: %d revisions in %d groups checked, %d divergent leaves, %d values repaired, %d failures",
+		s.status.Rounds, s.status.Revisions, s.status.GroupsDone, s.status.DivergentLeaves, s.status.Repaired, s.status.Failed)
+}
+
+func (s *Service) update(f func(status *Status)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.status)
+}
+
+func (s *Service) fail(err error) {
+	klog.Warningf("anti-entropy: %v", err)
+	s.update(func(status *Status) {
+		status.Failed++
+		status.LastError = err.Error()
+	})
+}
+
+// revision is a revision of a key and the name of its value in the stores
+type revision struct {
+	key  []byte
+	rev  index.Revision
+	name string
+}
+
+// group is a set of replicas and the revisions placed on them
+type group struct {
+	// nodes are in the placement order of the first revision, so that the leader wins a tie
+	nodes     []string
+	revisions []revision
+}
+
+func groupKey(nodes []string) string {
+	sorted := append([]string(nil), nodes...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func (s *Service) round(ctx context.Context) error {
+	groups := make(map[string]*group)
+	s.indexTree.Walk(ctx, func(key []byte, rev index.Revision) bool {
+		nodes := rev.GetPlacement().Nodes()
+		// tombstones do not have a value, and a single replica has nothing to compare with
+		if len(nodes) < 2 {
+			return true
+		}
+		k := groupKey(nodes)
+		g, ok := groups[k]
+		if !ok {
+			g = &group{nodes: nodes}
+			groups[k] = g
+		}
+		g.revisions = append(g.revisions, revision{key: key, rev: rev, name: rev.String()})
+		return true
+	})
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s.update(func(status *Status) {
+		status.Groups = len(groups)
+	})
+
+	for _, k := range keys {
+		g := groups[k]
+		sort.Slice(g.revisions, func(i, j int) bool {
+			return g.revisions[i].name < g.revisions[j].name
+		})
+		if err := s.compare(ctx, g); err != nil {
+			return err
+		}
+		s.update(func(status *Status) {
+			status.GroupsDone++
+			status.Revisions += len(g.revisions)
+		})
+	}
+	return nil
+}
+
+// replica is a store of a group whose values could all be digested
+type replica struct {
+	name string
+	db   database.Database
+	// digests caches the digests of the values of a store unable to hash a range of keys, so that the
+	// descent of the tree reads each of them once
+	digests map[string]digest
+}
+
+type digest struct {
+	value string
+	err   error
+}
+
+// errNoQuorum stops the descent of a group once too few of its replicas can be digested
+var errNoQuorum = errors.New("no quorum of replicas")
+
+// compare descends the Merkle tree of the revisions of a group, asking the replicas for the digests of the
+// values they hold for the revisions of each node, and repairs the revisions of the leaves where they differ.
+// The replicas which cannot be read are left out until the next round, and the group waits for it as well
+// unless a quorum of its replicas is compared.
+func (s *Service) compare(ctx context.Context, g *group) error {
+	replicas := make([]*replica, 0, len(g.nodes))
+	for _, name := range g.nodes {
+		db, err := s.resolve(name)
+		if err != nil {
+			s.fail(fmt.Errorf("failed to resolve the store %s: %v", name, err))
+			continue
+		}
+		rep := &replica{name: name, db: db}
+		if _, ok := db.(database.RangeDigester); !ok {
+			rep.digests = make(map[string]digest)
+		}
+		replicas = append(replicas, rep)
+	}
+	quorum := func() bool {
+		return len(replicas) >= 2 && len(replicas) > len(g.nodes)/2
+	}
+	if !quorum() {
+		return nil
+	}
+
+	names := make([]string, len(g.revisions))
+	for i, r := range g.revisions {
+		names[i] = r.name
+	}
+	leaves, err := NewMerkleTree(s.opts.Depth, names).Diff(func(keys []string) (bool, error) {
+		digests := make(map[string]bool)
+		live := replicas[:0]
+		for _, rep := range replicas {
+			d, err := s.digest(ctx, rep, keys)
+			if err != nil {
+				if ctx.Err() != nil {
+					return false, ctx.Err()
+				}
+				s.fail(fmt.Errorf("failed to read the store %s: %v", rep.name, err))
+				continue
+			}
+			digests[d] = true
+			live = append(live, rep)
+		}
+		replicas = live
+		if !quorum() {
+			return false, errNoQuorum
+		}
+		return len(digests) > 1, nil
+	})
+	if err == errNoQuorum {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(leaves) == 0 {
+		return nil
+	}
+	s.update(func(status *Status) {
+		status.DivergentLeaves += len(leaves)
+	})
+	divergent := make(map[int]bool, len(leaves))
+	for _, leaf := range leaves {
+		divergent[leaf] = true
+	}
+	for _, r := range g.revisions {
+		if !divergent[Leaf(s.opts.Depth, r.name)] {
+			continue
+		}
+		if err := s.repair(ctx, r, replicas); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// digest asks the replica for the digest of the values of the keys, a single request if the store hashes them
+// on its side and a request per key not digested yet otherwise
+func (s *Service) digest(ctx context.Context, rep *replica, keys []string) (string, error) {
+	if rep.digests == nil {
+		if err := s.limiter.wait(ctx); err != nil {
+			return "", err
+		}
+		return database.KeysDigest(rep.db, keys)
+	}
+	for _, key := range keys {
+		if _, ok := rep.digests[key]; ok {
+			continue
+		}
+		if err := s.limiter.wait(ctx); err != nil {
+			return "", err
+		}
+		value, err := database.ValueDigest(rep.db, key)
+		if err != nil && !database.IsNotFound(err) {
+			return "", err
+		}
+		rep.digests[key] = digest{value: value, err: err}
+	}
+	return database.KeysDigest(cachedDigests{Database: rep.db, digests: rep.digests}, keys)
+}
+
+// cachedDigests serves the digests cached for a replica, to hash them as the stores do
+type cachedDigests struct {
+	database.Database
+	digests map[string]digest
+}
+
+func (c cachedDigests) Digest(key string) (string, error) {
+	d := c.digests[key]
+	return d.value, d.err
+}
+
+// repair writes the value most replicas hold to the replicas missing it or holding another one
+func (s *Service) repair(ctx context.Context, r revision, replicas []*replica) error {
+	// the revision may have been moved since the walk
+	current, _, _, err := s.indexTree.Get(ctx, r.key, r.rev.GetMain())
+	if err != nil || current.GetSub() != r.rev.GetSub() || groupKey(current.GetPlacement().Nodes()) != groupKey(r.rev.GetPlacement().Nodes()) {
+		return nil
+	}
+	values := make([]string, len(replicas))
+	held := make([]bool, len(replicas))
+	readable := make([]bool, len(replicas))
+	counts := make(map[string]int)
+	for i, rep := range replicas {
+		value, err := s.get(ctx, rep.db, r.name)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		switch {
+		case err == nil:
+			values[i], held[i], readable[i] = value, true, true
+			counts[value]++
+		case database.IsNotFound(err):
+			readable[i] = true
+		default:
+			s.fail(fmt.Errorf("failed to read the revision %s from %s: %v", r.name, rep.name, err))
+		}
+	}
+	best, bestCount := "", 0
+	for i := range replicas {
+		if held[i] && counts[values[i]] > bestCount {
+			best, bestCount = values[i], counts[values[i]]
+		}
+	}
+	if bestCount == 0 {
+		klog.Warningf("anti-entropy: no replica of %v holds the revision %s of the key %s", r.rev.GetPlacement(), r.name, string(r.key))
+		s.update(func(status *Status) {
+			status.Unrecoverable++
+		})
+		return nil
+	}
+	for i, rep := range replicas {
+		if !readable[i] || (held[i] && values[i] == best) {
+			continue
+		}
+		if err := s.limiter.wait(ctx); err != nil {
+			return err
+		}
+		if _, err := rep.db.Put(r.name, best); err != nil {
+			s.fail(fmt.Errorf("failed to repair the revision %s on %s: %v", r.name, rep.name, err))
+			continue
+		}
+		s.update(func(status *Status) {
+			status.Repaired++
+		})
+	}
+	return nil
+}
+
+func (s *Service) get(ctx context.Context, db database.Database, name string) (string, error) {
+	if err := s.limiter.wait(ctx); err != nil {
+		return "", err
+	}
+	return db.Get(name)
+}
@@ -6,8 +6,10 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"sync"
 	"time"
 
+	ca "github.com/regionless-storage-service/pkg/consistent"
 	"github.com/regionless-storage-service/pkg/constants"
 	"github.com/regionless-storage-service/pkg/network/latency"
 	"github.com/regionless-storage-service/pkg/partition/consistent"
@@ -37,6 +39,73 @@ type KVConfiguration struct {
 	RemoteStoreLatencyThresholdInMilliSec int64
 	LocalReplicaNum                       int
 	RemoteReplicaNum                      int
+	// VirtualNodes is the number of ring tokens of a store per unit of weight; 100 if 0
+	VirtualNodes int
+	// DefaultConsistency applies to requests not asking for a specific level; LINEARIZABLE if empty
+	DefaultConsistency ca.CONSISTENCY
+	// MinConsistency is the server-side floor; weaker requested levels are raised to it
+	MinConsistency ca.CONSISTENCY
+	// ReplicationQueueDir keeps the durable queues of the async replication; they are memory only if empty
+	ReplicationQueueDir string
+	// ReplicationQueueMaxPending bounds the backlog of each remote store before writes are rejected; 0 means no limit
+	ReplicationQueueMaxPending        int
+	ReplicationRetryBackoffInMilliSec int64
+	ReplicationMaxBackoffInMilliSec   int64
+	// WriteAckPolicy is all, majority or firstN of the replicas written synchronously; all if empty
+	WriteAckPolicy constants.AckPolicy
+	// WriteAckCount is the number of acknowledgements required by the firstN policy
+	WriteAckCount int
+	// ErasureDataShards and ErasureParityShards are the k data and m parity shards of the erasureCoded piping,
+	// which places a revision on k+m stores, the replicas of the hashing manager
+	ErasureDataShards   int
+	ErasureParityShards int
+	// ReadHedgePercentile makes a read also ask the next replica once it takes longer than this percentile
+	// of the recent reads; 0 disables hedged reads
+	ReadHedgePercentile float64
+	// LatencyProbeIntervalInSec is how often the latency of the stores is measured again to reclassify them
+	// as local or remote; 0 keeps the classification done at startup
+	LatencyProbeIntervalInSec int64
+	// LatencyProbeWindow is the number of recent measurements averaged per store
+	LatencyProbeWindow int
+	// LatencyProbe is how the latency is measured: tcp, ping, getset or noop; if empty it is ping for redis,
+	// noop for mem and the artificial latency for dummy+latency
+	LatencyProbe constants.ProbeType
+	// UnhealthyAfterFailures is the number of consecutive failed probes after which a store is left out of the
+	// placement of the new revisions, the stores next to it standing in for it until it recovers; 3 if 0
+	UnhealthyAfterFailures int
+	// CircuitBreakerFailures is the number of consecutive failed requests to a store after which its circuit opens
+	// and its requests fail fast; 5 if 0
+	CircuitBreakerFailures int
+	// CircuitBreakerErrorRate opens the circuit of a store once this share of its last 20 requests failed; 0
+	// only counts the consecutive failures
+	CircuitBreakerErrorRate float64
+	// CircuitBreakerOpenTimeoutInMilliSec is how long a circuit stays open before a request probes the store; 5000
+	// if 0
+	CircuitBreakerOpenTimeoutInMilliSec int64
+	// MaxInFlightPerStore caps the concurrent requests to a store, the others being rejected; 0 means no limit
+	MaxInFlightPerStore int
+	// AntiEntropyIntervalInSec is how often the replicas are compared and repaired; 0 only does it on request
+	AntiEntropyIntervalInSec int64
+	// AntiEntropyOpsPerSecond caps the reads and writes of the anti-entropy to the stores; 0 means no limit
+	AntiEntropyOpsPerSecond int
+	// OrphanCollectionIntervalInSec is how often the stores are scanned for the values left unindexed by failed
+	// writes; 0 only does it on request
+	OrphanCollectionIntervalInSec int64
+	// OrphanGracePeriodInSec is how long a value stays unindexed before it is deleted; 600 if 0
+	OrphanGracePeriodInSec int64
+	// PlacementMode is revision, key or prefix: what the replicas of a revision are placed by; revision if empty
+	PlacementMode constants.PlacementMode
+	// PlacementPrefixSegments is the number of segments of the keys, split by PlacementPrefixDelimiter, the
+	// prefix mode places the keys by; 1 and "/" if not set
+	PlacementPrefixSegments  int
+	PlacementPrefixDelimiter string
+	// Topology declares the regions and zones of the stores; the AWS us-east and us-west ones if not set
+	Topology *Topology
+	// PlacementPolicy constrains the regions and zones of the replicas of every key; it is checked at startup
+	PlacementPolicy consistent.PlacementPolicy
+
+	// storesMu guards Stores once stores are added or removed at runtime
+	storesMu sync.RWMutex
 }
 
 type KVStore struct {
@@ -46,6 +115,16 @@ type KVStore struct {
 	Host                  string
 	Port                  int
 	ArtificialLatencyInMs int
+	// Weight is the share of the keys the store gets relative to the other stores, e.g. by capacity; 0 counts as 1
+	Weight int
+}
+
+// GetRegion returns the region of the store, the one of its zone if not set
+func (s KVStore) GetRegion() constants.Region {
+	if s.Region != "" {
+		return s.Region
+	}
+	return s.AvailabilityZone.Region()
 }
 
 func NewKVConfiguration(fileName string) (*KVConfiguration, error) {
@@ -55,6 +134,12 @@ func NewKVConfiguration(fileName string) (*KVConfiguration, error) {
 		return configuration, fmt.Errorf("failed to open the given config file %s", fileName)
 	}
 	filepath := path.Join(path.Dir(runningfile), fileName)
+	return LoadKVConfiguration(filepath)
+}
+
+// LoadKVConfiguration reads the configuration at the given path, e.g. for the tools working on a deployment
+func LoadKVConfiguration(filepath string) (*KVConfiguration, error) {
+	configuration := &KVConfiguration{}
 	file, err := os.Open(filepath)
 	if err != nil {
 		return configuration, err
@@ -62,42 +147,258 @@ func NewKVConfiguration(fileName string) (*KVConfiguration, error) {
 	defer file.Close()
 	decoder := json.NewDecoder(file)
 
-	err = decoder.Decode(&configuration)
-	return configuration, err
+	if err = decoder.Decode(&configuration); err != nil {
+		return configuration, err
+	}
+	return configuration, configuration.ResolveTopology()
+}
+
+// ResolveConsistency returns the consistency level to serve a request with. An empty requested level falls back
+// to the configured default, and any level weaker than the configured floor is raised to the floor.
+func (c *KVConfiguration) ResolveConsistency(requested string) (ca.CONSISTENCY, error) {
+	level := c.DefaultConsistency
+	if len(level) == 0 {
+		level = ca.LINEARIZABLE
+	}
+	if len(requested) != 0 {
+		var err error
+		if level, err = ca.ParseConsistency(requested); err != nil {
+			return "", err
+		}
+	}
+	if !level.Valid() {
+		return "", fmt.Errorf("unsupported default consistency level %q", level)
+	}
+	if len(c.MinConsistency) != 0 {
+		if !c.MinConsistency.Valid() {
+			return "", fmt.Errorf("unsupported minimum consistency level %q", c.MinConsistency)
+		}
+		level = level.AtLeast(c.MinConsistency)
+	}
+	return level, nil
 }
 
 // Please verify that any new datastore type does not break the codes here. Please do run real datastores locally before check-in
 // returned items identifing backend stores by name, NOT by hostname:port - backend may be other than redis type
 func (c *KVConfiguration) GetReplications() (map[constants.AvailabilityZone][]consistent.RkvNode, []consistent.RkvNode, error) {
-	localStores := make(map[constants.AvailabilityZone][]consistent.RkvNode)
-	remoteStores := make([]consistent.RkvNode, 0)
+	latencies := make(map[string]time.Duration)
+	for _, store := range c.StoreList() {
+		storeLatency, err := c.MeasureLatency(store)
+		if err != nil {
+			return make(map[constants.AvailabilityZone][]consistent.RkvNode), make([]consistent.RkvNode, 0), err
+		}
+		latencies[c.NodeName(store)] = storeLatency
+	}
+	localStores, remoteStores := c.ClassifyStores(latencies)
+	fmt.Printf("The local stores are %v and the remote are %v", localStores, remoteStores)
+	return localStores, remoteStores, nil
+}
+
+// StoreList returns a copy of the stores, which may change at runtime
+func (c *KVConfiguration) StoreList() []KVStore {
+	c.storesMu.RLock()
+	defer c.storesMu.RUnlock()
+	return append([]KVStore(nil), c.Stores...)
+}
+
+// FindStore looks a store up by its name
+func (c *KVConfiguration) FindStore(name string) (KVStore, bool) {
+	c.storesMu.RLock()
+	defer c.storesMu.RUnlock()
 	for _, store := range c.Stores {
-		target := fmt.Sprintf("%s:%d", store.Host, store.Port)
-		storeLatency := int64(0)
+		if store.Name == name {
+			return store, true
+		}
+	}
+	return KVStore{}, false
+}
+
+// AddStore adds a store at runtime; its name and node name must not be taken yet, and its zone must be in the
+// topology
+func (c *KVConfiguration) AddStore(store KVStore) error {
+	if store.Name == "" {
+		return fmt.Errorf("the store name is missing")
+	}
+	store, err := c.ResolveStore(store)
+	if err != nil {
+		return err
+	}
+	c.storesMu.Lock()
+	defer c.storesMu.Unlock()
+	for _, existing := range c.Stores {
+		if existing.Name == store.Name || c.NodeName(existing) == c.NodeName(store) {
+			return fmt.Errorf("the store %s already exists", store.Name)
+		}
+	}
+	c.Stores = append(c.Stores, store)
+	return nil
+}
+
+// RemoveStore removes a store at runtime
+func (c *KVConfiguration) RemoveStore(name string) error {
+	c.storesMu.Lock()
+	defer c.storesMu.Unlock()
+	for i, store := range c.Stores {
+		if store.Name == name {
+			c.Stores = append(c.Stores[:i:i], c.Stores[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("the store %s does not exist", name)
+}
+
+// NodeName identifies a store in the hashing rings and in database.Storages
+func (c *KVConfiguration) NodeName(store KVStore) string {
+	return NodeNameOf(c.StoreType, store)
+}
+
+// NodeNameOf is the node name of a store of the given type
+func NodeNameOf(storeType constants.StoreType, store KVStore) string {
+	if storeType == constants.Redis {
+		return fmt.Sprintf("%s:%d", store.Host, store.Port)
+	}
+	return store.Name
+}
+
+// MeasureLatency returns the 90th percentile of the round trips to a store with the configured probe; unless
+// probed otherwise, the simulated stores report their artificial latency
+func (c *KVConfiguration) MeasureLatency(store KVStore) (time.Duration, error) {
+	probeType := c.LatencyProbe
+	if probeType == "" {
 		if c.StoreType == constants.DummyLatency {
-			storeLatency = int64(store.ArtificialLatencyInMs)
+			return time.Duration(store.ArtificialLatencyInMs) * time.Millisecond, nil
+		}
+		probeType = latency.DefaultProbeType(c.StoreType)
+	}
+	target := latency.Target{StoreType: c.StoreType, Name: store.Name, Host: store.Host, Port: store.Port}
+	probe, err := latency.NewProbe(probeType, target)
+	if err != nil {
+		return 0, err
+	}
+	latencyResult, err := latency.Measure(probe, 10)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latency from %s with the %s probe: %v", store.Name, probeType, err)
+	}
+	// the tail of the round trips decides, as the average hides the slow ones the writes wait for, and the
+	// latency is kept at the millisecond granularity the threshold is configured with
+	return time.Duration(latencyResult.Summary.Success.P90/1000000) * time.Millisecond, nil
+}
+
+// ClassifyStores splits the stores into the local ones, grouped by availability zone, and the remote ones by
+// comparing their latency, keyed by node name, against RemoteStoreLatencyThresholdInMilliSec
+func (c *KVConfiguration) ClassifyStores(latencies map[string]time.Duration) (map[constants.AvailabilityZone][]consistent.RkvNode, []consistent.RkvNode) {
+	localStores := make(map[constants.AvailabilityZone][]consistent.RkvNode)
+	remoteStores := make([]consistent.RkvNode, 0)
+	threshold := time.Duration(c.RemoteStoreLatencyThresholdInMilliSec) * time.Millisecond
+	for _, store := range c.StoreList() {
+		name := c.NodeName(store)
+		storeLatency, ok := latencies[name]
+		if !ok {
+			continue
+		}
+		if storeLatency < threshold {
+			localStores[store.AvailabilityZone] = append(localStores[store.AvailabilityZone], c.StoreNode(store, storeLatency, false))
 		} else {
-			latencyResult, err := latency.GetLatency(target, 10)
-			if err != nil {
-				return localStores, remoteStores, fmt.Errorf("failed to get latency from %s", target)
-			}
-			storeLatency = latencyResult.Summary.Success.Average / 1000000
+			remoteStores = append(remoteStores, c.StoreNode(store, storeLatency, true))
 		}
+	}
+	return localStores, remoteStores
+}
+
+// StoreNode is the node of the store in the hashing rings
+func (c *KVConfiguration) StoreNode(store KVStore, latency time.Duration, remote bool) consistent.RkvNode {
+	return consistent.RkvNode{Name: c.NodeName(store), Latency: latency, IsRemote: remote, Weight: store.Weight,
+		Region: store.GetRegion(), AvailabilityZone: store.AvailabilityZone}
+}
 
-		if c.StoreType != constants.Redis {
-			target = store.Name
+// ValidateErasureCoding checks that the hashing manager places the revisions of the erasureCoded piping on a
+// store per shard
+func (c *KVConfiguration) ValidateErasureCoding() error {
+	if c.PipingType != constants.ErasureCoded {
+		return nil
+	}
+	shards := c.ErasureDataShards + c.ErasureParityShards
+	if c.ErasureDataShards < 1 || c.ErasureParityShards < 1 || shards > 256 {
+		return fmt.Errorf("invalid erasure coding: %d data and %d parity shards, at least 1 of each and at most 256 in all", c.ErasureDataShards, c.ErasureParityShards)
+	}
+	replicas := c.LocalReplicaNum
+	if c.HashingManagerType != constants.Sync {
+		replicas += c.RemoteReplicaNum
+	}
+	if replicas != shards {
+		return fmt.Errorf("invalid erasure coding: the revisions are placed on %d stores instead of the %d of their shards", replicas, shards)
+	}
+	if stores := len(c.StoreList()); stores < shards {
+		return fmt.Errorf("invalid erasure coding: %d stores for %d shards", stores, shards)
+	}
+	return nil
+}
+
+// ValidateReadHedging checks that the hedging percentile of the reads is a percentile
+func (c *KVConfiguration) ValidateReadHedging() error {
+	if !(c.ReadHedgePercentile >= 0 && c.ReadHedgePercentile <= 100) {
+		return fmt.Errorf("invalid read hedging: the percentile %v is not between 0 and 100", c.ReadHedgePercentile)
+	}
+	return nil
+}
+
+// ValidatePlacementPolicy checks that the placement policy can be met by the replicas of a key and the stores
+func (c *KVConfiguration) ValidatePlacementPolicy() error {
+	policy := c.PlacementPolicy
+	replicas := c.LocalReplicaNum
+	if c.HashingManagerType != constants.Sync {
+		replicas += c.RemoteReplicaNum
+	}
+	if err := policy.Validate(replicas); err != nil {
+		return fmt.Errorf("invalid placement policy: %v", err)
+	}
+	topology := c.GetTopology()
+	named := append(append(append([]constants.Region(nil), policy.RequiredRegions...), policy.ForbiddenRegions...), policy.PreferredLeaderRegion)
+	for _, rule := range policy.Residency {
+		named = append(named, rule.Regions...)
+	}
+	for _, r := range named {
+		if r != "" && !topology.HasRegion(r) {
+			return fmt.Errorf("invalid placement policy: the region %s is not in the topology", r)
+		}
+	}
+	regions := make(map[constants.Region]bool)
+	zones := make(map[constants.AvailabilityZone]int)
+	for _, store := range c.StoreList() {
+		if policy.Allows(store.GetRegion()) {
+			regions[store.GetRegion()] = true
+			zones[store.AvailabilityZone]++
+		}
+	}
+	for _, r := range policy.RequiredRegions {
+		if !regions[r] {
+			return fmt.Errorf("invalid placement policy: no store is in the required region %s", r)
 		}
-		if storeLatency < c.RemoteStoreLatencyThresholdInMilliSec {
-			if _, found := localStores[store.AvailabilityZone]; !found {
-				locals := make([]consistent.RkvNode, 0)
-				localStores[store.AvailabilityZone] = locals
+	}
+	if len(regions) < policy.MinRegions {
+		return fmt.Errorf("invalid placement policy: the allowed stores span %d regions instead of at least %d", len(regions), policy.MinRegions)
+	}
+	for _, rule := range policy.Residency {
+		for _, r := range rule.Regions {
+			if !regions[r] {
+				return fmt.Errorf("invalid placement policy: no store is in the region %s of the residency rule of the prefix %q", r, rule.Prefix)
 			}
-			localStores[store.AvailabilityZone] = append(localStores[store.AvailabilityZone],
-				consistent.RkvNode{Name: target, Latency: time.Duration(storeLatency * int64(time.Millisecond)), IsRemote: false})
-		} else {
-			remoteStores = append(remoteStores, consistent.RkvNode{Name: target, Latency: time.Duration(storeLatency * int64(time.Millisecond)), IsRemote: true})
 		}
 	}
-	fmt.Printf("The local stores are %v and the remote are %v", localStores, remoteStores)
-	return localStores, remoteStores, nil
+	if policy.PreferredLeaderRegion != "" && !regions[policy.PreferredLeaderRegion] {
+		return fmt.Errorf("invalid placement policy: no store is in the preferred leader region %s", policy.PreferredLeaderRegion)
+	}
+	if policy.MaxReplicasPerZone > 0 {
+		capacity := 0
+		for _, n := range zones {
+			if n > policy.MaxReplicasPerZone {
+				n = policy.MaxReplicasPerZone
+			}
+			capacity += n
+		}
+		if capacity < replicas {
+			return fmt.Errorf("invalid placement policy: at most %d replicas fit with %d per zone, %d are needed", capacity, policy.MaxReplicasPerZone, replicas)
+		}
+	}
+	return nil
 }
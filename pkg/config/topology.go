@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/regionless-storage-service/pkg/constants"
+)
+
+// Topology declares the regions and zones the stores may be in, e.g. of a cloud provider or of on-premises
+// data centers
+type Topology struct {
+	Regions []RegionSpec
+	// Costs are the costs of moving data between two regions, in any unit as long as it is the same for all;
+	// a cost applies both ways. Nothing but Cost reads them yet: the placement does not weigh them, and the
+	// stores are classified as remote by their measured latency.
+	Costs []RegionCost
+}
+
+// RegionSpec is a region and its zones
+type RegionSpec struct {
+	Name     constants.Region
+	Provider string
+	Zones    []constants.AvailabilityZone
+	// Location is optional, and like the costs read by nothing but DistanceKm yet
+	Location *Coordinates
+}
+
+// Coordinates locate a region, in degrees
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+type RegionCost struct {
+	From constants.Region
+	To   constants.Region
+	Cost float64
+}
+
+// DefaultTopology is the topology of the configurations not declaring one: the AWS us-east and us-west
+// regions with the zones named in the constants package
+func DefaultTopology() *Topology {
+	aws := func(region constants.Region, zones ...constants.AvailabilityZone) RegionSpec {
+		return RegionSpec{Name: region, Provider: "aws", Zones: zones}
+	}
+	return &Topology{Regions: []RegionSpec{
+		aws(constants.US_EAST_1, constants.US_EAST_1A, constants.US_EAST_1B, constants.US_EAST_1C, constants.US_EAST_1D, constants.US_EAST_1E),
+		aws(constants.US_EAST_2, constants.US_EAST_2A, constants.US_EAST_2B, constants.US_EAST_2C),
+		aws(constants.US_WEST_1, constants.US_WEST_1A, constants.US_WEST_1B, constants.US_WEST_1C),
+		aws(constants.US_WEST_2, constants.US_WEST_2A, constants.US_WEST_2B, constants.US_WEST_2C),
+	}}
+}
+
+// Validate checks that the names of the regions and zones are unique and that the coordinates and the costs
+// refer to known regions with sensible values
+func (t *Topology) Validate() error {
+	if len(t.Regions) == 0 {
+		return fmt.Errorf("the topology has no region")
+	}
+	regions := make(map[constants.Region]bool, len(t.Regions))
+	zones := make(map[constants.AvailabilityZone]constants.Region)
+	for _, region := range t.Regions {
+		if region.Name == "" {
+			return fmt.Errorf("a region of the topology has no name")
+		}
+		if regions[region.Name] {
+			return fmt.Errorf("the region %s is declared twice", region.Name)
+		}
+		regions[region.Name] = true
+		if len(region.Zones) == 0 {
+			return fmt.Errorf("the region %s has no zone", region.Name)
+		}
+		for _, zone := range region.Zones {
+			if zone == "" {
+				return fmt.Errorf("a zone of the region %s has no name", region.Name)
+			}
+			if other, ok := zones[zone]; ok {
+				return fmt.Errorf("the zone %s is declared in both %s and %s", zone, other, region.Name)
+			}
+			zones[zone] = region.Name
+		}
+		if l := region.Location; l != nil && !(math.Abs(l.Latitude) <= 90 && math.Abs(l.Longitude) <= 180) {
+			return fmt.Errorf("the location (%v, %v) of the region %s is out of range", l.Latitude, l.Longitude, region.Name)
+		}
+	}
+	for _, c := range t.Costs {
+		if !regions[c.From] || !regions[c.To] {
+			return fmt.Errorf("the cost between %s and %s refers to an unknown region", c.From, c.To)
+		}
+		if math.IsNaN(c.Cost) {
+			return fmt.Errorf("the cost between %s and %s is not a number", c.From, c.To)
+		}
+		if c.Cost < 0 {
+			return fmt.Errorf("the cost between %s and %s is negative", c.From, c.To)
+		}
+	}
+	return nil
+}
+
+// HasRegion tells whether the region is declared
+func (t *Topology) HasRegion(region constants.Region) bool {
+	for _, r := range t.Regions {
+		if r.Name == region {
+			return true
+		}
+	}
+	return false
+}
+
+// RegionOf returns the region of the zone, or false if the zone is not declared
+func (t *Topology) RegionOf(zone constants.AvailabilityZone) (constants.Region, bool) {
+	for _, r := range t.Regions {
+		for _, z := range r.Zones {
+			if z == zone {
+				return r.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Cost returns the cost of moving data between two regions: 0 within a region, and false if it is not declared
+func (t *Topology) Cost(from, to constants.Region) (float64, bool) {
+	if from == to {
+		return 0, true
+	}
+	for _, c := range t.Costs {
+		if c.From == from && c.To == to || c.From == to && c.To == from {
+			return c.Cost, true
+		}
+	}
+	return 0, false
+}
+
+// DistanceKm returns the great-circle distance between two regions, or false if either has no location
+func (t *Topology) DistanceKm(from, to constants.Region) (float64, bool) {
+	var a, b *Coordinates
+	for _, r := range t.Regions {
+		if r.Name == from {
+			a = r.Location
+		}
+		if r.Name == to {
+			b = r.Location
+		}
+	}
+	if a == nil || b == nil {
+		return 0, false
+	}
+	const earthRadiusKm = 6371
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(b.Latitude-a.Latitude), rad(b.Longitude-a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(a.Latitude))*math.Cos(rad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h)), true
+}
+
+// GetTopology returns the declared topology, or the default one if none is declared
+func (c *KVConfiguration) GetTopology() *Topology {
+	if c.Topology == nil || len(c.Topology.Regions) == 0 {
+		return DefaultTopology()
+	}
+	return c.Topology
+}
+
+// ResolveTopology validates the topology and the zones of the stores, and sets the region of the stores which
+// do not name it to the one of their zone. A store in an unknown zone, or whose region is not the one of its
+// zone, is an error.
+func (c *KVConfiguration) ResolveTopology() error {
+	topology := c.GetTopology()
+	if err := topology.Validate(); err != nil {
+		return fmt.Errorf("invalid topology: %v", err)
+	}
+	c.storesMu.Lock()
+	defer c.storesMu.Unlock()
+	for i, store := range c.Stores {
+		resolved, err := resolveStore(topology, store)
+		if err != nil {
+			return err
+		}
+		c.Stores[i] = resolved
+	}
+	return nil
+}
+
+// ResolveStore checks the zone of a store against the topology and returns it with the region of its zone
+func (c *KVConfiguration) ResolveStore(store KVStore) (KVStore, error) {
+	return resolveStore(c.GetTopology(), store)
+}
+
+func resolveStore(topology *Topology, store KVStore) (KVStore, error) {
+	region, ok := topology.RegionOf(store.AvailabilityZone)
+	if !ok {
+		return store, fmt.Errorf("the zone %q of the store %s is not in the topology", store.AvailabilityZone, store.Name)
+	}
+	if store.Region != "" && store.Region != region {
+		return store, fmt.Errorf("the store %s is in the region %s but its zone %s is in %s", store.Name, store.Region, store.AvailabilityZone, region)
+	}
+	store.Region = region
+	return store, nil
+}
@@ -31,12 +31,16 @@ func NewChain(ctx context.Context, nodeType constants.StoreType, nodes []string)
 	dbs := make([]database.Database, n)
 	if nodeType == constants.Memory {
 		for i := 0; i < n; i++ {
-			dbs[i] = database.NewMemDatabase(nodes[i])
+			db, err := database.FactoryWithNameAndLatency(nodeType, nodes[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			dbs[i] = db
 		}
 		return NewChainWithDatbases(ctx, dbs), nil
 	}
 	for i := 0; i < n; i++ {
-		db, ok := database.Storages[nodes[i]]
+		db, ok := database.Lookup(nodes[i])
 		if ok {
 			dbs[i] = db
 		} else {
@@ -57,21 +61,23 @@ func NewChainWithDatbases(ctx context.Context, dbs []database.Database) *Chain {
 	return &Chain{head: dummy.next, tail: prev, len: len(dbs), ctx: ctx}
 }
 
-func (c *Chain) Write(key, val string, consistency consistent.CONSISTENCY) error {
+// Write puts the value on the nodes from the head, returning the position of the node it failed at, the nodes
+// before it holding the value. The position is the length of the chain if no node failed.
+func (c *Chain) Write(key, val string, consistency consistent.CONSISTENCY) (int, error) {
 	_, rootSpan := otel.Tracer(config.TraceName).Start(c.ctx, "db put")
 	defer rootSpan.End()
 	if _, err := c.head.db.Put(key, val); err != nil {
 		rootSpan.RecordError(err)
 		rootSpan.SetStatus(codes.Error, err.Error())
-		return err
+		return 0, err
 	}
 	//Waiting for error handling design part
 	if consistency == consistent.LINEARIZABLE {
-		return c.head.next.Write(c.ctx, key, val)
+		return c.head.next.write(c.ctx, key, val)
 	} else if consistency == consistent.SEQUENTIAL {
 		go c.head.next.Write(c.ctx, key, val)
 	}
-	return nil
+	return c.len, nil
 }
 
 func (c *Chain) Delete(key string, consistency consistent.CONSISTENCY) error {
@@ -20,16 +20,24 @@ func NewNode(id int, db database.Database) *ChainNode {
 }
 
 func (n *ChainNode) Write(ctx context.Context, key, val string) error {
+	_, err := n.write(ctx, key, val)
+	return err
+}
+
+// write puts the value on the node and the ones after it, returning the position of the node it failed at
+// or, if none failed, the position past the tail
+func (n *ChainNode) write(ctx context.Context, key, val string) (int, error) {
 	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "db put")
 	defer rootSpan.End()
-	_, err := n.db.Put(key, val)
-	if err == nil && n.next != nil {
-		return n.next.Write(ctx, key, val)
-	} else if err != nil {
+	if _, err := n.db.Put(key, val); err != nil {
 		rootSpan.RecordError(err)
 		rootSpan.SetStatus(codes.Error, err.Error())
+		return n.id, err
 	}
-	return err
+	if n.next != nil {
+		return n.next.write(ctx, key, val)
+	}
+	return n.id + 1, nil
 }
 
 func (n *ChainNode) Read(ctx context.Context, key string) (string, error) {
@@ -55,7 +63,7 @@ func (n *ChainNode) Delete(ctx context.Context, key string) error {
 		rootSpan.RecordError(err)
 		rootSpan.SetStatus(codes.Error, err.Error())
 	}
-	return nil
+	return err
 }
 
 func (n *ChainNode) GetID() int {
@@ -1,8 +1,66 @@
 package consistent
 
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
 type CONSISTENCY string
 
 const (
 	SEQUENTIAL   CONSISTENCY = "SEQUENTIAL"
 	LINEARIZABLE CONSISTENCY = "LINEARIZABLE"
 )
+
+// strength orders the consistency levels from the weakest to the strongest
+var strength = map[CONSISTENCY]int{
+	SEQUENTIAL:   1,
+	LINEARIZABLE: 2,
+}
+
+// ParseConsistency converts a case-insensitive level name, e.g. from a request header, into a CONSISTENCY
+func ParseConsistency(level string) (CONSISTENCY, error) {
+	c := CONSISTENCY(strings.ToUpper(strings.TrimSpace(level)))
+	if _, ok := strength[c]; !ok {
+		return "", fmt.Errorf("unsupported consistency level %q", level)
+	}
+	return c, nil
+}
+
+// Valid reports whether c is one of the supported consistency levels
+func (c CONSISTENCY) Valid() bool {
+	_, ok := strength[c]
+	return ok
+}
+
+// StrongerThan reports whether c gives stronger guarantees than other
+func (c CONSISTENCY) StrongerThan(other CONSISTENCY) bool {
+	return strength[c] > strength[other]
+}
+
+// AtLeast raises c to the given floor if c is weaker than it
+func (c CONSISTENCY) AtLeast(floor CONSISTENCY) CONSISTENCY {
+	if floor.StrongerThan(c) {
+		return floor
+	}
+	return c
+}
+
+type consistencyKey struct{}
+
+// WithConsistency returns a copy of ctx carrying the consistency level chosen for the request
+func WithConsistency(ctx context.Context, c CONSISTENCY) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, c)
+}
+
+// FromContext returns the consistency level carried by ctx, or fallback if there is none
+func FromContext(ctx context.Context, fallback CONSISTENCY) CONSISTENCY {
+	if ctx == nil {
+		return fallback
+	}
+	if c, ok := ctx.Value(consistencyKey{}).(CONSISTENCY); ok && c.Valid() {
+		return c
+	}
+	return fallback
+}
@@ -1,11 +1,20 @@
 package constants
 
+import "strings"
+
 type AvailabilityZone string
 
 func (az AvailabilityZone) Name() string {
 	return string(az)
 }
 
+// Region guesses the region of a zone named the AWS way, without the trailing zone letter, e.g. us-west-1 for
+// us-west-1b; the topology of the configuration tells the actual region of a zone
+func (az AvailabilityZone) Region() Region {
+	return Region(strings.TrimRight(string(az), "abcdefghijklmnopqrstuvwxyz"))
+}
+
+// the zones of the default topology
 const (
 	US_EAST_1A AvailabilityZone = "us-east-1a"
 	US_EAST_1B AvailabilityZone = "us-east-1b"
@@ -9,6 +9,8 @@ func (r ConsistentHashingType) Name() string {
 const (
 	Rendezvous ConsistentHashingType = "rendezvous"
 	Ring       ConsistentHashingType = "ring"
+	Jump       ConsistentHashingType = "jump"
+	Maglev     ConsistentHashingType = "maglev"
 )
 
 type HashingManagerType string
@@ -21,3 +23,19 @@ const (
 	SyncAsync HashingManagerType = "syncAsync"
 	Sync      HashingManagerType = "sync"
 )
+
+// PlacementMode decides what the replicas of a revision are placed by
+type PlacementMode string
+
+func (pm PlacementMode) Name() string {
+	return string(pm)
+}
+
+const (
+	// RevisionPlacement places a revision by its bucket of revision numbers, spreading the history of a key
+	RevisionPlacement PlacementMode = "revision"
+	// KeyPlacement places all the revisions of a key on the same replicas
+	KeyPlacement PlacementMode = "key"
+	// PrefixPlacement places all the revisions of the keys sharing their first segments on the same replicas
+	PrefixPlacement PlacementMode = "prefix"
+)
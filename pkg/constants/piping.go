@@ -9,4 +9,19 @@ func (p PipingType) Name() string {
 const (
 	Chain                PipingType = "chain"
 	LocalSyncRemoteAsync PipingType = "localSyncRemoteAsync"
+	// ErasureCoded stores Reed-Solomon shards of the values instead of full replicas
+	ErasureCoded PipingType = "erasureCoded"
+)
+
+// AckPolicy decides how many replicas written synchronously have to acknowledge a write before it succeeds
+type AckPolicy string
+
+func (a AckPolicy) Name() string {
+	return string(a)
+}
+
+const (
+	AckAll      AckPolicy = "all"
+	AckMajority AckPolicy = "majority"
+	AckFirstN   AckPolicy = "firstN"
 )
@@ -6,6 +6,7 @@ func (r Region) Name() string {
 	return string(r)
 }
 
+// the regions of the default topology
 const (
 	US_EAST_1      Region = "us-east-1"
 	US_EAST_2      Region = "us-east-2"
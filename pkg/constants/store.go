@@ -17,4 +17,20 @@ const (
 const (
 	RedisRetryCount    int           = 5
 	RedisRetryInterval time.Duration = 10 * time.Millisecond
+	// RedisScanCount is the number of keys a SCAN is hinted to return at a time
+	RedisScanCount int = 1000
+)
+
+// ProbeType is how the latency to a store is measured
+type ProbeType string
+
+const (
+	// ProbeTCP times the TCP connection to the store
+	ProbeTCP ProbeType = "tcp"
+	// ProbePing times a PING round trip, for the stores answering it like redis
+	ProbePing ProbeType = "ping"
+	// ProbeGetSet times writing and reading back a small probe key
+	ProbeGetSet ProbeType = "getset"
+	// ProbeNoop reports no latency, for the in-process stores
+	ProbeNoop ProbeType = "noop"
 )
@@ -1,7 +1,10 @@
 package database
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/regionless-storage-service/pkg/config"
@@ -9,10 +12,56 @@ import (
 )
 
 var (
-	// Storages keeps all backend storages indexed by name
-	Storages map[string]Database = make(map[string]Database)
+	// Storages keeps all backend storages indexed by name; it is guarded by storagesMu once the server is running
+	Storages   map[string]Database = make(map[string]Database)
+	storagesMu sync.RWMutex
 )
 
+// Lookup returns the backend storage of the given name
+func Lookup(name string) (Database, bool) {
+	storagesMu.RLock()
+	defer storagesMu.RUnlock()
+	db, ok := Storages[name]
+	return db, ok
+}
+
+// Register creates the backend storage of a store added at runtime
+func Register(databaseType constants.StoreType, store *config.KVStore) (Database, error) {
+	if databaseType == constants.Redis {
+		AddStorageInstancePool(*store)
+	}
+	db, err := Factory(databaseType, store)
+	if err != nil {
+		return nil, err
+	}
+	db = Guard(config.NodeNameOf(databaseType, *store), db)
+	storagesMu.Lock()
+	defer storagesMu.Unlock()
+	Storages[store.Name] = db
+	return db, nil
+}
+
+// RegisterDatabase makes an existing backend storage available under the given name
+func RegisterDatabase(name string, db Database) {
+	storagesMu.Lock()
+	defer storagesMu.Unlock()
+	Storages[name] = db
+}
+
+// Unregister closes and forgets the backend storage of a removed store
+func Unregister(name string) error {
+	storagesMu.Lock()
+	db, ok := Storages[name]
+	delete(Storages, name)
+	storagesMu.Unlock()
+	forgetProbed(name)
+	if !ok {
+		return nil
+	}
+	unguard(db)
+	return db.Close()
+}
+
 type Database interface {
 	Put(key, value string) (string, error)
 	Get(key string) (string, error)
@@ -22,6 +71,75 @@ type Database interface {
 	SetLatency(latency time.Duration)
 }
 
+// Scanner is implemented by the databases able to list their keys
+type Scanner interface {
+	// Scan passes the keys to f until it returns false; the keys written or deleted meanwhile may be missed
+	Scan(f func(key string) bool) error
+}
+
+// Digester is implemented by the databases able to hash a value on the store, so that comparing the values of
+// the replicas does not transfer them
+type Digester interface {
+	// Digest returns the hex SHA-1 of the value of the key, or ErrKeyNotFound
+	Digest(key string) (string, error)
+}
+
+// ValueDigest returns the hex SHA-1 of the value of the key, hashed by the store if it is a Digester or read
+// and hashed here otherwise
+func ValueDigest(db Database, key string) (string, error) {
+	if digester, ok := db.(Digester); ok {
+		return digester.Digest(key)
+	}
+	value, err := db.Get(key)
+	if err != nil {
+		return "", err
+	}
+	return digestOf(value), nil
+}
+
+// RangeDigester is implemented by the databases able to hash the values of many keys on the store in a single
+// request, so that comparing the values of a range of keys costs a request whatever their number
+type RangeDigester interface {
+	// DigestKeys returns the hex SHA-1 of the keys held among the given ones, in order, each with the hex
+	// SHA-1 of its value
+	DigestKeys(keys []string) (string, error)
+}
+
+// KeysDigest returns the digest of the values of the keys, the keys missing on the store left out. It is
+// hashed by the store if it is a RangeDigester, or from the digests of the values one by one otherwise.
+func KeysDigest(db Database, keys []string) (string, error) {
+	if digester, ok := db.(RangeDigester); ok {
+		return digester.DigestKeys(keys)
+	}
+	return digestKeys(keys, func(key string) (string, error) {
+		return ValueDigest(db, key)
+	})
+}
+
+// digestKeys hashes the keys with the digests of their values as the stores do it in DigestKeys
+func digestKeys(keys []string, digest func(key string) (string, error)) (string, error) {
+	h := sha1.New()
+	for _, key := range keys {
+		d, err := digest(key)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(d))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func digestOf(value string) string {
+	sum := sha1.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
 func Factory(databaseType constants.StoreType, store *config.KVStore) (Database, error) {
 	switch databaseType {
 	case constants.Redis:
@@ -37,14 +155,18 @@ func Factory(databaseType constants.StoreType, store *config.KVStore) (Database,
 	}
 }
 
+// FactoryWithNameAndLatency returns the database of the given node name, the guarded one if it is guarded
 func FactoryWithNameAndLatency(databaseType constants.StoreType, name string, latency time.Duration) (Database, error) {
+	if g, ok := lookupGuard(name); ok {
+		return g, nil
+	}
 	switch databaseType {
 	case constants.Redis:
 		return createRedisDatabase(name)
 	case constants.Memory:
 		return NewMemDatabase(name), nil
 	case constants.DummyLatency: // simulator database backend suitable for internal perf load test
-		if db, ok := Storages[name]; ok {
+		if db, ok := Lookup(name); ok {
 			return db, nil
 		}
 		return newLatencyDummyDatabase(latency), nil
@@ -1,5 +1,15 @@
 package database
 
+import "errors"
+
+// ErrKeyNotFound is returned by a store which is reachable but does not hold the key
+var ErrKeyNotFound = errors.New("key not found")
+
+// IsNotFound tells a missing key apart from a store failing to serve the request
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrKeyNotFound)
+}
+
 type DatabaseNotImplementedError struct {
 	database string
 }
@@ -0,0 +1,415 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCircuitOpen is returned without calling a store whose recent requests failed
+	ErrCircuitOpen = errors.New("circuit open")
+	// ErrBulkheadFull is returned without calling a store which has too many requests in flight
+	ErrBulkheadFull = errors.New("too many requests in flight")
+)
+
+const (
+	DefaultFailureThreshold = 5
+	DefaultErrorRateWindow  = 20
+	DefaultOpenTimeout      = 5 * time.Second
+	// latencyWeight is the weight of the last request in the moving average of the latency
+	latencyWeight = 0.2
+)
+
+// CircuitState is whether the requests to a store go through
+type CircuitState string
+
+const (
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen rejects the requests until the open timeout elapses
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a single request through to probe the store; it closes the circuit if it succeeds
+	// and opens it again otherwise
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+type GuardOptions struct {
+	// FailureThreshold is the number of consecutive failures opening the circuit; DefaultFailureThreshold if 0
+	FailureThreshold int
+	// ErrorRateThreshold opens the circuit once this share of the last Window requests failed; 0 disables it
+	ErrorRateThreshold float64
+	// Window is the number of recent requests the error rate is computed over; DefaultErrorRateWindow if 0
+	Window int
+	// OpenTimeout is how long the circuit stays open before a request probes the store; DefaultOpenTimeout if 0
+	OpenTimeout time.Duration
+	// MaxInFlight caps the concurrent requests to the store; 0 means no limit
+	MaxInFlight int
+	// OnStateChange is called with the new state of the circuit, in order, outside of the request path locks
+	OnStateChange func(name string, state CircuitState)
+}
+
+// GuardStats are the health statistics of a store
+type GuardStats struct {
+	Name     string
+	State    CircuitState
+	InFlight int
+	Requests int64
+	Failures int64
+	// Rejected counts the requests failed fast by the open circuit or the full bulkhead
+	Rejected  int64
+	ErrorRate float64
+	Latency   time.Duration
+	OpenedAt  time.Time
+}
+
+// GuardedDatabase is a decorator tracking the health of a store: it records the error rate and the latency of
+// the requests, opens a circuit to fail fast once the store keeps failing, and caps the requests in flight so
+// that a slow store does not hold up all the callers. A missing key is not a failure.
+type GuardedDatabase struct {
+	name     string
+	backend  Database
+	opts     GuardOptions
+	inFlight chan struct{}
+
+	mu          sync.Mutex
+	state       CircuitState
+	probing     bool
+	consecutive int
+	// outcomes is the ring of the recent outcomes, true for a failure
+	outcomes []bool
+	next     int
+	stats    GuardStats
+
+	// notifyMu keeps the state changes reported in order
+	notifyMu sync.Mutex
+}
+
+func NewGuardedDatabase(name string, backend Database, opts GuardOptions) *GuardedDatabase {
+	if opts.FailureThreshold < 1 {
+		opts.FailureThreshold = DefaultFailureThreshold
+	}
+	if opts.Window < 1 {
+		opts.Window = DefaultErrorRateWindow
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = DefaultOpenTimeout
+	}
+	g := &GuardedDatabase{name: name, backend: backend, opts: opts, state: CircuitClosed, outcomes: make([]bool, 0, opts.Window)}
+	if opts.MaxInFlight > 0 {
+		g.inFlight = make(chan struct{}, opts.MaxInFlight)
+	}
+	g.stats.Name = name
+	return g
+}
+
+func (g *GuardedDatabase) Put(key, value string) (string, error) {
+	var res string
+	err := g.do(func() error {
+		var err error
+		res, err = g.backend.Put(key, value)
+		return err
+	})
+	return res, err
+}
+
+func (g *GuardedDatabase) Get(key string) (string, error) {
+	var res string
+	err := g.do(func() error {
+		var err error
+		res, err = g.backend.Get(key)
+		return err
+	})
+	return res, err
+}
+
+func (g *GuardedDatabase) Delete(key string) error {
+	return g.do(func() error {
+		return g.backend.Delete(key)
+	})
+}
+
+// Ping goes through the circuit as the other requests, so that the health checks also probe a half-open store
+func (g *GuardedDatabase) Ping() error {
+	pinger, ok := g.backend.(Pinger)
+	if !ok {
+		return fmt.Errorf("the store %s does not support the ping probe", g.name)
+	}
+	return g.do(pinger.Ping)
+}
+
+// Digest goes through the circuit as a read, hashed by the backend if it can
+func (g *GuardedDatabase) Digest(key string) (string, error) {
+	var res string
+	err := g.do(func() error {
+		var err error
+		res, err = ValueDigest(g.backend, key)
+		return err
+	})
+	return res, err
+}
+
+// DigestKeys goes through the circuit as a single read, hashed by the backend if it can
+func (g *GuardedDatabase) DigestKeys(keys []string) (string, error) {
+	var res string
+	err := g.do(func() error {
+		var err error
+		res, err = KeysDigest(g.backend, keys)
+		return err
+	})
+	return res, err
+}
+
+// Scan goes through the circuit as a single request
+func (g *GuardedDatabase) Scan(f func(key string) bool) error {
+	scanner, ok := g.backend.(Scanner)
+	if !ok {
+		return fmt.Errorf("the store %s does not support scanning its keys", g.name)
+	}
+	return g.do(func() error {
+		return scanner.Scan(f)
+	})
+}
+
+func (g *GuardedDatabase) Close() error {
+	return g.backend.Close()
+}
+
+func (g *GuardedDatabase) Latency() time.Duration {
+	return g.backend.Latency()
+}
+
+func (g *GuardedDatabase) SetLatency(latency time.Duration) {
+	g.backend.SetLatency(latency)
+}
+
+// Backend is the decorated database
+func (g *GuardedDatabase) Backend() Database {
+	return g.backend
+}
+
+func (g *GuardedDatabase) State() CircuitState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state
+}
+
+func (g *GuardedDatabase) Stats() GuardStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stats := g.stats
+	stats.State = g.state
+	stats.InFlight = len(g.inFlight)
+	failures := 0
+	for _, failed := range g.outcomes {
+		if failed {
+			failures++
+		}
+	}
+	if len(g.outcomes) > 0 {
+		stats.ErrorRate = float64(failures) / float64(len(g.outcomes))
+	}
+	return stats
+}
+
+func (g *GuardedDatabase) do(op func() error) error {
+	if g.inFlight != nil {
+		select {
+		case g.inFlight <- struct{}{}:
+			defer func() { <-g.inFlight }()
+		default:
+			g.mu.Lock()
+			g.stats.Rejected++
+			g.mu.Unlock()
+			return fmt.Errorf("%w: %s", ErrBulkheadFull, g.name)
+		}
+	}
+	probe, changed, err := g.allow()
+	if changed {
+		g.notify()
+	}
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = op()
+	if g.record(probe, time.Since(start), err != nil && !IsNotFound(err)) {
+		g.notify()
+	}
+	return err
+}
+
+// allow tells whether a request goes through and whether it is the probe of a half-open circuit
+func (g *GuardedDatabase) allow() (probe bool, changed bool, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch g.state {
+	case CircuitOpen:
+		if time.Since(g.stats.OpenedAt) < g.opts.OpenTimeout {
+			g.stats.Rejected++
+			return false, false, fmt.Errorf("%w: %s", ErrCircuitOpen, g.name)
+		}
+		g.state, changed = CircuitHalfOpen, true
+		fallthrough
+	case CircuitHalfOpen:
+		if g.probing {
+			g.stats.Rejected++
+			return false, changed, fmt.Errorf("%w: %s", ErrCircuitOpen, g.name)
+		}
+		g.probing = true
+		return true, changed, nil
+	}
+	return false, false, nil
+}
+
+// record accounts for a request and tells whether it changed the state of the circuit
+func (g *GuardedDatabase) record(probe bool, latency time.Duration, failed bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.Requests++
+	if g.stats.Latency == 0 {
+		g.stats.Latency = latency
+	} else {
+		g.stats.Latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(g.stats.Latency))
+	}
+	if failed {
+		g.stats.Failures++
+		g.consecutive++
+	} else {
+		g.consecutive = 0
+	}
+	if len(g.outcomes) < g.opts.Window {
+		g.outcomes = append(g.outcomes, failed)
+	} else {
+		g.outcomes[g.next] = failed
+	}
+	g.next = (g.next + 1) % g.opts.Window
+
+	switch {
+	case probe && failed:
+		g.probing = false
+		g.open()
+		return true
+	case probe:
+		g.probing = false
+		g.state = CircuitClosed
+		g.outcomes, g.next = g.outcomes[:0], 0
+		return true
+	case g.state == CircuitClosed && g.tripped():
+		g.open()
+		return true
+	}
+	return false
+}
+
+func (g *GuardedDatabase) tripped() bool {
+	if g.consecutive >= g.opts.FailureThreshold {
+		return true
+	}
+	if g.opts.ErrorRateThreshold <= 0 || len(g.outcomes) < g.opts.Window {
+		return false
+	}
+	failures := 0
+	for _, failed := range g.outcomes {
+		if failed {
+			failures++
+		}
+	}
+	return float64(failures)/float64(len(g.outcomes)) >= g.opts.ErrorRateThreshold
+}
+
+func (g *GuardedDatabase) open() {
+	g.state = CircuitOpen
+	g.stats.OpenedAt = time.Now()
+}
+
+// notify reports the current state rather than the one which triggered the call, so that the last report is
+// always the latest state
+func (g *GuardedDatabase) notify() {
+	if g.opts.OnStateChange == nil {
+		return
+	}
+	g.notifyMu.Lock()
+	defer g.notifyMu.Unlock()
+	g.opts.OnStateChange(g.name, g.State())
+}
+
+var (
+	// guards are the guarded stores by node name
+	guards          = make(map[string]*GuardedDatabase)
+	guardsMu        sync.RWMutex
+	guardOptions    *GuardOptions
+	circuitListener func(name string, state CircuitState)
+)
+
+// EnableGuards makes Guard decorate the stores with the given options
+func EnableGuards(opts GuardOptions) {
+	guardsMu.Lock()
+	defer guardsMu.Unlock()
+	guardOptions = &opts
+}
+
+// OnCircuitChange sets the listener of the state changes of the circuits of all the guarded stores
+func OnCircuitChange(listener func(name string, state CircuitState)) {
+	guardsMu.Lock()
+	defer guardsMu.Unlock()
+	circuitListener = listener
+}
+
+// Guard decorates the database of the given node name if the guards are enabled, and returns it as is
+// otherwise. The database factories return the guarded database of a node name from then on.
+func Guard(name string, backend Database) Database {
+	guardsMu.Lock()
+	defer guardsMu.Unlock()
+	if guardOptions == nil {
+		return backend
+	}
+	opts := *guardOptions
+	opts.OnStateChange = func(name string, state CircuitState) {
+		guardsMu.RLock()
+		listener := circuitListener
+		guardsMu.RUnlock()
+		if listener != nil {
+			listener(name, state)
+		}
+	}
+	g := NewGuardedDatabase(name, backend, opts)
+	guards[name] = g
+	return g
+}
+
+// Guards reports the health of the guarded stores sorted by node name
+func Guards() []GuardStats {
+	guardsMu.RLock()
+	defer guardsMu.RUnlock()
+	stats := make([]GuardStats, 0, len(guards))
+	for _, g := range guards {
+		stats = append(stats, g.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// Available tells whether the requests to the node go through, i.e. it is not guarded or its circuit is not open
+func Available(name string) bool {
+	g, ok := lookupGuard(name)
+	return !ok || g.State() != CircuitOpen
+}
+
+func lookupGuard(name string) (*GuardedDatabase, bool) {
+	guardsMu.RLock()
+	defer guardsMu.RUnlock()
+	g, ok := guards[name]
+	return g, ok
+}
+
+func unguard(db Database) {
+	if g, ok := db.(*GuardedDatabase); ok {
+		guardsMu.Lock()
+		defer guardsMu.Unlock()
+		delete(guards, g.name)
+	}
+}
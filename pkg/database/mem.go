@@ -1,14 +1,19 @@
 package database
 
 import (
-	"errors"
+	"sync"
 	"time"
 )
 
-var memDatabases map[string]*MemDatabase
+var (
+	memDatabases map[string]*MemDatabase
+	// memDatabasesMu guards the registry, which the writes fanned out to the stores look up concurrently
+	memDatabasesMu sync.Mutex
+)
 
 type MemDatabase struct {
 	Name     string
+	mu       *sync.RWMutex
 	db       map[string]string
 	wLatency int
 }
@@ -17,10 +22,12 @@ func init() {
 	memDatabases = make(map[string]*MemDatabase)
 }
 func NewMemDatabase(name string) Database {
+	memDatabasesMu.Lock()
+	defer memDatabasesMu.Unlock()
 	if md, ok := memDatabases[name]; ok {
 		return md
 	}
-	md := MemDatabase{db: make(map[string]string), Name: name, wLatency: 1}
+	md := MemDatabase{mu: &sync.RWMutex{}, db: make(map[string]string), Name: name, wLatency: 1}
 	memDatabases[name] = &md
 	return md
 }
@@ -29,22 +36,56 @@ func (md MemDatabase) Put(key, value string) (string, error) {
 	if md.wLatency > 0 {
 		time.Sleep(time.Duration(md.wLatency) * time.Second)
 	}
+	md.mu.Lock()
+	defer md.mu.Unlock()
 	md.db[key] = value
 	return "", nil
 }
 
 func (md MemDatabase) Get(key string) (string, error) {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
 	if val, ok := md.db[key]; ok {
 		return val, nil
 	}
-	return "", errors.New("key not found")
+	return "", ErrKeyNotFound
 }
 
 func (md MemDatabase) Delete(key string) error {
+	md.mu.Lock()
+	defer md.mu.Unlock()
 	delete(md.db, key)
 	return nil
 }
 
+func (md MemDatabase) Digest(key string) (string, error) {
+	value, err := md.Get(key)
+	if err != nil {
+		return "", err
+	}
+	return digestOf(value), nil
+}
+
+func (md MemDatabase) DigestKeys(keys []string) (string, error) {
+	return digestKeys(keys, md.Digest)
+}
+
+// Scan iterates over a snapshot of the keys, so that f may write to the database
+func (md MemDatabase) Scan(f func(key string) bool) error {
+	md.mu.RLock()
+	keys := make([]string, 0, len(md.db))
+	for key := range md.db {
+		keys = append(keys, key)
+	}
+	md.mu.RUnlock()
+	for _, key := range keys {
+		if !f(key) {
+			return nil
+		}
+	}
+	return nil
+}
+
 func (md MemDatabase) Close() error {
 	return nil
 }
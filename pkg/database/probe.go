@@ -0,0 +1,86 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/network/latency"
+	"k8s.io/klog"
+)
+
+// probeKey is written by the getset probes; it is never a revision key as those carry the revision number
+const probeKey = "__rkv_latency_probe__"
+
+// Pinger is implemented by the databases answering a lightweight health request
+type Pinger interface {
+	Ping() error
+}
+
+func init() {
+	latency.RegisterProbe(constants.ProbePing, func(target latency.Target) (latency.Probe, error) {
+		db, err := probedDatabase(target)
+		if err != nil {
+			return nil, err
+		}
+		pinger, ok := db.(Pinger)
+		if !ok {
+			return nil, fmt.Errorf("the %s store %s does not support the ping probe", target.StoreType, target.Name)
+		}
+		return latency.ProbeFunc(pinger.Ping), nil
+	})
+	latency.RegisterProbe(constants.ProbeGetSet, func(target latency.Target) (latency.Probe, error) {
+		db, err := probedDatabase(target)
+		if err != nil {
+			return nil, err
+		}
+		return latency.ProbeFunc(func() error {
+			if _, err := db.Put(probeKey, target.Name); err != nil {
+				return err
+			}
+			_, err := db.Get(probeKey)
+			return err
+		}), nil
+	})
+}
+
+var (
+	// probedMu guards probed, the databases created to probe the stores not registered yet, which are kept
+	// for the next probes instead of opening a connection pool per probe
+	probedMu sync.Mutex
+	probed   = make(map[latency.Target]Database)
+)
+
+// probedDatabase returns the database serving the requests to the target so that the probes go the same way
+func probedDatabase(target latency.Target) (Database, error) {
+	if db, ok := Lookup(target.Name); ok {
+		return db, nil
+	}
+	probedMu.Lock()
+	defer probedMu.Unlock()
+	if db, ok := probed[target]; ok {
+		return db, nil
+	}
+	db, err := Factory(target.StoreType, &config.KVStore{Name: target.Name, Host: target.Host, Port: target.Port})
+	if err != nil {
+		return nil, err
+	}
+	probed[target] = db
+	return db, nil
+}
+
+// forgetProbed closes the databases created to probe a store which is removed
+func forgetProbed(name string) {
+	probedMu.Lock()
+	defer probedMu.Unlock()
+	for target, db := range probed {
+		if target.Name != name {
+			continue
+		}
+		delete(probed, target)
+		if err := db.Close(); err != nil {
+			klog.Warningf("failed to close the probed database of %s: %v", name, err)
+		}
+	}
+}
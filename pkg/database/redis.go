@@ -11,12 +11,28 @@ import (
 	"github.com/regionless-storage-service/pkg/constants"
 )
 
+// digestScript hashes a value on the store with the SHA-1 the scripts come with
+var digestScript = redis.NewScript(1, `local v = redis.call('GET', KEYS[1])
+if not v then return false end
+return redis.sha1hex(v)`)
+
+// keysDigestScript hashes the values of the keys on the store as digestKeys does, in a single request
+var keysDigestScript = redis.NewScript(-1, `local parts = {}
+for _, k in ipairs(KEYS) do
+  local v = redis.call('GET', k)
+  if v then parts[#parts + 1] = k .. '\0' .. redis.sha1hex(v) .. '\n' end
+end
+return redis.sha1hex(table.concat(parts))`)
+
 var (
 	pools    map[string]*redis.Pool
+	poolsMu  sync.RWMutex
 	initOnce sync.Once
 )
 
 func InitStorageInstancePool(stores []config.KVStore) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
 	pools = make(map[string]*redis.Pool)
 	for _, conf := range stores {
 		url, pool := initPool(conf.Host, conf.Port)
@@ -24,6 +40,17 @@ func InitStorageInstancePool(stores []config.KVStore) {
 	}
 }
 
+// AddStorageInstancePool creates the connection pool of a redis store added at runtime
+func AddStorageInstancePool(store config.KVStore) {
+	initOnce.Do(func() {
+		InitStorageInstancePool(config.RKVConfig.Stores)
+	})
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	url, pool := initPool(store.Host, store.Port)
+	pools[url] = pool
+}
+
 func initPool(host string, port int) (string, *redis.Pool) {
 	url := fmt.Sprintf("%s:%d", host, port)
 	if pools[url] != nil {
@@ -59,6 +86,8 @@ func createRedisDatabase(databaseUrl string) (Database, error) {
 	initOnce.Do(func() {
 		InitStorageInstancePool(config.RKVConfig.Stores)
 	})
+	poolsMu.RLock()
+	defer poolsMu.RUnlock()
 	return &RedisDatabase{client: pools[databaseUrl], latency: 0}, nil
 }
 
@@ -82,8 +111,10 @@ func (rd *RedisDatabase) Get(key string) (string, error) {
 	}
 	defer conn.Close()
 
-	if resp, err := conn.Do("Get", key); err == nil {
-		return fmt.Sprintf("%s", resp), nil
+	if resp, err := redis.String(conn.Do("Get", key)); err == nil {
+		return resp, nil
+	} else if err == redis.ErrNil {
+		return "", ErrKeyNotFound
 	} else {
 		return "", err
 	}
@@ -103,6 +134,80 @@ func (rd *RedisDatabase) Delete(key string) error {
 	}
 }
 
+// Scan walks the keyspace with SCAN, which does not block the store as KEYS would; a key may be passed twice
+func (rd *RedisDatabase) Scan(f func(key string) bool) error {
+	conn, err := rd.client.Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "COUNT", constants.RedisScanCount))
+		if err != nil {
+			return err
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if !f(key) {
+				return nil
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// Ping does a PING round trip on a new connection, as the other requests do
+func (rd *RedisDatabase) Ping() error {
+	conn, err := rd.client.Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("PING")
+	return err
+}
+
+// Digest hashes the value on the store, so that only the hash is sent back
+func (rd *RedisDatabase) Digest(key string) (string, error) {
+	conn, err := rd.client.Dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if resp, err := redis.String(digestScript.Do(conn, key)); err == nil {
+		return resp, nil
+	} else if err == redis.ErrNil {
+		return "", ErrKeyNotFound
+	} else {
+		return "", err
+	}
+}
+
+// DigestKeys hashes the values of the keys on the store, so that only the hash is sent back
+func (rd *RedisDatabase) DigestKeys(keys []string) (string, error) {
+	conn, err := rd.client.Dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return redis.String(keysDigestScript.Do(conn, args...))
+}
+
 func (rd *RedisDatabase) Close() error {
 	return rd.client.Close()
 }
@@ -0,0 +1,171 @@
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooFewShards is returned when fewer than the data shards are left to reconstruct a value from
+var ErrTooFewShards = errors.New("too few shards to reconstruct")
+
+// MaxShards is the number of distinct elements of GF(2^8) the rows of the encoding matrix are built from
+const MaxShards = 256
+
+// Coder is a systematic Reed-Solomon code: a value is split into data shards, kept as they are, and parity
+// shards are computed from them so that any data shards out of all the shards give the value back.
+type Coder struct {
+	dataShards   int
+	parityShards int
+	// encoding is the identity over the data shards, and the rows of the parity shards below it
+	encoding matrix
+}
+
+func NewCoder(dataShards, parityShards int) (*Coder, error) {
+	if dataShards < 1 || parityShards < 0 || dataShards+parityShards > MaxShards {
+		return nil, fmt.Errorf("invalid erasure code of %d data and %d parity shards", dataShards, parityShards)
+	}
+	// the top of a Vandermonde matrix is turned into the identity, which keeps any square submatrix invertible
+	v := vandermonde(dataShards+parityShards, dataShards)
+	top, err := v[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+	return &Coder{dataShards: dataShards, parityShards: parityShards, encoding: v.multiply(top)}, nil
+}
+
+func (c *Coder) DataShards() int {
+	return c.dataShards
+}
+
+func (c *Coder) ParityShards() int {
+	return c.parityShards
+}
+
+// Split cuts the value into the data shards, the last one padded with zeros, and adds empty parity shards
+func (c *Coder) Split(value []byte) [][]byte {
+	size := (len(value) + c.dataShards - 1) / c.dataShards
+	shards := make([][]byte, c.dataShards+c.parityShards)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < c.dataShards && i*size < len(value) {
+			copy(shards[i], value[i*size:])
+		}
+	}
+	return shards
+}
+
+// Encode computes the parity shards from the data shards, which all have the same size
+func (c *Coder) Encode(shards [][]byte) error {
+	if err := c.check(shards, false); err != nil {
+		return err
+	}
+	for p := c.dataShards; p < len(shards); p++ {
+		out := shards[p]
+		for i := range out {
+			out[i] = 0
+		}
+		for d := 0; d < c.dataShards; d++ {
+			gfMulAdd(c.encoding[p][d], shards[d], out)
+		}
+	}
+	return nil
+}
+
+// Reconstruct fills the missing shards, the nil ones, from any data shards out of the others
+func (c *Coder) Reconstruct(shards [][]byte) error {
+	if err := c.check(shards, true); err != nil {
+		return err
+	}
+	present := make([]int, 0, c.dataShards)
+	size := 0
+	for i, shard := range shards {
+		if shard != nil && len(present) < c.dataShards {
+			present = append(present, i)
+			size = len(shard)
+		}
+	}
+	if len(present) < c.dataShards {
+		return fmt.Errorf("%w: %d of the %d shards are left, %d are needed", ErrTooFewShards, c.countPresent(shards), len(shards), c.dataShards)
+	}
+
+	// the rows of the present shards map the data shards to them, so their inverse maps them back
+	sub := newMatrix(c.dataShards, c.dataShards)
+	for r, i := range present {
+		copy(sub[r], c.encoding[i])
+	}
+	decoding, err := sub.invert()
+	if err != nil {
+		return err
+	}
+	data := make([][]byte, c.dataShards)
+	for d := range data {
+		if shards[d] != nil {
+			data[d] = shards[d]
+			continue
+		}
+		data[d] = make([]byte, size)
+		for r, i := range present {
+			gfMulAdd(decoding[d][r], shards[i], data[d])
+		}
+	}
+	for i := range shards {
+		if shards[i] != nil {
+			continue
+		}
+		if i < c.dataShards {
+			shards[i] = data[i]
+			continue
+		}
+		shards[i] = make([]byte, size)
+		for d := 0; d < c.dataShards; d++ {
+			gfMulAdd(c.encoding[i][d], data[d], shards[i])
+		}
+	}
+	return nil
+}
+
+// Join concatenates the data shards back into the value of the given size
+func (c *Coder) Join(shards [][]byte, size int) ([]byte, error) {
+	value := make([]byte, 0, size)
+	for d := 0; d < c.dataShards && len(value) < size; d++ {
+		if shards[d] == nil {
+			return nil, fmt.Errorf("%w: the data shard %d is missing", ErrTooFewShards, d)
+		}
+		value = append(value, shards[d]...)
+	}
+	if len(value) < size {
+		return nil, fmt.Errorf("the shards hold %d bytes instead of %d", len(value), size)
+	}
+	return value[:size], nil
+}
+
+func (c *Coder) countPresent(shards [][]byte) int {
+	n := 0
+	for _, shard := range shards {
+		if shard != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// check makes sure there is a shard for every row and that the present ones have the same size
+func (c *Coder) check(shards [][]byte, missingAllowed bool) error {
+	if len(shards) != c.dataShards+c.parityShards {
+		return fmt.Errorf("%d shards instead of %d", len(shards), c.dataShards+c.parityShards)
+	}
+	size := -1
+	for i, shard := range shards {
+		if shard == nil {
+			if !missingAllowed {
+				return fmt.Errorf("the shard %d is missing", i)
+			}
+			continue
+		}
+		if size >= 0 && len(shard) != size {
+			return fmt.Errorf("the shard %d has %d bytes instead of %d", i, len(shard), size)
+		}
+		size = len(shard)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package erasure
+
+// The arithmetic of GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1, which 2 generates. Adding is XOR,
+// and multiplying adds the logarithms of the operands.
+const fieldPolynomial = 0x11d
+
+var (
+	// expTable is doubled so that the sum of two logarithms does not have to be reduced
+	expTable [510]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i], expTable[i+255] = byte(x), byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= fieldPolynomial
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+// gfInv is the inverse of a non-zero element
+func gfInv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// gfExp raises a to the power n
+func gfExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+// gfMulAdd adds c times in to out, the inner loop of the encoding and the reconstruction
+func gfMulAdd(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+	logC := int(logTable[c])
+	for i, v := range in {
+		if v != 0 {
+			out[i] ^= expTable[logC+int(logTable[v])]
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package erasure
+
+import "errors"
+
+var errSingularMatrix = errors.New("the matrix is singular")
+
+// matrix is a matrix over GF(2^8), by rows
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+// vandermonde has the rows 1, r, r^2... of the distinct elements r, any square submatrix of it being invertible
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = gfExp(byte(r), c)
+		}
+	}
+	return m
+}
+
+func (m matrix) multiply(other matrix) matrix {
+	result := newMatrix(len(m), len(other[0]))
+	for r := range result {
+		for c := range result[r] {
+			var v byte
+			for i := range other {
+				v ^= gfMul(m[r][i], other[i][c])
+			}
+			result[r][c] = v
+		}
+	}
+	return result
+}
+
+// invert inverts a square matrix by the Gauss-Jordan elimination
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	// work is m with the identity on its right, which becomes the inverse as m becomes the identity
+	work := newMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+	for c := 0; c < n; c++ {
+		pivot := c
+		for pivot < n && work[pivot][c] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errSingularMatrix
+		}
+		work[c], work[pivot] = work[pivot], work[c]
+		if v := work[c][c]; v != 1 {
+			inv := gfInv(v)
+			for i := range work[c] {
+				work[c][i] = gfMul(work[c][i], inv)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r != c && work[r][c] != 0 {
+				f := work[r][c]
+				for i := range work[r] {
+					work[r][i] ^= gfMul(f, work[c][i])
+				}
+			}
+		}
+	}
+	inverse := newMatrix(n, n)
+	for r := range inverse {
+		copy(inverse[r], work[r][n:])
+	}
+	return inverse, nil
+}
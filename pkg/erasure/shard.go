@@ -0,0 +1,101 @@
+package erasure
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrCorruptShard is returned for a stored value which is not a shard, or whose checksum does not match
+var ErrCorruptShard = errors.New("corrupt shard")
+
+// shardHeaderSize is the version, the index, the data and parity shard counts, the value size and the CRC
+const shardHeaderSize = 1 + 1 + 1 + 1 + 8 + 4
+
+const shardVersion = 1
+
+// Shard is a piece of an erasure-coded value as it is stored. It describes itself, so that it is read back
+// without knowing the position of its store in the placement.
+type Shard struct {
+	Index        int
+	DataShards   int
+	ParityShards int
+	// Size is the size of the whole value
+	Size int
+	Data []byte
+}
+
+// EncodeValue splits the value into the shards of the coder
+func (c *Coder) EncodeValue(value []byte) ([]Shard, error) {
+	data := c.Split(value)
+	if err := c.Encode(data); err != nil {
+		return nil, err
+	}
+	shards := make([]Shard, len(data))
+	for i := range data {
+		shards[i] = Shard{Index: i, DataShards: c.dataShards, ParityShards: c.parityShards, Size: len(value), Data: data[i]}
+	}
+	return shards, nil
+}
+
+// DecodeValue gives the value back from the shards of the coder, which may be missing, duplicated or out of
+// order as long as any data shards out of them are distinct
+func (c *Coder) DecodeValue(shards []Shard) ([]byte, error) {
+	pieces := make([][]byte, c.dataShards+c.parityShards)
+	size := -1
+	for _, s := range shards {
+		if s.DataShards != c.dataShards || s.ParityShards != c.parityShards || s.Index < 0 || s.Index >= len(pieces) {
+			return nil, fmt.Errorf("%w: the shard %d of %d+%d does not belong to a %d+%d code", ErrCorruptShard, s.Index, s.DataShards, s.ParityShards, c.dataShards, c.parityShards)
+		}
+		if size >= 0 && s.Size != size {
+			return nil, fmt.Errorf("%w: the shards are of values of %d and %d bytes", ErrCorruptShard, size, s.Size)
+		}
+		size = s.Size
+		pieces[s.Index] = s.Data
+	}
+	// the data shards are joined as they are unless one of them is missing
+	for d := 0; d < c.dataShards; d++ {
+		if pieces[d] == nil {
+			if err := c.Reconstruct(pieces); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return c.Join(pieces, size)
+}
+
+// Marshal lays the shard out with its header and the CRC of its data
+func (s Shard) Marshal() []byte {
+	b := make([]byte, shardHeaderSize+len(s.Data))
+	b[0] = shardVersion
+	b[1] = byte(s.Index)
+	b[2] = byte(s.DataShards - 1)
+	b[3] = byte(s.ParityShards)
+	binary.BigEndian.PutUint64(b[4:12], uint64(s.Size))
+	binary.BigEndian.PutUint32(b[12:16], crc32.ChecksumIEEE(s.Data))
+	copy(b[shardHeaderSize:], s.Data)
+	return b
+}
+
+// UnmarshalShard reads a stored shard back, checking its data against its CRC
+func UnmarshalShard(b []byte) (Shard, error) {
+	if len(b) < shardHeaderSize || b[0] != shardVersion {
+		return Shard{}, fmt.Errorf("%w: no shard header", ErrCorruptShard)
+	}
+	s := Shard{
+		Index:        int(b[1]),
+		DataShards:   int(b[2]) + 1,
+		ParityShards: int(b[3]),
+		Size:         int(binary.BigEndian.Uint64(b[4:12])),
+		Data:         append([]byte{}, b[shardHeaderSize:]...),
+	}
+	if crc32.ChecksumIEEE(s.Data) != binary.BigEndian.Uint32(b[12:16]) {
+		return Shard{}, fmt.Errorf("%w: the checksum of the shard %d does not match", ErrCorruptShard, s.Index)
+	}
+	if s.DataShards+s.ParityShards > MaxShards || s.Index >= s.DataShards+s.ParityShards {
+		return Shard{}, fmt.Errorf("%w: the shard %d of %d+%d is out of its code", ErrCorruptShard, s.Index, s.DataShards, s.ParityShards)
+	}
+	return s, nil
+}
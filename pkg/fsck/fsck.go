@@ -0,0 +1,284 @@
+package fsck
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/index"
+)
+
+// Problem is what is wrong with a replica
+type Problem string
+
+const (
+	// ProblemMissing is a replica of the placement not holding the value
+	ProblemMissing Problem = "missing"
+	// ProblemMismatch is a replica whose value does not have the checksum recorded at write time, or the one most
+	// replicas have for the revisions written without it
+	ProblemMismatch Problem = "mismatch"
+	// ProblemUnreadable is a replica whose store failed to serve the value
+	ProblemUnreadable Problem = "unreadable"
+	// ProblemOrphaned is a store out of the placement still holding the value
+	ProblemOrphaned Problem = "orphaned"
+)
+
+// Resolver returns the backend database of the named node
+type Resolver func(name string) (database.Database, error)
+
+type Options struct {
+	// Repair copies the value matching the checksum recorded at write time to the replicas missing it or
+	// holding another one. Without a recorded checksum, the value most replicas hold is copied unless as many
+	// replicas hold another value.
+	Repair bool
+	// Stores are the node names looked up for orphaned values; none are looked up if empty
+	Stores []string
+}
+
+// Finding is a replica, or an orphaned copy, of a revision which is not as it should be
+type Finding struct {
+	Key      string
+	Revision string
+	Node     string
+	Problem  Problem
+	// Checksum is the CRC-32C of the value the node holds, and Expected the one recorded at write time or
+	// the one most replicas hold without it
+	Checksum string `json:",omitempty"`
+	Expected string `json:",omitempty"`
+	Repaired bool
+	Error    string `json:",omitempty"`
+}
+
+// Report is the outcome of a check; it is meant to be alerted on
+type Report struct {
+	// Revisions is the number of live and historical revisions checked, tombstones aside
+	Revisions int
+	Replicas  int
+	// UnderReplicated is the number of revisions with fewer replicas holding the value than placed, once repaired
+	UnderReplicated int
+	// Lost is the number of revisions none of the replicas holds
+	Lost       int
+	Missing    int
+	Mismatched int
+	Unreadable int
+	Orphaned   int
+	Repaired   int
+	Findings   []Finding
+}
+
+// Healthy tells whether every replica holds its value and no store holds a value it should not
+func (r Report) Healthy() bool {
+	return r.UnderReplicated == 0 && r.Lost == 0 && r.Unreadable == 0 && r.Orphaned == 0
+}
+
+// Check reads every replica of every revision of the index and verifies the checksums of the values
+func Check(ctx context.Context, indexTree index.Index, resolve Resolver, opts Options) (Report, error) {
+	type entry struct {
+		key []byte
+		rev index.Revision
+	}
+	// the stores are read once the walk is done, as the index must not be called back from it
+	entries := make([]entry, 0)
+	indexTree.Walk(ctx, func(key []byte, rev index.Revision) bool {
+		if !rev.IsTombstone() {
+			entries = append(entries, entry{key: key, rev: rev})
+		}
+		return true
+	})
+
+	report := Report{Findings: make([]Finding, 0)}
+	orphans := newOrphanScan(resolve, opts.Stores)
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.check(indexTree, resolve, opts, orphans, e.key, e.rev)
+	}
+	return report, nil
+}
+
+type replica struct {
+	node     string
+	db       database.Database
+	checksum string
+	value    string
+	problem  Problem
+	err      error
+}
+
+func checksum(value string) string {
+	return fmt.Sprintf("%08x", index.Checksum(value))
+}
+
+func (r *Report) check(indexTree index.Index, resolve Resolver, opts Options, orphans *orphanScan, key []byte, rev index.Revision) {
+	name := rev.String()
+	nodes := rev.GetPlacement().Nodes()
+	r.Revisions++
+	r.Replicas += len(nodes)
+
+	replicas := make([]*replica, 0, len(nodes))
+	counts := make(map[string]int)
+	for _, node := range nodes {
+		rep := &replica{node: node}
+		replicas = append(replicas, rep)
+		if rep.db, rep.err = resolve(node); rep.err != nil {
+			rep.problem = ProblemUnreadable
+			continue
+		}
+		value, err := rep.db.Get(name)
+		switch {
+		case err == nil:
+			rep.value, rep.checksum = value, checksum(value)
+			counts[rep.checksum]++
+		case database.IsNotFound(err):
+			rep.problem = ProblemMissing
+		default:
+			rep.problem, rep.err = ProblemUnreadable, err
+		}
+	}
+	expected, tie := expectedChecksum(rev, replicas, counts)
+	value, held := "", counts[expected]
+	for _, rep := range replicas {
+		if rep.checksum == expected {
+			value = rep.value
+			break
+		}
+	}
+
+	repair := opts.Repair && held > 0 && !tie && current(indexTree, key, rev)
+	for _, rep := range replicas {
+		if rep.problem == "" && rep.checksum != expected {
+			rep.problem = ProblemMismatch
+		}
+		if rep.problem == "" {
+			continue
+		}
+		f := Finding{Key: string(key), Revision: name, Node: rep.node, Problem: rep.problem, Checksum: rep.checksum, Expected: expected}
+		if rep.err != nil {
+			f.Error = rep.err.Error()
+		}
+		switch rep.problem {
+		case ProblemMissing:
+			r.Missing++
+		case ProblemMismatch:
+			r.Mismatched++
+		case ProblemUnreadable:
+			r.Unreadable++
+		}
+		if repair && rep.problem != ProblemUnreadable {
+			if _, err := rep.db.Put(name, value); err != nil {
+				f.Error = fmt.Sprintf("failed to repair: %v", err)
+			} else {
+				f.Repaired = true
+				r.Repaired++
+				held++
+			}
+		}
+		r.Findings = append(r.Findings, f)
+	}
+	if counts[expected] == 0 {
+		r.Lost++
+	} else if held < len(replicas) {
+		r.UnderReplicated++
+	}
+
+	r.orphans(orphans, key, name, nodes)
+}
+
+// expectedChecksum is the checksum recorded of the value when the revision was written, whatever the replicas
+// hold. A revision written before the checksums were recorded expects the checksum most replicas hold, the
+// leader winning a tie, which is then not to be repaired as the leader may well hold the wrong value.
+func expectedChecksum(rev index.Revision, replicas []*replica, counts map[string]int) (string, bool) {
+	if sum, ok := rev.GetChecksum(); ok {
+		return fmt.Sprintf("%08x", sum), false
+	}
+	expected, best := "", 0
+	for _, rep := range replicas {
+		if rep.checksum != "" && counts[rep.checksum] > best {
+			expected, best = rep.checksum, counts[rep.checksum]
+		}
+	}
+	tie := false
+	for sum, count := range counts {
+		tie = tie || (sum != expected && count == best)
+	}
+	return expected, tie
+}
+
+// current tells whether the revision is still placed as it was walked, as it may have been moved since
+func current(indexTree index.Index, key []byte, rev index.Revision) bool {
+	latest, _, _, err := indexTree.Get(context.Background(), key, rev.GetMain())
+	return err == nil && latest.GetSub() == rev.GetSub() && latest.GetPlacement().String() == rev.GetPlacement().String()
+}
+
+// orphanScan holds the keys of the stores looked up for orphaned values, each store being scanned once
+// rather than asked for every revision
+type orphanScan struct {
+	resolve Resolver
+	stores  []string
+	// keys are the keys of the stores, none for the stores which cannot be scanned
+	keys map[string]map[string]bool
+}
+
+func newOrphanScan(resolve Resolver, stores []string) *orphanScan {
+	s := &orphanScan{resolve: resolve, stores: stores, keys: make(map[string]map[string]bool, len(stores))}
+	for _, node := range stores {
+		db, err := resolve(node)
+		if err != nil {
+			continue
+		}
+		scanner, ok := db.(database.Scanner)
+		if !ok {
+			continue
+		}
+		keys := make(map[string]bool)
+		if err := scanner.Scan(func(key string) bool {
+			keys[key] = true
+			return true
+		}); err == nil {
+			s.keys[node] = keys
+		}
+	}
+	return s
+}
+
+// holds tells whether the store holds the key, from its scan or by reading it if it cannot be scanned
+func (s *orphanScan) holds(node, name string) bool {
+	if keys := s.keys[node]; keys != nil {
+		return keys[name]
+	}
+	db, err := s.resolve(node)
+	if err != nil {
+		return false
+	}
+	_, err = db.Get(name)
+	return err == nil
+}
+
+// orphans looks for the value on the stores out of the placement
+func (r *Report) orphans(orphans *orphanScan, key []byte, name string, nodes []string) {
+	placed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		placed[node] = true
+	}
+	for _, node := range orphans.stores {
+		if !placed[node] && orphans.holds(node, name) {
+			r.Orphaned++
+			r.Findings = append(r.Findings, Finding{Key: string(key), Revision: name, Node: node, Problem: ProblemOrphaned})
+		}
+	}
+}
+
+// Write prints the report as text
+func (r Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "%d revisions and %d replicas checked: %d under-replicated, %d lost\n", r.Revisions, r.Replicas, r.UnderReplicated, r.Lost)
+	fmt.Fprintf(w, "%d missing, %d mismatched, %d unreadable, %d orphaned, %d repaired\n", r.Missing, r.Mismatched, r.Unreadable, r.Orphaned, r.Repaired)
+	if len(r.Findings) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%-32s %-12s %-24s %-12s %s\n", "KEY", "REVISION", "NODE", "PROBLEM", "REPAIRED")
+	for _, f := range r.Findings {
+		fmt.Fprintf(w, "%-32s %-12s %-24s %-12s %t\n", f.Key, f.Revision, f.Node, f.Problem, f.Repaired)
+	}
+}
@@ -20,16 +20,30 @@ type Index interface {
 
 	// Update updates index of key only when the known its latest revision is assumed
 	Update(ctx context.Context, key []byte, rev Revision, revAssumed int64) error
+
+	// Walk passes every revision of every key, tombstones included, in key order until f returns false.
+	// f must not call the index.
+	Walk(ctx context.Context, f func(key []byte, rev Revision) bool)
+	// SetPlacement records the replicas an existing revision of key has been moved to
+	SetPlacement(ctx context.Context, key []byte, rev Revision) error
+	// CompactPlacements renumbers the placements of the revisions under a new version of the placement table,
+	// dropping the ones left unused e.g. by a membership change, and returns the number of placements kept
+	CompactPlacements(ctx context.Context) int
+	// Placements is the placement table the revisions put in the index are interned in
+	Placements() *PlacementTable
 }
 
 type treeIndex struct {
 	sync.RWMutex
 	tree *btree.BTree
+	// placements is owned by the index, as its compactions renumber the placements of its revisions only
+	placements *PlacementTable
 }
 
 func NewTreeIndex() Index {
 	return &treeIndex{
-		tree: btree.New(32),
+		tree:       btree.New(32),
+		placements: NewPlacementTable(),
 	}
 }
 
@@ -44,14 +58,16 @@ func (ti *treeIndex) Put(ctx context.Context, key []byte, rev Revision) error {
 
 	ti.Lock()
 	defer ti.Unlock()
+	// the placement is refreshed under the lock, so that no compaction leaves it behind before it is stored
+	rev.placement = ti.placements.refresh(rev.placement)
 	item := ti.tree.Get(keyi)
 	if item == nil {
-		keyi.put(rev.main, rev.sub, rev.nodes)
+		keyi.put(rev)
 		ti.tree.ReplaceOrInsert(keyi)
 		return nil
 	}
 	okeyi := item.(*keyIndex)
-	okeyi.put(rev.main, rev.sub, rev.nodes)
+	okeyi.put(rev)
 	return nil
 }
 
@@ -60,6 +76,7 @@ func (ti *treeIndex) Restore(key []byte, created, modified Revision, ver int64)
 
 	ti.Lock()
 	defer ti.Unlock()
+	created.placement, modified.placement = ti.placements.refresh(created.placement), ti.placements.refresh(modified.placement)
 	item := ti.tree.Get(keyi)
 	if item == nil {
 		keyi.restore(created, modified, ver)
@@ -67,7 +84,7 @@ func (ti *treeIndex) Restore(key []byte, created, modified Revision, ver int64)
 		return
 	}
 	okeyi := item.(*keyIndex)
-	okeyi.put(modified.main, modified.sub, modified.nodes)
+	okeyi.put(modified)
 }
 
 func (ti *treeIndex) Get(ctx context.Context, key []byte, atRev int64) (modified, created Revision, ver int64, err error) {
@@ -217,5 +234,70 @@ func (ti *treeIndex) Update(ctx context.Context, key []byte, rev Revision, revAs
 	}
 
 	keyi = item.(*keyIndex)
-	return keyi.update(rev.main, rev.sub, rev.nodes, revAssumed)
+	rev.placement = ti.placements.refresh(rev.placement)
+	return keyi.update(rev, revAssumed)
+}
+
+func (ti *treeIndex) Walk(ctx context.Context, f func(key []byte, rev Revision) bool) {
+	_, span := otel.Tracer(config.TraceName).Start(ctx, "walk index")
+	defer span.End()
+
+	ti.RLock()
+	defer ti.RUnlock()
+
+	ti.tree.Ascend(func(item btree.Item) bool {
+		keyi := item.(*keyIndex)
+		for _, g := range keyi.generations {
+			for _, rev := range g.revs {
+				if !f(keyi.key, rev) {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+func (ti *treeIndex) SetPlacement(ctx context.Context, key []byte, rev Revision) error {
+	_, span := otel.Tracer(config.TraceName).Start(ctx, "set placement index")
+	defer span.End()
+
+	keyi := &keyIndex{key: key}
+
+	ti.Lock()
+	defer ti.Unlock()
+
+	item := ti.tree.Get(keyi)
+	if item == nil {
+		return ErrRevisionNotFound
+	}
+	return item.(*keyIndex).setPlacement(rev.main, rev.sub, ti.placements.refresh(rev.placement))
+}
+
+func (ti *treeIndex) CompactPlacements(ctx context.Context) int {
+	_, span := otel.Tracer(config.TraceName).Start(ctx, "compact placements index")
+	defer span.End()
+
+	ti.Lock()
+	defer ti.Unlock()
+
+	ti.placements.compact(func(visit func(ref *placementRef)) {
+		ti.tree.Ascend(func(item btree.Item) bool {
+			keyi := item.(*keyIndex)
+			visit(&keyi.modified.placement)
+			for gi := range keyi.generations {
+				g := &keyi.generations[gi]
+				visit(&g.created.placement)
+				for i := range g.revs {
+					visit(&g.revs[i].placement)
+				}
+			}
+			return true
+		})
+	})
+	return ti.placements.Len()
+}
+
+func (ti *treeIndex) Placements() *PlacementTable {
+	return ti.placements
 }
@@ -62,8 +62,7 @@ type keyIndex struct {
 }
 
 // put puts a Revision to the keyIndex.
-func (ki *keyIndex) put(main int64, sub int64, nodes []string) {
-	rev := Revision{main: main, sub: sub, nodes: nodes}
+func (ki *keyIndex) put(rev Revision) {
 
 	if len(ki.generations) == 0 {
 		ki.generations = append(ki.generations, generation{})
@@ -118,7 +117,7 @@ func (ki *keyIndex) tombstone(main int64, sub int64) error {
 	if ki.generations[len(ki.generations)-1].isEmpty() {
 		return ErrRevisionNotFound
 	}
-	ki.put(main, sub, nil)
+	ki.put(Revision{main: main, sub: sub})
 	ki.generations = append(ki.generations, generation{})
 	// keysGauge.Dec()
 	return nil
@@ -150,7 +149,7 @@ func (ki *keyIndex) since(rev int64) []Revision {
 	if ki.isEmpty() {
 		panic(fmt.Errorf("store.keyindex: unexpected get on empty keyIndex %s", string(ki.key)))
 	}
-	since := Revision{rev, 0, nil}
+	since := Revision{main: rev}
 	var gi int
 	// find the generations to start checking
 	for gi = len(ki.generations) - 1; gi > 0; gi-- {
@@ -240,13 +239,34 @@ func (a *keyIndex) equal(b *keyIndex) bool {
 	return true
 }
 
-func (ki *keyIndex) update(main int64, sub int64, nodes []string, revAssumed int64) error {
+func (ki *keyIndex) update(rev Revision, revAssumed int64) error {
 	revLatest := ki.modified.main
 	if revLatest != revAssumed {
 		return fmt.Errorf("the rev to assume is not the latest one")
 	}
 
-	ki.put(main, sub, nodes)
+	ki.put(rev)
+	return nil
+}
+
+// setPlacement replaces the placement of the Revision main.sub wherever it is kept
+func (ki *keyIndex) setPlacement(main int64, sub int64, placement placementRef) error {
+	found := false
+	for gi := range ki.generations {
+		g := &ki.generations[gi]
+		for i := range g.revs {
+			if g.revs[i].main == main && g.revs[i].sub == sub {
+				g.revs[i].placement = placement
+				found = true
+			}
+		}
+	}
+	if !found {
+		return ErrRevisionNotFound
+	}
+	if ki.modified.main == main && ki.modified.sub == sub {
+		ki.modified.placement = placement
+	}
 	return nil
 }
 
@@ -3,6 +3,8 @@ package index
 import (
 	"reflect"
 	"testing"
+
+	"github.com/regionless-storage-service/pkg/partition/consistent"
 )
 
 func TestPut(t *testing.T) {
@@ -17,24 +19,24 @@ func TestPut(t *testing.T) {
 			name: "put newer rev",
 			index: keyIndex{
 				key:      []byte("testkey"),
-				modified: Revision{main: 99, sub: 0, nodes: []string{"node2"}},
+				modified: Revision{main: 99, sub: 0, placement: placedOn("node2")},
 				generations: []generation{{
 					ver:     2,
-					created: Revision{main: 98, sub: 0, nodes: []string{"node1"}},
+					created: Revision{main: 98, sub: 0, placement: placedOn("node1")},
 					revs: []Revision{
-						{main: 98, sub: 0, nodes: []string{"node1"}},
-						{main: 99, sub: 0, nodes: []string{"node2"}},
+						{main: 98, sub: 0, placement: placedOn("node1")},
+						{main: 99, sub: 0, placement: placedOn("node2")},
 					},
 				}}},
-			revToPut:         Revision{main: 100, sub: 0, nodes: []string{"node3"}},
-			expectedModified: Revision{main: 100, sub: 0, nodes: []string{"node3"}},
+			revToPut:         Revision{main: 100, sub: 0, placement: placedOn("node3")},
+			expectedModified: Revision{main: 100, sub: 0, placement: placedOn("node3")},
 			expectedGenerations: []generation{{
 				ver:     3,
-				created: Revision{main: 98, sub: 0, nodes: []string{"node1"}},
+				created: Revision{main: 98, sub: 0, placement: placedOn("node1")},
 				revs: []Revision{
-					{main: 98, sub: 0, nodes: []string{"node1"}},
-					{main: 99, sub: 0, nodes: []string{"node2"}},
-					{main: 100, sub: 0, nodes: []string{"node3"}},
+					{main: 98, sub: 0, placement: placedOn("node1")},
+					{main: 99, sub: 0, placement: placedOn("node2")},
+					{main: 100, sub: 0, placement: placedOn("node3")},
 				},
 			},
 			},
@@ -43,24 +45,24 @@ func TestPut(t *testing.T) {
 			name: "put stale rev",
 			index: keyIndex{
 				key:      []byte("testkey"),
-				modified: Revision{main: 100, sub: 0, nodes: []string{"node3"}},
+				modified: Revision{main: 100, sub: 0, placement: placedOn("node3")},
 				generations: []generation{{
 					ver:     2,
-					created: Revision{main: 98, sub: 0, nodes: []string{"node1"}},
+					created: Revision{main: 98, sub: 0, placement: placedOn("node1")},
 					revs: []Revision{
-						{main: 98, sub: 0, nodes: []string{"node1"}},
-						{main: 100, sub: 0, nodes: []string{"node3"}},
+						{main: 98, sub: 0, placement: placedOn("node1")},
+						{main: 100, sub: 0, placement: placedOn("node3")},
 					},
 				}}},
-			revToPut:         Revision{main: 99, sub: 0, nodes: []string{"node2"}},
-			expectedModified: Revision{main: 100, sub: 0, nodes: []string{"node3"}},
+			revToPut:         Revision{main: 99, sub: 0, placement: placedOn("node2")},
+			expectedModified: Revision{main: 100, sub: 0, placement: placedOn("node3")},
 			expectedGenerations: []generation{{
 				ver:     2,
-				created: Revision{main: 98, sub: 0, nodes: []string{"node1"}},
+				created: Revision{main: 98, sub: 0, placement: placedOn("node1")},
 				revs: []Revision{
-					{main: 98, sub: 0, nodes: []string{"node1"}},
-					{main: 99, sub: 0, nodes: []string{"node2"}},
-					{main: 100, sub: 0, nodes: []string{"node3"}},
+					{main: 98, sub: 0, placement: placedOn("node1")},
+					{main: 99, sub: 0, placement: placedOn("node2")},
+					{main: 100, sub: 0, placement: placedOn("node3")},
 				},
 			},
 			},
@@ -69,7 +71,7 @@ func TestPut(t *testing.T) {
 
 	for _, tc := range tcs {
 		t.Run(tc.name, func(t *testing.T) {
-			tc.index.put(tc.revToPut.main, tc.revToPut.sub, tc.revToPut.GetNodes())
+			tc.index.put(tc.revToPut)
 
 			if !reflect.DeepEqual(tc.index.modified, tc.expectedModified) {
 				t.Errorf("extecped modified rev %v, got %v", tc.expectedModified, tc.index.modified)
@@ -96,25 +98,25 @@ func TestUpdate(t *testing.T) {
 			name: "update rev on top of assumed one and succeed",
 			index: keyIndex{
 				key:      []byte("testkey"),
-				modified: Revision{main: 99, sub: 0, nodes: []string{"node2"}},
+				modified: Revision{main: 99, sub: 0, placement: placedOn("node2")},
 				generations: []generation{{
 					ver:     2,
-					created: Revision{main: 98, sub: 0, nodes: []string{"node1"}},
+					created: Revision{main: 98, sub: 0, placement: placedOn("node1")},
 					revs: []Revision{
-						{main: 98, sub: 0, nodes: []string{"node1"}},
-						{main: 99, sub: 0, nodes: []string{"node2"}},
+						{main: 98, sub: 0, placement: placedOn("node1")},
+						{main: 99, sub: 0, placement: placedOn("node2")},
 					},
 				}}},
-			revToPut:         Revision{main: 100, sub: 0, nodes: []string{"node3"}},
+			revToPut:         Revision{main: 100, sub: 0, placement: placedOn("node3")},
 			revToAssume:      99,
-			expectedModified: Revision{main: 100, sub: 0, nodes: []string{"node3"}},
+			expectedModified: Revision{main: 100, sub: 0, placement: placedOn("node3")},
 			expectedGenerations: []generation{{
 				ver:     3,
-				created: Revision{main: 98, sub: 0, nodes: []string{"node1"}},
+				created: Revision{main: 98, sub: 0, placement: placedOn("node1")},
 				revs: []Revision{
-					{main: 98, sub: 0, nodes: []string{"node1"}},
-					{main: 99, sub: 0, nodes: []string{"node2"}},
-					{main: 100, sub: 0, nodes: []string{"node3"}},
+					{main: 98, sub: 0, placement: placedOn("node1")},
+					{main: 99, sub: 0, placement: placedOn("node2")},
+					{main: 100, sub: 0, placement: placedOn("node3")},
 				},
 			},
 			},
@@ -123,25 +125,25 @@ func TestUpdate(t *testing.T) {
 			name: "update stale rev and fail",
 			index: keyIndex{
 				key:      []byte("testkey"),
-				modified: Revision{main: 100, sub: 0, nodes: []string{"node3"}},
+				modified: Revision{main: 100, sub: 0, placement: placedOn("node3")},
 				generations: []generation{{
 					ver:     2,
-					created: Revision{main: 98, sub: 0, nodes: []string{"node1"}},
+					created: Revision{main: 98, sub: 0, placement: placedOn("node1")},
 					revs: []Revision{
-						{main: 98, sub: 0, nodes: []string{"node1"}},
-						{main: 100, sub: 0, nodes: []string{"node3"}},
+						{main: 98, sub: 0, placement: placedOn("node1")},
+						{main: 100, sub: 0, placement: placedOn("node3")},
 					},
 				}}},
-			revToPut:         Revision{main: 101, sub: 0, nodes: []string{"node2"}},
+			revToPut:         Revision{main: 101, sub: 0, placement: placedOn("node2")},
 			revToAssume:      98,
-			expectedModified: Revision{main: 100, sub: 0, nodes: []string{"node3"}},
+			expectedModified: Revision{main: 100, sub: 0, placement: placedOn("node3")},
 			expectedGenerations: []generation{
 				{
 					ver:     2,
-					created: Revision{main: 98, sub: 0, nodes: []string{"node1"}},
+					created: Revision{main: 98, sub: 0, placement: placedOn("node1")},
 					revs: []Revision{
-						{main: 98, sub: 0, nodes: []string{"node1"}},
-						{main: 100, sub: 0, nodes: []string{"node3"}},
+						{main: 98, sub: 0, placement: placedOn("node1")},
+						{main: 100, sub: 0, placement: placedOn("node3")},
 					},
 				},
 			},
@@ -151,7 +153,7 @@ func TestUpdate(t *testing.T) {
 
 	for _, tc := range tcs {
 		t.Run(tc.name, func(t *testing.T) {
-			err := tc.index.update(tc.revToPut.main, tc.revToPut.sub, tc.revToPut.GetNodes(), tc.revToAssume)
+			err := tc.index.update(tc.revToPut, tc.revToAssume)
 
 			if len(tc.expectedError) == 0 && err != nil {
 				t.Errorf("unexpected error: %v", err)
@@ -177,3 +179,9 @@ func TestUpdate(t *testing.T) {
 		})
 	}
 }
+
+var testPlacements = NewPlacementTable()
+
+func placedOn(node string) placementRef {
+	return testPlacements.intern(consistent.NamedPlacement([]string{node}, nil))
+}
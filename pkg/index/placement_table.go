@@ -0,0 +1,170 @@
+package index
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+// PlacementID identifies a placement within a version of the placement table; 0 is the empty placement of
+// the tombstones
+type PlacementID uint32
+
+// placementRef is what a revision keeps of its placement, along with the table of the index it is interned in
+type placementRef struct {
+	table   *PlacementTable
+	id      PlacementID
+	version uint32
+}
+
+// PlacementTable interns the placements of the revisions, so that the many revisions sharing the same
+// replicas keep a small ID instead of their own copy of the replicas.
+// The table only grows between membership changes. Compacting it drops the placements no revision uses any
+// longer and renumbers the others under a new version; the IDs of the previous version still resolve until
+// the next compaction, for the revisions read from the index before it.
+type PlacementTable struct {
+	mu       sync.RWMutex
+	current  *placementVersion
+	previous *placementVersion
+}
+
+type placementVersion struct {
+	version uint32
+	// placements are indexed by ID, the first one being the empty placement
+	placements []consistent.Placement
+	ids        map[string]PlacementID
+}
+
+func newPlacementVersion(version uint32) *placementVersion {
+	return &placementVersion{version: version, placements: []consistent.Placement{{}}, ids: make(map[string]PlacementID)}
+}
+
+func (v *placementVersion) intern(p consistent.Placement) PlacementID {
+	if p.IsEmpty() {
+		return 0
+	}
+	key := placementKey(p)
+	if id, ok := v.ids[key]; ok {
+		return id
+	}
+	id := PlacementID(len(v.placements))
+	v.placements = append(v.placements, consistent.Placement{
+		Sync:  append([]consistent.Replica(nil), p.Sync...),
+		Async: append([]consistent.Replica(nil), p.Async...),
+	})
+	v.ids[key] = id
+	return id
+}
+
+// placementKey tells the placements apart by their replicas, roles, locations and hints included
+func placementKey(p consistent.Placement) string {
+	var b strings.Builder
+	for _, r := range p.Replicas() {
+		b.WriteString(r.Node)
+		b.WriteByte(0)
+		b.WriteString(string(r.Role))
+		b.WriteByte(0)
+		b.WriteString(string(r.AvailabilityZone))
+		b.WriteByte(0)
+		b.WriteString(string(r.Region))
+		b.WriteByte(0)
+		b.WriteString(r.HintFor)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func NewPlacementTable() *PlacementTable {
+	return &PlacementTable{current: newPlacementVersion(1)}
+}
+
+// Version is the version of the IDs the new revisions get
+func (t *PlacementTable) Version() uint32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.version
+}
+
+// Len is the number of placements of the current version, the empty one aside
+func (t *PlacementTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.current.placements) - 1
+}
+
+// intern references the placement in the table; the empty placement needs no table
+func (t *PlacementTable) intern(p consistent.Placement) placementRef {
+	if p.IsEmpty() {
+		return placementRef{table: t}
+	}
+	key := placementKey(p)
+	t.mu.RLock()
+	id, ok := t.current.ids[key]
+	version := t.current.version
+	t.mu.RUnlock()
+	if ok {
+		return placementRef{table: t, id: id, version: version}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return placementRef{table: t, id: t.current.intern(p), version: t.current.version}
+}
+
+// get resolves a reference of the current or the previous version of its table; an older one resolves to no
+// replica
+func (ref placementRef) get() consistent.Placement {
+	if ref.id == 0 || ref.table == nil {
+		return consistent.Placement{}
+	}
+	ref.table.mu.RLock()
+	defer ref.table.mu.RUnlock()
+	p, _ := ref.table.getLocked(ref)
+	return p
+}
+
+func (t *PlacementTable) getLocked(ref placementRef) (consistent.Placement, bool) {
+	for _, v := range []*placementVersion{t.current, t.previous} {
+		if v != nil && v.version == ref.version && int(ref.id) < len(v.placements) {
+			return v.placements[ref.id], true
+		}
+	}
+	return consistent.Placement{}, false
+}
+
+// refresh moves a reference of the previous version to the current one, and a reference of another table
+// to this one
+func (t *PlacementTable) refresh(ref placementRef) placementRef {
+	if ref.id == 0 {
+		return placementRef{}
+	}
+	if ref.table != t {
+		return t.intern(ref.get())
+	}
+	t.mu.RLock()
+	current := ref.version == t.current.version
+	t.mu.RUnlock()
+	if current {
+		return ref
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.getLocked(ref); ok {
+		return placementRef{table: t, id: t.current.intern(p), version: t.current.version}
+	}
+	return ref
+}
+
+// compact interns the placements of the references walk passes into a new version and points the references
+// to it. The caller makes sure no other reference is stored meanwhile.
+func (t *PlacementTable) compact(walk func(visit func(ref *placementRef))) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	next := newPlacementVersion(t.current.version + 1)
+	walk(func(ref *placementRef) {
+		if p, ok := t.getLocked(*ref); ok {
+			*ref = placementRef{table: t, id: next.intern(p), version: next.version}
+		}
+	})
+	t.previous, t.current = t.current, next
+}
@@ -17,6 +17,9 @@ package index
 import (
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+
+	"github.com/regionless-storage-service/pkg/partition/consistent"
 )
 
 // revBytesLen is the byte length of a normal Revision.
@@ -35,11 +38,30 @@ type Revision struct {
 	// set.
 	sub int64
 
-	nodes []string
+	// placement is where the replicas of the value are, interned in the placement table of an index; a
+	// tombstone has none
+	placement placementRef
+
+	// checksum is the CRC-32C of the value as it was written, which the replicas are verified against; the
+	// revisions written before the checksums were recorded have none
+	checksum    uint32
+	hasChecksum bool
 }
 
-func NewRevision(main, sub int64, nodes []string) Revision {
-	return Revision{main: main, sub: sub, nodes: nodes}
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum is the CRC-32C the revisions record of their value
+func Checksum(value string) uint32 {
+	return crc32.Checksum([]byte(value), castagnoli)
+}
+
+// NewRevision interns the placement in the placement table of the index the revision is meant for. Without
+// a table, the placement gets one of its own, which the index moves it out of once the revision is stored.
+func NewRevision(placements *PlacementTable, main, sub int64, placement consistent.Placement) Revision {
+	if placements == nil && !placement.IsEmpty() {
+		placements = NewPlacementTable()
+	}
+	return Revision{main: main, sub: sub, placement: placements.intern(placement)}
 }
 func (a Revision) String() string {
 	return fmt.Sprintf("%d", a.main)
@@ -50,12 +72,42 @@ func (a Revision) GetMain() int64 {
 func (a Revision) GetSub() int64 {
 	return a.sub
 }
-func (a Revision) GetNodes() []string {
-	return a.nodes
+func (a Revision) GetPlacement() consistent.Placement {
+	return a.placement.get()
+}
+
+// IsTombstone tells the revisions deleting their key, which have no value and so no placement
+func (a Revision) IsTombstone() bool {
+	return a.placement.id == 0
+}
+
+// SetPlacement interns the placement in the table the revision was made with
+func (a *Revision) SetPlacement(p consistent.Placement) {
+	a.placement = a.placement.table.intern(p)
 }
-func (a *Revision) SetNodes(ns []string) {
-	a.nodes = ns
+
+// SetChecksum records the checksum of the value written for the revision
+func (a *Revision) SetChecksum(value string) {
+	a.checksum, a.hasChecksum = Checksum(value), true
 }
+
+// GetChecksum returns the checksum recorded of the value, if any
+func (a Revision) GetChecksum() (uint32, bool) {
+	return a.checksum, a.hasChecksum
+}
+
+// GetPlacementID returns the ID of the placement in the placement table and the version of the table it is of
+func (a Revision) GetPlacementID() (PlacementID, uint32) {
+	return a.placement.id, a.placement.version
+}
+
+// BucketKey is the placement key of the revision; the revisions of the same bucket are placed on the same nodes
+func (a Revision) BucketKey(bucketSize int64) []byte {
+	bucketKey := make([]byte, 8)
+	binary.LittleEndian.PutUint64(bucketKey, uint64(a.main/bucketSize))
+	return bucketKey
+}
+
 func (a Revision) GreaterThan(b Revision) bool {
 	if a.main > b.main {
 		return true
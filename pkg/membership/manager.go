@@ -0,0 +1,492 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/monitor"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/pkg/placement"
+	"k8s.io/klog"
+)
+
+var (
+	ErrMigrationInProgress = errors.New("another store migration is in progress")
+	ErrStoreNotFound       = errors.New("store not found")
+)
+
+type Action string
+
+const (
+	ActionAdd    Action = "add"
+	ActionDrain  Action = "drain"
+	ActionRemove Action = "remove"
+	// ActionHandoff moves back to a recovered store the revisions written to its substitutes meanwhile
+	ActionHandoff Action = "handoff"
+)
+
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+)
+
+// Migration reports the progress of moving the revisions affected by a store change
+type Migration struct {
+	ID     int
+	Action Action
+	Store  string
+	State  State
+	// Total is the number of revisions whose placement changes; Moved and Failed add up to it once done
+	Total      int
+	Moved      int
+	Failed     int
+	LastError  string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Placements is the number of distinct placements left in the index once the revisions are moved
+	Placements int
+}
+
+// StoreStatus describes a store of the cluster
+type StoreStatus struct {
+	config.KVStore
+	NodeName string
+	Draining bool
+}
+
+// Resolver returns the backend database of the named node
+type Resolver func(name string) (database.Database, error)
+
+// Manager adds, drains and removes stores at runtime. The revisions whose placement changes are moved in the
+// background, one store change at a time, and their index entries are updated to the new nodes.
+type Manager struct {
+	mu         sync.Mutex
+	conf       *config.KVConfiguration
+	hm         consistent.HashingManager
+	monitor    *monitor.LatencyMonitor
+	indexTree  index.Index
+	resolve    Resolver
+	draining   map[string]bool
+	migrations []*Migration
+	running    bool
+	done       chan struct{}
+	onRemove   func(name string) error
+}
+
+func NewManager(conf *config.KVConfiguration, hm consistent.HashingManager, lm *monitor.LatencyMonitor, indexTree index.Index, resolve Resolver) *Manager {
+	return &Manager{conf: conf, hm: hm, monitor: lm, indexTree: indexTree, resolve: resolve, draining: make(map[string]bool)}
+}
+
+// OnRemove sets the hook called with the node name of a store once it is removed, e.g. to drop the writes
+// still queued for it
+func (m *Manager) OnRemove(hook func(name string) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onRemove = hook
+}
+
+// Stores lists the stores sorted by name
+func (m *Manager) Stores() []StoreStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stores := m.conf.StoreList()
+	out := make([]StoreStatus, 0, len(stores))
+	for _, store := range stores {
+		out = append(out, StoreStatus{KVStore: store, NodeName: m.conf.NodeName(store), Draining: m.draining[store.Name]})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Migrations reports all the migrations since the start, the latest last
+func (m *Manager) Migrations() []Migration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Migration, 0, len(m.migrations))
+	for _, job := range m.migrations {
+		out = append(out, *job)
+	}
+	return out
+}
+
+// Wait blocks until the running migration, if any, is done
+func (m *Manager) Wait(ctx context.Context) error {
+	m.mu.Lock()
+	done := m.done
+	m.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AddStore makes a new store available for placement once its latency is measured, and moves to it the
+// revisions it now owns. The store is measured in the background, the migration counting its revisions once
+// it is done.
+func (m *Manager) AddStore(store config.KVStore) (Migration, error) {
+	job, err := m.begin(ActionAdd, store.Name)
+	if err != nil {
+		return Migration{}, err
+	}
+	if err := m.add(store); err != nil {
+		m.finish(job, err)
+		return m.snapshot(job), err
+	}
+	snapshot := m.snapshot(job)
+	go func() {
+		if err := m.measure(store); err != nil {
+			m.finish(job, err)
+			return
+		}
+		moves := m.plan(touching(m.conf.NodeName(store)))
+		m.mu.Lock()
+		job.Total = len(moves)
+		m.mu.Unlock()
+		m.migrate(job, moves)
+	}()
+	return snapshot, nil
+}
+
+func (m *Manager) add(store config.KVStore) error {
+	if _, ok := m.conf.FindStore(store.Name); ok {
+		return fmt.Errorf("the store %s already exists", store.Name)
+	}
+	if _, err := database.Register(m.conf.StoreType, &store); err != nil {
+		return err
+	}
+	if err := m.conf.AddStore(store); err != nil {
+		database.Unregister(store.Name)
+		return err
+	}
+	return nil
+}
+
+// measure probes the stores, so that the new one is classified, and takes it back if it cannot be measured
+func (m *Manager) measure(store config.KVStore) error {
+	m.monitor.Probe()
+	name := m.conf.NodeName(store)
+	for _, s := range m.monitor.Snapshot() {
+		if s.Name == name && s.Samples > 0 {
+			return nil
+		}
+	}
+	// a store which cannot be measured cannot be classified, so it is not added
+	m.conf.RemoveStore(store.Name)
+	database.Unregister(store.Name)
+	m.monitor.Probe()
+	return fmt.Errorf("failed to measure the latency of the store %s", store.Name)
+}
+
+// DrainStore stops placing new revisions on a store and moves its revisions to the other stores; the store
+// keeps serving the reads of the revisions not moved yet
+func (m *Manager) DrainStore(name string) (Migration, error) {
+	return m.drainAndRun(ActionDrain, name)
+}
+
+// RemoveStore drains a store and, once all its revisions are moved, removes it
+func (m *Manager) RemoveStore(name string) (Migration, error) {
+	return m.drainAndRun(ActionRemove, name)
+}
+
+func (m *Manager) drainAndRun(action Action, name string) (Migration, error) {
+	store, ok := m.conf.FindStore(name)
+	if !ok {
+		return Migration{}, fmt.Errorf("%w: %s", ErrStoreNotFound, name)
+	}
+	job, err := m.begin(action, name)
+	if err != nil {
+		return Migration{}, err
+	}
+	m.mu.Lock()
+	m.draining[store.Name] = true
+	m.mu.Unlock()
+	m.monitor.SetDraining(m.conf.NodeName(store), true)
+	return m.run(job, m.plan(touching(m.conf.NodeName(store)))), nil
+}
+
+// HandOff moves the revisions hinted for the store of the given node name, written to other stores while it
+// was unhealthy, back to their placement now that it has recovered
+func (m *Manager) HandOff(node string) (Migration, error) {
+	var store config.KVStore
+	found := false
+	for _, s := range m.conf.StoreList() {
+		if m.conf.NodeName(s) == node {
+			store, found = s, true
+			break
+		}
+	}
+	if !found {
+		return Migration{}, fmt.Errorf("%w: %s", ErrStoreNotFound, node)
+	}
+	job, err := m.begin(ActionHandoff, store.Name)
+	if err != nil {
+		return Migration{}, err
+	}
+	return m.run(job, m.plan(hintedFor(node))), nil
+}
+
+func (m *Manager) remove(name string) error {
+	store, ok := m.conf.FindStore(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrStoreNotFound, name)
+	}
+	if err := m.conf.RemoveStore(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.draining, name)
+	hook := m.onRemove
+	m.mu.Unlock()
+	m.monitor.Probe()
+	if err := database.Unregister(name); err != nil {
+		return err
+	}
+	if hook != nil {
+		return hook(m.conf.NodeName(store))
+	}
+	return nil
+}
+
+func (m *Manager) begin(action Action, store string) (*Migration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running {
+		return nil, ErrMigrationInProgress
+	}
+	job := &Migration{ID: len(m.migrations) + 1, Action: action, Store: store, State: StateRunning, StartedAt: time.Now()}
+	m.migrations = append(m.migrations, job)
+	m.running = true
+	m.done = make(chan struct{})
+	return job, nil
+}
+
+func (m *Manager) finish(job *Migration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.State = StateSucceeded
+	if err != nil {
+		job.State, job.LastError = StateFailed, err.Error()
+	} else if job.Failed > 0 {
+		job.State = StateFailed
+	}
+	job.FinishedAt = time.Now()
+	m.running = false
+	close(m.done)
+	klog.Infof("the %s of the store %s %s: %d of %d revisions moved", job.Action, job.Store, job.State, job.Moved, job.Total)
+}
+
+func (m *Manager) snapshot(job *Migration) Migration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *job
+}
+
+// run moves the revisions in the background
+func (m *Manager) run(job *Migration, moves []move) Migration {
+	m.mu.Lock()
+	job.Total = len(moves)
+	m.mu.Unlock()
+	snapshot := m.snapshot(job)
+	go m.migrate(job, moves)
+	return snapshot
+}
+
+// migrate moves the revisions and finishes the job
+func (m *Manager) migrate(job *Migration, moves []move) {
+	for _, mv := range moves {
+		err := m.move(mv)
+		m.mu.Lock()
+		if err != nil {
+			job.Failed++
+			job.LastError = err.Error()
+		} else {
+			job.Moved++
+		}
+		m.mu.Unlock()
+		if err != nil {
+			klog.Warningf("failed to move the revision %s of the key %s from %v to %v: %v", mv.rev.String(), string(mv.key), mv.rev.GetPlacement(), mv.target, err)
+		}
+	}
+	var err error
+	if job.Action == ActionRemove && m.snapshot(job).Failed == 0 {
+		err = m.remove(job.Store)
+	}
+	// the placements the moved revisions left are dropped from the placement table
+	placements := m.indexTree.CompactPlacements(context.Background())
+	m.mu.Lock()
+	job.Placements = placements
+	m.mu.Unlock()
+	m.finish(job, err)
+}
+
+type move struct {
+	key    []byte
+	rev    index.Revision
+	target consistent.Placement
+}
+
+// touching selects the revisions whose current or new placement includes the node
+func touching(node string) func(current, next consistent.Placement) bool {
+	return func(current, next consistent.Placement) bool {
+		return contains(current.Nodes(), node) || contains(next.Nodes(), node)
+	}
+}
+
+// hintedFor selects the revisions with a replica standing in for the node
+func hintedFor(node string) func(current, next consistent.Placement) bool {
+	return func(current, next consistent.Placement) bool {
+		for _, r := range current.Hinted() {
+			if r.HintFor == node {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// plan lists the moves of the selected revisions whose placement changes
+func (m *Manager) plan(selected func(current, next consistent.Placement) bool) []move {
+	type entry struct {
+		key []byte
+		rev index.Revision
+	}
+	entries := make([]entry, 0)
+	m.indexTree.Walk(context.Background(), func(key []byte, rev index.Revision) bool {
+		// tombstones do not have a value to move
+		if !rev.GetPlacement().IsEmpty() {
+			entries = append(entries, entry{key: key, rev: rev})
+		}
+		return true
+	})
+
+	moves := make([]move, 0)
+	for _, e := range entries {
+		target, err := m.hm.GetPlacementForKey(e.key, placement.Key(m.conf, e.key, e.rev))
+		if err != nil {
+			klog.Warningf("failed to place the revision %s of the key %s: %v", e.rev.String(), string(e.key), err)
+			continue
+		}
+		current := e.rev.GetPlacement()
+		if !selected(current, target) {
+			continue
+		}
+		// a revision on the same stores still moves to drop the hints it no longer needs
+		if sameNodes(current.Nodes(), target.Nodes()) && sameHints(current, target) {
+			continue
+		}
+		moves = append(moves, move{key: e.key, rev: e.rev, target: target})
+	}
+	return moves
+}
+
+// move copies the value of a revision to its new nodes, points the index to them, and then deletes the value
+// from the nodes it left
+func (m *Manager) move(mv move) error {
+	current, next := mv.rev.GetPlacement().Nodes(), mv.target.Nodes()
+	key := mv.rev.String()
+	value, err := m.read(current, key)
+	if err != nil {
+		return err
+	}
+	written := make([]string, 0)
+	for _, name := range difference(next, current) {
+		db, err := m.resolve(name)
+		if err == nil {
+			_, err = db.Put(key, value)
+		}
+		if err != nil {
+			m.cleanup(written, key)
+			return fmt.Errorf("failed to copy to %s: %v", name, err)
+		}
+		written = append(written, name)
+	}
+	if err := m.indexTree.SetPlacement(context.Background(), mv.key, index.NewRevision(m.indexTree.Placements(), mv.rev.GetMain(), mv.rev.GetSub(), mv.target)); err != nil {
+		m.cleanup(written, key)
+		return err
+	}
+	m.cleanup(difference(current, next), key)
+	return nil
+}
+
+func (m *Manager) read(nodes []string, key string) (string, error) {
+	var lastErr error = database.ErrKeyNotFound
+	for _, name := range nodes {
+		db, err := m.resolve(name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		value, err := db.Get(key)
+		if err == nil {
+			return value, nil
+		}
+		lastErr = err
+	}
+	return "", fmt.Errorf("failed to read from any of %v: %w", nodes, lastErr)
+}
+
+func (m *Manager) cleanup(nodes []string, key string) {
+	for _, name := range nodes {
+		db, err := m.resolve(name)
+		if err == nil {
+			err = db.Delete(key)
+		}
+		if err != nil {
+			klog.Warningf("failed to delete the revision %s from %s: %v", key, name, err)
+		}
+	}
+}
+
+func sameNodes(a, b []string) bool {
+	return len(difference(a, b)) == 0 && len(difference(b, a)) == 0
+}
+
+func sameHints(a, b consistent.Placement) bool {
+	ah, bh := a.Hinted(), b.Hinted()
+	if len(ah) != len(bh) {
+		return false
+	}
+	for i := range ah {
+		if ah[i].Node != bh[i].Node || ah[i].HintFor != bh[i].HintFor {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(nodes []string, name string) bool {
+	for _, n := range nodes {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// difference returns the nodes of a missing from b
+func difference(a, b []string) []string {
+	out := make([]string, 0)
+	for _, n := range a {
+		if !contains(b, n) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,414 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+	"k8s.io/klog"
+)
+
+const (
+	DefaultWindow = 5
+	// DefaultUnhealthyAfterFailures is the number of consecutive failed probes after which a store is unhealthy
+	DefaultUnhealthyAfterFailures = 3
+	// hysteresis keeps a store whose latency hovers around the threshold from flapping between local and remote:
+	// a local store becomes remote above threshold*(1+hysteresis) and a remote store local below threshold*(1-hysteresis)
+	hysteresis = 0.1
+)
+
+// StoreStats are the rolling latency statistics of a store
+type StoreStats struct {
+	Name             string
+	AvailabilityZone constants.AvailabilityZone
+	Remote           bool
+	// Draining stores are measured but no longer given new revisions
+	Draining bool
+	// Healthy stores answer the probes; an unhealthy store is left out of the placement until a probe succeeds
+	Healthy             bool
+	ConsecutiveFailures int
+	// CircuitOpen stores keep failing the requests; they are left out of the placement as the unhealthy ones
+	CircuitOpen    bool
+	Last           time.Duration
+	Mean           time.Duration
+	Min            time.Duration
+	Max            time.Duration
+	Samples        int
+	Failures       int
+	LastError      string
+	LastMeasuredAt time.Time
+}
+
+type storeStats struct {
+	weight  int
+	region  constants.Region
+	remote  bool
+	samples []time.Duration
+	stats   StoreStats
+}
+
+// LatencyMonitor periodically measures the latency to every store and moves the stores between the local
+// and the remote ones of the hashing manager as the network conditions change. It is the one keeping the
+// stores of the hashing manager up to date, including when stores are added, drained or removed, and telling
+// it which stores fail to answer.
+type LatencyMonitor struct {
+	// probeMu serializes the probes
+	probeMu        sync.Mutex
+	mu             sync.RWMutex
+	conf           *config.KVConfiguration
+	hm             consistent.HashingManager
+	interval       time.Duration
+	window         int
+	unhealthyAfter int
+	stores         map[string]*storeStats
+	// recovered are the stores back to health whose recovery hook did not succeed yet
+	recovered  map[string]bool
+	onRecovery func(name string) error
+	// recovering is set while the recovery hooks run in the background, one run at a time
+	recovering bool
+	recoveries sync.WaitGroup
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewLatencyMonitor starts from the classification the hashing manager was built with
+func NewLatencyMonitor(conf *config.KVConfiguration, hm consistent.HashingManager, localStores map[constants.AvailabilityZone][]consistent.RkvNode, remoteStores []consistent.RkvNode, interval time.Duration, window int) *LatencyMonitor {
+	if window < 1 {
+		window = DefaultWindow
+	}
+	initial := make(map[string]time.Duration)
+	for _, nodes := range localStores {
+		for _, node := range nodes {
+			initial[node.Name] = node.Latency
+		}
+	}
+	remote := make(map[string]bool)
+	for _, node := range remoteStores {
+		initial[node.Name] = node.Latency
+		remote[node.Name] = true
+	}
+	unhealthyAfter := conf.UnhealthyAfterFailures
+	if unhealthyAfter < 1 {
+		unhealthyAfter = DefaultUnhealthyAfterFailures
+	}
+	lm := &LatencyMonitor{conf: conf, hm: hm, interval: interval, window: window, unhealthyAfter: unhealthyAfter,
+		stores: make(map[string]*storeStats), recovered: make(map[string]bool)}
+	for _, store := range conf.StoreList() {
+		s := lm.newStoreStats(store)
+		s.remote, s.stats.Remote = remote[s.stats.Name], remote[s.stats.Name]
+		if l, ok := initial[s.stats.Name]; ok {
+			s.record(l, window)
+		}
+	}
+	return lm
+}
+
+func (lm *LatencyMonitor) newStoreStats(store config.KVStore) *storeStats {
+	s := &storeStats{weight: store.Weight, region: store.GetRegion()}
+	s.stats.Name, s.stats.AvailabilityZone = lm.conf.NodeName(store), store.AvailabilityZone
+	s.stats.Healthy = true
+	lm.stores[s.stats.Name] = s
+	return s
+}
+
+// OnRecovery sets the hook called with the node name of a store once it is healthy again, e.g. to hand the
+// revisions written to its substitutes back to it. A failed call is retried after the next probes.
+func (lm *LatencyMonitor) OnRecovery(hook func(name string) error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.onRecovery = hook
+}
+
+// Start probes the stores every interval until Stop is called
+func (lm *LatencyMonitor) Start() {
+	if lm.interval <= 0 {
+		return
+	}
+	lm.stop, lm.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(lm.done)
+		ticker := time.NewTicker(lm.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lm.stop:
+				return
+			case <-ticker.C:
+				lm.Probe()
+			}
+		}
+	}()
+}
+
+// Stop waits for the running probe to finish
+func (lm *LatencyMonitor) Stop() {
+	if lm.stop == nil {
+		return
+	}
+	close(lm.stop)
+	<-lm.done
+	lm.stop = nil
+	lm.WaitRecovery()
+}
+
+// WaitRecovery blocks until the recovery hooks running in the background return
+func (lm *LatencyMonitor) WaitRecovery() {
+	lm.recoveries.Wait()
+}
+
+// Probe measures every store of the configuration once and updates the hashing manager if a store was added,
+// removed or moved, if its latency changed, or if it became unhealthy or healthy again
+func (lm *LatencyMonitor) Probe() {
+	lm.probeMu.Lock()
+	defer lm.probeMu.Unlock()
+
+	// the measurement is done without the lock as it may take a while for unreachable stores
+	stores := lm.conf.StoreList()
+	latencies := make([]time.Duration, len(stores))
+	errs := make([]error, len(stores))
+	var wg sync.WaitGroup
+	for i, store := range stores {
+		wg.Add(1)
+		go func(i int, store config.KVStore) {
+			defer wg.Done()
+			latencies[i], errs[i] = lm.conf.MeasureLatency(store)
+		}(i, store)
+	}
+	wg.Wait()
+
+	lm.mu.Lock()
+	changed, healthChanged := false, false
+	now := time.Now()
+	seen := make(map[string]bool, len(stores))
+	for i, store := range stores {
+		name := lm.conf.NodeName(store)
+		seen[name] = true
+		s, ok := lm.stores[name]
+		if !ok {
+			s = lm.newStoreStats(store)
+		}
+		s.stats.LastMeasuredAt = now
+		if s.weight != store.Weight {
+			s.weight = store.Weight
+			changed = true
+		}
+		if errs[i] != nil {
+			s.stats.Failures++
+			s.stats.ConsecutiveFailures++
+			s.stats.LastError = errs[i].Error()
+			klog.V(4).Infof("failed to measure the latency of %s: %v", name, errs[i])
+			if s.stats.Healthy && s.stats.ConsecutiveFailures >= lm.unhealthyAfter {
+				klog.Warningf("store %s is unhealthy after %d failed probes: %v", name, s.stats.ConsecutiveFailures, errs[i])
+				s.stats.Healthy = false
+				delete(lm.recovered, name)
+				healthChanged = true
+			}
+			continue
+		}
+		s.stats.ConsecutiveFailures = 0
+		if !s.stats.Healthy {
+			klog.Infof("store %s is healthy again", name)
+			s.stats.Healthy = true
+			if !s.stats.CircuitOpen {
+				lm.recovered[name] = true
+			}
+			healthChanged = true
+		}
+		mean := s.stats.Mean
+		first := s.stats.Samples == 0
+		s.record(latencies[i], lm.window)
+		if first || s.stats.Mean != mean {
+			changed = true
+		}
+		if remote := lm.isRemote(s, first); remote != s.remote {
+			klog.Infof("store %s with the latency %v is now remote: %t", name, s.stats.Mean, remote)
+			s.remote, s.stats.Remote = remote, remote
+			changed = true
+		}
+	}
+	for name := range lm.stores {
+		if !seen[name] {
+			if !lm.stores[name].stats.Healthy || lm.stores[name].stats.CircuitOpen {
+				healthChanged = true
+			}
+			delete(lm.stores, name)
+			delete(lm.recovered, name)
+			changed = true
+		}
+	}
+	lm.mu.Unlock()
+
+	if healthChanged {
+		lm.updateHealth()
+	}
+	if changed {
+		lm.update()
+	}
+	lm.recover()
+}
+
+// SetCircuitOpen leaves a store, given by its node name, out of the placement while its circuit is open. Once
+// the circuit closes, the recovery hook is called after the next probe.
+func (lm *LatencyMonitor) SetCircuitOpen(name string, open bool) {
+	lm.mu.Lock()
+	s, ok := lm.stores[name]
+	if !ok || s.stats.CircuitOpen == open {
+		lm.mu.Unlock()
+		return
+	}
+	s.stats.CircuitOpen = open
+	if !open && s.stats.Healthy {
+		lm.recovered[name] = true
+	}
+	lm.mu.Unlock()
+	lm.updateHealth()
+}
+
+// SetDraining stops or resumes placing new revisions on a store, given by its node name
+func (lm *LatencyMonitor) SetDraining(name string, draining bool) {
+	lm.mu.Lock()
+	s, ok := lm.stores[name]
+	if !ok || s.stats.Draining == draining {
+		lm.mu.Unlock()
+		return
+	}
+	s.stats.Draining = draining
+	lm.mu.Unlock()
+	lm.update()
+}
+
+// Snapshot returns the statistics of the stores sorted by name
+func (lm *LatencyMonitor) Snapshot() []StoreStats {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	stats := make([]StoreStats, 0, len(lm.stores))
+	for _, s := range lm.stores {
+		stats = append(stats, s.stats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// isRemote applies the hysteresis, except to the first measurement of a store
+func (lm *LatencyMonitor) isRemote(s *storeStats, first bool) bool {
+	threshold := float64(time.Duration(lm.conf.RemoteStoreLatencyThresholdInMilliSec) * time.Millisecond)
+	switch {
+	case first:
+		return float64(s.stats.Mean) >= threshold
+	case s.remote:
+		return float64(s.stats.Mean) >= threshold*(1-hysteresis)
+	default:
+		return float64(s.stats.Mean) >= threshold*(1+hysteresis)
+	}
+}
+
+// update hands the current stores to the hashing manager. It holds the write lock, which makes the concurrent
+// updates take their snapshot and apply it one after the other, so that a stale snapshot is never applied last.
+func (lm *LatencyMonitor) update() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	localStores, remoteStores := lm.nodes()
+	lm.hm.UpdateStores(localStores, remoteStores)
+}
+
+// updateHealth hands the unhealthy stores and the ones with an open circuit to the hashing manager, under the
+// write lock as update does
+func (lm *LatencyMonitor) updateHealth() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	unhealthy := make([]string, 0)
+	for name, s := range lm.stores {
+		if !s.stats.Healthy || s.stats.CircuitOpen {
+			unhealthy = append(unhealthy, name)
+		}
+	}
+	sort.Strings(unhealthy)
+	lm.hm.SetUnhealthyStores(unhealthy)
+}
+
+// recover calls the recovery hook in the background for the stores back to health, keeping the ones it failed
+// for, so that a slow hook does not hold the probes. The stores recovered while the hook runs wait for the
+// next probe.
+func (lm *LatencyMonitor) recover() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	hook := lm.onRecovery
+	if hook == nil || lm.recovering || len(lm.recovered) == 0 {
+		return
+	}
+	names := make([]string, 0, len(lm.recovered))
+	for name := range lm.recovered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lm.recovering = true
+	lm.recoveries.Add(1)
+	go func() {
+		defer lm.recoveries.Done()
+		for _, name := range names {
+			if err := hook(name); err != nil {
+				klog.Warningf("failed to recover the store %s, retrying after the next probe: %v", name, err)
+				continue
+			}
+			lm.mu.Lock()
+			delete(lm.recovered, name)
+			lm.mu.Unlock()
+		}
+		lm.mu.Lock()
+		lm.recovering = false
+		lm.mu.Unlock()
+	}()
+}
+
+// nodes lists the stores with at least one successful measurement, leaving out the draining ones
+func (lm *LatencyMonitor) nodes() (map[constants.AvailabilityZone][]consistent.RkvNode, []consistent.RkvNode) {
+	names := make([]string, 0, len(lm.stores))
+	for name := range lm.stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	localStores := make(map[constants.AvailabilityZone][]consistent.RkvNode)
+	remoteStores := make([]consistent.RkvNode, 0)
+	for _, name := range names {
+		s := lm.stores[name]
+		if s.stats.Samples == 0 || s.stats.Draining {
+			continue
+		}
+		node := consistent.RkvNode{Name: s.stats.Name, Latency: s.stats.Mean, IsRemote: s.remote, Weight: s.weight,
+			Region: s.region, AvailabilityZone: s.stats.AvailabilityZone}
+		if s.remote {
+			remoteStores = append(remoteStores, node)
+		} else {
+			localStores[s.stats.AvailabilityZone] = append(localStores[s.stats.AvailabilityZone], node)
+		}
+	}
+	return localStores, remoteStores
+}
+
+func (s *storeStats) record(latency time.Duration, window int) {
+	s.samples = append(s.samples, latency)
+	if len(s.samples) > window {
+		s.samples = s.samples[len(s.samples)-window:]
+	}
+	var sum time.Duration
+	s.stats.Min, s.stats.Max = s.samples[0], s.samples[0]
+	for _, l := range s.samples {
+		sum += l
+		if l < s.stats.Min {
+			s.stats.Min = l
+		}
+		if l > s.stats.Max {
+			s.stats.Max = l
+		}
+	}
+	// the mean is kept at the millisecond granularity of the threshold so that noise does not rebuild the rings
+	s.stats.Mean = (sum / time.Duration(len(s.samples))).Truncate(time.Millisecond)
+	s.stats.Last = latency
+	s.stats.Samples++
+	s.stats.LastError = ""
+}
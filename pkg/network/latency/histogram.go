@@ -0,0 +1,171 @@
+package latency
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Histogram records latencies in log-linear buckets in the manner of an HDR histogram: the values are kept
+// to a fixed number of significant digits whatever their magnitude, so that the tail of the distribution is
+// as precise as its bulk while the memory stays bounded
+type Histogram struct {
+	subBucketBits  int
+	subBucketCount int64
+	subBucketHalf  int64
+	counts         []int64
+	total          int64
+	min            int64
+	max            int64
+	sum            float64
+}
+
+// Bucket is a range of values and the number of values recorded in it
+type Bucket struct {
+	From  int64
+	To    int64
+	Count int64
+}
+
+// NewHistogram keeps the given number of significant decimal digits, from 1 to 5
+func NewHistogram(significantDigits int) (*Histogram, error) {
+	if significantDigits < 1 || significantDigits > 5 {
+		return nil, fmt.Errorf("the significant digits %d are not within [1, 5]", significantDigits)
+	}
+	largest := 2 * int64(math.Pow10(significantDigits))
+	subBucketBits := bits.Len64(uint64(largest - 1))
+	return &Histogram{
+		subBucketBits:  subBucketBits,
+		subBucketCount: 1 << subBucketBits,
+		subBucketHalf:  1 << (subBucketBits - 1),
+		min:            math.MaxInt64,
+	}, nil
+}
+
+// Record adds a value; negative values are recorded as 0
+func (h *Histogram) Record(value int64) {
+	if value < 0 {
+		value = 0
+	}
+	i := h.index(value)
+	if i >= len(h.counts) {
+		counts := make([]int64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += float64(value)
+	if value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+}
+
+// Merge adds all the values recorded by another histogram with the same precision
+func (h *Histogram) Merge(other *Histogram) error {
+	if other.subBucketBits != h.subBucketBits {
+		return fmt.Errorf("cannot merge histograms with different precisions")
+	}
+	if len(other.counts) > len(h.counts) {
+		counts := make([]int64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.total += other.total
+	h.sum += other.sum
+	if other.total > 0 {
+		if other.min < h.min {
+			h.min = other.min
+		}
+		if other.max > h.max {
+			h.max = other.max
+		}
+	}
+	return nil
+}
+
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+func (h *Histogram) Min() int64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+func (h *Histogram) Mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+// ValueAtQuantile returns the highest value equivalent to the one at the given quantile within [0, 1]
+func (h *Histogram) ValueAtQuantile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	q = math.Max(0, math.Min(1, q))
+	target := int64(math.Ceil(q * float64(h.total)))
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			_, to := h.bounds(i)
+			if to > h.max {
+				return h.max
+			}
+			return to
+		}
+	}
+	return h.max
+}
+
+// Buckets returns the non empty buckets in ascending order
+func (h *Histogram) Buckets() []Bucket {
+	out := make([]Bucket, 0)
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		from, to := h.bounds(i)
+		out = append(out, Bucket{From: from, To: to, Count: c})
+	}
+	return out
+}
+
+// index maps the values below subBucketCount one to one and the larger ones to subBucketHalf buckets
+// per power of 2
+func (h *Histogram) index(value int64) int {
+	if value < h.subBucketCount {
+		return int(value)
+	}
+	shift := bits.Len64(uint64(value)) - h.subBucketBits
+	sub := value >> uint(shift)
+	return int(h.subBucketCount + int64(shift-1)*h.subBucketHalf + sub - h.subBucketHalf)
+}
+
+func (h *Histogram) bounds(i int) (int64, int64) {
+	if int64(i) < h.subBucketCount {
+		return int64(i), int64(i)
+	}
+	offset := int64(i) - h.subBucketCount
+	shift := uint(offset/h.subBucketHalf + 1)
+	sub := offset%h.subBucketHalf + h.subBucketHalf
+	return sub << shift, (sub+1)<<shift - 1
+}
@@ -23,7 +23,8 @@ func (m *Measurements) InsuccessCount() (out int) {
 	}
 	return
 }
-func (m *Measurements) AllSeconds() []int64 {
+// AllNanoseconds returns the latencies of all the samples in nanoseconds, in the order they were taken
+func (m *Measurements) AllNanoseconds() []int64 {
 	out := make([]int64, len(*m))
 	for i, s := range *m {
 		out[i] = s.Duration.Nanoseconds()
@@ -32,7 +33,8 @@ func (m *Measurements) AllSeconds() []int64 {
 	return out
 }
 
-func (m *Measurements) SuccessSeconds() []int64 {
+// SuccessNanoseconds returns the latencies of the successful samples in nanoseconds, in the order they were taken
+func (m *Measurements) SuccessNanoseconds() []int64 {
 	out := make([]int64, 0, len(*m))
 	for _, s := range *m {
 		if s.Success {
@@ -0,0 +1,95 @@
+package latency
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/constants"
+)
+
+// Target identifies the store to probe
+type Target struct {
+	StoreType constants.StoreType
+	Name      string
+	Host      string
+	Port      int
+}
+
+func (t Target) Address() string {
+	return fmt.Sprintf("%s:%d", t.Host, t.Port)
+}
+
+// Probe does one round trip to a store
+type Probe interface {
+	Do() error
+}
+
+// ProbeFunc adapts a function to a Probe
+type ProbeFunc func() error
+
+func (f ProbeFunc) Do() error {
+	return f()
+}
+
+// ProbeFactory creates the probe of a target
+type ProbeFactory func(target Target) (Probe, error)
+
+var (
+	probesMu sync.RWMutex
+	probes   = map[constants.ProbeType]ProbeFactory{
+		constants.ProbeTCP: func(target Target) (Probe, error) {
+			return tcpProbe(target.Address()), nil
+		},
+		constants.ProbeNoop: func(target Target) (Probe, error) {
+			return ProbeFunc(func() error { return nil }), nil
+		},
+	}
+)
+
+// RegisterProbe makes a probe type available; the probes needing the backend databases are registered by them
+func RegisterProbe(probeType constants.ProbeType, factory ProbeFactory) {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+	probes[probeType] = factory
+}
+
+// NewProbe creates a probe of the given type
+func NewProbe(probeType constants.ProbeType, target Target) (Probe, error) {
+	probesMu.RLock()
+	factory, ok := probes[probeType]
+	probesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown probe type %q", probeType)
+	}
+	return factory(target)
+}
+
+// DefaultProbeType is the probe measuring what a database call to the given type of store costs
+func DefaultProbeType(storeType constants.StoreType) constants.ProbeType {
+	switch storeType {
+	case constants.Redis:
+		return constants.ProbePing
+	case constants.Memory:
+		return constants.ProbeNoop
+	default:
+		return constants.ProbeTCP
+	}
+}
+
+// Measure runs the probe count times, one round trip after the other so that they do not queue behind each
+// other, and summarizes how long the round trips took
+func Measure(probe Probe, count int) (Output, error) {
+	var out Output
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		err := probe.Do()
+		out.Measurements.Append(Sample{
+			Success:  err == nil,
+			Duration: time.Since(start),
+		})
+	}
+	summary, err := out.Measurements.Summary()
+	out.Summary = summary
+	return out, err
+}
@@ -1,18 +1,40 @@
 package latency
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"sort"
+)
 
-type distribution struct {
+// HistogramSignificantDigits is the precision of the histogram kept in a summary
+const HistogramSignificantDigits = 3
+
+// Distribution describes a set of latencies in nanoseconds
+type Distribution struct {
+	Count   int
 	Average int64
+	Min     int64
+	Max     int64
+	P50     int64
+	P90     int64
+	P99     int64
+	StdDev  int64
+	// Jitter is the mean difference between consecutive successful latencies in the order they were taken,
+	// only set for the successful ones as the failed ones end on a timeout
+	Jitter int64
 }
 
-type summary struct {
-	All     *distribution
-	Success *distribution
+type Summary struct {
+	All     *Distribution
+	Success *Distribution
+	// LossRate is the share of the connections that failed
+	LossRate float64
+	// Histogram keeps the successful latencies
+	Histogram *Histogram
 }
 
-func average(x []int64) (out distribution) {
-
+func distribution(x []int64) (out Distribution) {
+	out.Count = len(x)
 	if len(x) == 0 {
 		return
 	}
@@ -22,19 +44,63 @@ func average(x []int64) (out distribution) {
 		sum += v
 	}
 	out.Average = sum / int64(len(x))
+
+	var variance float64
+	for _, v := range x {
+		d := float64(v - out.Average)
+		variance += d * d
+	}
+	out.StdDev = int64(math.Sqrt(variance / float64(len(x))))
+
+	sorted := append([]int64(nil), x...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	out.Min, out.Max = sorted[0], sorted[len(sorted)-1]
+	out.P50 = percentile(sorted, 50)
+	out.P90 = percentile(sorted, 90)
+	out.P99 = percentile(sorted, 99)
 	return
 }
 
-func (m *Measurements) Summary() (summary, error) {
-	all := average(m.AllSeconds())
-	var out summary
+// jitter is the mean difference between consecutive latencies
+func jitter(x []int64) int64 {
+	if len(x) < 2 {
+		return 0
+	}
+	var sum float64
+	for i := 1; i < len(x); i++ {
+		sum += math.Abs(float64(x[i] - x[i-1]))
+	}
+	return int64(sum / float64(len(x)-1))
+}
+
+// percentile is the nearest-rank percentile of sorted values
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Summary describes the measurements; it fails only if no connection succeeded, the failed ones being
+// reported by the loss rate
+func (m *Measurements) Summary() (Summary, error) {
+	all := distribution(m.AllNanoseconds())
+	var out Summary
 	out.All = &all
-	insuccessCount := m.InsuccessCount()
-	if insuccessCount < 2 {
-		success := average(m.SuccessSeconds())
-		out.Success = &success
-	} else {
-		return out, fmt.Errorf("failed to connect for %d times", insuccessCount)
+	if len(*m) > 0 {
+		out.LossRate = float64(m.InsuccessCount()) / float64(len(*m))
+	}
+	successNanoseconds := m.SuccessNanoseconds()
+	if len(successNanoseconds) == 0 {
+		return out, fmt.Errorf("failed to connect for %d times", m.InsuccessCount())
+	}
+	success := distribution(successNanoseconds)
+	success.Jitter = jitter(successNanoseconds)
+	out.Success = &success
+	out.Histogram, _ = NewHistogram(HistogramSignificantDigits)
+	for _, v := range successNanoseconds {
+		out.Histogram.Record(v)
 	}
 
 	return out, nil
@@ -0,0 +1,299 @@
+package orphan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/index"
+	"k8s.io/klog"
+)
+
+var ErrRoundInProgress = errors.New("an orphan collection is in progress")
+
+// DefaultGracePeriod leaves the revisions being written enough time to be indexed
+const DefaultGracePeriod = 10 * time.Minute
+
+// Resolver returns the backend database of the named node
+type Resolver func(name string) (database.Database, error)
+
+type Options struct {
+	// GracePeriod is how long a value must stay unreferenced by the index before it is deleted; DefaultGracePeriod if 0
+	GracePeriod time.Duration
+	// Interval is the time between two rounds started by Start; 0 only runs the rounds asked for
+	Interval time.Duration
+}
+
+// Status reports the progress of the running round, or the outcome of the last one
+type Status struct {
+	Running    bool
+	Rounds     int
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Stores     int
+	// Scanned is the number of revision keys found on the stores
+	Scanned int
+	// Pending is the number of unreferenced values waiting for the grace period to be over
+	Pending   int
+	Deleted   int
+	Failed    int
+	LastError string
+}
+
+// Collector deletes the values a failed write left on the stores. A round scans the keys of every store, then
+// walks the index, and the revision keys the index does not place on the store they are found on are
+// candidates. A candidate is deleted
+// once it is found unreferenced again by a round started at least a grace period after the round it was first
+// found by, which leaves the writes in flight the time to be indexed.
+type Collector struct {
+	mu        sync.Mutex
+	indexTree index.Index
+	stores    func() []string
+	resolve   Resolver
+	opts      Options
+	status    Status
+	// seen is when the candidates of every store were first found unreferenced
+	seen map[string]map[string]time.Time
+	// stop and done are guarded by mu, as Start and Stop may be called concurrently
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCollector collects the orphans of the stores returned by stores, which may change from a round to the next
+func NewCollector(indexTree index.Index, stores func() []string, resolve Resolver, opts Options) *Collector {
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = DefaultGracePeriod
+	}
+	return &Collector{indexTree: indexTree, stores: stores, resolve: resolve, opts: opts, seen: make(map[string]map[string]time.Time)}
+}
+
+// Status reports the progress of the collection
+func (c *Collector) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// Start runs a round every interval until Stop is called
+func (c *Collector) Start() {
+	if c.opts.Interval <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	c.stop, c.done = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(c.opts.Interval)
+		defer ticker.Stop()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := c.RunOnce(ctx); err != nil && err != ErrRoundInProgress {
+					klog.Warningf("the orphan collection failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop cancels the running round and waits for it
+func (c *Collector) Stop() {
+	c.mu.Lock()
+	stop, done := c.stop, c.done
+	c.stop, c.done = nil, nil
+	c.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Trigger starts a round in the background
+func (c *Collector) Trigger() error {
+	if !c.begin() {
+		return ErrRoundInProgress
+	}
+	go func() {
+		c.finish(c.round(context.Background()))
+	}()
+	return nil
+}
+
+// RunOnce runs a round and returns its outcome
+func (c *Collector) RunOnce(ctx context.Context) (Status, error) {
+	if !c.begin() {
+		return c.Status(), ErrRoundInProgress
+	}
+	err := c.round(ctx)
+	c.finish(err)
+	return c.Status(), err
+}
+
+func (c *Collector) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status.Running {
+		return false
+	}
+	c.status = Status{Running: true, Rounds: c.status.Rounds + 1, StartedAt: time.Now()}
+	return true
+}
+
+func (c *Collector) finish(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.Running = false
+	c.status.FinishedAt = time.Now()
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+	klog.Infof("orphan collection %d: %d revision keys scanned on %d stores, %d orphans deleted, %d pending, %d failures",
+		c.status.Rounds, c.status.Scanned, c.status.Stores, c.status.Deleted, c.status.Pending, c.status.Failed)
+}
+
+func (c *Collector) update(f func(status *Status)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(&c.status)
+}
+
+func (c *Collector) fail(err error) {
+	klog.Warningf("orphan collection: %v", err)
+	c.update(func(status *Status) {
+		status.Failed++
+		status.LastError = err.Error()
+	})
+}
+
+// isRevisionKey tells the values of the revisions, named by their main revision, from the other keys of a store
+func isRevisionKey(key string) bool {
+	main, err := strconv.ParseInt(key, 10, 64)
+	return err == nil && main > 0 && strconv.FormatInt(main, 10) == key
+}
+
+func (c *Collector) round(ctx context.Context) error {
+	started := c.Status().StartedAt
+	// the stores are scanned before the index is walked, so that a value indexed meanwhile is found referenced
+	keys := make(map[string][]string)
+	for _, name := range c.stores() {
+		found, err := c.scan(name)
+		if err != nil {
+			c.fail(err)
+			continue
+		}
+		keys[name] = found
+		c.update(func(status *Status) {
+			status.Stores++
+			status.Scanned += len(found)
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// a value is referenced on the stores of the placement of its revision only, so that the copies left
+	// elsewhere by a write retried on other stores, a move or a handoff are collected as well
+	referenced := make(map[string]map[string]bool)
+	c.indexTree.Walk(ctx, func(key []byte, rev index.Revision) bool {
+		for _, name := range rev.GetPlacement().Nodes() {
+			if referenced[name] == nil {
+				referenced[name] = make(map[string]bool)
+			}
+			referenced[name][rev.String()] = true
+		}
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for name, found := range keys {
+		seen := make(map[string]time.Time)
+		for _, key := range found {
+			if referenced[name][key] {
+				continue
+			}
+			first, ok := c.seen[name][key]
+			if !ok {
+				first = started
+			}
+			if started.Sub(first) < c.opts.GracePeriod {
+				seen[key] = first
+				continue
+			}
+			if err := c.delete(name, key); err != nil {
+				c.fail(err)
+				seen[key] = first
+				continue
+			}
+			c.update(func(status *Status) {
+				status.Deleted++
+			})
+		}
+		// the candidates not found again were deleted or indexed meanwhile
+		c.seen[name] = seen
+		c.update(func(status *Status) {
+			status.Pending += len(seen)
+		})
+	}
+	// the stores removed since are forgotten, as well as the ones failing the scan until they are back
+	for name := range c.seen {
+		if _, ok := keys[name]; !ok {
+			delete(c.seen, name)
+		}
+	}
+	return nil
+}
+
+func (c *Collector) scan(name string) ([]string, error) {
+	db, err := c.resolve(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the store %s: %v", name, err)
+	}
+	scanner, ok := db.(database.Scanner)
+	if !ok {
+		return nil, fmt.Errorf("the store %s does not support scanning its keys", name)
+	}
+	found := make([]string, 0)
+	if err := scanner.Scan(func(key string) bool {
+		if isRevisionKey(key) {
+			found = append(found, key)
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan the store %s: %v", name, err)
+	}
+	return found, nil
+}
+
+func (c *Collector) delete(name, key string) error {
+	db, err := c.resolve(name)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the store %s: %v", name, err)
+	}
+	if err := db.Delete(key); err != nil {
+		return fmt.Errorf("failed to delete the orphaned revision %s from %s: %v", key, name, err)
+	}
+	klog.V(4).Infof("deleted the orphaned revision %s from %s", key, name)
+	return nil
+}
@@ -3,7 +3,7 @@ package consistent
 import (
 	"fmt"
 	"sort"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/cespare/xxhash"
@@ -21,6 +21,7 @@ type Node interface {
 
 type ConsistentHashing interface {
 	AddNode(node Node)
+	RemoveNode(node Node)
 	LocateKey(key []byte) Node
 	LocateNodes(key []byte, count int) []Node
 }
@@ -29,12 +30,55 @@ type RkvNode struct {
 	Name     string
 	Latency  time.Duration
 	IsRemote bool
+	// Weight is the share of the keys the node gets relative to the other nodes; 0 counts as 1
+	Weight int
+	// Region and AvailabilityZone locate the node for the placement policy; the region defaults to the one of the zone
+	Region           constants.Region
+	AvailabilityZone constants.AvailabilityZone
 }
 
 func (rn RkvNode) String() string {
 	return rn.Name
 }
 
+func (rn RkvNode) GetWeight() int {
+	return rn.Weight
+}
+
+func (rn RkvNode) region() constants.Region {
+	if rn.Region != "" {
+		return rn.Region
+	}
+	if rn.AvailabilityZone != "" {
+		return rn.AvailabilityZone.Region()
+	}
+	return ""
+}
+
+// toRkvNode returns the node as added to the hashing, or a node of that name if it is of another type
+func toRkvNode(node Node) RkvNode {
+	if rn, ok := node.(RkvNode); ok {
+		return rn
+	}
+	return RkvNode{Name: node.String()}
+}
+
+func toRkvNodes(nodes []Node) []RkvNode {
+	res := make([]RkvNode, 0, len(nodes))
+	for _, node := range nodes {
+		res = append(res, toRkvNode(node))
+	}
+	return res
+}
+
+func toNodes(nodes []RkvNode) []Node {
+	res := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		res = append(res, node)
+	}
+	return res
+}
+
 type rkvHash struct{}
 
 func (th rkvHash) Hash(key []byte) uint64 {
@@ -44,72 +88,278 @@ func (th rkvHash) Hash(key []byte) uint64 {
 type HashingManager interface {
 	GetSyncNodes(key []byte) ([]Node, error)
 	GetAsyncNodes(key []byte) ([]Node, error)
-	GetNodes(key []byte) ([]string, error)
+	// GetPlacement returns the sync and the async replicas of the key
+	GetPlacement(key []byte) (Placement, error)
+	// GetPlacementForKey places a revision of the user key at placementKey, the way GetPlacement does, within
+	// the regions the residency rule of the user key allows
+	GetPlacementForKey(key []byte, placementKey []byte) (Placement, error)
+	// UpdateStores replaces the stores and their latencies, e.g. after the network conditions changed.
+	// It only affects the placement of the revisions written afterwards.
+	UpdateStores(localStores map[constants.AvailabilityZone][]RkvNode, remoteStores []RkvNode)
+	// SetPlacementPolicy constrains the placement of the revisions written afterwards
+	SetPlacementPolicy(policy PlacementPolicy)
+	// SetUnhealthyStores leaves the given stores out of the placement of the revisions written afterwards, which
+	// get substitutes hinted for them. The stores stay in the hashing so that the keys go back to them once
+	// they recover.
+	SetUnhealthyStores(names []string)
+}
+
+// unhealthyStores are the stores the hashing managers do not place new revisions on
+type unhealthyStores map[string]bool
+
+func newUnhealthyStores(names []string) unhealthyStores {
+	u := make(unhealthyStores, len(names))
+	for _, name := range names {
+		u[name] = true
+	}
+	return u
+}
+
+// any tells whether a replica of the placement is unhealthy
+func (u unhealthyStores) any(p Placement) bool {
+	if len(u) == 0 {
+		return false
+	}
+	for _, r := range p.Replicas() {
+		if u[r.Node] {
+			return true
+		}
+	}
+	return false
+}
+
+// healthy keeps the healthy nodes, in order
+func (u unhealthyStores) healthy(nodes []RkvNode) []RkvNode {
+	res := make([]RkvNode, 0, len(nodes))
+	for _, n := range nodes {
+		if !u[n.Name] {
+			res = append(res, n)
+		}
+	}
+	return res
 }
 
 type SyncHashingManager struct {
-	hasing ConsistentHashing
-	count  int
+	mu           sync.RWMutex
+	hashingType  constants.ConsistentHashingType
+	virtualNodes int
+	hasing       ConsistentHashing
+	count        int
+	size         int
+	policy       PlacementPolicy
+	unhealthy    unhealthyStores
+}
+
+func NewSyncHashingManager(hashingType constants.ConsistentHashingType, nodes []RkvNode, count int) *SyncHashingManager {
+	return NewSyncHashingManagerWithVirtualNodes(hashingType, nodes, count, DefaultVirtualNodes)
+}
+
+// NewSyncHashingManagerWithVirtualNodes sets the number of tokens per unit of weight of the ring hashing
+func NewSyncHashingManagerWithVirtualNodes(hashingType constants.ConsistentHashingType, nodes []RkvNode, count int, virtualNodes int) *SyncHashingManager {
+	shm := &SyncHashingManager{hashingType: hashingType, count: count, virtualNodes: virtualNodes}
+	shm.setNodes(nodes)
+	return shm
 }
 
-func NewSyncHashingManager(hashingType constants.ConsistentHashingType, nodes []RkvNode, count int) SyncHashingManager {
-	h := Factory(hashingType)
+func (shm *SyncHashingManager) setNodes(nodes []RkvNode) {
+	h := FactoryWithVirtualNodes(shm.hashingType, shm.virtualNodes)
+	names := make(map[string]bool, len(nodes))
 	for _, node := range nodes {
 		h.AddNode(node)
+		names[node.Name] = true
 	}
-	return SyncHashingManager{hasing: h, count: count}
+	shm.hasing, shm.size = h, len(names)
 }
 
-func (shm SyncHashingManager) GetSyncNodes(key []byte) ([]Node, error) {
-	return shm.hasing.LocateNodes(key, shm.count), nil
+func (shm *SyncHashingManager) SetPlacementPolicy(policy PlacementPolicy) {
+	shm.mu.Lock()
+	defer shm.mu.Unlock()
+	shm.policy = policy
 }
 
-func (shm SyncHashingManager) GetAsyncNodes(key []byte) ([]Node, error) {
+func (shm *SyncHashingManager) SetUnhealthyStores(names []string) {
+	shm.mu.Lock()
+	defer shm.mu.Unlock()
+	shm.unhealthy = newUnhealthyStores(names)
+}
+
+// UpdateStores places the revisions on the local stores only, as the sync hashing manager does not have remote replicas
+func (shm *SyncHashingManager) UpdateStores(localStores map[constants.AvailabilityZone][]RkvNode, remoteStores []RkvNode) {
+	nodes := make([]RkvNode, 0)
+	for _, stores := range localStores {
+		nodes = append(nodes, stores...)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	shm.mu.Lock()
+	defer shm.mu.Unlock()
+	shm.setNodes(nodes)
+}
+
+func (shm *SyncHashingManager) GetSyncNodes(key []byte) ([]Node, error) {
+	p, err := shm.locate(nil, key)
+	if err != nil {
+		return nil, err
+	}
+	return replicaNodes(p.Sync), nil
+}
+
+// locate places the key on its home nodes, or on the healthy nodes next to them if any of them is unhealthy
+func (shm *SyncHashingManager) locate(key []byte, placementKey []byte) (Placement, error) {
+	shm.mu.RLock()
+	defer shm.mu.RUnlock()
+	policy := shm.policy.ForKey(key)
+	homeNodes, err := shm.syncNodes(policy, placementKey, false)
+	if err != nil {
+		return Placement{}, err
+	}
+	home := NewPlacement(homeNodes, nil)
+	if !shm.unhealthy.any(home) {
+		return home, nil
+	}
+	nodes, err := shm.syncNodes(policy, placementKey, true)
+	if err != nil {
+		return Placement{}, err
+	}
+	return NewPlacement(nodes, nil).withHints(home), nil
+}
+
+func (shm *SyncHashingManager) syncNodes(policy PlacementPolicy, placementKey []byte, healthyOnly bool) ([]Node, error) {
+	if policy.IsZero() && !healthyOnly {
+		return shm.hasing.LocateNodes(placementKey, shm.count), nil
+	}
+	candidates := toRkvNodes(shm.hasing.LocateNodes(placementKey, shm.size))
+	if healthyOnly {
+		candidates = shm.unhealthy.healthy(candidates)
+	}
+	chosen, err := policy.choose([][]RkvNode{candidates}, []int{shm.count})
+	if err != nil {
+		return nil, err
+	}
+	policy.lead(chosen[0])
+	return toNodes(chosen[0]), nil
+}
+
+func (shm *SyncHashingManager) GetAsyncNodes(key []byte) ([]Node, error) {
 	return nil, nil
 }
 
-func (shm SyncHashingManager) GetNodes(key []byte) ([]string, error) {
-	syncNodes, err := shm.GetSyncNodes(key)
-	res := make([]string, 0)
+func (shm *SyncHashingManager) GetPlacement(key []byte) (Placement, error) {
+	return shm.GetPlacementForKey(nil, key)
+}
+
+func (shm *SyncHashingManager) GetPlacementForKey(key []byte, placementKey []byte) (Placement, error) {
+	p, err := shm.locate(key, placementKey)
 	if err != nil {
 		klog.Errorf("failed to get all the sync nodes: %v", err)
-		return res, err
 	}
-	return []string{strings.Join(convertNodeArrToStringArr(syncNodes), ",")}, nil
+	return p, err
 }
 
 type SyncByZoneAsyncHashingManager struct {
+	mu           sync.RWMutex
+	hashingType  constants.ConsistentHashingType
+	virtualNodes int
 	AzHashing    ConsistentHashing
 	LocalHashing map[constants.AvailabilityZone]ConsistentHashing
 	RemoteHasing ConsistentHashing
 	LatencyMap   map[string]time.Duration
 	LocalCount   int
 	RemoteCount  int
+	zoneSizes    map[constants.AvailabilityZone]int
+	remoteSize   int
+	policy       PlacementPolicy
+	unhealthy    unhealthyStores
+}
+
+func NewSyncAsyncHashingManager(hashingType constants.ConsistentHashingType, localStores map[constants.AvailabilityZone][]RkvNode, localCount int, remoteStores []RkvNode, remoteCount int) *SyncByZoneAsyncHashingManager {
+	return NewSyncAsyncHashingManagerWithVirtualNodes(hashingType, localStores, localCount, remoteStores, remoteCount, DefaultVirtualNodes)
+}
+
+// NewSyncAsyncHashingManagerWithVirtualNodes sets the number of tokens per unit of weight of the ring hashing
+func NewSyncAsyncHashingManagerWithVirtualNodes(hashingType constants.ConsistentHashingType, localStores map[constants.AvailabilityZone][]RkvNode, localCount int, remoteStores []RkvNode, remoteCount int, virtualNodes int) *SyncByZoneAsyncHashingManager {
+	sahm := &SyncByZoneAsyncHashingManager{hashingType: hashingType, virtualNodes: virtualNodes, LocalCount: localCount, RemoteCount: remoteCount}
+	sahm.setStores(localStores, remoteStores)
+	return sahm
 }
 
-func NewSyncAsyncHashingManager(hashingType constants.ConsistentHashingType, localStores map[constants.AvailabilityZone][]RkvNode, localCount int, remoteStores []RkvNode, remoteCount int) SyncByZoneAsyncHashingManager {
-	azRing := Factory(hashingType)
+// UpdateStores rebuilds the rings from the given stores
+func (sahm *SyncByZoneAsyncHashingManager) UpdateStores(localStores map[constants.AvailabilityZone][]RkvNode, remoteStores []RkvNode) {
+	sahm.mu.Lock()
+	defer sahm.mu.Unlock()
+	sahm.setStores(localStores, remoteStores)
+}
+
+func (sahm *SyncByZoneAsyncHashingManager) setStores(localStores map[constants.AvailabilityZone][]RkvNode, remoteStores []RkvNode) {
+	hashingType := sahm.hashingType
+	azRing := FactoryWithVirtualNodes(hashingType, sahm.virtualNodes)
 	localRing := make(map[constants.AvailabilityZone]ConsistentHashing)
 	latencyMap := make(map[string]time.Duration)
-	for az, stores := range localStores {
+	zoneSizes := make(map[constants.AvailabilityZone]int)
+	// the zones are added in a fixed order since the ring of a hashing type may depend on the insertion order
+	azs := make([]constants.AvailabilityZone, 0, len(localStores))
+	for az := range localStores {
+		azs = append(azs, az)
+	}
+	sort.Slice(azs, func(i, j int) bool {
+		return azs[i] < azs[j]
+	})
+	for _, az := range azs {
+		stores := localStores[az]
 		azRing.AddNode(RkvNode{Name: az.Name()})
 		if _, found := localRing[az]; !found {
-			localRing[az] = Factory(hashingType)
+			localRing[az] = FactoryWithVirtualNodes(hashingType, sahm.virtualNodes)
 		}
 		for _, store := range stores {
+			if store.AvailabilityZone == "" {
+				store.AvailabilityZone = az
+			}
+			if _, found := latencyMap[store.Name]; !found {
+				zoneSizes[az]++
+			}
 			localRing[az].AddNode(store)
 			latencyMap[store.Name] = store.Latency
 		}
 	}
-	remoteRing := Factory(hashingType)
+	remoteRing := FactoryWithVirtualNodes(hashingType, sahm.virtualNodes)
+	remoteNames := make(map[string]bool, len(remoteStores))
 	for _, store := range remoteStores {
 		remoteRing.AddNode(store)
 		latencyMap[store.Name] = store.Latency
+		remoteNames[store.Name] = true
 	}
-	return SyncByZoneAsyncHashingManager{AzHashing: azRing, LocalHashing: localRing, RemoteHasing: remoteRing, LocalCount: localCount, RemoteCount: remoteCount, LatencyMap: latencyMap}
+	sahm.AzHashing, sahm.LocalHashing, sahm.RemoteHasing, sahm.LatencyMap = azRing, localRing, remoteRing, latencyMap
+	sahm.zoneSizes, sahm.remoteSize = zoneSizes, len(remoteNames)
 }
 
-func (sahm SyncByZoneAsyncHashingManager) GetSyncNodes(key []byte) ([]Node, error) {
+func (sahm *SyncByZoneAsyncHashingManager) SetPlacementPolicy(policy PlacementPolicy) {
+	sahm.mu.Lock()
+	defer sahm.mu.Unlock()
+	sahm.policy = policy
+}
+
+func (sahm *SyncByZoneAsyncHashingManager) SetUnhealthyStores(names []string) {
+	sahm.mu.Lock()
+	defer sahm.mu.Unlock()
+	sahm.unhealthy = newUnhealthyStores(names)
+}
+
+func (sahm *SyncByZoneAsyncHashingManager) GetSyncNodes(key []byte) ([]Node, error) {
+	sahm.mu.RLock()
+	defer sahm.mu.RUnlock()
+	policy := sahm.policy.ForKey(nil)
+	if policy.IsZero() && len(sahm.unhealthy) == 0 {
+		return sahm.syncNodes(key)
+	}
+	p, err := sahm.locate(policy, key)
+	if err != nil {
+		return nil, err
+	}
+	return replicaNodes(p.Sync), nil
+}
+
+func (sahm *SyncByZoneAsyncHashingManager) syncNodes(key []byte) ([]Node, error) {
 	localNodes := make([]Node, 0)
 	if sahm.LocalCount < 1 {
 		return localNodes, nil
@@ -124,7 +374,9 @@ func (sahm SyncByZoneAsyncHashingManager) GetSyncNodes(key []byte) ([]Node, erro
 		if len(lnodes) != 1 {
 			return nil, fmt.Errorf("failed to get 1 local node. The return number is %d", len(lnodes))
 		}
-		nodesWithLatency = append(nodesWithLatency, RkvNode{Name: lnodes[0].String(), Latency: sahm.LatencyMap[lnodes[0].String()]})
+		node := toRkvNode(lnodes[0])
+		node.Latency = sahm.LatencyMap[node.Name]
+		nodesWithLatency = append(nodesWithLatency, node)
 	}
 	sort.Slice(nodesWithLatency, func(i, j int) bool {
 		return nodesWithLatency[i].Latency < nodesWithLatency[j].Latency
@@ -135,7 +387,21 @@ func (sahm SyncByZoneAsyncHashingManager) GetSyncNodes(key []byte) ([]Node, erro
 	return localNodes, nil
 }
 
-func (sahm SyncByZoneAsyncHashingManager) GetAsyncNodes(key []byte) ([]Node, error) {
+func (sahm *SyncByZoneAsyncHashingManager) GetAsyncNodes(key []byte) ([]Node, error) {
+	sahm.mu.RLock()
+	defer sahm.mu.RUnlock()
+	policy := sahm.policy.ForKey(nil)
+	if policy.IsZero() && len(sahm.unhealthy) == 0 {
+		return sahm.asyncNodes(key)
+	}
+	p, err := sahm.locate(policy, key)
+	if err != nil {
+		return nil, err
+	}
+	return replicaNodes(p.Async), nil
+}
+
+func (sahm *SyncByZoneAsyncHashingManager) asyncNodes(key []byte) ([]Node, error) {
 	if sahm.RemoteCount < 1 {
 		return make([]Node, 0), nil
 	}
@@ -146,38 +412,129 @@ func (sahm SyncByZoneAsyncHashingManager) GetAsyncNodes(key []byte) ([]Node, err
 	return rnodes, nil
 }
 
-func (sahm SyncByZoneAsyncHashingManager) GetNodes(key []byte) ([]string, error) {
-	syncNodes, err := sahm.GetSyncNodes(key)
-	res := make([]string, 0)
+// locate places the key on its home nodes, or on the healthy nodes next to them if any of them is unhealthy,
+// the substitutes being hinted for the home nodes they stand in for
+func (sahm *SyncByZoneAsyncHashingManager) locate(policy PlacementPolicy, key []byte) (Placement, error) {
+	syncNodes, asyncNodes, err := sahm.nodes(policy, key, false)
 	if err != nil {
-		klog.Errorf("failed to get all the sync nodes: %v", err)
-		return res, err
+		return Placement{}, err
+	}
+	home := NewPlacement(syncNodes, asyncNodes)
+	if !sahm.unhealthy.any(home) {
+		return home, nil
+	}
+	if syncNodes, asyncNodes, err = sahm.nodes(policy, key, true); err != nil {
+		return Placement{}, err
 	}
-	syncNodesString := strings.Join(convertNodeArrToStringArr(syncNodes), ",")
-	asyncNodes, err := sahm.GetAsyncNodes(key)
+	return NewPlacement(syncNodes, asyncNodes).withHints(home), nil
+}
+
+func (sahm *SyncByZoneAsyncHashingManager) nodes(policy PlacementPolicy, key []byte, healthyOnly bool) ([]Node, []Node, error) {
+	if policy.IsZero() && !healthyOnly {
+		syncNodes, err := sahm.syncNodes(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		asyncNodes, err := sahm.asyncNodes(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return syncNodes, asyncNodes, nil
+	}
+	return sahm.place(policy, key, healthyOnly)
+}
+
+// place picks the sync nodes and the async nodes of the key together, so that they satisfy the placement
+// policy as a whole. The sync candidates are the best ranked store of each zone in an allowed region,
+// one per zone. With healthyOnly, the unhealthy sync candidates are skipped and the other stores of the zones
+// come after the best ones, so that the sync nodes still fill up when a zone is out, and the unhealthy async
+// candidates come last, as the queue keeps their writes until they recover.
+func (sahm *SyncByZoneAsyncHashingManager) place(policy PlacementPolicy, key []byte, healthyOnly bool) ([]Node, []Node, error) {
+	localCount, remoteCount := sahm.LocalCount, sahm.RemoteCount
+	if localCount < 0 {
+		localCount = 0
+	}
+	if remoteCount < 0 {
+		remoteCount = 0
+	}
+	usable := func(n RkvNode) bool {
+		return policy.Allows(n.region()) && !(healthyOnly && sahm.unhealthy[n.Name])
+	}
+	syncCandidates := make([]RkvNode, 0, len(sahm.LocalHashing))
+	seconds := make([]RkvNode, 0)
+	for _, az := range sahm.AzHashing.LocateNodes(key, len(sahm.LocalHashing)) {
+		zone := constants.AvailabilityZone(az.String())
+		first := true
+		for _, n := range sahm.LocalHashing[zone].LocateNodes(key, sahm.zoneSizes[zone]) {
+			if node := toRkvNode(n); usable(node) {
+				if first {
+					syncCandidates = append(syncCandidates, node)
+				} else if healthyOnly {
+					seconds = append(seconds, node)
+				} else {
+					break
+				}
+				first = false
+			}
+		}
+	}
+	syncCandidates = append(syncCandidates, seconds...)
+	asyncCandidates := toRkvNodes(sahm.RemoteHasing.LocateNodes(key, sahm.remoteSize))
+	if healthyOnly {
+		healthy := sahm.unhealthy.healthy(asyncCandidates)
+		for _, n := range asyncCandidates {
+			if sahm.unhealthy[n.Name] {
+				healthy = append(healthy, n)
+			}
+		}
+		asyncCandidates = healthy
+	}
+	chosen, err := policy.choose([][]RkvNode{syncCandidates, asyncCandidates}, []int{localCount, remoteCount})
 	if err != nil {
-		klog.Errorf("failed to get all the async nodes: %v", err)
-		return res, err
+		return nil, nil, err
+	}
+	syncNodes := chosen[0]
+	for i := range syncNodes {
+		syncNodes[i].Latency = sahm.LatencyMap[syncNodes[i].Name]
 	}
-	asyncNodesString := strings.Join(convertNodeArrToStringArr(asyncNodes), ",")
-	return []string{syncNodesString, asyncNodesString}, nil
+	sort.SliceStable(syncNodes, func(i, j int) bool {
+		return syncNodes[i].Latency < syncNodes[j].Latency
+	})
+	policy.lead(syncNodes)
+	return toNodes(syncNodes), toNodes(chosen[1]), nil
+}
+
+func (sahm *SyncByZoneAsyncHashingManager) GetPlacement(key []byte) (Placement, error) {
+	return sahm.GetPlacementForKey(nil, key)
+}
+
+func (sahm *SyncByZoneAsyncHashingManager) GetPlacementForKey(key []byte, placementKey []byte) (Placement, error) {
+	sahm.mu.RLock()
+	p, err := sahm.locate(sahm.policy.ForKey(key), placementKey)
+	sahm.mu.RUnlock()
+	if err != nil {
+		klog.Errorf("failed to place the key: %v", err)
+		return Placement{}, err
+	}
+	return p, nil
 }
 
 func Factory(hashingType constants.ConsistentHashingType) ConsistentHashing {
+	return FactoryWithVirtualNodes(hashingType, DefaultVirtualNodes)
+}
+
+// FactoryWithVirtualNodes sets the number of tokens per unit of weight of the ring hashing
+func FactoryWithVirtualNodes(hashingType constants.ConsistentHashingType, virtualNodes int) ConsistentHashing {
 	switch hashingType {
 	case constants.Rendezvous:
 		return NewRendezvous(nil, rkvHash{})
 	case constants.Ring:
-		return NewRingHashing(rkvHash{})
+		return NewRingHashingWithVirtualNodes(rkvHash{}, virtualNodes)
+	case constants.Jump:
+		return NewJumpHashing(rkvHash{})
+	case constants.Maglev:
+		return NewMaglevHashing(rkvHash{})
 	default:
 		return NewRendezvous(nil, rkvHash{})
 	}
 }
-
-func convertNodeArrToStringArr(nodes []Node) []string {
-	res := make([]string, 0)
-	for _, node := range nodes {
-		res = append(res, node.String())
-	}
-	return res
-}
@@ -0,0 +1,118 @@
+package consistent
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// JumpHashing places keys with the jump consistent hash of Lamping and Veach over numbered buckets; a node of
+// weight w owns w buckets. It needs no memory besides the bucket list and is the most even, but only adding
+// or removing the last bucket moves the minimum of keys: removing another node renumbers the buckets after it.
+type JumpHashing struct {
+	hasher  Hasher
+	nodes   map[string]Node
+	order   []string
+	buckets []string
+}
+
+func NewJumpHashing(hasher Hasher) *JumpHashing {
+	return &JumpHashing{hasher: hasher, nodes: make(map[string]Node)}
+}
+
+func (jh *JumpHashing) AddNode(node Node) {
+	if _, ok := jh.nodes[node.String()]; ok {
+		return
+	}
+	jh.nodes[node.String()] = node
+	jh.order = append(jh.order, node.String())
+	for i := 0; i < weightOf(node); i++ {
+		jh.buckets = append(jh.buckets, node.String())
+	}
+}
+
+func (jh *JumpHashing) RemoveNode(node Node) {
+	name := node.String()
+	if _, ok := jh.nodes[name]; !ok {
+		return
+	}
+	delete(jh.nodes, name)
+	order := jh.order[:0]
+	for _, n := range jh.order {
+		if n != name {
+			order = append(order, n)
+		}
+	}
+	jh.order = order
+	buckets := jh.buckets[:0]
+	for _, n := range jh.buckets {
+		if n != name {
+			buckets = append(buckets, n)
+		}
+	}
+	jh.buckets = buckets
+}
+
+func (jh *JumpHashing) GetNodes() []Node {
+	nodes := make([]Node, 0, len(jh.order))
+	for _, name := range jh.order {
+		nodes = append(nodes, jh.nodes[name])
+	}
+	return nodes
+}
+
+func (jh *JumpHashing) LocateKey(key []byte) Node {
+	if len(jh.buckets) == 0 {
+		return nil
+	}
+	return jh.nodes[jh.buckets[jumpHash(jh.hasher.Hash(key), len(jh.buckets))]]
+}
+
+// LocateNodes takes the first replica from the key and each next one from the key salted with the replica
+// number, skipping the nodes already taken
+func (jh *JumpHashing) LocateNodes(key []byte, count int) []Node {
+	if len(jh.nodes) < count || len(jh.buckets) == 0 {
+		return nil
+	}
+	res := make([]Node, 0, count)
+	seen := make(map[string]bool, count)
+	salted := make([]byte, len(key)+8)
+	copy(salted, key)
+	h := jh.hasher.Hash(key)
+	// the attempts are bounded as a heavy node may keep winning; the remaining nodes are then taken in order
+	for attempt := 0; len(res) < count && attempt < 8*len(jh.buckets); attempt++ {
+		if attempt > 0 {
+			binary.LittleEndian.PutUint64(salted[len(key):], uint64(attempt))
+			h = jh.hasher.Hash(salted)
+		}
+		name := jh.buckets[jumpHash(h, len(jh.buckets))]
+		if !seen[name] {
+			seen[name] = true
+			res = append(res, jh.nodes[name])
+		}
+	}
+	if len(res) < count {
+		rest := make([]string, 0, len(jh.order))
+		for _, name := range jh.order {
+			if !seen[name] {
+				rest = append(rest, name)
+			}
+		}
+		sort.Strings(rest)
+		for _, name := range rest[:count-len(res)] {
+			res = append(res, jh.nodes[name])
+		}
+	}
+	return res
+}
+
+// jumpHash maps the key to one of the buckets so that growing from n to n+1 buckets moves 1/(n+1) of the keys,
+// all to the new bucket
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,144 @@
+package consistent
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultMaglevTableSize is a prime well above 100 times the expected number of nodes
+const DefaultMaglevTableSize = 65537
+
+// MaglevHashing places keys with a lookup table filled by the nodes in turn, each following its own permutation
+// of the slots (Eisenbud et al., Maglev). A node of weight w takes w slots per turn. Lookups are a single table
+// access; the table is rebuilt on the first lookup after the nodes change, so that adding the nodes one by one
+// builds it once, and the rebuild moves slightly more keys than the ring.
+type MaglevHashing struct {
+	hasher    Hasher
+	tableSize int
+	nodes     map[string]Node
+	// mu guards the table, rebuilt by the concurrent lookups once stale
+	mu    sync.Mutex
+	table []string
+	stale bool
+}
+
+func NewMaglevHashing(hasher Hasher) *MaglevHashing {
+	return NewMaglevHashingWithTableSize(hasher, DefaultMaglevTableSize)
+}
+
+// NewMaglevHashingWithTableSize takes a prime table size for the permutations to cover every slot
+func NewMaglevHashingWithTableSize(hasher Hasher, tableSize int) *MaglevHashing {
+	return &MaglevHashing{hasher: hasher, tableSize: tableSize, nodes: make(map[string]Node)}
+}
+
+func (mh *MaglevHashing) AddNode(node Node) {
+	if _, ok := mh.nodes[node.String()]; ok {
+		return
+	}
+	mh.nodes[node.String()] = node
+	mh.invalidate()
+}
+
+func (mh *MaglevHashing) RemoveNode(node Node) {
+	if _, ok := mh.nodes[node.String()]; !ok {
+		return
+	}
+	delete(mh.nodes, node.String())
+	mh.invalidate()
+}
+
+func (mh *MaglevHashing) invalidate() {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	mh.stale = true
+}
+
+// lookupTable returns the table of the current nodes, rebuilding it if they changed since it was built
+func (mh *MaglevHashing) lookupTable() []string {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	if mh.stale {
+		mh.table = mh.populate()
+		mh.stale = false
+	}
+	return mh.table
+}
+
+func (mh *MaglevHashing) GetNodes() []Node {
+	nodes := make([]Node, 0, len(mh.nodes))
+	for _, name := range mh.names() {
+		nodes = append(nodes, mh.nodes[name])
+	}
+	return nodes
+}
+
+// names are sorted for the table not to depend on the order the nodes were added in
+func (mh *MaglevHashing) names() []string {
+	names := make([]string, 0, len(mh.nodes))
+	for name := range mh.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (mh *MaglevHashing) populate() []string {
+	names := mh.names()
+	if len(names) == 0 {
+		return nil
+	}
+	m := uint64(mh.tableSize)
+	offsets := make([]uint64, len(names))
+	skips := make([]uint64, len(names))
+	next := make([]uint64, len(names))
+	weights := make([]int, len(names))
+	for i, name := range names {
+		offsets[i] = mh.hasher.Hash([]byte("offset:"+name)) % m
+		skips[i] = mh.hasher.Hash([]byte("skip:"+name))%(m-1) + 1
+		weights[i] = weightOf(mh.nodes[name])
+	}
+	table := make([]string, mh.tableSize)
+	filled := 0
+	for filled < mh.tableSize {
+		for i, name := range names {
+			for w := 0; w < weights[i] && filled < mh.tableSize; w++ {
+				slot := (offsets[i] + next[i]*skips[i]) % m
+				for table[slot] != "" {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % m
+				}
+				table[slot] = name
+				next[i]++
+				filled++
+			}
+		}
+	}
+	return table
+}
+
+func (mh *MaglevHashing) LocateKey(key []byte) Node {
+	table := mh.lookupTable()
+	if len(table) == 0 {
+		return nil
+	}
+	return mh.nodes[table[mh.hasher.Hash(key)%uint64(len(table))]]
+}
+
+// LocateNodes walks the table from the slot of the key and returns the first count distinct nodes
+func (mh *MaglevHashing) LocateNodes(key []byte, count int) []Node {
+	table := mh.lookupTable()
+	if len(mh.nodes) < count || len(table) == 0 {
+		return nil
+	}
+	start := int(mh.hasher.Hash(key) % uint64(len(table)))
+	res := make([]Node, 0, count)
+	seen := make(map[string]bool, count)
+	for i := 0; i < len(table) && len(res) < count; i++ {
+		name := table[(start+i)%len(table)]
+		if !seen[name] {
+			seen[name] = true
+			res = append(res, mh.nodes[name])
+		}
+	}
+	return res
+}
@@ -0,0 +1,162 @@
+package consistent
+
+import (
+	"strings"
+
+	"github.com/regionless-storage-service/pkg/constants"
+)
+
+// Role is the part a replica plays in the placement of a revision
+type Role string
+
+const (
+	// RoleLeader is the first sync replica, e.g. the head of a chain
+	RoleLeader Role = "leader"
+	// RoleFollower is a sync replica other than the leader
+	RoleFollower Role = "follower"
+	// RoleAsync is a replica written after the write is acknowledged
+	RoleAsync Role = "async"
+)
+
+// Replica is a store holding a revision
+type Replica struct {
+	Node             string
+	Role             Role
+	AvailabilityZone constants.AvailabilityZone
+	Region           constants.Region
+	// HintFor is the unhealthy store this replica stands in for; the replica is handed back to that store once
+	// it recovers
+	HintFor string
+}
+
+// Placement is where the replicas of a revision are: the sync replicas in write order, the leader first, and
+// the async ones
+type Placement struct {
+	Sync  []Replica
+	Async []Replica
+}
+
+// NewPlacement describes the nodes returned by the hashing, keeping their order
+func NewPlacement(syncNodes []Node, asyncNodes []Node) Placement {
+	p := Placement{Sync: make([]Replica, 0, len(syncNodes)), Async: make([]Replica, 0, len(asyncNodes))}
+	for i, n := range syncNodes {
+		role := RoleFollower
+		if i == 0 {
+			role = RoleLeader
+		}
+		p.Sync = append(p.Sync, replicaOf(toRkvNode(n), role))
+	}
+	for _, n := range asyncNodes {
+		p.Async = append(p.Async, replicaOf(toRkvNode(n), RoleAsync))
+	}
+	return p
+}
+
+// NamedPlacement describes the stores of the given names, whose locations are unknown
+func NamedPlacement(syncNodes []string, asyncNodes []string) Placement {
+	return NewPlacement(namedNodes(syncNodes), namedNodes(asyncNodes))
+}
+
+func namedNodes(names []string) []Node {
+	nodes := make([]Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, RkvNode{Name: name})
+	}
+	return nodes
+}
+
+func replicaOf(n RkvNode, role Role) Replica {
+	return Replica{Node: n.Name, Role: role, AvailabilityZone: n.AvailabilityZone, Region: n.region()}
+}
+
+func replicaNodes(replicas []Replica) []Node {
+	nodes := make([]Node, 0, len(replicas))
+	for _, r := range replicas {
+		nodes = append(nodes, RkvNode{Name: r.Node, AvailabilityZone: r.AvailabilityZone, Region: r.Region})
+	}
+	return nodes
+}
+
+// IsEmpty tells whether the placement has no replica, as the one of a tombstone
+func (p Placement) IsEmpty() bool {
+	return len(p.Sync) == 0 && len(p.Async) == 0
+}
+
+// Replicas lists the sync replicas and then the async ones
+func (p Placement) Replicas() []Replica {
+	res := make([]Replica, 0, len(p.Sync)+len(p.Async))
+	return append(append(res, p.Sync...), p.Async...)
+}
+
+// SyncNodes lists the names of the sync replicas in write order
+func (p Placement) SyncNodes() []string {
+	return replicaNames(p.Sync)
+}
+
+// AsyncNodes lists the names of the async replicas
+func (p Placement) AsyncNodes() []string {
+	return replicaNames(p.Async)
+}
+
+// Nodes lists the names of the sync replicas and then of the async ones
+func (p Placement) Nodes() []string {
+	return replicaNames(p.Replicas())
+}
+
+func replicaNames(replicas []Replica) []string {
+	res := make([]string, 0, len(replicas))
+	for _, r := range replicas {
+		res = append(res, r.Node)
+	}
+	return res
+}
+
+// Hinted lists the replicas standing in for unhealthy stores
+func (p Placement) Hinted() []Replica {
+	res := make([]Replica, 0)
+	for _, r := range p.Replicas() {
+		if r.HintFor != "" {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// withHints pairs the replicas of p which are not in the home placement, in order, with the replicas of the
+// home placement which p left out
+func (p Placement) withHints(home Placement) Placement {
+	in := func(replicas []Replica, node string) bool {
+		for _, r := range replicas {
+			if r.Node == node {
+				return true
+			}
+		}
+		return false
+	}
+	missing := make([]string, 0)
+	for _, r := range home.Replicas() {
+		if !in(p.Sync, r.Node) && !in(p.Async, r.Node) {
+			missing = append(missing, r.Node)
+		}
+	}
+	homeReplicas := home.Replicas()
+	for _, group := range [][]Replica{p.Sync, p.Async} {
+		for i := range group {
+			if len(missing) == 0 {
+				return p
+			}
+			if !in(homeReplicas, group[i].Node) {
+				group[i].HintFor, missing = missing[0], missing[1:]
+			}
+		}
+	}
+	return p
+}
+
+func (p Placement) String() string {
+	s := strings.Join(p.SyncNodes(), ",")
+	if len(p.Async) > 0 {
+		s += " async " + strings.Join(p.AsyncNodes(), ",")
+	}
+	return s
+}
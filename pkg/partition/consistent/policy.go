@@ -0,0 +1,231 @@
+package consistent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/regionless-storage-service/pkg/constants"
+)
+
+// ErrPolicyUnsatisfied is returned when the available stores cannot hold the replicas of a key as the placement
+// policy requires
+var ErrPolicyUnsatisfied = errors.New("the placement policy cannot be satisfied")
+
+// ResidencyRule keeps the keys starting with Prefix, e.g. the keys of a tenant, in the given regions only
+type ResidencyRule struct {
+	Prefix  string
+	Regions []constants.Region
+}
+
+// PlacementPolicy constrains where the replicas of every key go, the sync and the async ones together.
+// The zero policy leaves the placement to the hashing alone.
+type PlacementPolicy struct {
+	// MinRegions is the least number of distinct regions the replicas of a key span
+	MinRegions int
+	// RequiredRegions each hold at least one replica of every key
+	RequiredRegions []constants.Region
+	// ForbiddenRegions never hold a replica
+	ForbiddenRegions []constants.Region
+	// MaxReplicasPerZone bounds the replicas of a key in one availability zone; 0 means no bound
+	MaxReplicasPerZone int
+	// PreferredLeaderRegion puts a sync replica of this region first, as the leader, whenever a key has one there
+	PreferredLeaderRegion constants.Region
+	// Residency pins the keys of a prefix to regions; the rule of the longest matching prefix applies
+	Residency []ResidencyRule
+
+	// allowed are the only regions which may hold replicas, if any; they come from the residency rule of a key
+	allowed []constants.Region
+}
+
+// IsZero tells whether the policy has no constraint
+func (p PlacementPolicy) IsZero() bool {
+	return p.MinRegions == 0 && len(p.RequiredRegions) == 0 && len(p.ForbiddenRegions) == 0 &&
+		p.MaxReplicasPerZone == 0 && p.PreferredLeaderRegion == "" && len(p.Residency) == 0 && len(p.allowed) == 0
+}
+
+// Allows tells whether the region may hold replicas
+func (p PlacementPolicy) Allows(region constants.Region) bool {
+	if containsRegion(p.ForbiddenRegions, region) {
+		return false
+	}
+	return len(p.allowed) == 0 || containsRegion(p.allowed, region)
+}
+
+// Rule returns the residency rule of the key, the one of the longest matching prefix
+func (p PlacementPolicy) Rule(key []byte) (ResidencyRule, bool) {
+	var rule ResidencyRule
+	found := false
+	for _, r := range p.Residency {
+		if bytes.HasPrefix(key, []byte(r.Prefix)) && (!found || len(r.Prefix) > len(rule.Prefix)) {
+			rule, found = r, true
+		}
+	}
+	return rule, found
+}
+
+// ForKey returns the policy applying to the key: the residency rule of the key, if any, restricts the regions
+// which may hold its replicas. The keys without a rule get the policy without the residency rules.
+func (p PlacementPolicy) ForKey(key []byte) PlacementPolicy {
+	rule, found := p.Rule(key)
+	p.Residency = nil
+	if found {
+		p.allowed = rule.Regions
+	}
+	return p
+}
+
+func containsRegion(regions []constants.Region, region constants.Region) bool {
+	for _, r := range regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the constraints do not contradict each other and can be met by the given number of
+// replicas of a key
+func (p PlacementPolicy) Validate(replicas int) error {
+	if p.MinRegions < 0 || p.MaxReplicasPerZone < 0 {
+		return fmt.Errorf("MinRegions and MaxReplicasPerZone must not be negative")
+	}
+	if p.MinRegions > replicas {
+		return fmt.Errorf("%d replicas cannot span %d regions", replicas, p.MinRegions)
+	}
+	required := make(map[constants.Region]bool, len(p.RequiredRegions))
+	for _, r := range p.RequiredRegions {
+		if !p.Allows(r) {
+			return fmt.Errorf("the region %s is both required and forbidden", r)
+		}
+		required[r] = true
+	}
+	if len(required) > replicas {
+		return fmt.Errorf("%d replicas cannot cover the %d required regions", replicas, len(required))
+	}
+	if p.PreferredLeaderRegion != "" && !p.Allows(p.PreferredLeaderRegion) {
+		return fmt.Errorf("the preferred leader region %s is forbidden", p.PreferredLeaderRegion)
+	}
+	prefixes := make(map[string]bool, len(p.Residency))
+	for _, rule := range p.Residency {
+		if prefixes[rule.Prefix] {
+			return fmt.Errorf("the prefix %q has several residency rules", rule.Prefix)
+		}
+		prefixes[rule.Prefix] = true
+		if len(rule.Regions) == 0 {
+			return fmt.Errorf("the residency rule of the prefix %q has no region", rule.Prefix)
+		}
+		regions := make(map[constants.Region]bool, len(rule.Regions))
+		for _, r := range rule.Regions {
+			if !p.Allows(r) {
+				return fmt.Errorf("the region %s of the residency rule of the prefix %q is forbidden", r, rule.Prefix)
+			}
+			regions[r] = true
+		}
+		for r := range required {
+			if !regions[r] {
+				return fmt.Errorf("the required region %s is not allowed by the residency rule of the prefix %q", r, rule.Prefix)
+			}
+		}
+		if len(regions) < p.MinRegions {
+			return fmt.Errorf("the residency rule of the prefix %q allows %d regions, fewer than %d", rule.Prefix, len(regions), p.MinRegions)
+		}
+	}
+	return nil
+}
+
+// choose picks counts[g] nodes out of every group of candidates, each group ranked by the hashing, so that all
+// the picked nodes together satisfy the policy. The required regions are covered first, then the missing
+// regions up to MinRegions, then the groups are filled up; every step takes the best ranked candidate which
+// fits, so a key only moves off its hashing placement as far as a constraint asks.
+func (p PlacementPolicy) choose(groups [][]RkvNode, counts []int) ([][]RkvNode, error) {
+	chosen := make([][]RkvNode, len(groups))
+	taken := make(map[string]bool)
+	zones := make(map[constants.AvailabilityZone]int)
+	regions := make(map[constants.Region]bool)
+
+	fits := func(n RkvNode) bool {
+		if taken[n.Name] || !p.Allows(n.region()) {
+			return false
+		}
+		return p.MaxReplicasPerZone == 0 || n.AvailabilityZone == "" || zones[n.AvailabilityZone] < p.MaxReplicasPerZone
+	}
+	take := func(g int, n RkvNode) {
+		chosen[g] = append(chosen[g], n)
+		taken[n.Name] = true
+		zones[n.AvailabilityZone]++
+		if n.region() != "" {
+			regions[n.region()] = true
+		}
+	}
+	// pick takes the best matching candidate of the first group with a free slot
+	pick := func(match func(RkvNode) bool) bool {
+		for g, candidates := range groups {
+			if len(chosen[g]) >= counts[g] {
+				continue
+			}
+			for _, n := range candidates {
+				if fits(n) && match(n) {
+					take(g, n)
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, r := range p.RequiredRegions {
+		region := r
+		if regions[region] {
+			continue
+		}
+		if !pick(func(n RkvNode) bool { return n.region() == region }) {
+			return nil, fmt.Errorf("%w: no store of the required region %s is available", ErrPolicyUnsatisfied, region)
+		}
+	}
+	for len(regions) < p.MinRegions {
+		if !pick(func(n RkvNode) bool { return n.region() != "" && !regions[n.region()] }) {
+			return nil, fmt.Errorf("%w: the replicas span %d regions instead of at least %d", ErrPolicyUnsatisfied, len(regions), p.MinRegions)
+		}
+	}
+	for g, candidates := range groups {
+		for _, n := range candidates {
+			if len(chosen[g]) >= counts[g] {
+				break
+			}
+			if fits(n) {
+				take(g, n)
+			}
+		}
+		if len(chosen[g]) < counts[g] {
+			return nil, fmt.Errorf("%w: only %d of %d replicas can be placed", ErrPolicyUnsatisfied, len(chosen[g]), counts[g])
+		}
+	}
+
+	// the picked nodes keep the order of the hashing
+	for g, candidates := range groups {
+		rank := make(map[string]int, len(candidates))
+		for i, n := range candidates {
+			rank[n.Name] = i
+		}
+		sort.SliceStable(chosen[g], func(i, j int) bool {
+			return rank[chosen[g][i].Name] < rank[chosen[g][j].Name]
+		})
+	}
+	return chosen, nil
+}
+
+// lead moves the first node of the preferred leader region to the front, keeping the order of the others
+func (p PlacementPolicy) lead(nodes []RkvNode) {
+	if p.PreferredLeaderRegion == "" {
+		return
+	}
+	for i, n := range nodes {
+		if n.region() == p.PreferredLeaderRegion {
+			copy(nodes[1:i+1], nodes[:i])
+			nodes[0] = n
+			return
+		}
+	}
+}
@@ -1,24 +1,30 @@
 package consistent
 
+import (
+	"math"
+	"sort"
+)
+
+// Rendezvous places a key on the nodes with the highest scores for it (highest random weight hashing). The score
+// of a node is -weight/ln(u), u being the hash of the key and the node mapped to (0, 1), so that every node gets
+// a share of the keys proportional to its weight, and adding or removing a node only moves the keys it wins or
+// held.
 type Rendezvous struct {
-	nodes  map[Node]int
-	nstr   []Node
-	nhash  []uint64
-	hasher Hasher
+	nodes   map[string]int
+	nstr    []Node
+	nhash   []uint64
+	weights []float64
+	hasher  Hasher
 }
 
 func NewRendezvous(nodes []Node, hasher Hasher) *Rendezvous {
 	r := &Rendezvous{
-		nodes:  make(map[Node]int, len(nodes)),
-		nstr:   make([]Node, len(nodes)),
-		nhash:  make([]uint64, len(nodes)),
+		nodes:  make(map[string]int, len(nodes)),
 		hasher: hasher,
 	}
 
-	for i, n := range nodes {
-		r.nodes[n] = i
-		r.nstr[i] = n
-		r.nhash[i] = hasher.Hash([]byte(n.String()))
+	for _, n := range nodes {
+		r.AddNode(n)
 	}
 
 	return r
@@ -32,12 +38,12 @@ func (r *Rendezvous) LocateKey(key []byte) Node {
 	khash := r.hasher.Hash(key)
 
 	var midx int
-	var mhash = xorshiftMult64(khash ^ r.nhash[0])
+	var mscore = r.score(khash, 0)
 
-	for i, nhash := range r.nhash[1:] {
-		if h := xorshiftMult64(khash ^ nhash); h > mhash {
-			midx = i + 1
-			mhash = h
+	for i := 1; i < len(r.nstr); i++ {
+		if s := r.score(khash, i); s > mscore {
+			midx = i
+			mscore = s
 		}
 	}
 
@@ -45,9 +51,26 @@ func (r *Rendezvous) LocateKey(key []byte) Node {
 }
 
 func (r *Rendezvous) AddNode(node Node) {
-	r.nodes[node] = len(r.nstr)
+	if _, ok := r.nodes[node.String()]; ok {
+		return
+	}
+	r.nodes[node.String()] = len(r.nstr)
 	r.nstr = append(r.nstr, node)
 	r.nhash = append(r.nhash, r.hasher.Hash([]byte(node.String())))
+	r.weights = append(r.weights, float64(weightOf(node)))
+}
+
+// RemoveNode forgets the node; only the keys it held move, each to the node with its next highest score
+func (r *Rendezvous) RemoveNode(node Node) {
+	idx, ok := r.nodes[node.String()]
+	if !ok {
+		return
+	}
+	last := len(r.nstr) - 1
+	r.nstr[idx], r.nhash[idx], r.weights[idx] = r.nstr[last], r.nhash[last], r.weights[last]
+	r.nodes[r.nstr[idx].String()] = idx
+	r.nstr, r.nhash, r.weights = r.nstr[:last], r.nhash[:last], r.weights[:last]
+	delete(r.nodes, node.String())
 }
 
 func (r *Rendezvous) GetNodes() []Node {
@@ -65,6 +88,15 @@ func xorshiftMult64(x uint64) uint64 {
 	return x * 2685821657736338717
 }
 
+// score is the weighted score of the i-th node for the key hash
+func (r *Rendezvous) score(khash uint64, i int) float64 {
+	h := xorshiftMult64(khash ^ r.nhash[i])
+	// the top 53 bits mapped to (0, 1)
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -r.weights[i] / math.Log(u)
+}
+
+// LocateNodes returns the count nodes with the highest scores for the key, the highest first
 func (r *Rendezvous) LocateNodes(key []byte, count int) []Node {
 	if len(r.nodes) < count {
 		return nil
@@ -72,18 +104,20 @@ func (r *Rendezvous) LocateNodes(key []byte, count int) []Node {
 
 	khash := r.hasher.Hash(key)
 
-	var midx int
-	var mhash = xorshiftMult64(khash ^ r.nhash[0])
-
-	for i, nhash := range r.nhash[1:] {
-		if h := xorshiftMult64(khash ^ nhash); h > mhash {
-			midx = i + 1
-			mhash = h
-		}
+	type scored struct {
+		idx   int
+		score float64
+	}
+	scores := make([]scored, len(r.nstr))
+	for i := range r.nstr {
+		scores[i] = scored{idx: i, score: r.score(khash, i)}
 	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].score > scores[j].score
+	})
 	res := make([]Node, count)
 	for i := 0; i < count; i++ {
-		res[i] = r.nstr[(midx+i)%len(r.nodes)]
+		res[i] = r.nstr[scores[i].idx]
 	}
 	return res
 }
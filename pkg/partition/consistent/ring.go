@@ -2,23 +2,51 @@ package consistent
 
 import (
 	"sort"
+	"strconv"
 	"sync"
 )
 
+// DefaultVirtualNodes is the number of tokens a node of weight 1 owns on the ring
+const DefaultVirtualNodes = 100
+
+// Weighted is implemented by the nodes owning a share of the keys other than 1
+type Weighted interface {
+	GetWeight() int
+}
+
+// weightOf returns the weight of a node, at least 1
+func weightOf(node Node) int {
+	if w, ok := node.(Weighted); ok && w.GetWeight() > 0 {
+		return w.GetWeight()
+	}
+	return 1
+}
+
+// RingHashing places every node at several tokens of a hash ring, virtualNodes times its weight; a key belongs to
+// the node of the first token at or after the hash of the key, so that adding or removing a node only moves the
+// keys of its tokens
 type RingHashing struct {
-	mu        sync.RWMutex
-	hasher    Hasher
-	nodes     map[string]*Node
-	sortedSet []uint64
-	ring      map[uint64]*Node
+	mu           sync.RWMutex
+	hasher       Hasher
+	virtualNodes int
+	nodes        map[string]Node
+	sortedSet    []uint64
+	ring         map[uint64]string
 }
 
 func NewRingHashing(hasher Hasher) *RingHashing {
+	return NewRingHashingWithVirtualNodes(hasher, DefaultVirtualNodes)
+}
 
+func NewRingHashingWithVirtualNodes(hasher Hasher, virtualNodes int) *RingHashing {
+	if virtualNodes < 1 {
+		virtualNodes = DefaultVirtualNodes
+	}
 	rh := &RingHashing{
-		nodes:  make(map[string]*Node),
-		ring:   make(map[uint64]*Node),
-		hasher: hasher,
+		nodes:        make(map[string]Node),
+		ring:         make(map[uint64]string),
+		hasher:       hasher,
+		virtualNodes: virtualNodes,
 	}
 
 	return rh
@@ -35,13 +63,46 @@ func (rh *RingHashing) AddNode(node Node) {
 }
 
 func (rh *RingHashing) addNode(node Node) {
-	hKey := rh.hasher.Hash([]byte(node.String()))
-	rh.ring[hKey] = &node
-	rh.sortedSet = append(rh.sortedSet, hKey)
+	name := node.String()
+	tokens := rh.virtualNodes * weightOf(node)
+	for i := 0; i < tokens; i++ {
+		hKey := rh.token(name, i)
+		// on the rare collision the token stays with its first owner
+		if _, taken := rh.ring[hKey]; taken {
+			continue
+		}
+		rh.ring[hKey] = name
+		rh.sortedSet = append(rh.sortedSet, hKey)
+	}
 	sort.Slice(rh.sortedSet, func(i int, j int) bool {
 		return rh.sortedSet[i] < rh.sortedSet[j]
 	})
-	rh.nodes[node.String()] = &node
+	rh.nodes[name] = node
+}
+
+// RemoveNode takes the tokens of the node off the ring
+func (rh *RingHashing) RemoveNode(node Node) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+
+	name := node.String()
+	if _, ok := rh.nodes[name]; !ok {
+		return
+	}
+	sortedSet := rh.sortedSet[:0]
+	for _, hKey := range rh.sortedSet {
+		if rh.ring[hKey] == name {
+			delete(rh.ring, hKey)
+			continue
+		}
+		sortedSet = append(sortedSet, hKey)
+	}
+	rh.sortedSet = sortedSet
+	delete(rh.nodes, name)
+}
+
+func (rh *RingHashing) token(name string, i int) uint64 {
+	return rh.hasher.Hash([]byte(name + "#" + strconv.Itoa(i)))
 }
 
 func (rh *RingHashing) LocateKey(key []byte) Node {
@@ -49,25 +110,32 @@ func (rh *RingHashing) LocateKey(key []byte) Node {
 	return rh.GetPartitionOwner(partID)
 }
 
+// FindPartitionID returns the position on the ring of the first token at or after the hash of the key, or -1 if
+// the ring is empty
 func (rh *RingHashing) FindPartitionID(key []byte) int {
-	if len(rh.nodes) == 0 {
+	rh.mu.RLock()
+	defer rh.mu.RUnlock()
+	return rh.search(key)
+}
+
+func (rh *RingHashing) search(key []byte) int {
+	if len(rh.sortedSet) == 0 {
 		return -1
 	}
 	hkey := rh.hasher.Hash(key)
-	return int(hkey % uint64(len(rh.nodes)))
+	i := sort.Search(len(rh.sortedSet), func(i int) bool {
+		return rh.sortedSet[i] >= hkey
+	})
+	return i % len(rh.sortedSet)
 }
 
 func (rh *RingHashing) GetPartitionOwner(partID int) Node {
 	rh.mu.RLock()
 	defer rh.mu.RUnlock()
-	if partID < 0 {
-		return nil
-	}
-	node, ok := rh.ring[rh.sortedSet[partID]]
-	if !ok {
+	if partID < 0 || partID >= len(rh.sortedSet) {
 		return nil
 	}
-	return *node
+	return rh.nodes[rh.ring[rh.sortedSet[partID]]]
 }
 
 func (rh *RingHashing) GetNodes() []Node {
@@ -76,28 +144,31 @@ func (rh *RingHashing) GetNodes() []Node {
 
 	nodes := make([]Node, 0, len(rh.nodes))
 	for _, node := range rh.nodes {
-		nodes = append(nodes, *node)
+		nodes = append(nodes, node)
 	}
 	return nodes
 }
 
+// LocateNodes walks the ring clockwise from the key and returns the first count distinct nodes
 func (rh *RingHashing) LocateNodes(key []byte, count int) []Node {
+	rh.mu.RLock()
+	defer rh.mu.RUnlock()
 	if len(rh.nodes) < count {
 		return nil
 	}
-	partID := rh.FindPartitionID(key)
-	rh.mu.RLock()
-	defer rh.mu.RUnlock()
+	partID := rh.search(key)
 	if partID < 0 {
 		return nil
 	}
-	res := make([]Node, count)
-	for i := 0; i < count; i++ {
-		node, ok := rh.ring[rh.sortedSet[(partID+i)%len(rh.nodes)]]
-		if !ok {
-			return nil
+	res := make([]Node, 0, count)
+	seen := make(map[string]bool, count)
+	for i := 0; i < len(rh.sortedSet) && len(res) < count; i++ {
+		name := rh.ring[rh.sortedSet[(partID+i)%len(rh.sortedSet)]]
+		if seen[name] {
+			continue
 		}
-		res[i] = *node
+		seen[name] = true
+		res = append(res, rh.nodes[name])
 	}
 
 	return res
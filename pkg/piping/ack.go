@@ -0,0 +1,83 @@
+package piping
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/regionless-storage-service/pkg/constants"
+)
+
+// AckPolicy is the number of acknowledgements a write waits for among the replicas written synchronously
+type AckPolicy struct {
+	Policy constants.AckPolicy
+	// Count is the number of acknowledgements required by the firstN policy
+	Count int
+}
+
+func (a AckPolicy) Validate() error {
+	switch a.Policy {
+	case "", constants.AckAll, constants.AckMajority:
+		return nil
+	case constants.AckFirstN:
+		if a.Count < 1 {
+			return fmt.Errorf("the %s ack policy requires a positive count instead of %d", a.Policy, a.Count)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported ack policy %s", a.Policy)
+	}
+}
+
+// Required returns how many of the given number of replicas have to acknowledge a write
+func (a AckPolicy) Required(replicas int) int {
+	required := replicas
+	switch a.Policy {
+	case constants.AckMajority:
+		required = replicas/2 + 1
+	case constants.AckFirstN:
+		required = a.Count
+	}
+	if required > replicas {
+		required = replicas
+	}
+	return required
+}
+
+type ackResult struct {
+	i   int
+	err error
+}
+
+// fanOut applies op to all the nodes concurrently, passing the position of the node. It returns as soon as
+// required nodes succeeded, leaving the others to complete in the background. Once that is no longer possible,
+// it waits for all the nodes and returns an error together with the positions of the nodes that did succeed,
+// so that the caller is able to roll them back.
+func fanOut(nodes []string, required int, op func(i int) error) ([]int, error) {
+	if required < 1 {
+		for i := range nodes {
+			go op(i)
+		}
+		return nil, nil
+	}
+	results := make(chan ackResult, len(nodes))
+	for i := range nodes {
+		go func(i int) {
+			results <- ackResult{i: i, err: op(i)}
+		}(i)
+	}
+	succeeded := make([]int, 0, len(nodes))
+	failures := make([]string, 0)
+	for range nodes {
+		res := <-results
+		if res.err == nil {
+			succeeded = append(succeeded, res.i)
+			if len(succeeded) >= required {
+				return succeeded, nil
+			}
+		} else {
+			failures = append(failures, fmt.Sprintf("%s: %v", nodes[res.i], res.err))
+		}
+	}
+	return succeeded, fmt.Errorf("%d of %d replicas acknowledged while %d are required: %s",
+		len(succeeded), len(nodes), required, strings.Join(failures, "; "))
+}
@@ -2,39 +2,61 @@ package piping
 
 import (
 	"context"
-	"sync"
+	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/regionless-storage-service/pkg/config"
 	"github.com/regionless-storage-service/pkg/consistent"
 	"github.com/regionless-storage-service/pkg/consistent/chain"
 	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/database"
 	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/replication"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
+	"k8s.io/klog"
 )
 
+var errAsyncWithoutReplicator = errors.New("the chain piping has no replicator for the async replicas")
+
+// ChainPiping writes a revision through its sync replicas in placement order, the leader at the head. The async
+// replicas are not part of the chain: their operations are handed over to the replicator.
 type ChainPiping struct {
 	databaseType constants.StoreType
-	consistency  consistent.CONSISTENCY
-	concurrent   bool
+	// consistency is the default level used when the request context does not carry one
+	consistency consistent.CONSISTENCY
+	concurrent  bool
+	// ack applies to the concurrent writes; a chain write is acknowledged by the tail
+	ack AckPolicy
+	// replicator is optional; without it the revisions with async replicas are rejected
+	replicator Replicator
 }
 
 func NewChainPiping(databaseType constants.StoreType, consistency consistent.CONSISTENCY, concurrent bool) *ChainPiping {
-	return &ChainPiping{databaseType: databaseType, consistency: consistency, concurrent: concurrent}
+	return NewChainPipingWithAckPolicy(databaseType, consistency, concurrent, AckPolicy{Policy: constants.AckAll})
+}
+
+func NewChainPipingWithAckPolicy(databaseType constants.StoreType, consistency consistent.CONSISTENCY, concurrent bool, ack AckPolicy) *ChainPiping {
+	return NewChainPipingWithReplicator(databaseType, consistency, concurrent, nil, ack)
+}
+
+func NewChainPipingWithReplicator(databaseType constants.StoreType, consistency consistent.CONSISTENCY, concurrent bool, replicator Replicator, ack AckPolicy) *ChainPiping {
+	return &ChainPiping{databaseType: databaseType, consistency: consistency, concurrent: concurrent, ack: ack, replicator: replicator}
 }
 
 func (c *ChainPiping) Read(ctx context.Context, rev index.Revision) (string, error) {
-	chain, err := chain.NewChain(ctx, c.databaseType, rev.GetNodes())
+	chain, err := chain.NewChain(ctx, c.databaseType, rev.GetPlacement().SyncNodes())
 	if err != nil {
 		return "", err
 	}
 	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "chain read")
 	defer rootSpan.End()
-	return chain.Read(rev.String(), c.consistency)
+	return chain.Read(rev.String(), consistent.FromContext(ctx, c.consistency))
 }
 
 func (c *ChainPiping) ReadTail(ctx context.Context, rev index.Revision) (string, error) {
-	chain, err := chain.NewChain(ctx, c.databaseType, rev.GetNodes())
+	chain, err := chain.NewChain(ctx, c.databaseType, rev.GetPlacement().SyncNodes())
 	if err != nil {
 		return "", err
 	}
@@ -44,61 +66,139 @@ func (c *ChainPiping) ReadTail(ctx context.Context, rev index.Revision) (string,
 }
 
 func (c *ChainPiping) Write(ctx context.Context, rev index.Revision, val string) error {
-	nodeChains, err := chain.NewChain(ctx, c.databaseType, rev.GetNodes())
+	placement := rev.GetPlacement()
+	nodeChains, err := chain.NewChain(ctx, c.databaseType, placement.SyncNodes())
 	if err != nil {
 		return err
 	}
 	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "chain write")
 	defer rootSpan.End()
+	// the async operations are queued ahead of the chain so that a full queue rejects the write untouched
+	asyncNodes := placement.AsyncNodes()
+	if err := c.enqueue(asyncNodes, replication.OpPut, rev.String(), val); err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if err := c.writeChain(ctx, nodeChains, placement.SyncNodes(), rev, val); err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		if err := c.enqueue(asyncNodes, replication.OpDelete, rev.String(), ""); err != nil {
+			klog.Warningf("failed to queue the rollback of the revision %s: %v", rev.String(), err)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeChain writes the value to the sync replicas, taking it back from them if the write fails
+func (c *ChainPiping) writeChain(ctx context.Context, nodeChains *chain.Chain, syncNodes []string, rev index.Revision, val string) error {
+	consistency := consistent.FromContext(ctx, c.consistency)
 	if c.concurrent {
-		var wg sync.WaitGroup
-		p := nodeChains.GetHead()
-		for p != nil {
-			wg.Add(1)
-			go func(ctx context.Context, node *chain.ChainNode, key, val string) {
-				defer wg.Done()
-				_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "db put")
-				defer rootSpan.End()
-				if _, err := node.GetDB().Put(key, val); err != nil {
-					rootSpan.RecordError(err)
-					rootSpan.SetStatus(codes.Error, err.Error())
+		// a linearizable write waits for the acks required by the ack policy while a sequential one only waits for one
+		succeeded, err := fanOut(syncNodes, c.requiredAcks(consistency, len(syncNodes)), func(i int) error {
+			_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "db put")
+			defer rootSpan.End()
+			db, err := database.FactoryWithNameAndLatency(c.databaseType, syncNodes[i], 0)
+			if err == nil {
+				_, err = db.Put(rev.String(), val)
+			}
+			if err != nil {
+				rootSpan.RecordError(err)
+				rootSpan.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		})
+		if err != nil {
+			for _, i := range succeeded {
+				if db, err := database.FactoryWithNameAndLatency(c.databaseType, syncNodes[i], 0); err == nil {
+					c.rollback(db, syncNodes[i], rev.String())
 				}
-			}(ctx, p, rev.String(), val)
-			p = p.GetNext()
+			}
+			return fmt.Errorf("failed to write the revision %s: %v", rev.String(), err)
 		}
-		wg.Wait()
-	} else {
-		nodeChains.Write(rev.String(), val, c.consistency)
+		return nil
+	}
+	if failedAt, err := nodeChains.Write(rev.String(), val, consistency); err != nil {
+		// the chain stops at the first failing node, so only the nodes before it hold the value
+		for i, node := range chainNodes(nodeChains)[:failedAt] {
+			c.rollback(node.GetDB(), syncNodes[i], rev.String())
+		}
+		return fmt.Errorf("failed to write the revision %s: %v", rev.String(), err)
 	}
 	return nil
 }
 
 func (c *ChainPiping) Delete(ctx context.Context, rev index.Revision) error {
-	nodeChains, err := chain.NewChain(ctx, c.databaseType, rev.GetNodes())
+	placement := rev.GetPlacement()
+	nodeChains, err := chain.NewChain(ctx, c.databaseType, placement.SyncNodes())
 	if err != nil {
 		return err
 	}
 	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "chain delete")
 	defer rootSpan.End()
+	if err := c.enqueue(placement.AsyncNodes(), replication.OpDelete, rev.String(), ""); err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	consistency := consistent.FromContext(ctx, c.consistency)
 	if c.concurrent {
-		var wg sync.WaitGroup
-		p := nodeChains.GetHead()
-		for p != nil {
-			wg.Add(1)
-			go func(ctx context.Context, node *chain.ChainNode, key string) {
-				defer wg.Done()
-				_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "db delete")
-				defer rootSpan.End()
-				if err := node.GetDB().Delete(key); err != nil {
-					rootSpan.RecordError(err)
-					rootSpan.SetStatus(codes.Error, err.Error())
-				}
-			}(ctx, p, rev.String())
-			p = p.GetNext()
-		}
-		wg.Wait()
+		syncNodes := placement.SyncNodes()
+		_, err = fanOut(syncNodes, c.requiredAcks(consistency, len(syncNodes)), func(i int) error {
+			_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "db delete")
+			defer rootSpan.End()
+			db, err := database.FactoryWithNameAndLatency(c.databaseType, syncNodes[i], 0)
+			if err == nil {
+				err = db.Delete(rev.String())
+			}
+			if err != nil {
+				rootSpan.RecordError(err)
+				rootSpan.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		})
 	} else {
-		nodeChains.Delete(rev.String(), c.consistency)
+		err = nodeChains.Delete(rev.String(), consistency)
+	}
+	if err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to delete the revision %s: %v", rev.String(), err)
 	}
 	return nil
 }
+
+func (c *ChainPiping) requiredAcks(consistency consistent.CONSISTENCY, nodes int) int {
+	if consistency == consistent.SEQUENTIAL && nodes > 0 {
+		return 1
+	}
+	return c.ack.Required(nodes)
+}
+
+// enqueue hands an operation of the async replicas over to the replicator
+func (c *ChainPiping) enqueue(asyncNodes []string, op replication.Op, key, val string) error {
+	if len(asyncNodes) == 0 {
+		return nil
+	}
+	if c.replicator == nil {
+		return fmt.Errorf("%w: %s", errAsyncWithoutReplicator, strings.Join(asyncNodes, ","))
+	}
+	_, err := enqueue(c.replicator, asyncNodes, op, key, val)
+	return err
+}
+
+// rollback takes a value back from a node which accepted the write of a failed revision
+func (c *ChainPiping) rollback(db database.Database, name, key string) {
+	if err := db.Delete(key); err != nil {
+		klog.Warningf("failed to roll back the revision %s on %s: %v", key, name, err)
+	}
+}
+
+func chainNodes(c *chain.Chain) []*chain.ChainNode {
+	nodes := make([]*chain.ChainNode, 0, c.GetLen())
+	for p := c.GetHead(); p != nil; p = p.GetNext() {
+		nodes = append(nodes, p)
+	}
+	return nodes
+}
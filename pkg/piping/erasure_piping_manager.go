@@ -0,0 +1,236 @@
+package piping
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/erasure"
+	"github.com/regionless-storage-service/pkg/index"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"k8s.io/klog"
+)
+
+// ErasurePiping stores a revision as Reed-Solomon shards instead of full copies: the value is split into data
+// shards and parity shards are added, one shard per store of the placement, in placement order. A read
+// gathers any data shards' worth of distinct shards, so it survives as many lost stores as there are parity
+// shards, for a fraction of the storage and of the traffic of the replication.
+type ErasurePiping struct {
+	databaseType constants.StoreType
+	coder        *erasure.Coder
+	// ack is applied to all the shards, a write never succeeding with fewer than the data shards
+	ack AckPolicy
+}
+
+func NewErasurePiping(databaseType constants.StoreType, dataShards, parityShards int, ack AckPolicy) (*ErasurePiping, error) {
+	coder, err := erasure.NewCoder(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &ErasurePiping{databaseType: databaseType, coder: coder, ack: ack}, nil
+}
+
+// shards checks that the revision is placed on a store per shard
+func (ep *ErasurePiping) shards(rev index.Revision) ([]string, error) {
+	nodes := rev.GetPlacement().Nodes()
+	if len(nodes) == 0 {
+		return nil, errNoReplica
+	}
+	if want := ep.coder.DataShards() + ep.coder.ParityShards(); len(nodes) != want {
+		return nil, fmt.Errorf("the revision %s is placed on %d stores instead of the %d of its shards", rev.String(), len(nodes), want)
+	}
+	return nodes, nil
+}
+
+// requiredAcks is the ack policy over all the shards, but at least the data shards to be able to read it back
+func (ep *ErasurePiping) requiredAcks(shards int) int {
+	required := ep.ack.Required(shards)
+	if required < ep.coder.DataShards() {
+		required = ep.coder.DataShards()
+	}
+	return required
+}
+
+func (ep *ErasurePiping) Write(ctx context.Context, rev index.Revision, val string) error {
+	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "ErasurePiping write")
+	defer rootSpan.End()
+	nodes, err := ep.shards(rev)
+	if err != nil {
+		return err
+	}
+	shards, err := ep.coder.EncodeValue([]byte(val))
+	if err != nil {
+		return err
+	}
+
+	succeeded, err := fanOut(nodes, ep.requiredAcks(len(nodes)), func(i int) error {
+		return ep.put(ctx, nodes[i], rev.String(), string(shards[i].Marshal()))
+	})
+	if err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		// the write as a whole failed, so the shards are taken back from the stores which have accepted them
+		for _, i := range succeeded {
+			if err := ep.delete(ctx, nodes[i], rev.String()); err != nil {
+				klog.Warningf("failed to roll back the shard of the revision %s on %s: %v", rev.String(), nodes[i], err)
+			}
+		}
+		return fmt.Errorf("failed to write the revision %s: %v", rev.String(), err)
+	}
+	return nil
+}
+
+type shardResult struct {
+	node  string
+	shard erasure.Shard
+	err   error
+}
+
+// Read asks the stores of the data shards first, as the value is then joined without decoding, and moves on
+// to the stores of the parity shards as the others fail, until it holds any data shards' worth of shards.
+// The stores whose circuit is open come last.
+func (ep *ErasurePiping) Read(ctx context.Context, rev index.Revision) (string, error) {
+	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "ErasurePiping read")
+	defer rootSpan.End()
+	nodes, err := ep.shards(rev)
+	if err != nil {
+		return "", err
+	}
+	candidates := availableFirst(nodes)
+	results := make(chan shardResult, len(candidates))
+	next, inflight := 0, 0
+	launch := func() {
+		go func(node string) {
+			shard, err := ep.get(ctx, node, rev.String())
+			results <- shardResult{node: node, shard: shard, err: err}
+		}(candidates[next])
+		next++
+		inflight++
+	}
+	needed := ep.coder.DataShards()
+	for next < needed {
+		launch()
+	}
+
+	shards := make([]erasure.Shard, 0, needed)
+	held := make(map[int]bool)
+	notFound := 0
+	failures := make([]string, 0)
+	for inflight > 0 {
+		res := <-results
+		inflight--
+		switch {
+		case res.err == nil && !held[res.shard.Index]:
+			held[res.shard.Index] = true
+			shards = append(shards, res.shard)
+		case res.err == nil:
+			failures = append(failures, fmt.Sprintf("%s: the shard %d is held twice", res.node, res.shard.Index))
+		default:
+			if database.IsNotFound(res.err) {
+				notFound++
+			}
+			failures = append(failures, fmt.Sprintf("%s: %v", res.node, res.err))
+		}
+		if len(shards) == needed {
+			if len(failures) > 0 {
+				klog.V(4).Infof("degraded read of the revision %s: %s", rev.String(), strings.Join(failures, "; "))
+			}
+			val, err := ep.coder.DecodeValue(shards)
+			if err != nil {
+				rootSpan.RecordError(err)
+				rootSpan.SetStatus(codes.Error, err.Error())
+				return "", err
+			}
+			return string(val), nil
+		}
+		if next < len(candidates) && len(shards)+inflight < needed {
+			launch()
+		}
+	}
+
+	// a revision is reported missing only if every store has been reached and none of them holds a shard
+	if notFound == len(candidates) {
+		err = fmt.Errorf("%w on any of the stores %s", database.ErrKeyNotFound, strings.Join(candidates, ","))
+	} else {
+		err = fmt.Errorf("%w: %d of the %d shards needed: %s", ErrUnavailable, len(shards), needed, strings.Join(failures, "; "))
+	}
+	rootSpan.RecordError(err)
+	rootSpan.SetStatus(codes.Error, err.Error())
+	return "", err
+}
+
+func (ep *ErasurePiping) Delete(ctx context.Context, rev index.Revision) error {
+	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "ErasurePiping delete")
+	defer rootSpan.End()
+	nodes, err := ep.shards(rev)
+	if err != nil {
+		return err
+	}
+	if _, err := fanOut(nodes, len(nodes), func(i int) error {
+		return ep.delete(ctx, nodes[i], rev.String())
+	}); err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to delete the revision %s: %v", rev.String(), err)
+	}
+	return nil
+}
+
+func (ep *ErasurePiping) get(ctx context.Context, name, key string) (erasure.Shard, error) {
+	_, span := otel.Tracer(config.TraceName).Start(ctx, "shard get")
+	defer span.End()
+	db, err := database.FactoryWithNameAndLatency(ep.databaseType, name, 0)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return erasure.Shard{}, err
+	}
+	val, err := db.Get(key)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return erasure.Shard{}, err
+	}
+	// a corrupt shard is left out as a lost one would be
+	shard, err := erasure.UnmarshalShard([]byte(val))
+	if err == nil && (shard.DataShards != ep.coder.DataShards() || shard.ParityShards != ep.coder.ParityShards()) {
+		err = fmt.Errorf("%w: the shard %d is of a %d+%d code", erasure.ErrCorruptShard, shard.Index, shard.DataShards, shard.ParityShards)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return shard, err
+}
+
+func (ep *ErasurePiping) put(ctx context.Context, name, key, val string) error {
+	_, span := otel.Tracer(config.TraceName).Start(ctx, "shard put")
+	defer span.End()
+	db, err := database.FactoryWithNameAndLatency(ep.databaseType, name, 0)
+	if err == nil {
+		_, err = db.Put(key, val)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (ep *ErasurePiping) delete(ctx context.Context, name, key string) error {
+	_, span := otel.Tracer(config.TraceName).Start(ctx, "shard delete")
+	defer span.End()
+	db, err := database.FactoryWithNameAndLatency(ep.databaseType, name, 0)
+	if err == nil {
+		err = db.Delete(key)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package piping
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/database"
+)
+
+// ErrUnavailable is returned by a read when no replica holding the revision could serve it
+var ErrUnavailable = errors.New("no replica available")
+
+const (
+	latencyWindowSize = 256
+	// hedgeMinSamples is the number of observed reads before the hedging delay is trusted
+	hedgeMinSamples = 20
+)
+
+// latencyWindow keeps the latencies of the most recent successful reads
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (lw *latencyWindow) observe(d time.Duration) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.samples) < latencyWindowSize {
+		lw.samples = append(lw.samples, d)
+		return
+	}
+	lw.samples[lw.next] = d
+	lw.next = (lw.next + 1) % latencyWindowSize
+}
+
+// percentile returns the p-th percentile of the recent latencies, or false if there are too few of them
+func (lw *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	lw.mu.Lock()
+	if len(lw.samples) < hedgeMinSamples {
+		lw.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, len(lw.samples))
+	copy(sorted, lw.samples)
+	lw.mu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p / 100 * float64(len(sorted)-1))
+	if idx < 0 {
+		idx = 0
+	} else if idx > len(sorted)-1 {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx], true
+}
+
+type readResult struct {
+	node string
+	val  string
+	err  error
+}
+
+// availableFirst moves the nodes whose circuit is open to the end, keeping the order otherwise; they are still
+// tried last as they may be the only ones holding the revision
+func availableFirst(candidates []string) []string {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return database.Available(candidates[i]) && !database.Available(candidates[j])
+	})
+	return candidates
+}
+
+// readWithFailover reads the key from the candidates in order, moving on to the next candidate whenever one fails.
+// If hedgePercentile is positive, the next candidate is also asked once the pending reads take longer than that
+// percentile of the recent reads. The first value returned wins, since a revision never changes once written.
+func readWithFailover(candidates []string, get func(node string) (string, error), lw *latencyWindow, hedgePercentile float64) (string, error) {
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("%w: the revision does not have any nodes", ErrUnavailable)
+	}
+	results := make(chan readResult, len(candidates))
+	next, inflight := 0, 0
+	launch := func() {
+		go func(node string) {
+			start := time.Now()
+			val, err := get(node)
+			if err == nil {
+				lw.observe(time.Since(start))
+			}
+			results <- readResult{node: node, val: val, err: err}
+		}(candidates[next])
+		next++
+		inflight++
+	}
+
+	launch()
+	notFound := 0
+	failures := make([]string, 0)
+	for inflight > 0 {
+		var hedge <-chan time.Time
+		var timer *time.Timer
+		if hedgePercentile > 0 && next < len(candidates) {
+			if delay, ok := lw.percentile(hedgePercentile); ok {
+				timer = time.NewTimer(delay)
+				hedge = timer.C
+			}
+		}
+		select {
+		case res := <-results:
+			inflight--
+			if res.err == nil {
+				if timer != nil {
+					timer.Stop()
+				}
+				return res.val, nil
+			}
+			if database.IsNotFound(res.err) {
+				notFound++
+			}
+			failures = append(failures, fmt.Sprintf("%s: %v", res.node, res.err))
+			if next < len(candidates) {
+				launch()
+			}
+		case <-hedge:
+			launch()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	// a revision is reported missing only if every replica has been reached and none of them holds it
+	if notFound == len(candidates) {
+		return "", fmt.Errorf("%w on any of the replicas %s", database.ErrKeyNotFound, strings.Join(candidates, ","))
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnavailable, strings.Join(failures, "; "))
+}
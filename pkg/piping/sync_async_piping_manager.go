@@ -2,173 +2,234 @@ package piping
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
-	"sync"
+	"math/rand"
 
 	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/consistent"
 	"github.com/regionless-storage-service/pkg/constants"
 	"github.com/regionless-storage-service/pkg/database"
 	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/replication"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
+	"k8s.io/klog"
 )
 
+var errNoReplica = errors.New("no stores in the revision")
+
+// Replicator hands the operations of the async nodes over to durable replication queues
+type Replicator interface {
+	Enqueue(dest string, op replication.Op, key, value string) error
+}
+
 type SyncAsyncPiping struct {
 	databaseType constants.StoreType
+	// consistency is the default level used when the request context does not carry one
+	consistency consistent.CONSISTENCY
+	// replicator is optional; without it the async nodes are written by fire-and-forget goroutines
+	replicator Replicator
+	ack        AckPolicy
+
+	readLatency     latencyWindow
+	hedgePercentile float64
 }
 
 func NewSyncAsyncPiping(storeType constants.StoreType) *SyncAsyncPiping {
-	return &SyncAsyncPiping{databaseType: storeType}
+	return NewSyncAsyncPipingWithConsistency(storeType, consistent.LINEARIZABLE)
+}
+
+func NewSyncAsyncPipingWithConsistency(storeType constants.StoreType, consistency consistent.CONSISTENCY) *SyncAsyncPiping {
+	return NewSyncAsyncPipingWithReplicator(storeType, consistency, nil, AckPolicy{Policy: constants.AckAll})
+}
+
+func NewSyncAsyncPipingWithReplicator(storeType constants.StoreType, consistency consistent.CONSISTENCY, replicator Replicator, ack AckPolicy) *SyncAsyncPiping {
+	return &SyncAsyncPiping{databaseType: storeType, consistency: consistency, replicator: replicator, ack: ack}
 }
 
+// Read tries the sync nodes, ordered by latency, and then the async ones until one of them returns the value.
+// A sequential read shuffles the replicas to spread the load. The replicas whose circuit is open come last.
 func (sap *SyncAsyncPiping) Read(ctx context.Context, rev index.Revision) (string, error) {
 	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "SyncAsyncPiping Read")
 	defer rootSpan.End()
-	syncNodes, asyncNodes, err := splitStores(rev.GetNodes())
-	if err != nil {
-		return "", err
+	placement := rev.GetPlacement()
+	if placement.IsEmpty() {
+		return "", errNoReplica
 	}
-	target := ""
-	if len(syncNodes) > 0 {
-		target = syncNodes[0]
-	} else if len(asyncNodes) > 0 {
-		target = asyncNodes[0]
-	} else {
-		return "", fmt.Errorf("the rev %v does not have any nodes", rev)
+	candidates := placement.Nodes()
+	if consistent.FromContext(ctx, sap.consistency) == consistent.SEQUENTIAL {
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
 	}
-	// The first sync store has the fewest latency. Threfore, it is chosen to read
-	if database, err := database.FactoryWithNameAndLatency(sap.databaseType, target, 0); err != nil {
+	candidates = availableFirst(candidates)
+	val, err := readWithFailover(candidates, func(name string) (string, error) {
+		_, span := otel.Tracer(config.TraceName).Start(ctx, "db get")
+		defer span.End()
+		db, err := database.FactoryWithNameAndLatency(sap.databaseType, name, 0)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return "", err
+		}
+		val, err := db.Get(rev.String())
+		if err == nil {
+			return val, nil
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return "", err
-	} else {
-		return database.Get(rev.String())
+	}, &sap.readLatency, sap.hedgePercentile)
+	if err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
 	}
+	return val, err
+}
+
+// SetReadHedgePercentile enables hedged reads: the next replica is also asked once a read takes longer than
+// the given percentile of the recent reads. 0 disables hedging.
+func (sap *SyncAsyncPiping) SetReadHedgePercentile(percentile float64) {
+	sap.hedgePercentile = percentile
 }
 
 func (sap *SyncAsyncPiping) Write(ctx context.Context, rev index.Revision, val string) error {
 	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "SyncAsyncPiping write")
 	defer rootSpan.End()
-	syncNodes, asyncNodes, err := splitStores(rev.GetNodes())
-	if err != nil {
-		return err
+	placement := rev.GetPlacement()
+	if placement.IsEmpty() {
+		return errNoReplica
 	}
-
-	for _, asyncNode := range asyncNodes {
-		if len(asyncNode) < 1 {
-			continue
+	syncNodes, asyncNodes := placement.SyncNodes(), placement.AsyncNodes()
+
+	// The async operations are queued ahead of the sync writes so that a full queue rejects the write untouched
+	if sap.replicator != nil {
+		if queued, err := enqueue(sap.replicator, asyncNodes, replication.OpPut, rev.String(), val); err != nil {
+			sap.replicateAsync(ctx, queued, replication.OpDelete, rev.String(), "")
+			rootSpan.RecordError(err)
+			rootSpan.SetStatus(codes.Error, err.Error())
+			return err
 		}
-		go func(ctx context.Context, databaseType constants.StoreType, name, key, val string) {
-			_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "async db put")
-			defer rootSpan.End()
-			if database, err := database.FactoryWithNameAndLatency(sap.databaseType, name, 0); err != nil {
-				rootSpan.RecordError(err)
-				rootSpan.SetStatus(codes.Error, err.Error())
-			} else {
-				if _, err := database.Put(key, val); err != nil {
-					rootSpan.RecordError(err)
-					rootSpan.SetStatus(codes.Error, err.Error())
-				}
-			}
-
-		}(ctx, sap.databaseType, asyncNode, rev.String(), val)
 	}
 
-	var wg sync.WaitGroup
-	for _, syncNode := range syncNodes {
-		wg.Add(1)
-		go func(ctx context.Context, databaseType constants.StoreType, name, key, val string) {
-			defer wg.Done()
-			if len(name) < 1 {
-				return
-			}
-			_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "sync db put")
-			defer rootSpan.End()
-			if database, err := database.FactoryWithNameAndLatency(sap.databaseType, name, 0); err != nil {
-				rootSpan.RecordError(err)
-				rootSpan.SetStatus(codes.Error, err.Error())
-			} else {
-				if _, err := database.Put(key, val); err != nil {
-					rootSpan.RecordError(err)
-					rootSpan.SetStatus(codes.Error, err.Error())
-				}
+	succeeded, err := fanOut(syncNodes, sap.requiredAcks(ctx, len(syncNodes)), func(i int) error {
+		return sap.put(ctx, "sync db put", syncNodes[i], rev.String(), val)
+	})
+	if err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		// The write as a whole failed, so the value is taken back from the replicas which have accepted it
+		for _, i := range succeeded {
+			if err := sap.delete(ctx, "sync db rollback", syncNodes[i], rev.String()); err != nil {
+				klog.Warningf("failed to roll back the revision %s on %s: %v", rev.String(), syncNodes[i], err)
 			}
-
-		}(ctx, sap.databaseType, syncNode, rev.String(), val)
+		}
+		if sap.replicator != nil {
+			sap.replicateAsync(ctx, asyncNodes, replication.OpDelete, rev.String(), "")
+		}
+		return fmt.Errorf("failed to write the revision %s: %v", rev.String(), err)
+	}
+	if sap.replicator == nil {
+		sap.replicateAsync(ctx, asyncNodes, replication.OpPut, rev.String(), val)
 	}
-	wg.Wait()
-
 	return nil
 }
 
 func (sap *SyncAsyncPiping) Delete(ctx context.Context, rev index.Revision) error {
 	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "SyncAsyncPiping delete")
 	defer rootSpan.End()
-	syncNodes, asyncNodes, err := splitStores(rev.GetNodes())
-	if err != nil {
-		return err
+	placement := rev.GetPlacement()
+	if placement.IsEmpty() {
+		return errNoReplica
 	}
+	syncNodes, asyncNodes := placement.SyncNodes(), placement.AsyncNodes()
 
-	for _, asyncNode := range asyncNodes {
-		if len(asyncNode) < 1 {
-			continue
+	if sap.replicator != nil {
+		if _, err := enqueue(sap.replicator, asyncNodes, replication.OpDelete, rev.String(), ""); err != nil {
+			rootSpan.RecordError(err)
+			rootSpan.SetStatus(codes.Error, err.Error())
+			return err
 		}
-		go func(ctx context.Context, databaseType constants.StoreType, name, key string) {
-			_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "async db delete")
-			defer rootSpan.End()
-			if database, err := database.FactoryWithNameAndLatency(sap.databaseType, name, 0); err != nil {
-				rootSpan.RecordError(err)
-				rootSpan.SetStatus(codes.Error, err.Error())
-			} else {
-				if err := database.Delete(key); err != nil {
-					rootSpan.RecordError(err)
-					rootSpan.SetStatus(codes.Error, err.Error())
-				}
-			}
+	} else {
+		sap.replicateAsync(ctx, asyncNodes, replication.OpDelete, rev.String(), "")
+	}
 
-		}(ctx, sap.databaseType, asyncNode, rev.String())
+	if _, err := fanOut(syncNodes, sap.requiredAcks(ctx, len(syncNodes)), func(i int) error {
+		return sap.delete(ctx, "sync db delete", syncNodes[i], rev.String())
+	}); err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to delete the revision %s: %v", rev.String(), err)
 	}
+	return nil
+}
 
-	var wg sync.WaitGroup
-	for _, syncNode := range syncNodes {
-		wg.Add(1)
-		go func(ctx context.Context, databaseType constants.StoreType, name, key string) {
-			defer wg.Done()
-			if len(name) < 1 {
-				return
-			}
-			_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, "sync db delete")
-			defer rootSpan.End()
-			if database, err := database.FactoryWithNameAndLatency(sap.databaseType, name, 0); err != nil {
-				rootSpan.RecordError(err)
-				rootSpan.SetStatus(codes.Error, err.Error())
-			} else {
-				if err := database.Delete(key); err != nil {
-					rootSpan.RecordError(err)
-					rootSpan.SetStatus(codes.Error, err.Error())
-				}
-			}
+// requiredAcks returns how many sync nodes have to acknowledge a write. A linearizable write follows the ack
+// policy while a sequential one only waits for the first acknowledgement.
+func (sap *SyncAsyncPiping) requiredAcks(ctx context.Context, syncNodes int) int {
+	if consistent.FromContext(ctx, sap.consistency) == consistent.SEQUENTIAL && syncNodes > 0 {
+		return 1
+	}
+	return sap.ack.Required(syncNodes)
+}
 
-		}(ctx, sap.databaseType, syncNode, rev.String())
+func (sap *SyncAsyncPiping) put(ctx context.Context, spanName, name, key, val string) error {
+	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, spanName)
+	defer rootSpan.End()
+	db, err := database.FactoryWithNameAndLatency(sap.databaseType, name, 0)
+	if err == nil {
+		_, err = db.Put(key, val)
+	}
+	if err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
 	}
-	wg.Wait()
+	return err
+}
 
-	return nil
+func (sap *SyncAsyncPiping) delete(ctx context.Context, spanName, name, key string) error {
+	_, rootSpan := otel.Tracer(config.TraceName).Start(ctx, spanName)
+	defer rootSpan.End()
+	db, err := database.FactoryWithNameAndLatency(sap.databaseType, name, 0)
+	if err == nil {
+		err = db.Delete(key)
+	}
+	if err != nil {
+		rootSpan.RecordError(err)
+		rootSpan.SetStatus(codes.Error, err.Error())
+	}
+	return err
 }
 
-func splitStores(stores []string) ([]string, []string, error) {
-	syncNodes := make([]string, 0)
-	asyncNodes := make([]string, 0)
-	if len(stores) < 1 {
-		return syncNodes, asyncNodes, fmt.Errorf("no stores in the revision")
+// replicateAsync applies an operation to the async nodes through the replication queues if there are any,
+// or by fire-and-forget goroutines otherwise
+func (sap *SyncAsyncPiping) replicateAsync(ctx context.Context, asyncNodes []string, op replication.Op, key, val string) {
+	if sap.replicator != nil {
+		if _, err := enqueue(sap.replicator, asyncNodes, op, key, val); err != nil {
+			klog.Warningf("failed to queue %s of the revision %s: %v", op, key, err)
+		}
+		return
 	}
-	syncNodes = strings.Split(stores[0], ",")
-	// The first store item is for sync nodes
-	if len(syncNodes) < 1 {
-		return syncNodes, asyncNodes, fmt.Errorf("no sync stores in the revision")
+	for _, asyncNode := range asyncNodes {
+		go func(name string) {
+			if op == replication.OpDelete {
+				sap.delete(ctx, "async db delete", name, key)
+			} else {
+				sap.put(ctx, "async db put", name, key, val)
+			}
+		}(asyncNode)
 	}
-	// The second store item is for async nodes
-	if len(stores) == 2 {
-		asyncNodes = strings.Split(stores[1], ",")
+}
+
+// enqueue queues an operation for the async nodes and returns the nodes it has been queued for
+func enqueue(replicator Replicator, asyncNodes []string, op replication.Op, key, val string) ([]string, error) {
+	queued := make([]string, 0, len(asyncNodes))
+	for _, asyncNode := range asyncNodes {
+		if err := replicator.Enqueue(asyncNode, op, key, val); err != nil {
+			return queued, fmt.Errorf("failed to queue the replication to %s: %v", asyncNode, err)
+		}
+		queued = append(queued, asyncNode)
 	}
-	return syncNodes, asyncNodes, nil
+	return queued, nil
 }
@@ -0,0 +1,382 @@
+package placement
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+// Options are the simulation parameters
+type Options struct {
+	// Buckets is the number of revision buckets placed, or of keys in the key and prefix placement modes
+	Buckets int
+	// Keys and Versions simulate writing Versions revisions of each of Keys keys, to report how spread the
+	// history of a key is in the placement mode; no history is simulated if either is 0
+	Keys     int
+	Versions int
+	// AddStore and RemoveStore, by name, simulate a store change to report the placements it moves
+	AddStore    *config.KVStore
+	RemoveStore string
+}
+
+// StoreLoad is the number of buckets a store holds a replica of
+type StoreLoad struct {
+	Name             string
+	AvailabilityZone constants.AvailabilityZone
+	Remote           bool
+	Sync             int
+	Async            int
+	// Share is the share of all the replicas, against Expected if the replicas were spread by the weights of the stores
+	Share    float64
+	Expected float64
+}
+
+// weightOf is the weight of a store, 0 counting as 1 as in the hashing
+func weightOf(node consistent.RkvNode) int {
+	if node.Weight > 0 {
+		return node.Weight
+	}
+	return 1
+}
+
+// ZoneLoad is the number of replicas placed in an availability zone
+type ZoneLoad struct {
+	AvailabilityZone constants.AvailabilityZone
+	Replicas         int
+	Share            float64
+}
+
+// Change reports the placements moved by adding or removing a store
+type Change struct {
+	Description string
+	// Moved is the number of buckets whose replica set changed, and MovedReplicas the number of replicas moved
+	Moved         int
+	MovedShare    float64
+	MovedReplicas int
+}
+
+// History reports how many stores and replica sets hold the revisions of a key
+type History struct {
+	Keys     int
+	Versions int
+	// Stores and ReplicaSets are the averages per key
+	Stores      float64
+	ReplicaSets float64
+}
+
+type Report struct {
+	HashingManagerType constants.HashingManagerType
+	ConsistentHash     constants.ConsistentHashingType
+	PlacementMode      constants.PlacementMode
+	Buckets            int
+	// Failures is the number of buckets the hashing manager failed to place, e.g. for lack of zones
+	Failures    int
+	FailureRate float64
+	LastFailure string
+	Stores      []StoreLoad
+	Zones       []ZoneLoad
+	// ReplicaSets is the number of distinct replica sets, and ZoneDiverse the share of the buckets whose
+	// replicas are all in different zones
+	ReplicaSets int
+	ZoneDiverse float64
+	Change      *Change
+	History     *History
+}
+
+type placed struct {
+	sync, async []string
+}
+
+// Analyze places the buckets with the hashing manager built over the given stores
+func Analyze(conf *config.KVConfiguration, localStores map[constants.AvailabilityZone][]consistent.RkvNode, remoteStores []consistent.RkvNode, opts Options) (Report, error) {
+	if opts.Buckets < 1 {
+		return Report{}, fmt.Errorf("the number of buckets %d is not positive", opts.Buckets)
+	}
+	zones := make(map[string]constants.AvailabilityZone)
+	for _, store := range conf.StoreList() {
+		zones[conf.NodeName(store)] = store.AvailabilityZone
+	}
+
+	if err := ValidateMode(conf); err != nil {
+		return Report{}, err
+	}
+	mode := conf.PlacementMode
+	if mode == "" {
+		mode = constants.RevisionPlacement
+	}
+
+	report := Report{HashingManagerType: conf.HashingManagerType, ConsistentHash: conf.ConsistentHash, PlacementMode: mode, Buckets: opts.Buckets}
+	hm := NewHashingManager(conf, localStores, remoteStores)
+	placements := place(conf, hm, opts.Buckets, &report)
+
+	loads := make(map[string]*StoreLoad)
+	weights := make(map[string]int)
+	for az, nodes := range localStores {
+		for _, node := range nodes {
+			loads[node.Name] = &StoreLoad{Name: node.Name, AvailabilityZone: az}
+			weights[node.Name] = weightOf(node)
+		}
+	}
+	for _, node := range remoteStores {
+		loads[node.Name] = &StoreLoad{Name: node.Name, AvailabilityZone: zones[node.Name], Remote: true}
+		weights[node.Name] = weightOf(node)
+	}
+	zoneReplicas := make(map[constants.AvailabilityZone]int)
+	sets := make(map[string]bool)
+	diverse, total, placedBuckets := 0, 0, 0
+	for _, p := range placements {
+		if p == nil {
+			continue
+		}
+		placedBuckets++
+		seenZones := make(map[constants.AvailabilityZone]bool)
+		for i, names := range [][]string{p.sync, p.async} {
+			for _, name := range names {
+				load, ok := loads[name]
+				if !ok {
+					load = &StoreLoad{Name: name, AvailabilityZone: zones[name]}
+					loads[name] = load
+					weights[name] = 1
+				}
+				if i == 0 {
+					load.Sync++
+				} else {
+					load.Async++
+				}
+				zoneReplicas[load.AvailabilityZone]++
+				seenZones[load.AvailabilityZone] = true
+				total++
+			}
+		}
+		if len(seenZones) == len(p.sync)+len(p.async) {
+			diverse++
+		}
+		sets[key(p)] = true
+	}
+
+	totalWeight := 0
+	for _, w := range weights {
+		totalWeight += w
+	}
+	for _, load := range loads {
+		if total > 0 {
+			load.Share = float64(load.Sync+load.Async) / float64(total)
+		}
+		load.Expected = float64(weights[load.Name]) / float64(totalWeight)
+		report.Stores = append(report.Stores, *load)
+	}
+	sort.Slice(report.Stores, func(i, j int) bool {
+		return report.Stores[i].Name < report.Stores[j].Name
+	})
+	for az, replicas := range zoneReplicas {
+		report.Zones = append(report.Zones, ZoneLoad{AvailabilityZone: az, Replicas: replicas, Share: float64(replicas) / float64(total)})
+	}
+	sort.Slice(report.Zones, func(i, j int) bool {
+		return report.Zones[i].AvailabilityZone < report.Zones[j].AvailabilityZone
+	})
+	report.ReplicaSets = len(sets)
+	if placedBuckets > 0 {
+		report.ZoneDiverse = float64(diverse) / float64(placedBuckets)
+	}
+
+	if opts.AddStore != nil || opts.RemoveStore != "" {
+		change, err := simulateChange(conf, localStores, remoteStores, opts, placements)
+		if err != nil {
+			return report, err
+		}
+		report.Change = change
+	}
+	if opts.Keys > 0 && opts.Versions > 0 {
+		report.History = history(conf, hm, opts.Keys, opts.Versions)
+	}
+	return report, nil
+}
+
+// unitKey is the placement key of the b-th bucket, or of the b-th key in the key and prefix modes
+func unitKey(conf *config.KVConfiguration, b int) []byte {
+	if conf.PlacementMode == constants.KeyPlacement || conf.PlacementMode == constants.PrefixPlacement {
+		return Key(conf, []byte(fmt.Sprintf("key-%d", b)), index.Revision{})
+	}
+	bucketSize := conf.BucketSize
+	if bucketSize < 1 {
+		bucketSize = 1
+	}
+	return Key(conf, nil, index.NewRevision(nil, int64(b)*bucketSize, 0, consistent.Placement{}))
+}
+
+// history writes the revisions of the keys one version of every key after the other, as concurrent clients
+// would, and counts the stores and replica sets each key ends up on
+func history(conf *config.KVConfiguration, hm consistent.HashingManager, keys, versions int) *History {
+	stores := make([]map[string]bool, keys)
+	sets := make([]map[string]bool, keys)
+	for k := range stores {
+		stores[k], sets[k] = make(map[string]bool), make(map[string]bool)
+	}
+	var main int64
+	for v := 0; v < versions; v++ {
+		for k := 0; k < keys; k++ {
+			main++
+			key := []byte(fmt.Sprintf("tenant-%d/key-%d", k%16, k))
+			p, err := hm.GetPlacementForKey(key, Key(conf, key, index.NewRevision(nil, main, 0, consistent.Placement{})))
+			if err != nil {
+				continue
+			}
+			sets[k][p.String()] = true
+			for _, name := range p.Nodes() {
+				stores[k][name] = true
+			}
+		}
+	}
+	totalStores, totalSets := 0, 0
+	for k := range stores {
+		totalStores += len(stores[k])
+		totalSets += len(sets[k])
+	}
+	return &History{Keys: keys, Versions: versions, Stores: float64(totalStores) / float64(keys), ReplicaSets: float64(totalSets) / float64(keys)}
+}
+
+func place(conf *config.KVConfiguration, hm consistent.HashingManager, buckets int, report *Report) []*placed {
+	placements := make([]*placed, buckets)
+	for b := 0; b < buckets; b++ {
+		bucketKey := unitKey(conf, b)
+		p, err := hm.GetPlacement(bucketKey)
+		if err == nil && len(p.Sync) == 0 {
+			err = fmt.Errorf("no sync node")
+		}
+		if err != nil {
+			if report != nil {
+				report.Failures++
+				report.LastFailure = err.Error()
+			}
+			continue
+		}
+		placements[b] = &placed{sync: p.SyncNodes(), async: p.AsyncNodes()}
+	}
+	if report != nil {
+		report.FailureRate = float64(report.Failures) / float64(buckets)
+	}
+	return placements
+}
+
+func simulateChange(conf *config.KVConfiguration, localStores map[constants.AvailabilityZone][]consistent.RkvNode, remoteStores []consistent.RkvNode, opts Options, before []*placed) (*Change, error) {
+	changedLocal := make(map[constants.AvailabilityZone][]consistent.RkvNode)
+	for az, nodes := range localStores {
+		changedLocal[az] = append([]consistent.RkvNode(nil), nodes...)
+	}
+	changedRemote := append([]consistent.RkvNode(nil), remoteStores...)
+	descriptions := make([]string, 0, 2)
+
+	if opts.RemoveStore != "" {
+		store, ok := conf.FindStore(opts.RemoveStore)
+		if !ok {
+			return nil, fmt.Errorf("the store %s to remove does not exist", opts.RemoveStore)
+		}
+		name := conf.NodeName(store)
+		for az, nodes := range changedLocal {
+			changedLocal[az] = without(nodes, name)
+			if len(changedLocal[az]) == 0 {
+				delete(changedLocal, az)
+			}
+		}
+		changedRemote = without(changedRemote, name)
+		descriptions = append(descriptions, "remove "+opts.RemoveStore)
+	}
+	if opts.AddStore != nil {
+		store, err := conf.ResolveStore(*opts.AddStore)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := conf.FindStore(store.Name); ok {
+			return nil, fmt.Errorf("the store %s to add already exists", store.Name)
+		}
+		storeLatency := time.Duration(store.ArtificialLatencyInMs) * time.Millisecond
+		if int64(store.ArtificialLatencyInMs) < conf.RemoteStoreLatencyThresholdInMilliSec {
+			changedLocal[store.AvailabilityZone] = append(changedLocal[store.AvailabilityZone], conf.StoreNode(store, storeLatency, false))
+		} else {
+			changedRemote = append(changedRemote, conf.StoreNode(store, storeLatency, true))
+		}
+		descriptions = append(descriptions, "add "+store.Name)
+	}
+
+	after := place(conf, NewHashingManager(conf, changedLocal, changedRemote), len(before), nil)
+	change := &Change{Description: strings.Join(descriptions, ", ")}
+	for b := range before {
+		if before[b] == nil || after[b] == nil {
+			if (before[b] == nil) != (after[b] == nil) {
+				change.Moved++
+			}
+			continue
+		}
+		old := append(append([]string(nil), before[b].sync...), before[b].async...)
+		now := append(append([]string(nil), after[b].sync...), after[b].async...)
+		moved := 0
+		for _, name := range now {
+			if !contains(old, name) {
+				moved++
+			}
+		}
+		if moved > 0 || len(old) != len(now) {
+			change.Moved++
+		}
+		change.MovedReplicas += moved
+	}
+	change.MovedShare = float64(change.Moved) / float64(len(before))
+	return change, nil
+}
+
+// Write prints the report as text
+func (r Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "hashing manager %s with %s hashing, %s placement, %d buckets\n", r.HashingManagerType, r.ConsistentHash, r.PlacementMode, r.Buckets)
+	fmt.Fprintf(w, "placement failures: %d (%.2f%%)", r.Failures, 100*r.FailureRate)
+	if r.LastFailure != "" {
+		fmt.Fprintf(w, ", e.g. %s", r.LastFailure)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "distinct replica sets: %d, buckets with all replicas in different zones: %.2f%%\n\n", r.ReplicaSets, 100*r.ZoneDiverse)
+	fmt.Fprintf(w, "%-24s %-16s %-7s %10s %10s %8s %8s\n", "STORE", "ZONE", "REMOTE", "SYNC", "ASYNC", "SHARE", "EVEN")
+	for _, s := range r.Stores {
+		fmt.Fprintf(w, "%-24s %-16s %-7t %10d %10d %7.2f%% %7.2f%%\n", s.Name, s.AvailabilityZone, s.Remote, s.Sync, s.Async, 100*s.Share, 100*s.Expected)
+	}
+	fmt.Fprintf(w, "\n%-24s %10s %8s\n", "ZONE", "REPLICAS", "SHARE")
+	for _, z := range r.Zones {
+		fmt.Fprintf(w, "%-24s %10d %7.2f%%\n", z.AvailabilityZone, z.Replicas, 100*z.Share)
+	}
+	if r.Change != nil {
+		fmt.Fprintf(w, "\n%s: %d buckets (%.2f%%) change replicas, %d replicas move\n", r.Change.Description, r.Change.Moved, 100*r.Change.MovedShare, r.Change.MovedReplicas)
+	}
+	if r.History != nil {
+		fmt.Fprintf(w, "\nthe %d versions of a key are on %.2f stores and %.2f replica sets on average, over %d keys\n", r.History.Versions, r.History.Stores, r.History.ReplicaSets, r.History.Keys)
+	}
+}
+
+func key(p *placed) string {
+	sorted := append(append([]string(nil), p.sync...), p.async...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func contains(nodes []string, name string) bool {
+	for _, n := range nodes {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func without(nodes []consistent.RkvNode, name string) []consistent.RkvNode {
+	out := make([]consistent.RkvNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Name != name {
+			out = append(out, node)
+		}
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package placement
+
+import (
+	"sort"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+// NewHashingManager builds the hashing manager of the configured type
+// over the classified stores, enforcing the configured placement policy
+func NewHashingManager(conf *config.KVConfiguration, localStores map[constants.AvailabilityZone][]consistent.RkvNode, remoteStores []consistent.RkvNode) consistent.HashingManager {
+	var hm consistent.HashingManager
+	switch conf.HashingManagerType {
+	case constants.Sync:
+		stores := make([]consistent.RkvNode, 0)
+		for _, localStore := range localStores {
+			stores = append(stores, localStore...)
+		}
+		// the zones come out of the map in any order, and the hashing of some types depends on the order
+		sort.Slice(stores, func(i, j int) bool {
+			return stores[i].Name < stores[j].Name
+		})
+		hm = consistent.NewSyncHashingManagerWithVirtualNodes(conf.ConsistentHash, stores, conf.LocalReplicaNum, conf.VirtualNodes)
+	default:
+		hm = consistent.NewSyncAsyncHashingManagerWithVirtualNodes(conf.ConsistentHash, localStores, conf.LocalReplicaNum, remoteStores, conf.RemoteReplicaNum, conf.VirtualNodes)
+	}
+	hm.SetPlacementPolicy(conf.PlacementPolicy)
+	return hm
+}
+
+// SimulatedStores classifies the stores by their ArtificialLatencyInMs instead of measuring them, for the tools
+// working offline
+func SimulatedStores(conf *config.KVConfiguration) (map[constants.AvailabilityZone][]consistent.RkvNode, []consistent.RkvNode) {
+	latencies := make(map[string]time.Duration)
+	for _, store := range conf.StoreList() {
+		latencies[conf.NodeName(store)] = time.Duration(store.ArtificialLatencyInMs) * time.Millisecond
+	}
+	return conf.ClassifyStores(latencies)
+}
@@ -0,0 +1,61 @@
+package placement
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/index"
+)
+
+// Key returns what the hashing manager places a revision of the user key by: the bucket of the revision in the
+// revision mode, the user key in the key mode, and the first segments of the user key in the prefix mode
+func Key(conf *config.KVConfiguration, key []byte, rev index.Revision) []byte {
+	switch conf.PlacementMode {
+	case constants.KeyPlacement:
+		return key
+	case constants.PrefixPlacement:
+		delimiter, segments := conf.PlacementPrefixDelimiter, conf.PlacementPrefixSegments
+		if delimiter == "" {
+			delimiter = "/"
+		}
+		if segments < 1 {
+			segments = 1
+		}
+		return Prefix(key, []byte(delimiter), segments)
+	default:
+		bucketSize := conf.BucketSize
+		if bucketSize < 1 {
+			bucketSize = 1
+		}
+		return rev.BucketKey(bucketSize)
+	}
+}
+
+// Prefix returns the first segments of the key up to and including their delimiter, or the whole key if it
+// has fewer segments
+func Prefix(key []byte, delimiter []byte, segments int) []byte {
+	end := 0
+	for i := 0; i < segments; i++ {
+		next := bytes.Index(key[end:], delimiter)
+		if next < 0 {
+			return key
+		}
+		end += next + len(delimiter)
+	}
+	return key[:end]
+}
+
+// ValidateMode checks the placement mode of the configuration
+func ValidateMode(conf *config.KVConfiguration) error {
+	switch conf.PlacementMode {
+	case "", constants.RevisionPlacement, constants.KeyPlacement, constants.PrefixPlacement:
+	default:
+		return fmt.Errorf("unsupported placement mode %q", conf.PlacementMode)
+	}
+	if conf.PlacementPrefixSegments < 0 {
+		return fmt.Errorf("the number of prefix segments %d is negative", conf.PlacementPrefixSegments)
+	}
+	return nil
+}
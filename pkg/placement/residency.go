@@ -0,0 +1,89 @@
+package placement
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/index"
+)
+
+// Violation is a replica of a revision stored in a region its key may not be stored in
+type Violation struct {
+	Key      string
+	Revision string
+	Node     string
+	// Region is empty if the node is not a store of the configuration any longer
+	Region constants.Region
+	// Prefix is the one of the residency rule of the key, if any
+	Prefix  string
+	Allowed []constants.Region
+}
+
+// Audit reports whether the revisions of the index are stored in the regions the placement policy allows
+type Audit struct {
+	Revisions int
+	// Pinned is the number of revisions whose key has a residency rule
+	Pinned     int
+	Violations []Violation
+}
+
+// AuditResidency checks every revision of the index, tombstones aside, against the residency rules and the
+// forbidden regions of the placement policy
+func AuditResidency(ctx context.Context, conf *config.KVConfiguration, indexTree index.Index) Audit {
+	regions := make(map[string]constants.Region)
+	for _, store := range conf.StoreList() {
+		regions[conf.NodeName(store)] = store.GetRegion()
+	}
+	policy := conf.PlacementPolicy
+	audit := Audit{Violations: make([]Violation, 0)}
+	indexTree.Walk(ctx, func(key []byte, rev index.Revision) bool {
+		if rev.GetPlacement().IsEmpty() {
+			return true
+		}
+		audit.Revisions++
+		rule, pinned := policy.Rule(key)
+		if pinned {
+			audit.Pinned++
+		}
+		allowed := policy.ForKey(key)
+		for _, node := range rev.GetPlacement().Nodes() {
+			region, known := regions[node]
+			// a node no longer known is only a violation for the keys pinned to regions
+			if known && allowed.Allows(region) || !known && !pinned {
+				continue
+			}
+			audit.Violations = append(audit.Violations, Violation{Key: string(key), Revision: rev.String(), Node: node,
+				Region: region, Prefix: rule.Prefix, Allowed: rule.Regions})
+		}
+		return true
+	})
+	return audit
+}
+
+// Write prints the audit as text
+func (a Audit) Write(w io.Writer) {
+	fmt.Fprintf(w, "%d revisions checked, %d of them pinned by residency rules, %d violations\n", a.Revisions, a.Pinned, len(a.Violations))
+	if len(a.Violations) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%-32s %-12s %-24s %-12s %s\n", "KEY", "REVISION", "NODE", "REGION", "ALLOWED")
+	for _, v := range a.Violations {
+		region := string(v.Region)
+		if region == "" {
+			region = "unknown"
+		}
+		allowed := "any but forbidden"
+		if len(v.Allowed) > 0 {
+			names := make([]string, 0, len(v.Allowed))
+			for _, r := range v.Allowed {
+				names = append(names, r.Name())
+			}
+			allowed = strings.Join(names, ",")
+		}
+		fmt.Fprintf(w, "%-32s %-12s %-24s %-12s %s\n", v.Key, v.Revision, v.Node, region, allowed)
+	}
+}
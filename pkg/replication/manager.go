@@ -0,0 +1,181 @@
+package replication
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/database"
+)
+
+const (
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 30 * time.Second
+)
+
+// Resolver returns the backend database of the named store
+type Resolver func(name string) (database.Database, error)
+
+// Options configures the replication queues
+type Options struct {
+	// Dir keeps one queue file per destination store; the queues are memory only if it is empty
+	Dir string
+	// MaxPending is the number of entries a destination may lag behind before new writes are rejected; 0 means no limit
+	MaxPending     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Manager keeps a durable replication queue per destination store
+type Manager struct {
+	mu      sync.Mutex
+	opts    Options
+	resolve Resolver
+	queues  map[string]*queue
+	closed  bool
+}
+
+// NewManager creates the replication manager and resumes the queues left in opts.Dir by a previous run
+func NewManager(opts Options, resolve Resolver) (*Manager, error) {
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = DefaultInitialBackoff
+	}
+	if opts.MaxBackoff < opts.InitialBackoff {
+		opts.MaxBackoff = DefaultMaxBackoff
+		if opts.MaxBackoff < opts.InitialBackoff {
+			opts.MaxBackoff = opts.InitialBackoff
+		}
+	}
+	m := &Manager{opts: opts, resolve: resolve, queues: make(map[string]*queue)}
+	if len(opts.Dir) == 0 {
+		return m, nil
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), queueFileSuffix) {
+			continue
+		}
+		dest, err := url.QueryUnescape(strings.TrimSuffix(file.Name(), queueFileSuffix))
+		if err != nil {
+			continue
+		}
+		if _, err := m.queue(dest); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Manager) queue(dest string) (*queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrQueueClosed
+	}
+	if q, ok := m.queues[dest]; ok {
+		return q, nil
+	}
+	q, err := newQueue(dest, m.opts, m.resolve)
+	if err != nil {
+		return nil, err
+	}
+	m.queues[dest] = q
+	return q, nil
+}
+
+// Enqueue durably queues an operation for the destination store. The operations queued for the same
+// destination are applied in order, retrying with exponential backoff until the destination accepts them.
+func (m *Manager) Enqueue(dest string, op Op, key, value string) error {
+	q, err := m.queue(dest)
+	if err != nil {
+		return err
+	}
+	return q.enqueue(op, key, value)
+}
+
+// Remove drops the queue of a destination store removed from the cluster, with the entries it has not
+// replicated yet and its queue file
+func (m *Manager) Remove(dest string) error {
+	m.mu.Lock()
+	q, ok := m.queues[dest]
+	delete(m.queues, dest)
+	m.mu.Unlock()
+	if ok {
+		if err := q.close(); err != nil {
+			return err
+		}
+	}
+	if len(m.opts.Dir) == 0 {
+		return nil
+	}
+	if err := os.Remove(queueFileName(m.opts.Dir, dest)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Stats returns the replication progress of every destination store, sorted by destination
+func (m *Manager) Stats() []Stats {
+	m.mu.Lock()
+	queues := make([]*queue, 0, len(m.queues))
+	for _, q := range m.queues {
+		queues = append(queues, q)
+	}
+	m.mu.Unlock()
+	res := make([]Stats, 0, len(queues))
+	for _, q := range queues {
+		res = append(res, q.snapshot())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Destination < res[j].Destination
+	})
+	return res
+}
+
+// Wait blocks until every queue is drained or ctx is done
+func (m *Manager) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		pending := 0
+		for _, s := range m.Stats() {
+			pending += s.Pending
+		}
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops all the queues; the entries not yet replicated are kept for the next start
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	queues := m.queues
+	m.queues = make(map[string]*queue)
+	m.mu.Unlock()
+	var firstErr error
+	for _, q := range queues {
+		if err := q.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
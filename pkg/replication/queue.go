@@ -0,0 +1,338 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const queueFileSuffix = ".queue"
+
+var (
+	ErrQueueFull   = errors.New("replication queue is full")
+	ErrQueueClosed = errors.New("replication queue is closed")
+)
+
+type Op string
+
+const (
+	OpPut    Op = "put"
+	OpDelete Op = "delete"
+)
+
+// Entry is a single replication operation waiting to be applied to a destination store.
+// The same structure is appended to the queue file; a record with Done set acknowledges the entry of the same Seq.
+type Entry struct {
+	Seq        uint64    `json:"seq"`
+	Op         Op        `json:"op,omitempty"`
+	Key        string    `json:"key,omitempty"`
+	Value      string    `json:"value,omitempty"`
+	EnqueuedAt time.Time `json:"enqueuedAt,omitempty"`
+	Done       bool      `json:"done,omitempty"`
+}
+
+// Stats reports the replication progress of one destination store
+type Stats struct {
+	Destination      string
+	Pending          int
+	Lag              time.Duration // age of the oldest pending entry
+	Replicated       uint64
+	Retries          uint64
+	LastError        string
+	LastReplicatedAt time.Time
+}
+
+// queue is a FIFO of replication entries for a single destination store. A single worker applies the entries
+// in order and retries the head until it succeeds, so the operations on any key reach the destination in order.
+type queue struct {
+	mu      sync.Mutex
+	dest    string
+	opts    Options
+	resolve Resolver
+	pending []Entry
+	nextSeq uint64
+	file    *os.File
+	// acked counts the done records appended since the file was last compacted
+	acked   int
+	stats   Stats
+	notify  chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+	closed  bool
+}
+
+func newQueue(dest string, opts Options, resolve Resolver) (*queue, error) {
+	q := &queue{
+		dest:    dest,
+		opts:    opts,
+		resolve: resolve,
+		pending: make([]Entry, 0),
+		nextSeq: 1,
+		stats:   Stats{Destination: dest},
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if len(opts.Dir) > 0 {
+		if err := q.recover(); err != nil {
+			return nil, err
+		}
+	}
+	go q.run()
+	return q, nil
+}
+
+func queueFileName(dir, dest string) string {
+	return filepath.Join(dir, url.QueryEscape(dest)+queueFileSuffix)
+}
+
+// recover loads the entries not yet acknowledged from the queue file and compacts the file
+func (q *queue) recover() error {
+	name := queueFileName(q.opts.Dir, q.dest)
+	if file, err := os.Open(name); err == nil {
+		entries := make(map[uint64]Entry)
+		order := make([]uint64, 0)
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var e Entry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				// a torn record at the end of the file is left by a crash during append
+				klog.Warningf("skipping corrupted record in %s: %v", name, err)
+				continue
+			}
+			if e.Seq >= q.nextSeq {
+				q.nextSeq = e.Seq + 1
+			}
+			if e.Done {
+				delete(entries, e.Seq)
+				continue
+			}
+			entries[e.Seq] = e
+			order = append(order, e.Seq)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read replication queue %s: %v", name, err)
+		}
+		for _, seq := range order {
+			if e, ok := entries[seq]; ok {
+				q.pending = append(q.pending, e)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if len(q.pending) > 0 {
+		klog.Infof("recovered %d pending replication entries for %s", len(q.pending), q.dest)
+	}
+	return q.compact()
+}
+
+// compact rewrites the queue file with only the pending entries
+func (q *queue) compact() error {
+	if len(q.opts.Dir) == 0 {
+		return nil
+	}
+	name := queueFileName(q.opts.Dir, q.dest)
+	tmp := name + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, e := range q.pending {
+		if err := writeRecord(w, e); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	if q.file != nil {
+		q.file.Close()
+	}
+	q.file, err = os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	q.acked = 0
+	return err
+}
+
+func writeRecord(w interface{ Write([]byte) (int, error) }, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// enqueue durably appends an entry to the queue. It fails with ErrQueueFull when the configured
+// limit of pending entries has been reached, which pushes back on the writers.
+func (q *queue) enqueue(op Op, key, value string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if q.opts.MaxPending > 0 && len(q.pending) >= q.opts.MaxPending {
+		return ErrQueueFull
+	}
+	e := Entry{Seq: q.nextSeq, Op: op, Key: key, Value: value, EnqueuedAt: time.Now()}
+	if q.file != nil {
+		if err := writeRecord(q.file, e); err != nil {
+			return err
+		}
+		// only the entries are synced; a lost done record just replays an idempotent operation after restart
+		if err := q.file.Sync(); err != nil {
+			return err
+		}
+	}
+	q.nextSeq++
+	q.pending = append(q.pending, e)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *queue) head() (Entry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return Entry{}, false
+	}
+	return q.pending[0], true
+}
+
+func (q *queue) ack(e Entry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 || q.pending[0].Seq != e.Seq {
+		return
+	}
+	q.pending = q.pending[1:]
+	q.stats.Replicated++
+	q.stats.LastReplicatedAt = time.Now()
+	q.stats.LastError = ""
+	if q.file == nil {
+		return
+	}
+	done := Entry{Seq: e.Seq, Done: true}
+	if err := writeRecord(q.file, done); err != nil {
+		klog.Warningf("failed to acknowledge replication entry %d for %s: %v", e.Seq, q.dest, err)
+	}
+	q.acked++
+	// the file is rewritten under the lock, so only once the done records are many and outnumber the pending
+	// entries, which keeps the rewrites rare and the cost of each of them below that of the acks it drops
+	if q.acked >= compactThreshold && q.acked >= len(q.pending) {
+		if err := q.compact(); err != nil {
+			klog.Warningf("failed to compact replication queue for %s: %v", q.dest, err)
+		}
+	}
+}
+
+// compactThreshold is the number of done records the queue file holds before it is compacted
+const compactThreshold = 4096
+
+func (q *queue) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stats.Retries++
+	q.stats.LastError = err.Error()
+}
+
+func (q *queue) apply(e Entry) error {
+	db, err := q.resolve(q.dest)
+	if err != nil {
+		return err
+	}
+	switch e.Op {
+	case OpPut:
+		_, err = db.Put(e.Key, e.Value)
+	case OpDelete:
+		err = db.Delete(e.Key)
+	default:
+		klog.Errorf("dropping replication entry %d for %s with unknown op %q", e.Seq, q.dest, e.Op)
+	}
+	return err
+}
+
+func (q *queue) run() {
+	defer close(q.stopped)
+	backoff := q.opts.InitialBackoff
+	for {
+		e, ok := q.head()
+		if !ok {
+			select {
+			case <-q.notify:
+				continue
+			case <-q.stop:
+				return
+			}
+		}
+		if err := q.apply(e); err != nil {
+			q.fail(err)
+			klog.V(2).Infof("replication of %s %s to %s failed, retrying in %v: %v", e.Op, e.Key, q.dest, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-q.stop:
+				return
+			}
+			if backoff *= 2; backoff > q.opts.MaxBackoff {
+				backoff = q.opts.MaxBackoff
+			}
+			continue
+		}
+		backoff = q.opts.InitialBackoff
+		q.ack(e)
+	}
+}
+
+func (q *queue) snapshot() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := q.stats
+	s.Pending = len(q.pending)
+	if len(q.pending) > 0 {
+		s.Lag = time.Since(q.pending[0].EnqueuedAt)
+	}
+	return s
+}
+
+// close stops the worker. The pending entries stay in the queue file and are replayed on the next start.
+func (q *queue) close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+	close(q.stop)
+	<-q.stopped
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file != nil {
+		return q.file.Close()
+	}
+	return nil
+}
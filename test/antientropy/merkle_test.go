@@ -0,0 +1,73 @@
+package antientropy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/antientropy"
+)
+
+func TestMerkleTreeDiff(t *testing.T) {
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%d_0", i)
+	}
+	tree := antientropy.NewMerkleTree(4, keys)
+	if n := len(tree.Keys(4, 0)); n != len(keys) {
+		t.Fatalf("expected the root to hold the %d keys, got %d", len(keys), n)
+	}
+	for leaf := 0; leaf < 16; leaf++ {
+		for _, key := range tree.Keys(0, leaf) {
+			if antientropy.Leaf(4, key) != leaf {
+				t.Fatalf("the key %s is not expected in the leaf %d", key, leaf)
+			}
+		}
+	}
+
+	// the stores differ on 7_0 and 42_0
+	asked := 0
+	diff, err := tree.Diff(func(keys []string) (bool, error) {
+		asked++
+		for _, key := range keys {
+			if key == "7_0" || key == "42_0" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]bool{antientropy.Leaf(4, "7_0"): true, antientropy.Leaf(4, "42_0"): true}
+	if len(diff) != len(expected) {
+		t.Fatalf("expected the leaves %v to differ, got %v", expected, diff)
+	}
+	for _, leaf := range diff {
+		if !expected[leaf] {
+			t.Errorf("the leaf %d is not expected to differ", leaf)
+		}
+	}
+	// the root and at most both children of the nodes on the paths to the 2 leaves
+	if asked > 1+2*4*2 {
+		t.Errorf("expected the descent to skip the subtrees in sync, %d nodes were compared", asked)
+	}
+
+	asked = 0
+	if diff, err := tree.Diff(func(keys []string) (bool, error) {
+		asked++
+		return false, nil
+	}); err != nil || len(diff) != 0 || asked != 1 {
+		t.Errorf("expected only the root to be compared when the stores are in sync, got %v, %v after %d nodes", diff, err, asked)
+	}
+}
+
+func TestMerkleTreeWithoutKeys(t *testing.T) {
+	tree := antientropy.NewMerkleTree(4, nil)
+	diff, err := tree.Diff(func(keys []string) (bool, error) {
+		t.Fatalf("no node is expected to be compared, got %v", keys)
+		return true, nil
+	})
+	if err != nil || len(diff) != 0 {
+		t.Fatalf("unexpected %v, %v", diff, err)
+	}
+}
@@ -0,0 +1,235 @@
+package antientropy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/antientropy"
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/test/mock"
+)
+
+type cluster struct {
+	indexTree index.Index
+	dbs       map[string]database.Database
+	revs      []index.Revision
+	down      map[string]bool
+}
+
+// newCluster writes the revisions to 2 sync stores and an async one, or to the other 3 stores for the odd ones
+func newCluster(t *testing.T, revisions int) *cluster {
+	c := &cluster{indexTree: index.NewTreeIndex(), dbs: make(map[string]database.Database), down: make(map[string]bool)}
+	for _, name := range []string{"s1", "s2", "s3", "s4", "s5", "s6"} {
+		c.dbs[name] = mock.NewMockDatabase()
+	}
+	placements := []consistent.Placement{
+		consistent.NamedPlacement([]string{"s1", "s2"}, []string{"s3"}),
+		consistent.NamedPlacement([]string{"s4", "s5"}, []string{"s6"}),
+	}
+	for i := 1; i <= revisions; i++ {
+		rev := index.NewRevision(c.indexTree.Placements(), int64(i), 0, placements[i%2])
+		for _, name := range rev.GetPlacement().Nodes() {
+			c.dbs[name].Put(rev.String(), c.value(i))
+		}
+		if err := c.indexTree.Put(context.Background(), []byte(fmt.Sprintf("k%d", i)), rev); err != nil {
+			t.Fatal(err)
+		}
+		c.revs = append(c.revs, rev)
+	}
+	return c
+}
+
+func (c *cluster) value(i int) string {
+	return fmt.Sprintf("v%d", i)
+}
+
+func (c *cluster) resolve(name string) (database.Database, error) {
+	if c.down[name] {
+		return nil, errors.New("store unavailable")
+	}
+	return c.dbs[name], nil
+}
+
+// verify checks that every replica holds the value of every revision
+func (c *cluster) verify(t *testing.T) {
+	for i, rev := range c.revs {
+		for _, name := range rev.GetPlacement().Nodes() {
+			if v, err := c.dbs[name].Get(rev.String()); err != nil || v != c.value(i+1) {
+				t.Errorf("the revision %s on %s is %q, %v", rev.String(), name, v, err)
+			}
+		}
+	}
+}
+
+func TestRepairDivergedStores(t *testing.T) {
+	c := newCluster(t, 200)
+	// the async replica s3 missed some writes, s2 holds a corrupted value and s4 lost a value
+	missed := 0
+	for i := 1; i < len(c.revs); i += 10 {
+		c.dbs["s3"].Delete(c.revs[i].String())
+		missed++
+	}
+	c.dbs["s2"].Put(c.revs[5].String(), "corrupted")
+	c.dbs["s4"].Delete(c.revs[6].String())
+
+	ae := antientropy.NewService(c.indexTree, c.resolve, antientropy.Options{Depth: 6})
+	status, err := ae.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Repaired != missed+2 || status.Groups != 2 || status.GroupsDone != 2 || status.Revisions != 200 || status.Failed != 0 {
+		t.Fatalf("unexpected status %+v, %d values missed by s3", status, missed)
+	}
+	c.verify(t)
+
+	// the replicas are in sync after the repair
+	status, err = ae.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.DivergentLeaves != 0 || status.Repaired != 0 || status.Rounds != 2 {
+		t.Fatalf("unexpected status %+v of the second round", status)
+	}
+}
+
+func TestRepairNeedsQuorum(t *testing.T) {
+	c := newCluster(t, 20)
+	c.dbs["s3"].Delete(c.revs[1].String())
+	c.dbs["s1"].Delete(c.revs[3].String())
+	c.down["s2"] = true
+	c.down["s1"] = true
+
+	ae := antientropy.NewService(c.indexTree, c.resolve, antientropy.Options{Depth: 4})
+	status, err := ae.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Repaired != 0 || status.Failed != 2 {
+		t.Fatalf("a group without a quorum of replicas is not expected to be repaired: %+v", status)
+	}
+
+	// once a second replica is back, the group has a quorum
+	c.down["s2"] = false
+	status, err = ae.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Repaired != 1 {
+		t.Fatalf("expected the value missed by s3 to be repaired: %+v", status)
+	}
+	if v, err := c.dbs["s3"].Get(c.revs[1].String()); err != nil || v != c.value(2) {
+		t.Errorf("unexpected value %q, %v", v, err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	c := newCluster(t, 10)
+	// 10 revisions on 3 replicas are 30 reads
+	ae := antientropy.NewService(c.indexTree, c.resolve, antientropy.Options{OpsPerSecond: 300})
+	start := time.Now()
+	if _, err := ae.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("30 reads at 300 per second are expected to take about 100ms, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ae.RunOnce(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("a cancelled round is expected to stop, got %v", err)
+	}
+}
+
+// digestingDatabase hashes its values as a store would, counting the values read in full
+type digestingDatabase struct {
+	database.Database
+	reads *int32
+}
+
+func (d digestingDatabase) Get(key string) (string, error) {
+	atomic.AddInt32(d.reads, 1)
+	return d.Database.Get(key)
+}
+
+func (d digestingDatabase) Digest(key string) (string, error) {
+	return database.ValueDigest(d.Database, key)
+}
+
+func TestTreesFromDigests(t *testing.T) {
+	c := newCluster(t, 100)
+	var reads int32
+	for name, db := range c.dbs {
+		c.dbs[name] = digestingDatabase{Database: db, reads: &reads}
+	}
+
+	ae := antientropy.NewService(c.indexTree, c.resolve, antientropy.Options{})
+	if _, err := ae.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if reads != 0 {
+		t.Fatalf("the replicas in sync are not expected to be read, %d values were", reads)
+	}
+
+	// only the revisions of the divergent leaf are read, from every replica
+	c.dbs["s3"].Delete(c.revs[7].String())
+	status, err := ae.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Repaired != 1 || reads == 0 || reads > 9 {
+		t.Fatalf("unexpected status %+v after %d values read", status, reads)
+	}
+	c.verify(t)
+}
+
+// rangeDigestingDatabase hashes the values of many keys as a store would, counting the requests
+type rangeDigestingDatabase struct {
+	database.Database
+	requests *int32
+}
+
+func (d rangeDigestingDatabase) Get(key string) (string, error) {
+	atomic.AddInt32(d.requests, 1)
+	return d.Database.Get(key)
+}
+
+func (d rangeDigestingDatabase) DigestKeys(keys []string) (string, error) {
+	atomic.AddInt32(d.requests, 1)
+	return database.KeysDigest(d.Database, keys)
+}
+
+func TestCompareByRangeDigests(t *testing.T) {
+	c := newCluster(t, 200)
+	var requests int32
+	for name, db := range c.dbs {
+		c.dbs[name] = rangeDigestingDatabase{Database: db, requests: &requests}
+	}
+
+	ae := antientropy.NewService(c.indexTree, c.resolve, antientropy.Options{Depth: 6})
+	if _, err := ae.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 2 groups of 3 replicas in sync
+	if requests != 6 {
+		t.Fatalf("expected a request per replica in sync, got %d", requests)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	c.dbs["s3"].Delete(c.revs[7].String())
+	status, err := ae.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the descent to the divergent leaf, then the reads of its revisions and the repair
+	if status.Repaired != 1 || status.DivergentLeaves != 1 || requests > 6+3*2*6+3*10+1 {
+		t.Fatalf("unexpected status %+v after %d requests", status, requests)
+	}
+	c.verify(t)
+}
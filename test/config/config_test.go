@@ -1,10 +1,13 @@
 package config
 
 import (
+	"math"
 	"testing"
 
 	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/consistent"
 	"github.com/regionless-storage-service/pkg/constants"
+	pc "github.com/regionless-storage-service/pkg/partition/consistent"
 )
 
 func TestLocalStores(t *testing.T) {
@@ -195,3 +198,110 @@ func TestRemoteStores(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveConsistency(t *testing.T) {
+	c := &config.KVConfiguration{}
+	if level, err := c.ResolveConsistency(""); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if level != consistent.LINEARIZABLE {
+		t.Fatalf("The default level shouldn't be %s", level)
+	}
+
+	c = &config.KVConfiguration{DefaultConsistency: consistent.SEQUENTIAL}
+	if level, err := c.ResolveConsistency(""); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if level != consistent.SEQUENTIAL {
+		t.Fatalf("The configured default shouldn't be replaced by %s", level)
+	}
+	if level, err := c.ResolveConsistency("linearizable"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if level != consistent.LINEARIZABLE {
+		t.Fatalf("The requested level shouldn't be replaced by %s", level)
+	}
+	if _, err := c.ResolveConsistency("strong"); err == nil {
+		t.Fatal("error is expected for an unsupported level")
+	}
+
+	c = &config.KVConfiguration{MinConsistency: consistent.LINEARIZABLE}
+	if level, err := c.ResolveConsistency("SEQUENTIAL"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if level != consistent.LINEARIZABLE {
+		t.Fatalf("The level should be raised to the floor instead of %s", level)
+	}
+}
+
+func TestValidatePlacementPolicy(t *testing.T) {
+	newConf := func(policy pc.PlacementPolicy) *config.KVConfiguration {
+		return &config.KVConfiguration{
+			StoreType:        constants.DummyLatency,
+			LocalReplicaNum:  2,
+			RemoteReplicaNum: 1,
+			PlacementPolicy:  policy,
+			Stores: []config.KVStore{
+				{AvailabilityZone: constants.US_WEST_1A, Name: "s1"},
+				{AvailabilityZone: constants.US_WEST_1A, Name: "s2"},
+				{AvailabilityZone: constants.US_WEST_1B, Name: "s3"},
+				{Region: constants.US_EAST_1, AvailabilityZone: constants.US_EAST_1A, Name: "s4"},
+			},
+		}
+	}
+	if err := newConf(pc.PlacementPolicy{MinRegions: 2, RequiredRegions: []constants.Region{constants.US_EAST_1}}).ValidatePlacementPolicy(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	invalid := []pc.PlacementPolicy{
+		{RequiredRegions: []constants.Region{constants.US_WEST_2}},
+		{MinRegions: 2, ForbiddenRegions: []constants.Region{constants.US_EAST_1}},
+		{PreferredLeaderRegion: constants.US_EAST_2},
+		{MaxReplicasPerZone: 1, ForbiddenRegions: []constants.Region{constants.US_EAST_1}},
+		{MinRegions: 4},
+	}
+	for i, policy := range invalid {
+		if err := newConf(policy).ValidatePlacementPolicy(); err == nil {
+			t.Errorf("case %d: error is expected for the policy %+v", i, policy)
+		}
+	}
+}
+
+func TestValidateErasureCoding(t *testing.T) {
+	newConf := func(local, remote, data, parity int) *config.KVConfiguration {
+		return &config.KVConfiguration{
+			StoreType:           constants.DummyLatency,
+			PipingType:          constants.ErasureCoded,
+			LocalReplicaNum:     local,
+			RemoteReplicaNum:    remote,
+			ErasureDataShards:   data,
+			ErasureParityShards: parity,
+			Stores: []config.KVStore{
+				{AvailabilityZone: constants.US_WEST_1A, Name: "s1"},
+				{AvailabilityZone: constants.US_WEST_1B, Name: "s2"},
+				{AvailabilityZone: constants.US_WEST_1C, Name: "s3"},
+				{Region: constants.US_EAST_1, AvailabilityZone: constants.US_EAST_1A, Name: "s4"},
+			},
+		}
+	}
+	if err := newConf(3, 1, 3, 1).ValidateErasureCoding(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i, conf := range []*config.KVConfiguration{
+		newConf(2, 1, 3, 1),
+		newConf(3, 1, 4, 0),
+		newConf(3, 2, 3, 2),
+	} {
+		if err := conf.ValidateErasureCoding(); err == nil {
+			t.Errorf("case %d: error is expected for %d+%d shards on %d+%d replicas", i, conf.ErasureDataShards, conf.ErasureParityShards, conf.LocalReplicaNum, conf.RemoteReplicaNum)
+		}
+	}
+}
+
+func TestValidateReadHedging(t *testing.T) {
+	for _, p := range []float64{0, 50, 99.9, 100} {
+		if err := (&config.KVConfiguration{ReadHedgePercentile: p}).ValidateReadHedging(); err != nil {
+			t.Errorf("unexpected error %v for the percentile %v", err, p)
+		}
+	}
+	for _, p := range []float64{-1, 100.1, math.NaN()} {
+		if err := (&config.KVConfiguration{ReadHedgePercentile: p}).ValidateReadHedging(); err == nil {
+			t.Errorf("error is expected for the percentile %v", p)
+		}
+	}
+}
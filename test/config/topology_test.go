@@ -0,0 +1,114 @@
+package config
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+)
+
+func onPremTopology() *config.Topology {
+	return &config.Topology{
+		Regions: []config.RegionSpec{
+			{Name: "dc-east", Provider: "onprem", Zones: []constants.AvailabilityZone{"rack1", "rack2"}, Location: &config.Coordinates{Latitude: 40.7, Longitude: -74}},
+			{Name: "dc-west", Provider: "onprem", Zones: []constants.AvailabilityZone{"rack3"}, Location: &config.Coordinates{Latitude: 37.8, Longitude: -122.4}},
+		},
+		Costs: []config.RegionCost{{From: "dc-east", To: "dc-west", Cost: 0.02}},
+	}
+}
+
+func TestResolveTopology(t *testing.T) {
+	conf := &config.KVConfiguration{
+		Topology: onPremTopology(),
+		Stores: []config.KVStore{
+			{AvailabilityZone: "rack1", Name: "s1"},
+			{AvailabilityZone: "rack3", Name: "s2", Region: "dc-west"},
+		},
+	}
+	if err := conf.ResolveTopology(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if conf.Stores[0].Region != "dc-east" || conf.Stores[1].Region != "dc-west" {
+		t.Fatalf("unexpected regions of the stores %+v", conf.Stores)
+	}
+	if err := conf.AddStore(config.KVStore{AvailabilityZone: "us-west-1a", Name: "s3"}); err == nil {
+		t.Fatal("error is expected when adding a store in an unknown zone")
+	}
+	if err := conf.AddStore(config.KVStore{AvailabilityZone: "rack2", Name: "s3"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if store, _ := conf.FindStore("s3"); store.Region != "dc-east" {
+		t.Fatalf("the added store is expected in dc-east, got %s", store.Region)
+	}
+
+	conf.Stores = append(conf.Stores, config.KVStore{AvailabilityZone: "rack3", Name: "s4", Region: "dc-east"})
+	if err := conf.ResolveTopology(); err == nil {
+		t.Fatal("error is expected for a store whose region is not the one of its zone")
+	}
+
+	// the AWS zones remain available when no topology is declared
+	conf = &config.KVConfiguration{Stores: []config.KVStore{{AvailabilityZone: constants.US_WEST_2B, Name: "s1"}}}
+	if err := conf.ResolveTopology(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if conf.Stores[0].Region != constants.US_WEST_2 {
+		t.Fatalf("unexpected region %s", conf.Stores[0].Region)
+	}
+}
+
+func TestValidateTopology(t *testing.T) {
+	if err := onPremTopology().Validate(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	invalid := map[string]func(*config.Topology){
+		"duplicate region": func(tp *config.Topology) { tp.Regions[1].Name = "dc-east" },
+		"duplicate zone":   func(tp *config.Topology) { tp.Regions[1].Zones = append(tp.Regions[1].Zones, "rack1") },
+		"no zone":          func(tp *config.Topology) { tp.Regions[1].Zones = nil },
+		"bad location":     func(tp *config.Topology) { tp.Regions[0].Location.Latitude = 91 },
+		"unknown cost":     func(tp *config.Topology) { tp.Costs[0].To = "dc-north" },
+		"negative cost":    func(tp *config.Topology) { tp.Costs[0].Cost = -1 },
+		"NaN cost":         func(tp *config.Topology) { tp.Costs[0].Cost = math.NaN() },
+		"NaN location":     func(tp *config.Topology) { tp.Regions[0].Location.Longitude = math.NaN() },
+	}
+	for name, change := range invalid {
+		tp := onPremTopology()
+		change(tp)
+		if err := tp.Validate(); err == nil {
+			t.Errorf("%s: error is expected", name)
+		}
+	}
+	tp := onPremTopology()
+	tp.Costs[0].Cost = math.NaN()
+	if err := tp.Validate(); err == nil || !strings.Contains(err.Error(), "not a number") {
+		t.Errorf("unexpected error %v for a NaN cost", err)
+	}
+
+	tp = onPremTopology()
+	if cost, ok := tp.Cost("dc-west", "dc-east"); !ok || cost != 0.02 {
+		t.Fatalf("unexpected cost %v", cost)
+	}
+	if d, ok := tp.DistanceKm("dc-east", "dc-west"); !ok || d < 4000 || d > 4200 {
+		t.Fatalf("unexpected distance %v", d)
+	}
+}
+
+func TestLoadUnknownZone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkv-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	content := `{"Stores": [{"Name": "store1", "AvailabilityZone": "eu-central-1a"}]}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.LoadKVConfiguration(path); err == nil {
+		t.Fatal("error is expected when loading a store in a zone of no declared region")
+	}
+}
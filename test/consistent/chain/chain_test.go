@@ -7,6 +7,7 @@ import (
 
 	"github.com/regionless-storage-service/pkg/consistent"
 	"github.com/regionless-storage-service/pkg/consistent/chain"
+	"github.com/regionless-storage-service/pkg/constants"
 	"github.com/regionless-storage-service/pkg/database"
 	"github.com/regionless-storage-service/test/mock"
 )
@@ -75,3 +76,20 @@ func TestChainWriteSEQUENTIAL(t *testing.T) {
 		t.Fatalf("tail failed to read a correct value %s", v1)
 	}
 }
+
+func TestMemoryChainIsGuarded(t *testing.T) {
+	database.EnableGuards(database.GuardOptions{FailureThreshold: 1, OpenTimeout: time.Minute})
+	database.RegisterDatabase("chain-guarded", database.Guard("chain-guarded", mock.NewFailingDatabase()))
+	defer database.Unregister("chain-guarded")
+
+	c, err := chain.NewChain(context.TODO(), constants.Memory, []string{"chain-guarded", "chain-next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write("k1", "v1", consistent.LINEARIZABLE); err == nil {
+		t.Fatalf("the write is expected to go through the guard of the failing head")
+	}
+	if database.Available("chain-guarded") {
+		t.Fatalf("the circuit of the failing head is expected to be open")
+	}
+}
@@ -0,0 +1,43 @@
+package consistent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/consistent"
+)
+
+func TestParseConsistency(t *testing.T) {
+	if c, err := consistent.ParseConsistency("sequential"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if c != consistent.SEQUENTIAL {
+		t.Fatalf("The consistency shouldn't be %s", c)
+	}
+	if c, err := consistent.ParseConsistency(" LINEARIZABLE "); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if c != consistent.LINEARIZABLE {
+		t.Fatalf("The consistency shouldn't be %s", c)
+	}
+	if _, err := consistent.ParseConsistency("eventual"); err == nil {
+		t.Fatal("error is expected for an unsupported level")
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+	if c := consistent.SEQUENTIAL.AtLeast(consistent.LINEARIZABLE); c != consistent.LINEARIZABLE {
+		t.Fatalf("sequential should be raised to linearizable instead of %s", c)
+	}
+	if c := consistent.LINEARIZABLE.AtLeast(consistent.SEQUENTIAL); c != consistent.LINEARIZABLE {
+		t.Fatalf("linearizable shouldn't be lowered to %s", c)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if c := consistent.FromContext(context.TODO(), consistent.LINEARIZABLE); c != consistent.LINEARIZABLE {
+		t.Fatalf("The fallback should be used instead of %s", c)
+	}
+	ctx := consistent.WithConsistency(context.TODO(), consistent.SEQUENTIAL)
+	if c := consistent.FromContext(ctx, consistent.LINEARIZABLE); c != consistent.SEQUENTIAL {
+		t.Fatalf("The context level should be used instead of %s", c)
+	}
+}
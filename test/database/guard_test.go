@@ -0,0 +1,196 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/test/mock"
+)
+
+// flakyDatabase fails while it is down and counts the requests reaching it
+type flakyDatabase struct {
+	database.Database
+	mu    sync.Mutex
+	down  bool
+	calls int
+	block chan struct{}
+}
+
+func newFlakyDatabase() *flakyDatabase {
+	return &flakyDatabase{Database: mock.NewMockDatabase()}
+}
+
+func (f *flakyDatabase) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *flakyDatabase) Get(key string) (string, error) {
+	f.mu.Lock()
+	f.calls++
+	down, block := f.down, f.block
+	f.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	if down {
+		return "", errors.New("connection refused")
+	}
+	return f.Database.Get(key)
+}
+
+func (f *flakyDatabase) requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []database.CircuitState
+}
+
+func (r *stateRecorder) record(name string, state database.CircuitState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, state)
+}
+
+func (r *stateRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprint(r.states)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	backend := newFlakyDatabase()
+	backend.Put("k", "v")
+	states := &stateRecorder{}
+	g := database.NewGuardedDatabase("s1", backend, database.GuardOptions{FailureThreshold: 3, OpenTimeout: 50 * time.Millisecond, OnStateChange: states.record})
+
+	// a missing key is not a failure
+	for i := 0; i < 5; i++ {
+		if _, err := g.Get("missing"); !database.IsNotFound(err) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if g.State() != database.CircuitClosed {
+		t.Fatalf("the missing keys are not expected to open the circuit")
+	}
+
+	backend.setDown(true)
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get("k"); err == nil || errors.Is(err, database.ErrCircuitOpen) {
+			t.Fatalf("the failure %d is expected from the store, got %v", i, err)
+		}
+	}
+	if g.State() != database.CircuitOpen {
+		t.Fatalf("the circuit is expected to open after 3 failures, got %s", g.State())
+	}
+	calls := backend.requests()
+	if _, err := g.Get("k"); !errors.Is(err, database.ErrCircuitOpen) {
+		t.Fatalf("an open circuit is expected to fail fast, got %v", err)
+	}
+	if backend.requests() != calls {
+		t.Fatalf("an open circuit is not expected to call the store")
+	}
+
+	// the probe of the half-open circuit fails and opens it again
+	time.Sleep(60 * time.Millisecond)
+	if _, err := g.Get("k"); err == nil || errors.Is(err, database.ErrCircuitOpen) {
+		t.Fatalf("the half-open circuit is expected to let the probe through, got %v", err)
+	}
+	if g.State() != database.CircuitOpen {
+		t.Fatalf("the failed probe is expected to open the circuit again, got %s", g.State())
+	}
+
+	// the probe succeeds once the store is back and closes the circuit
+	backend.setDown(false)
+	time.Sleep(60 * time.Millisecond)
+	if v, err := g.Get("k"); err != nil || v != "v" {
+		t.Fatalf("unexpected value %s, error %v", v, err)
+	}
+	if g.State() != database.CircuitClosed {
+		t.Fatalf("the successful probe is expected to close the circuit, got %s", g.State())
+	}
+	if states.String() != "[open half-open open half-open closed]" {
+		t.Errorf("unexpected state changes %s", states)
+	}
+	stats := g.Stats()
+	if stats.Failures != 4 || stats.Rejected != 1 || stats.Requests != 10 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	backend := newFlakyDatabase()
+	backend.Put("k", "v")
+	g := database.NewGuardedDatabase("s1", backend, database.GuardOptions{FailureThreshold: 100, ErrorRateThreshold: 0.5, Window: 10})
+	// every other request fails, which never makes 100 failures in a row
+	for i := 0; i < 10; i++ {
+		backend.setDown(i%2 == 0)
+		g.Get("k")
+	}
+	if g.State() != database.CircuitOpen {
+		t.Fatalf("the circuit is expected to open at a 50%% error rate, got %s with %+v", g.State(), g.Stats())
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	backend := newFlakyDatabase()
+	backend.block = make(chan struct{})
+	g := database.NewGuardedDatabase("s1", backend, database.GuardOptions{MaxInFlight: 1})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Get("k")
+	}()
+	for backend.requests() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := g.Get("k"); !errors.Is(err, database.ErrBulkheadFull) {
+		t.Fatalf("the second request in flight is expected to be rejected, got %v", err)
+	}
+	close(backend.block)
+	<-done
+	if stats := g.Stats(); stats.Rejected != 1 || stats.Failures != 0 || stats.State != database.CircuitClosed {
+		t.Errorf("a rejected request is not expected to count as a failure: %+v", stats)
+	}
+}
+
+func TestGuardRegistry(t *testing.T) {
+	database.EnableGuards(database.GuardOptions{FailureThreshold: 1, OpenTimeout: time.Minute})
+	states := &stateRecorder{}
+	database.OnCircuitChange(states.record)
+	defer database.OnCircuitChange(nil)
+
+	backend := newFlakyDatabase()
+	database.RegisterDatabase("guarded", database.Guard("guarded", backend))
+	defer database.Unregister("guarded")
+
+	db, err := database.FactoryWithNameAndLatency(constants.DummyLatency, "guarded", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.(*database.GuardedDatabase); !ok || !database.Available("guarded") {
+		t.Fatalf("the factory is expected to return the guarded database")
+	}
+	backend.setDown(true)
+	db.Get("k")
+	if database.Available("guarded") || states.String() != "[open]" {
+		t.Fatalf("the store is expected to be unavailable, the state changes are %s", states)
+	}
+	found := false
+	for _, s := range database.Guards() {
+		found = found || (s.Name == "guarded" && s.State == database.CircuitOpen)
+	}
+	if !found {
+		t.Errorf("the open circuit is expected in the health report %+v", database.Guards())
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/database"
+)
+
+func TestGetSetProbe(t *testing.T) {
+	conf := &config.KVConfiguration{
+		StoreType:    constants.DummyLatency,
+		LatencyProbe: constants.ProbeGetSet,
+		Stores:       []config.KVStore{{Name: "probed", ArtificialLatencyInMs: 5}},
+	}
+	db, err := database.Factory(conf.StoreType, &conf.Stores[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.Storages["probed"] = db
+	defer delete(database.Storages, "probed")
+
+	// a put and a get, each delayed by the artificial latency
+	l, err := conf.MeasureLatency(conf.Stores[0])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if l < 10*time.Millisecond {
+		t.Fatalf("the probe is expected to take at least 10ms, got %v", l)
+	}
+}
+
+func TestPingProbeUnsupported(t *testing.T) {
+	conf := &config.KVConfiguration{
+		StoreType:    constants.Memory,
+		LatencyProbe: constants.ProbePing,
+		Stores:       []config.KVStore{{Name: "mem1", Host: "127.0.0.1", Port: 1}},
+	}
+	if _, err := conf.MeasureLatency(conf.Stores[0]); err == nil {
+		t.Fatalf("error is expected as the memory store does not answer PING")
+	}
+}
+
+func TestNoopProbe(t *testing.T) {
+	conf := &config.KVConfiguration{
+		StoreType: constants.Memory,
+		Stores:    []config.KVStore{{Name: "mem1", Host: "127.0.0.1", Port: 1}},
+	}
+	l, err := conf.MeasureLatency(conf.Stores[0])
+	if err != nil || l != 0 {
+		t.Fatalf("no latency is expected for an in-process store, got %v, %v", l, err)
+	}
+}
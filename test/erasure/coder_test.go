@@ -0,0 +1,105 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/erasure"
+)
+
+// losses returns every set of at most max positions out of n
+func losses(n, max int) [][]int {
+	sets := [][]int{{}}
+	for i := 0; i < n; i++ {
+		for _, set := range sets {
+			if len(set) < max {
+				sets = append(sets, append(append([]int{}, set...), i))
+			}
+		}
+	}
+	return sets
+}
+
+func TestReconstruct(t *testing.T) {
+	for _, code := range [][2]int{{1, 1}, {2, 1}, {4, 2}, {3, 3}, {10, 4}} {
+		coder, err := erasure.NewCoder(code[0], code[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []int{0, 1, 7, 100, 4099} {
+			value := make([]byte, size)
+			rand.Read(value)
+			shards, err := coder.EncodeValue(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, lost := range losses(len(shards), code[1]) {
+				left := make([]erasure.Shard, 0, len(shards))
+				for i, s := range shards {
+					kept := true
+					for _, l := range lost {
+						kept = kept && l != i
+					}
+					if kept {
+						left = append(left, s)
+					}
+				}
+				// the shards come back in any order
+				rand.Shuffle(len(left), func(i, j int) { left[i], left[j] = left[j], left[i] })
+				got, err := coder.DecodeValue(left)
+				if err != nil || !bytes.Equal(got, value) {
+					t.Fatalf("%d+%d code of %d bytes without the shards %v: %v", code[0], code[1], size, lost, err)
+				}
+			}
+		}
+	}
+}
+
+func TestTooManyLostShards(t *testing.T) {
+	coder, err := erasure.NewCoder(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, err := coder.EncodeValue([]byte("a value spread over six stores"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a shard held twice does not stand in for a lost one
+	left := []erasure.Shard{shards[0], shards[2], shards[5], shards[5]}
+	if _, err := coder.DecodeValue(left); !errors.Is(err, erasure.ErrTooFewShards) {
+		t.Fatalf("3 distinct shards out of 6 are not expected to be enough, got %v", err)
+	}
+}
+
+func TestShardChecksum(t *testing.T) {
+	coder, err := erasure.NewCoder(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, err := coder.EncodeValue([]byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := shards[1].Marshal()
+	s, err := erasure.UnmarshalShard(b)
+	if err != nil || s.Index != 1 || s.DataShards != 2 || s.ParityShards != 1 || s.Size != 5 || !bytes.Equal(s.Data, shards[1].Data) {
+		t.Fatalf("unexpected shard %+v, %v", s, err)
+	}
+	b[len(b)-1] ^= 0xff
+	if _, err := erasure.UnmarshalShard(b); !errors.Is(err, erasure.ErrCorruptShard) {
+		t.Errorf("a corrupt shard is expected to be detected, got %v", err)
+	}
+	if _, err := erasure.UnmarshalShard([]byte("a replicated value")); !errors.Is(err, erasure.ErrCorruptShard) {
+		t.Errorf("a value which is not a shard is expected to be rejected, got %v", err)
+	}
+}
+
+func TestInvalidCode(t *testing.T) {
+	for _, code := range [][2]int{{0, 2}, {2, -1}, {200, 57}} {
+		if _, err := erasure.NewCoder(code[0], code[1]); err == nil {
+			t.Errorf("the %d+%d code is expected to be rejected", code[0], code[1])
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package fsck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/fsck"
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/test/mock"
+)
+
+type cluster struct {
+	indexTree index.Index
+	dbs       map[string]database.Database
+	revs      []index.Revision
+	down      map[string]bool
+}
+
+// newCluster writes 2 revisions of every key to s1, s2 and s3, the second one of the last key being a tombstone.
+// The revisions record the checksum of their value unless they are written as before the checksums.
+func newCluster(t *testing.T, keys int, checksums bool) *cluster {
+	c := &cluster{indexTree: index.NewTreeIndex(), dbs: make(map[string]database.Database), down: make(map[string]bool)}
+	for _, name := range []string{"s1", "s2", "s3", "s4"} {
+		c.dbs[name] = mock.NewMockDatabase()
+	}
+	p := consistent.NamedPlacement([]string{"s1", "s2"}, []string{"s3"})
+	main := int64(0)
+	for i := 0; i < keys; i++ {
+		key := []byte(fmt.Sprintf("k%d", i))
+		for j := 0; j < 2; j++ {
+			main++
+			if i == keys-1 && j == 1 {
+				if err := c.indexTree.Tombstone(context.Background(), key, index.NewRevision(c.indexTree.Placements(), main, 0, consistent.Placement{})); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			rev := index.NewRevision(c.indexTree.Placements(), main, 0, p)
+			value := fmt.Sprintf("v%d", main)
+			if checksums {
+				rev.SetChecksum(value)
+			}
+			for _, name := range p.Nodes() {
+				c.dbs[name].Put(rev.String(), value)
+			}
+			if err := c.indexTree.Put(context.Background(), key, rev); err != nil {
+				t.Fatal(err)
+			}
+			c.revs = append(c.revs, rev)
+		}
+	}
+	return c
+}
+
+func (c *cluster) resolve(name string) (database.Database, error) {
+	if c.down[name] {
+		return nil, errors.New("store unavailable")
+	}
+	return c.dbs[name], nil
+}
+
+func problems(report fsck.Report) map[string]fsck.Problem {
+	found := make(map[string]fsck.Problem)
+	for _, f := range report.Findings {
+		found[f.Revision+"@"+f.Node] = f.Problem
+	}
+	return found
+}
+
+func TestCheck(t *testing.T) {
+	c := newCluster(t, 5, true)
+	c.dbs["s3"].Delete(c.revs[0].String())
+	c.dbs["s2"].Put(c.revs[1].String(), "corrupted")
+	for _, name := range []string{"s1", "s2", "s3"} {
+		c.dbs[name].Delete(c.revs[2].String())
+	}
+	c.dbs["s4"].Put(c.revs[3].String(), "left behind")
+
+	report, err := fsck.Check(context.Background(), c.indexTree, c.resolve, fsck.Options{Stores: []string{"s1", "s2", "s3", "s4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the historical revisions are checked, the tombstone is not
+	if report.Revisions != 9 || report.Replicas != 27 {
+		t.Fatalf("unexpected number of revisions %d and replicas %d", report.Revisions, report.Replicas)
+	}
+	if report.UnderReplicated != 2 || report.Lost != 1 || report.Missing != 4 || report.Mismatched != 1 || report.Orphaned != 1 || report.Healthy() {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	found := problems(report)
+	expected := map[string]fsck.Problem{
+		c.revs[0].String() + "@s3": fsck.ProblemMissing,
+		c.revs[1].String() + "@s2": fsck.ProblemMismatch,
+		c.revs[2].String() + "@s1": fsck.ProblemMissing,
+		c.revs[3].String() + "@s4": fsck.ProblemOrphaned,
+	}
+	for k, problem := range expected {
+		if found[k] != problem {
+			t.Errorf("%s is expected to be %s, got %q", k, problem, found[k])
+		}
+	}
+
+	// nothing is written without repair
+	if _, err := c.dbs["s3"].Get(c.revs[0].String()); !database.IsNotFound(err) {
+		t.Errorf("the check is not expected to repair, got %v", err)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	c := newCluster(t, 5, true)
+	c.dbs["s3"].Delete(c.revs[0].String())
+	c.dbs["s2"].Put(c.revs[1].String(), "corrupted")
+	c.down["s1"] = true
+
+	report, err := fsck.Check(context.Background(), c.indexTree, c.resolve, fsck.Options{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// s1 is unreadable, and the checksum recorded at write time tells which of s2 and s3 holds the revision 2
+	if report.Repaired != 2 || report.Unreadable != 9 || report.UnderReplicated != 9 || report.Lost != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if v, err := c.dbs["s3"].Get(c.revs[0].String()); err != nil || v != "v1" {
+		t.Errorf("the revision 1 on s3 is %q, %v", v, err)
+	}
+	if v, err := c.dbs["s2"].Get(c.revs[1].String()); err != nil || v != "v2" {
+		t.Errorf("the revision 2 on s2 is %q, %v", v, err)
+	}
+
+	c.down["s1"] = false
+	report, err = fsck.Check(context.Background(), c.indexTree, c.resolve, fsck.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Healthy() || len(report.Findings) != 0 {
+		t.Errorf("the replicas are expected to be healthy once repaired: %+v", report)
+	}
+}
+
+func TestRepairCorruptedMajority(t *testing.T) {
+	c := newCluster(t, 2, true)
+	// most replicas of the revision 1 hold the same wrong value, and every replica of the revision 2 a wrong one
+	c.dbs["s1"].Put(c.revs[0].String(), "corrupted")
+	c.dbs["s2"].Put(c.revs[0].String(), "corrupted")
+	for _, name := range []string{"s1", "s2", "s3"} {
+		c.dbs[name].Put(c.revs[1].String(), "corrupted")
+	}
+
+	report, err := fsck.Check(context.Background(), c.indexTree, c.resolve, fsck.Options{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Mismatched != 5 || report.Repaired != 2 || report.Lost != 1 || report.UnderReplicated != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for _, name := range []string{"s1", "s2", "s3"} {
+		if v, err := c.dbs[name].Get(c.revs[0].String()); err != nil || v != "v1" {
+			t.Errorf("the revision 1 on %s is %q, %v", name, v, err)
+		}
+		if v, err := c.dbs[name].Get(c.revs[1].String()); err != nil || v != "corrupted" {
+			t.Errorf("the revision 2 is not expected to be repaired from a wrong value, it is %q on %s, %v", v, name, err)
+		}
+	}
+}
+
+func TestRepairWithoutChecksum(t *testing.T) {
+	c := newCluster(t, 5, false)
+	c.dbs["s2"].Put(c.revs[1].String(), "corrupted")
+	c.down["s1"] = true
+
+	report, err := fsck.Check(context.Background(), c.indexTree, c.resolve, fsck.Options{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// s2 and s3 disagree on the revision 2, which is left as it is
+	if report.Repaired != 0 || report.Mismatched != 1 || report.Lost != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if v, err := c.dbs["s3"].Get(c.revs[1].String()); err != nil || v != "v2" {
+		t.Errorf("a tie is not expected to be repaired, the revision 2 on s3 is %q, %v", v, err)
+	}
+
+	// s1 breaks the tie once back
+	c.down["s1"] = false
+	report, err = fsck.Check(context.Background(), c.indexTree, c.resolve, fsck.Options{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Repaired != 1 || report.Mismatched != 1 || !report.Healthy() {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if v, err := c.dbs["s2"].Get(c.revs[1].String()); err != nil || v != "v2" {
+		t.Errorf("the revision 2 on s2 is %q, %v", v, err)
+	}
+}
@@ -0,0 +1,114 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+var benchKeys = []int{1000000, 2000000}
+
+// copiedRevision is a revision keeping its own copy of its replicas, as the revisions did before the
+// placements were interned: the sync nodes and the async nodes, each joined with commas
+type copiedRevision struct {
+	main, sub int64
+	nodes     []string
+}
+
+func copiedNodes(p consistent.Placement) []string {
+	return []string{strings.Join(p.SyncNodes(), ","), strings.Join(p.AsyncNodes(), ",")}
+}
+
+func benchHashing() consistent.HashingManager {
+	localStores := make(map[constants.AvailabilityZone][]consistent.RkvNode)
+	for _, az := range []constants.AvailabilityZone{constants.US_WEST_1A, constants.US_WEST_1B, constants.US_WEST_1C} {
+		for i := 0; i < 4; i++ {
+			localStores[az] = append(localStores[az], consistent.RkvNode{Name: fmt.Sprintf("%s-store-%d:6379", az, i), AvailabilityZone: az})
+		}
+	}
+	remoteStores := make([]consistent.RkvNode, 0)
+	for i := 0; i < 4; i++ {
+		remoteStores = append(remoteStores, consistent.RkvNode{Name: fmt.Sprintf("us-east-1a-store-%d:6379", i), AvailabilityZone: constants.US_EAST_1A})
+	}
+	return consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 2, remoteStores, 1)
+}
+
+// heapInUse returns the bytes of the live heap
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// benchmarkMemory reports the heap held per revision by what build keeps
+func benchmarkMemory(b *testing.B, keys int, build func(hm consistent.HashingManager, keys int) interface{}) {
+	hm := benchHashing()
+	var perRevision float64
+	for n := 0; n < b.N; n++ {
+		before := heapInUse()
+		kept := build(hm, keys)
+		after := heapInUse()
+		runtime.KeepAlive(kept)
+		perRevision = float64(after-before) / float64(keys)
+	}
+	b.ReportMetric(perRevision, "B/rev")
+}
+
+func placementOf(b testing.TB, hm consistent.HashingManager, i int) consistent.Placement {
+	p, err := hm.GetPlacement([]byte(fmt.Sprintf("bucket-%d", i)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+// BenchmarkRevisionMemory compares the memory of the revisions keeping an ID of the placement table to the one
+// of the revisions keeping a copy of their replicas, e.g. with go test -bench RevisionMemory -benchtime 1x
+func BenchmarkRevisionMemory(b *testing.B) {
+	for _, keys := range benchKeys {
+		b.Run(fmt.Sprintf("interned/%d", keys), func(b *testing.B) {
+			benchmarkMemory(b, keys, func(hm consistent.HashingManager, keys int) interface{} {
+				placements := index.NewPlacementTable()
+				revs := make([]index.Revision, keys)
+				for i := range revs {
+					revs[i] = index.NewRevision(placements, int64(i+1), 0, placementOf(b, hm, i))
+				}
+				return revs
+			})
+		})
+		b.Run(fmt.Sprintf("copied/%d", keys), func(b *testing.B) {
+			benchmarkMemory(b, keys, func(hm consistent.HashingManager, keys int) interface{} {
+				revs := make([]copiedRevision, keys)
+				for i := range revs {
+					revs[i] = copiedRevision{main: int64(i + 1), nodes: copiedNodes(placementOf(b, hm, i))}
+				}
+				return revs
+			})
+		})
+	}
+}
+
+// BenchmarkIndexMemory reports the memory of the index per key, each key having one revision
+func BenchmarkIndexMemory(b *testing.B) {
+	for _, keys := range benchKeys {
+		b.Run(fmt.Sprint(keys), func(b *testing.B) {
+			benchmarkMemory(b, keys, func(hm consistent.HashingManager, keys int) interface{} {
+				ti := index.NewTreeIndex()
+				for i := 0; i < keys; i++ {
+					rev := index.NewRevision(ti.Placements(), int64(i+1), 0, placementOf(b, hm, i))
+					if err := ti.Put(context.Background(), []byte(fmt.Sprintf("key-%d", i)), rev); err != nil {
+						b.Fatal(err)
+					}
+				}
+				return ti
+			})
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+func TestPlacementInterning(t *testing.T) {
+	placements := index.NewPlacementTable()
+	p := consistent.NamedPlacement([]string{"a", "b"}, []string{"c"})
+	rev1, rev2 := index.NewRevision(placements, 1, 0, p), index.NewRevision(placements, 2, 0, consistent.NamedPlacement([]string{"a", "b"}, []string{"c"}))
+	id1, version1 := rev1.GetPlacementID()
+	id2, version2 := rev2.GetPlacementID()
+	if id1 == 0 || id1 != id2 || version1 != version2 {
+		t.Fatalf("the revisions of the same placement are expected to share an ID, got %d.%d and %d.%d", id1, version1, id2, version2)
+	}
+	other := index.NewRevision(placements, 3, 0, consistent.NamedPlacement([]string{"b", "a"}, []string{"c"}))
+	if id, _ := other.GetPlacementID(); id == id1 {
+		t.Fatal("the placements with another leader are expected to have another ID")
+	}
+	if fmt.Sprint(rev1.GetPlacement()) != fmt.Sprint(p) {
+		t.Fatalf("the placement %v is expected, got %v", p, rev1.GetPlacement())
+	}
+	if id, _ := index.NewRevision(placements, 4, 0, consistent.Placement{}).GetPlacementID(); id != 0 {
+		t.Fatalf("the empty placement is expected to be 0, got %d", id)
+	}
+}
+
+func TestCompactPlacements(t *testing.T) {
+	ctx := context.Background()
+	ti := index.NewTreeIndex()
+	for i := 0; i < 100; i++ {
+		p := consistent.NamedPlacement([]string{fmt.Sprintf("old-%d", i%10)}, nil)
+		if err := ti.Put(ctx, []byte(fmt.Sprintf("k%d", i)), index.NewRevision(ti.Placements(), int64(i+1), 0, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the stores are replaced, as a membership change would do it
+	for i := 0; i < 100; i++ {
+		p := consistent.NamedPlacement([]string{fmt.Sprintf("new-%d", i%4)}, nil)
+		if err := ti.SetPlacement(ctx, []byte(fmt.Sprintf("k%d", i)), index.NewRevision(ti.Placements(), int64(i+1), 0, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale, _, _, err := ti.Get(ctx, []byte("k5"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version := ti.Placements().Version()
+	if kept := ti.CompactPlacements(ctx); kept != 4 {
+		t.Fatalf("4 placements are expected to be kept, got %d", kept)
+	}
+	if ti.Placements().Version() != version+1 {
+		t.Fatal("the compaction is expected to make a new version of the placement table")
+	}
+	ti.Walk(ctx, func(key []byte, rev index.Revision) bool {
+		id, v := rev.GetPlacementID()
+		if v != version+1 || id < 1 || id > 4 {
+			t.Fatalf("the revision %s of %s has the placement %d.%d", rev.String(), key, id, v)
+		}
+		var i int
+		fmt.Sscanf(string(key), "k%d", &i)
+		if expected := fmt.Sprintf("new-%d", i%4); rev.GetPlacement().String() != expected {
+			t.Fatalf("the revision of %s is on %v instead of %s", key, rev.GetPlacement(), expected)
+		}
+		return true
+	})
+	// a revision read before the compaction still resolves
+	if stale.GetPlacement().String() != "new-1" {
+		t.Fatalf("the revision read before the compaction is on %v", stale.GetPlacement())
+	}
+}
+
+func TestCompactPlacementsOfOneIndex(t *testing.T) {
+	ctx := context.Background()
+	indexes := []index.Index{index.NewTreeIndex(), index.NewTreeIndex()}
+	for n, ti := range indexes {
+		for i := 0; i < 10; i++ {
+			p := consistent.NamedPlacement([]string{fmt.Sprintf("index-%d-%d", n, i%2)}, nil)
+			if err := ti.Put(ctx, []byte(fmt.Sprintf("k%d", i)), index.NewRevision(ti.Placements(), int64(i+1), 0, p)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// a revision made for the first index is put in the second one too
+	moved := index.NewRevision(indexes[0].Placements(), 11, 0, consistent.NamedPlacement([]string{"moved"}, nil))
+	if err := indexes[1].Put(ctx, []byte("moved"), moved); err != nil {
+		t.Fatal(err)
+	}
+	read, _, _, err := indexes[1].Get(ctx, []byte("k3"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the compactions of the first index leave the second one, and the revisions read from it, as they are
+	version := indexes[1].Placements().Version()
+	for i := 0; i < 2; i++ {
+		if kept := indexes[0].CompactPlacements(ctx); kept != 2 {
+			t.Fatalf("2 placements are expected to be kept, got %d", kept)
+		}
+	}
+	if v := indexes[1].Placements().Version(); v != version {
+		t.Fatalf("the placement table of the second index is expected to be at the version %d, got %d", version, v)
+	}
+	if read.GetPlacement().String() != "index-1-1" {
+		t.Fatalf("the revision read from the second index is on %v", read.GetPlacement())
+	}
+	for n, ti := range indexes {
+		ti.Walk(ctx, func(key []byte, rev index.Revision) bool {
+			expected := "moved"
+			if string(key) != "moved" {
+				var i int
+				fmt.Sscanf(string(key), "k%d", &i)
+				expected = fmt.Sprintf("index-%d-%d", n, i%2)
+			}
+			if rev.GetPlacement().String() != expected {
+				t.Fatalf("the revision of %s in the index %d is on %v instead of %s", key, n, rev.GetPlacement(), expected)
+			}
+			return true
+		})
+	}
+}
+
+func TestRevisionWithoutTable(t *testing.T) {
+	ctx := context.Background()
+	p := consistent.NamedPlacement([]string{"a", "b"}, []string{"c"})
+	rev := index.NewRevision(nil, 1, 0, p)
+	if rev.IsTombstone() || fmt.Sprint(rev.GetPlacement()) != fmt.Sprint(p) {
+		t.Fatalf("the placement %v is expected without a table, got %v", p, rev.GetPlacement())
+	}
+	ti := index.NewTreeIndex()
+	if err := ti.Put(ctx, []byte("k"), rev); err != nil {
+		t.Fatal(err)
+	}
+	ti.CompactPlacements(ctx)
+	ti.CompactPlacements(ctx)
+	if stored, _, _, err := ti.Get(ctx, []byte("k"), 0); err != nil || fmt.Sprint(stored.GetPlacement()) != fmt.Sprint(p) {
+		t.Fatalf("the placement %v is expected to be moved to the table of the index, got %v with the error %v", p, stored.GetPlacement(), err)
+	}
+}
+
+func TestPutWhileCompacting(t *testing.T) {
+	ctx := context.Background()
+	ti := index.NewTreeIndex()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			ti.CompactPlacements(ctx)
+		}
+	}()
+	for i := 0; i < 2000; i++ {
+		p := consistent.NamedPlacement([]string{fmt.Sprintf("s%d", i%7)}, nil)
+		// the revisions come with a table of their own, which no compaction of the index changes before the put
+		if err := ti.Put(ctx, []byte(fmt.Sprintf("k%d", i)), index.NewRevision(nil, int64(i+1), 0, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	ti.CompactPlacements(ctx)
+	ti.CompactPlacements(ctx)
+	for i := 0; i < 2000; i++ {
+		rev, _, _, err := ti.Get(ctx, []byte(fmt.Sprintf("k%d", i)), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes := rev.GetPlacement().Nodes(); len(nodes) != 1 || nodes[0] != fmt.Sprintf("s%d", i%7) {
+			t.Fatalf("the key k%d lost its placement in a compaction, got %v", i, nodes)
+		}
+	}
+}
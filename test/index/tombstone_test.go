@@ -0,0 +1,66 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/pkg/revision"
+)
+
+func next(ti index.Index, p consistent.Placement) index.Revision {
+	return index.NewRevision(ti.Placements(), int64(revision.GetGlobalIncreasingRevision()), 0, p)
+}
+
+func TestTombstoneKeepsHistory(t *testing.T) {
+	ctx := context.Background()
+	ti := index.NewTreeIndex()
+	key := []byte("k")
+	p := consistent.NamedPlacement([]string{"a", "b"}, []string{"c"})
+	put1, put2 := next(ti, p), next(ti, p)
+	for _, rev := range []index.Revision{put1, put2} {
+		if err := ti.Put(ctx, key, rev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tombstone := next(ti, consistent.Placement{})
+	if err := ti.Tombstone(ctx, key, tombstone); err != nil {
+		t.Fatal(err)
+	}
+	if !tombstone.IsTombstone() || put1.IsTombstone() {
+		t.Fatalf("only the revision without placement is expected to be a tombstone")
+	}
+
+	// the deleted key is not found, its earlier revisions are
+	if _, _, _, err := ti.Get(ctx, key, 0); !errors.Is(err, index.ErrRevisionNotFound) {
+		t.Fatalf("the deleted key is expected not to be found, got %v", err)
+	}
+	if rev, _, _, err := ti.Get(ctx, key, put2.GetMain()); err != nil || rev.GetMain() != put2.GetMain() || rev.GetPlacement().String() != p.String() {
+		t.Fatalf("the revision %s is expected to be read with its placement, got %s at %v, %v", put2, rev, rev.GetPlacement(), err)
+	}
+	if err := ti.Tombstone(ctx, key, next(ti, consistent.Placement{})); !errors.Is(err, index.ErrRevisionNotFound) {
+		t.Fatalf("a deleted key is not expected to be deleted again, got %v", err)
+	}
+
+	// the key is written again after it is deleted
+	put3 := next(ti, p)
+	if err := ti.Put(ctx, key, put3); err != nil {
+		t.Fatal(err)
+	}
+	revs := ti.RangeSince(ctx, key, nil, put1.GetMain())
+	expected := []index.Revision{put1, put2, tombstone, put3}
+	if len(revs) != len(expected) {
+		t.Fatalf("the history %v is expected, got %v", expected, revs)
+	}
+	for i, rev := range revs {
+		if rev.GetMain() != expected[i].GetMain() || rev.IsTombstone() != expected[i].IsTombstone() {
+			t.Errorf("the revision %d of the history is expected to be %s, tombstone %t, got %s, tombstone %t",
+				i, expected[i], expected[i].IsTombstone(), rev, rev.IsTombstone())
+		}
+		if !rev.IsTombstone() && rev.GetPlacement().String() != p.String() {
+			t.Errorf("the revision %s is expected to keep its placement, got %v", rev, rev.GetPlacement())
+		}
+	}
+}
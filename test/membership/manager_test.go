@@ -0,0 +1,257 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/membership"
+	"github.com/regionless-storage-service/pkg/monitor"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/pkg/replication"
+	"github.com/regionless-storage-service/test/mock"
+)
+
+type cluster struct {
+	conf      *config.KVConfiguration
+	hm        consistent.HashingManager
+	indexTree index.Index
+	dbs       map[string]database.Database
+	manager   *membership.Manager
+}
+
+func newCluster(t *testing.T, revisions int) *cluster {
+	conf := &config.KVConfiguration{
+		StoreType:                             constants.DummyLatency,
+		ConsistentHash:                        constants.Rendezvous,
+		BucketSize:                            1,
+		RemoteStoreLatencyThresholdInMilliSec: 100,
+		Stores: []config.KVStore{
+			{AvailabilityZone: "az1", Name: "s1", ArtificialLatencyInMs: 1},
+			{AvailabilityZone: "az2", Name: "s2", ArtificialLatencyInMs: 1},
+			{AvailabilityZone: "az3", Name: "s3", ArtificialLatencyInMs: 1},
+		},
+		Topology: &config.Topology{Regions: []config.RegionSpec{
+			{Name: "dc1", Zones: []constants.AvailabilityZone{"az1", "az2", "az3", "az4"}},
+		}},
+	}
+	localStores, remoteStores, err := conf.GetReplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cluster{conf: conf, indexTree: index.NewTreeIndex(), dbs: make(map[string]database.Database)}
+	c.hm = consistent.NewSyncAsyncHashingManager(conf.ConsistentHash, localStores, 2, remoteStores, 0)
+	for _, name := range []string{"s1", "s2", "s3", "s4"} {
+		c.dbs[name] = mock.NewMockDatabase()
+	}
+	lm := monitor.NewLatencyMonitor(conf, c.hm, localStores, remoteStores, 0, 1)
+	c.manager = membership.NewManager(conf, c.hm, lm, c.indexTree, func(name string) (database.Database, error) {
+		if db, ok := c.dbs[name]; ok {
+			return db, nil
+		}
+		return nil, fmt.Errorf("unknown store %s", name)
+	})
+
+	c.write(t, 1, revisions)
+	return c
+}
+
+// write puts the revisions from to to, each of its own key, where the hashing manager places them
+func (c *cluster) write(t *testing.T, from, to int) {
+	for i := from; i <= to; i++ {
+		rev := index.NewRevision(c.indexTree.Placements(), int64(i), 0, consistent.Placement{})
+		p, err := c.hm.GetPlacement(rev.BucketKey(c.conf.BucketSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rev.SetPlacement(p)
+		for _, name := range p.Nodes() {
+			c.dbs[name].Put(rev.String(), fmt.Sprintf("v%d", i))
+		}
+		if err := c.indexTree.Put(context.Background(), []byte(fmt.Sprintf("k%d", i)), rev); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func names(p consistent.Placement) []string {
+	out := p.Nodes()
+	sort.Strings(out)
+	return out
+}
+
+func (c *cluster) wait(t *testing.T) membership.Migration {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.manager.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	migrations := c.manager.Migrations()
+	return migrations[len(migrations)-1]
+}
+
+// verify checks that every revision is placed where the hashing manager places it now, and that its value is
+// on exactly these nodes
+func (c *cluster) verify(t *testing.T) {
+	c.indexTree.Walk(context.Background(), func(key []byte, rev index.Revision) bool {
+		expected, err := c.hm.GetPlacement(rev.BucketKey(c.conf.BucketSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(names(rev.GetPlacement())) != fmt.Sprint(names(expected)) {
+			t.Errorf("the revision %s of %s is at %v instead of %v", rev.String(), key, rev.GetPlacement(), expected)
+		}
+		placed := names(rev.GetPlacement())
+		for name, db := range c.dbs {
+			_, err := db.Get(rev.String())
+			found := err == nil
+			want := false
+			for _, n := range placed {
+				want = want || n == name
+			}
+			if found != want {
+				t.Errorf("the revision %s of %s is on %s: %t, expected %t", rev.String(), key, name, found, want)
+			}
+		}
+		return true
+	})
+}
+
+func TestAddStore(t *testing.T) {
+	c := newCluster(t, 50)
+	if _, err := c.manager.AddStore(config.KVStore{AvailabilityZone: "az4", Name: "s4", ArtificialLatencyInMs: 1}); err != nil {
+		t.Fatal(err)
+	}
+	migration := c.wait(t)
+	if migration.State != membership.StateSucceeded || migration.Total == 0 || migration.Moved != migration.Total {
+		t.Fatalf("unexpected migration %+v", migration)
+	}
+	if _, err := c.manager.AddStore(config.KVStore{AvailabilityZone: "az4", Name: "s4"}); err == nil {
+		t.Fatalf("error is expected when adding an existing store")
+	}
+	c.verify(t)
+}
+
+func TestDrainStore(t *testing.T) {
+	c := newCluster(t, 50)
+	if _, err := c.manager.AddStore(config.KVStore{AvailabilityZone: "az4", Name: "s4", ArtificialLatencyInMs: 1}); err != nil {
+		t.Fatal(err)
+	}
+	c.wait(t)
+	if _, err := c.manager.DrainStore("s1"); err != nil {
+		t.Fatal(err)
+	}
+	migration := c.wait(t)
+	if migration.State != membership.StateSucceeded || migration.Total == 0 {
+		t.Fatalf("unexpected migration %+v", migration)
+	}
+	c.verify(t)
+	c.indexTree.Walk(context.Background(), func(key []byte, rev index.Revision) bool {
+		for _, name := range names(rev.GetPlacement()) {
+			if name == "s1" {
+				t.Errorf("the revision %s of %s is still on the drained store", rev.String(), key)
+			}
+		}
+		return true
+	})
+	for _, store := range c.manager.Stores() {
+		if store.Name == "s1" && !store.Draining {
+			t.Errorf("the store s1 is expected to be draining")
+		}
+	}
+}
+
+func TestRemoveStore(t *testing.T) {
+	c := newCluster(t, 50)
+	if _, err := c.manager.RemoveStore("s9"); !errors.Is(err, membership.ErrStoreNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := c.manager.RemoveStore("s3"); err != nil {
+		t.Fatal(err)
+	}
+	migration := c.wait(t)
+	if migration.State != membership.StateSucceeded {
+		t.Fatalf("unexpected migration %+v", migration)
+	}
+	c.verify(t)
+	for _, store := range c.manager.Stores() {
+		if store.Name == "s3" {
+			t.Errorf("the store s3 is expected to be removed")
+		}
+	}
+	if _, ok := c.conf.FindStore("s3"); ok {
+		t.Errorf("the store s3 is expected to be removed from the configuration")
+	}
+}
+
+func TestRemoveStoreDropsItsReplicationQueue(t *testing.T) {
+	c := newCluster(t, 20)
+	rm, err := replication.NewManager(replication.Options{Dir: t.TempDir(), InitialBackoff: time.Second}, func(name string) (database.Database, error) {
+		return nil, errors.New("store unavailable")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm.Close()
+	c.manager.OnRemove(rm.Remove)
+	// s3 stopped taking the async writes before it is removed
+	if err := rm.Enqueue(c.conf.NodeName(config.KVStore{Name: "s3"}), replication.OpPut, "1_0", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := rm.Stats(); len(stats) != 1 || stats[0].Pending != 1 {
+		t.Fatalf("unexpected replication backlog %+v", stats)
+	}
+
+	if _, err := c.manager.RemoveStore("s3"); err != nil {
+		t.Fatal(err)
+	}
+	if migration := c.wait(t); migration.State != membership.StateSucceeded {
+		t.Fatalf("unexpected migration %+v", migration)
+	}
+	if stats := rm.Stats(); len(stats) != 0 {
+		t.Fatalf("the backlog of the removed store is expected to be dropped, got %+v", stats)
+	}
+}
+
+func TestHandOff(t *testing.T) {
+	c := newCluster(t, 50)
+	// the revisions written while s1 is unhealthy go to the other stores, hinted for s1
+	c.hm.SetUnhealthyStores([]string{"s1"})
+	c.write(t, 51, 100)
+	hinted := 0
+	c.indexTree.Walk(context.Background(), func(key []byte, rev index.Revision) bool {
+		if len(rev.GetPlacement().Hinted()) > 0 {
+			hinted++
+		}
+		return true
+	})
+	if hinted == 0 {
+		t.Fatalf("expected revisions hinted for s1")
+	}
+
+	if _, err := c.manager.HandOff("s9"); !errors.Is(err, membership.ErrStoreNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	c.hm.SetUnhealthyStores(nil)
+	if _, err := c.manager.HandOff("s1"); err != nil {
+		t.Fatal(err)
+	}
+	migration := c.wait(t)
+	if migration.Action != membership.ActionHandoff || migration.State != membership.StateSucceeded || migration.Total != hinted {
+		t.Fatalf("unexpected migration %+v, %d revisions are hinted", migration, hinted)
+	}
+	c.verify(t)
+	c.indexTree.Walk(context.Background(), func(key []byte, rev index.Revision) bool {
+		if h := rev.GetPlacement().Hinted(); len(h) > 0 {
+			t.Errorf("the revision %s of %s still has the hints %v", rev.String(), key, h)
+		}
+		return true
+	})
+}
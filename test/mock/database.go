@@ -2,29 +2,34 @@ package mock
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/regionless-storage-service/pkg/database"
 )
 
 type MockDatabase struct {
+	// mu guards db, which the writes fanned out to the stores reach concurrently
+	mu           *sync.RWMutex
 	db           map[string]string
 	readLatency  int
 	writeLatency int
 }
 
 func NewMockDatabase() database.Database {
-	return MockDatabase{db: make(map[string]string)}
+	return MockDatabase{mu: &sync.RWMutex{}, db: make(map[string]string)}
 }
 
 func NewMockDatabaseWithLatency(readLatency, writeLatency int) database.Database {
-	return MockDatabase{db: make(map[string]string), readLatency: readLatency, writeLatency: writeLatency}
+	return MockDatabase{mu: &sync.RWMutex{}, db: make(map[string]string), readLatency: readLatency, writeLatency: writeLatency}
 }
 
 func (md MockDatabase) Put(key, value string) (string, error) {
 	if md.writeLatency > 0 {
 		time.Sleep(time.Duration(md.writeLatency) * time.Second)
 	}
+	md.mu.Lock()
+	defer md.mu.Unlock()
 	md.db[key] = value
 	return "", nil
 }
@@ -33,17 +38,36 @@ func (md MockDatabase) Get(key string) (string, error) {
 	if md.readLatency > 0 {
 		time.Sleep(time.Duration(md.readLatency) * time.Second)
 	}
+	md.mu.RLock()
+	defer md.mu.RUnlock()
 	if val, ok := md.db[key]; ok {
 		return val, nil
 	}
-	return "", errors.New("key not found")
+	return "", database.ErrKeyNotFound
 }
 
 func (md MockDatabase) Delete(key string) error {
+	md.mu.Lock()
+	defer md.mu.Unlock()
 	delete(md.db, key)
 	return nil
 }
 
+func (md MockDatabase) Scan(f func(key string) bool) error {
+	md.mu.RLock()
+	keys := make([]string, 0, len(md.db))
+	for key := range md.db {
+		keys = append(keys, key)
+	}
+	md.mu.RUnlock()
+	for _, key := range keys {
+		if !f(key) {
+			return nil
+		}
+	}
+	return nil
+}
+
 func (md MockDatabase) Close() error {
 	return nil
 }
@@ -54,3 +78,33 @@ func (md MockDatabase) Latency() time.Duration {
 
 func (md MockDatabase) SetLatency(latency time.Duration) {
 }
+
+// FailingDatabase fails every operation as an unavailable store would do
+type FailingDatabase struct{}
+
+func NewFailingDatabase() database.Database {
+	return FailingDatabase{}
+}
+
+func (fd FailingDatabase) Put(key, value string) (string, error) {
+	return "", errors.New("store unavailable")
+}
+
+func (fd FailingDatabase) Get(key string) (string, error) {
+	return "", errors.New("store unavailable")
+}
+
+func (fd FailingDatabase) Delete(key string) error {
+	return errors.New("store unavailable")
+}
+
+func (fd FailingDatabase) Close() error {
+	return nil
+}
+
+func (fd FailingDatabase) Latency() time.Duration {
+	return 0
+}
+
+func (fd FailingDatabase) SetLatency(latency time.Duration) {
+}
@@ -0,0 +1,317 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/monitor"
+	"github.com/regionless-storage-service/pkg/network/latency"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+func newConfig() *config.KVConfiguration {
+	return &config.KVConfiguration{
+		StoreType:                             constants.DummyLatency,
+		ConsistentHash:                        constants.Rendezvous,
+		RemoteStoreLatencyThresholdInMilliSec: 100,
+		Stores: []config.KVStore{
+			{AvailabilityZone: "az1", Name: "local1", ArtificialLatencyInMs: 1},
+			{AvailabilityZone: "az2", Name: "local2", ArtificialLatencyInMs: 2},
+			{AvailabilityZone: "az3", Name: "remote1", ArtificialLatencyInMs: 200},
+		},
+	}
+}
+
+func names(nodes []consistent.Node) map[string]bool {
+	result := make(map[string]bool)
+	for _, node := range nodes {
+		result[node.String()] = true
+	}
+	return result
+}
+
+func TestReclassification(t *testing.T) {
+	conf := newConfig()
+	localStores, remoteStores, err := conf.GetReplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hm := consistent.NewSyncAsyncHashingManager(conf.ConsistentHash, localStores, 2, remoteStores, 1)
+	lm := monitor.NewLatencyMonitor(conf, hm, localStores, remoteStores, 0, 1)
+	key := []byte("k1")
+
+	async, _ := hm.GetAsyncNodes(key)
+	if !names(async)["remote1"] {
+		t.Fatalf("expected remote1 to be remote at startup, got %v", async)
+	}
+
+	// local2 degrades well beyond the threshold and remote1 recovers
+	conf.Stores[1].ArtificialLatencyInMs = 300
+	conf.Stores[2].ArtificialLatencyInMs = 1
+	lm.Probe()
+
+	sync, _ := hm.GetSyncNodes(key)
+	async, _ = hm.GetAsyncNodes(key)
+	if !names(sync)["remote1"] || names(sync)["local2"] {
+		t.Errorf("expected remote1 to be local and local2 not, got sync nodes %v", sync)
+	}
+	if !names(async)["local2"] {
+		t.Errorf("expected local2 to be remote, got async nodes %v", async)
+	}
+	for _, s := range lm.Snapshot() {
+		if s.Name == "local2" && (!s.Remote || s.Mean != 300*time.Millisecond) {
+			t.Errorf("unexpected stats of local2: %+v", s)
+		}
+	}
+}
+
+func TestHysteresis(t *testing.T) {
+	conf := newConfig()
+	localStores, remoteStores, err := conf.GetReplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hm := consistent.NewSyncAsyncHashingManager(conf.ConsistentHash, localStores, 2, remoteStores, 1)
+	lm := monitor.NewLatencyMonitor(conf, hm, localStores, remoteStores, 0, 1)
+
+	// slightly above the threshold is not enough to become remote
+	conf.Stores[0].ArtificialLatencyInMs = 105
+	lm.Probe()
+	if remote(lm, "local1") {
+		t.Errorf("expected local1 to stay local within the hysteresis")
+	}
+	conf.Stores[0].ArtificialLatencyInMs = 150
+	lm.Probe()
+	if !remote(lm, "local1") {
+		t.Errorf("expected local1 to become remote")
+	}
+	// slightly below the threshold is not enough to become local again
+	conf.Stores[0].ArtificialLatencyInMs = 95
+	lm.Probe()
+	if !remote(lm, "local1") {
+		t.Errorf("expected local1 to stay remote within the hysteresis")
+	}
+	conf.Stores[0].ArtificialLatencyInMs = 10
+	lm.Probe()
+	if remote(lm, "local1") {
+		t.Errorf("expected local1 to become local again")
+	}
+}
+
+func TestRollingWindow(t *testing.T) {
+	conf := newConfig()
+	localStores, remoteStores, err := conf.GetReplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hm := consistent.NewSyncAsyncHashingManager(conf.ConsistentHash, localStores, 2, remoteStores, 1)
+	lm := monitor.NewLatencyMonitor(conf, hm, localStores, remoteStores, 0, 3)
+
+	// a single spike is averaged out by the window
+	conf.Stores[0].ArtificialLatencyInMs = 200
+	lm.Probe()
+	if remote(lm, "local1") {
+		t.Errorf("expected a single spike not to make local1 remote")
+	}
+	lm.Probe()
+	if !remote(lm, "local1") {
+		t.Errorf("expected a sustained latency to make local1 remote")
+	}
+}
+
+func remote(lm *monitor.LatencyMonitor, name string) bool {
+	for _, s := range lm.Snapshot() {
+		if s.Name == name {
+			return s.Remote
+		}
+	}
+	return false
+}
+
+func TestUnhealthyStore(t *testing.T) {
+	var mu sync.Mutex
+	down := map[string]bool{}
+	latency.RegisterProbe("flaky", func(target latency.Target) (latency.Probe, error) {
+		return latency.ProbeFunc(func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if down[target.Name] {
+				return errors.New("connection refused")
+			}
+			return nil
+		}), nil
+	})
+	setDown := func(name string, d bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		down[name] = d
+	}
+
+	conf := newConfig()
+	conf.LatencyProbe = "flaky"
+	conf.UnhealthyAfterFailures = 2
+	localStores, remoteStores, err := conf.GetReplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hm := consistent.NewSyncAsyncHashingManager(conf.ConsistentHash, localStores, 2, remoteStores, 0)
+	lm := monitor.NewLatencyMonitor(conf, hm, localStores, remoteStores, 0, 1)
+	recovered := make([]string, 0)
+	failRecovery := true
+	lm.OnRecovery(func(name string) error {
+		recovered = append(recovered, name)
+		if failRecovery {
+			failRecovery = false
+			return errors.New("another store migration is in progress")
+		}
+		return nil
+	})
+	placedOnLocal1 := func() int {
+		count := 0
+		for i := 0; i < 100; i++ {
+			nodes, err := hm.GetSyncNodes([]byte(fmt.Sprintf("k%d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names(nodes)["local1"] {
+				count++
+			}
+		}
+		return count
+	}
+	if placedOnLocal1() == 0 {
+		t.Fatalf("expected keys placed on local1")
+	}
+
+	setDown("local1", true)
+	lm.Probe()
+	if !healthy(lm, "local1") || placedOnLocal1() == 0 {
+		t.Fatalf("expected local1 to stay healthy after a single failure")
+	}
+	lm.Probe()
+	if healthy(lm, "local1") {
+		t.Fatalf("expected local1 to be unhealthy after 2 failures")
+	}
+	if n := placedOnLocal1(); n != 0 {
+		t.Errorf("expected no key placed on the unhealthy local1, got %d", n)
+	}
+
+	setDown("local1", false)
+	lm.Probe()
+	if !healthy(lm, "local1") || placedOnLocal1() == 0 {
+		t.Fatalf("expected local1 to be back in the placement once healthy")
+	}
+	// the failed recovery is retried after the next probe, and only once it succeeds
+	lm.WaitRecovery()
+	lm.Probe()
+	lm.WaitRecovery()
+	lm.Probe()
+	lm.WaitRecovery()
+	if fmt.Sprint(recovered) != "[local1 local1]" {
+		t.Errorf("unexpected recovery calls %v", recovered)
+	}
+}
+
+func healthy(lm *monitor.LatencyMonitor, name string) bool {
+	for _, s := range lm.Snapshot() {
+		if s.Name == name {
+			return s.Healthy
+		}
+	}
+	return false
+}
+
+func TestCircuitOpenStore(t *testing.T) {
+	conf := newConfig()
+	localStores, remoteStores, err := conf.GetReplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hm := consistent.NewSyncAsyncHashingManager(conf.ConsistentHash, localStores, 1, remoteStores, 1)
+	lm := monitor.NewLatencyMonitor(conf, hm, localStores, remoteStores, 0, 1)
+	recovered := make([]string, 0)
+	lm.OnRecovery(func(name string) error {
+		recovered = append(recovered, name)
+		return nil
+	})
+
+	lm.SetCircuitOpen("local2", true)
+	for i := 0; i < 100; i++ {
+		nodes, err := hm.GetSyncNodes([]byte(fmt.Sprintf("k%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names(nodes)["local2"] {
+			t.Fatalf("expected no key placed on local2 while its circuit is open, got %v", nodes)
+		}
+	}
+	lm.SetCircuitOpen("local2", false)
+	lm.Probe()
+	lm.WaitRecovery()
+	if fmt.Sprint(recovered) != "[local2]" {
+		t.Errorf("expected local2 to recover once its circuit closes, got %v", recovered)
+	}
+}
+
+func TestRecoveryInBackground(t *testing.T) {
+	var mu sync.Mutex
+	down := false
+	latency.RegisterProbe("local2-down", func(target latency.Target) (latency.Probe, error) {
+		return latency.ProbeFunc(func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if down && target.Name == "local2" {
+				return errors.New("connection refused")
+			}
+			return nil
+		}), nil
+	})
+	setDown := func(d bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		down = d
+	}
+
+	conf := newConfig()
+	conf.LatencyProbe = "local2-down"
+	conf.UnhealthyAfterFailures = 1
+	localStores, remoteStores, err := conf.GetReplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hm := consistent.NewSyncAsyncHashingManager(conf.ConsistentHash, localStores, 1, remoteStores, 1)
+	lm := monitor.NewLatencyMonitor(conf, hm, localStores, remoteStores, 0, 1)
+	release := make(chan struct{})
+	calls := 0
+	lm.OnRecovery(func(name string) error {
+		calls++
+		<-release
+		return nil
+	})
+
+	setDown(true)
+	lm.Probe()
+	setDown(false)
+	// the probes go on while the hook is held, without calling it again
+	probed := make(chan struct{})
+	go func() {
+		lm.Probe()
+		lm.Probe()
+		close(probed)
+	}()
+	select {
+	case <-probed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the probes are not expected to wait for the recovery hook")
+	}
+	close(release)
+	lm.WaitRecovery()
+	if calls != 1 {
+		t.Errorf("expected the recovery hook to be called once, got %d", calls)
+	}
+}
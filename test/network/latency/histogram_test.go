@@ -0,0 +1,69 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/network/latency"
+)
+
+func TestNewHistogram(t *testing.T) {
+	if _, err := latency.NewHistogram(0); err == nil {
+		t.Fatalf("error is expected for 0 significant digits")
+	}
+	if _, err := latency.NewHistogram(6); err == nil {
+		t.Fatalf("error is expected for 6 significant digits")
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	h, err := latency.NewHistogram(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1us .. 1s, where the tail is 1000 times the bulk
+	for i := int64(1); i <= 1000; i++ {
+		h.Record(i * 1000)
+	}
+	h.Record(1000000000)
+	if h.Count() != 1001 {
+		t.Fatalf("unexpected count %d", h.Count())
+	}
+	if h.Min() != 1000 || h.Max() != 1000000000 {
+		t.Fatalf("unexpected min %d or max %d", h.Min(), h.Max())
+	}
+	for q, expected := range map[float64]int64{0.5: 501000, 0.9: 901000, 0.99: 991000, 1: 1000000000} {
+		v := h.ValueAtQuantile(q)
+		// 3 significant digits
+		if diff := v - expected; diff < -expected/1000 || diff > expected/1000 {
+			t.Errorf("unexpected value %d at %f, expected %d", v, q, expected)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	h1, _ := latency.NewHistogram(2)
+	h2, _ := latency.NewHistogram(2)
+	h1.Record(10)
+	h2.Record(5000)
+	h2.Record(7000)
+	if err := h1.Merge(h2); err != nil {
+		t.Fatal(err)
+	}
+	if h1.Count() != 3 || h1.Min() != 10 || h1.Max() != 7000 {
+		t.Fatalf("unexpected merged histogram count %d, min %d, max %d", h1.Count(), h1.Min(), h1.Max())
+	}
+	var total int64
+	for _, b := range h1.Buckets() {
+		if b.From > b.To {
+			t.Errorf("unexpected bucket %+v", b)
+		}
+		total += b.Count
+	}
+	if total != 3 {
+		t.Fatalf("unexpected total of the buckets %d", total)
+	}
+	h3, _ := latency.NewHistogram(3)
+	if err := h1.Merge(h3); err == nil {
+		t.Fatalf("error is expected for different precisions")
+	}
+}
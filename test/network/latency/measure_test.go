@@ -19,14 +19,14 @@ func TestAppend(t *testing.T) {
 	}
 }
 
-func TestAllSeconds(t *testing.T) {
+func TestAllNanoseconds(t *testing.T) {
 	var measurements latency.Measurements
 
 	measurements.Append(latency.Sample{Success: true, Duration: 1 * time.Nanosecond})
 	measurements.Append(latency.Sample{Success: false, Duration: 2 * time.Nanosecond})
 
-	if len(measurements.AllSeconds()) != 2 {
-		t.Fatalf("There are 2 latencies as expected. %d latencies instead", len(measurements.AllSeconds()))
+	if len(measurements.AllNanoseconds()) != 2 {
+		t.Fatalf("There are 2 latencies as expected. %d latencies instead", len(measurements.AllNanoseconds()))
 	}
 }
 
@@ -45,21 +45,21 @@ func TestInsuccessCount(t *testing.T) {
 	}
 }
 
-func TestSuccessSeconds(t *testing.T) {
+func TestSuccessNanoseconds(t *testing.T) {
 	var measurements latency.Measurements
 
 	measurements.Append(latency.Sample{Success: true, Duration: 1 * time.Nanosecond})
 	measurements.Append(latency.Sample{Success: false, Duration: 2 * time.Nanosecond})
 
-	if len(measurements.SuccessSeconds()) != 1 {
-		t.Fatalf("There are 1 success latency as expected. %d latencies instead", len(measurements.AllSeconds()))
+	if len(measurements.SuccessNanoseconds()) != 1 {
+		t.Fatalf("There are 1 success latency as expected. %d latencies instead", len(measurements.AllNanoseconds()))
 	}
 	measurements.Append(latency.Sample{Success: false, Duration: 3 * time.Nanosecond})
-	if len(measurements.SuccessSeconds()) != 1 {
-		t.Fatalf("There are 1 success latency as expected. %d latencies instead", len(measurements.AllSeconds()))
+	if len(measurements.SuccessNanoseconds()) != 1 {
+		t.Fatalf("There are 1 success latency as expected. %d latencies instead", len(measurements.AllNanoseconds()))
 	}
 	measurements.Append(latency.Sample{Success: true, Duration: 4 * time.Nanosecond})
-	if len(measurements.SuccessSeconds()) != 2 {
-		t.Fatalf("There are 2 success latencies as expected. %d latencies instead", len(measurements.AllSeconds()))
+	if len(measurements.SuccessNanoseconds()) != 2 {
+		t.Fatalf("There are 2 success latencies as expected. %d latencies instead", len(measurements.AllNanoseconds()))
 	}
 }
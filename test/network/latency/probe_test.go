@@ -0,0 +1,93 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/network/latency"
+)
+
+func TestTCPProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr := l.Addr().(*net.TCPAddr)
+
+	probe, err := latency.NewProbe(constants.ProbeTCP, latency.Target{Host: "127.0.0.1", Port: addr.Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := latency.Measure(probe, 5)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(out.Measurements) != 5 || out.Summary.LossRate != 0 {
+		t.Fatalf("unexpected measurements %v", out.Measurements)
+	}
+	if out.Summary.Success.Average <= 0 {
+		t.Fatalf("the connection time is expected to be measured, got %d", out.Summary.Success.Average)
+	}
+
+	l.Close()
+	if _, err := latency.Measure(probe, 2); err == nil {
+		t.Fatalf("error is expected once the listener is closed")
+	}
+}
+
+func TestMeasureOneProbeAtATime(t *testing.T) {
+	var inFlight, maxInFlight int32
+	probe := latency.ProbeFunc(func() error {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		if n > atomic.LoadInt32(&maxInFlight) {
+			atomic.StoreInt32(&maxInFlight, n)
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	out, err := latency.Measure(probe, 10)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(out.Measurements) != 10 || maxInFlight != 1 {
+		t.Fatalf("the 10 probes are expected to run one at a time, %d ran concurrently", maxInFlight)
+	}
+}
+
+func TestUnknownProbe(t *testing.T) {
+	if _, err := latency.NewProbe("icmp", latency.Target{}); err == nil {
+		t.Fatalf("error is expected for an unknown probe type")
+	}
+}
+
+func TestRegisterProbe(t *testing.T) {
+	var calls int32
+	latency.RegisterProbe("flaky", func(target latency.Target) (latency.Probe, error) {
+		return latency.ProbeFunc(func() error {
+			if atomic.AddInt32(&calls, 1)%2 == 0 {
+				return errors.New("lost")
+			}
+			return nil
+		}), nil
+	})
+	probe, err := latency.NewProbe("flaky", latency.Target{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := latency.Measure(probe, 4)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if out.Summary.LossRate != 0.5 {
+		t.Fatalf("unexpected loss rate %f", out.Summary.LossRate)
+	}
+	if latency.DefaultProbeType(constants.Redis) != constants.ProbePing || latency.DefaultProbeType(constants.Memory) != constants.ProbeNoop {
+		t.Fatalf("unexpected default probe types")
+	}
+}
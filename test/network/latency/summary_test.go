@@ -35,12 +35,81 @@ func TestSummary(t *testing.T) {
 func TestSummaryError(t *testing.T) {
 	var measurements latency.Measurements
 
-	measurements.Append(latency.Sample{Success: true, Duration: 100 * time.Nanosecond})
 	measurements.Append(latency.Sample{Success: false, Duration: 200 * time.Nanosecond})
 	measurements.Append(latency.Sample{Success: false, Duration: 200 * time.Nanosecond})
 
-	_, err := measurements.Summary()
+	summary, err := measurements.Summary()
 	if err == nil {
 		t.Fatalf("error is expected since the insuccessful count is %d", measurements.InsuccessCount())
 	}
+	if summary.LossRate != 1 {
+		t.Fatalf("unexpected loss rate %f", summary.LossRate)
+	}
+}
+
+func TestSummaryLossRate(t *testing.T) {
+	var measurements latency.Measurements
+
+	measurements.Append(latency.Sample{Success: true, Duration: 100 * time.Nanosecond})
+	measurements.Append(latency.Sample{Success: false, Duration: 200 * time.Nanosecond})
+	measurements.Append(latency.Sample{Success: false, Duration: 200 * time.Nanosecond})
+	measurements.Append(latency.Sample{Success: true, Duration: 300 * time.Nanosecond})
+
+	summary, err := measurements.Summary()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if summary.LossRate != 0.5 {
+		t.Fatalf("unexpected loss rate %f", summary.LossRate)
+	}
+	if summary.Success.Average != 200 {
+		t.Fatalf("unexpected success average %d", summary.Success.Average)
+	}
+}
+
+func TestSummaryDistribution(t *testing.T) {
+	var measurements latency.Measurements
+
+	for i := 1; i <= 100; i++ {
+		measurements.Append(latency.Sample{Success: true, Duration: time.Duration(i) * time.Millisecond})
+	}
+	summary, err := measurements.Summary()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	success := summary.Success
+	expected := map[string][2]int64{
+		"min":    {success.Min, int64(time.Millisecond)},
+		"max":    {success.Max, int64(100 * time.Millisecond)},
+		"p50":    {success.P50, int64(50 * time.Millisecond)},
+		"p90":    {success.P90, int64(90 * time.Millisecond)},
+		"p99":    {success.P99, int64(99 * time.Millisecond)},
+		"jitter": {success.Jitter, int64(time.Millisecond)},
+		// the standard deviation of 1..100 is sqrt((100^2-1)/12)
+		"stddev": {success.StdDev / int64(time.Microsecond), 28866},
+	}
+	for name, v := range expected {
+		if v[0] != v[1] {
+			t.Errorf("unexpected %s %d, expected %d", name, v[0], v[1])
+		}
+	}
+	if summary.Histogram.Count() != 100 {
+		t.Errorf("unexpected histogram count %d", summary.Histogram.Count())
+	}
+}
+
+func TestSummaryJitterOfTheSuccessfulSamples(t *testing.T) {
+	var measurements latency.Measurements
+	// a timed out connection between the successful ones
+	measurements.Append(latency.Sample{Success: true, Duration: 10 * time.Millisecond})
+	measurements.Append(latency.Sample{Success: true, Duration: 20 * time.Millisecond})
+	measurements.Append(latency.Sample{Success: false, Duration: time.Second})
+	measurements.Append(latency.Sample{Success: true, Duration: 30 * time.Millisecond})
+	summary, err := measurements.Summary()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if summary.Success.Jitter != int64(10*time.Millisecond) {
+		t.Fatalf("the jitter of 10ms, 20ms and 30ms is expected to be 10ms, got %v", time.Duration(summary.Success.Jitter))
+	}
 }
@@ -0,0 +1,157 @@
+package orphan
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/orphan"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/test/mock"
+)
+
+type cluster struct {
+	indexTree index.Index
+	dbs       map[string]database.Database
+}
+
+// newCluster indexes the revisions 1 to n written to s1 and s2, the first store being guarded
+func newCluster(t *testing.T, n int) *cluster {
+	c := &cluster{indexTree: index.NewTreeIndex(), dbs: map[string]database.Database{
+		"s1": database.NewGuardedDatabase("s1", mock.NewMockDatabase(), database.GuardOptions{}),
+		"s2": mock.NewMockDatabase(),
+	}}
+	p := consistent.NamedPlacement([]string{"s1", "s2"}, nil)
+	for i := 1; i <= n; i++ {
+		rev := index.NewRevision(c.indexTree.Placements(), int64(i), 0, p)
+		c.write(rev, "s1", "s2")
+		if err := c.indexTree.Put(context.Background(), []byte(fmt.Sprintf("k%d", i%3)), rev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func (c *cluster) write(rev index.Revision, nodes ...string) {
+	for _, name := range nodes {
+		c.dbs[name].Put(rev.String(), "v"+rev.String())
+	}
+}
+
+func (c *cluster) stores() []string {
+	return []string{"s1", "s2"}
+}
+
+func (c *cluster) resolve(name string) (database.Database, error) {
+	return c.dbs[name], nil
+}
+
+func (c *cluster) holds(name string, main int64) bool {
+	_, err := c.dbs[name].Get(index.NewRevision(nil, main, 0, consistent.Placement{}).String())
+	return err == nil
+}
+
+func TestCollectOrphans(t *testing.T) {
+	c := newCluster(t, 10)
+	// the revisions 11 and 12 failed to be indexed, and a probe key is not a revision
+	c.write(index.NewRevision(nil, 11, 0, consistent.Placement{}), "s1", "s2")
+	c.write(index.NewRevision(nil, 12, 0, consistent.Placement{}), "s2")
+	c.dbs["s1"].Put("__rkv_latency_probe__", "s1")
+
+	oc := orphan.NewCollector(c.indexTree, c.stores, c.resolve, orphan.Options{GracePeriod: 50 * time.Millisecond})
+	status, err := oc.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the orphans are only deleted once the grace period is over
+	if status.Stores != 2 || status.Scanned != 23 || status.Pending != 3 || status.Deleted != 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// the revision 12 gets indexed meanwhile, as a slow write would
+	if err := c.indexTree.Put(context.Background(), []byte("k0"), index.NewRevision(c.indexTree.Placements(), 12, 0, consistent.NamedPlacement([]string{"s2"}, nil))); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	status, err = oc.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Deleted != 2 || status.Pending != 0 || status.Failed != 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if c.holds("s1", 11) || c.holds("s2", 11) {
+		t.Errorf("the orphaned revision 11 is expected to be deleted")
+	}
+	if !c.holds("s2", 12) {
+		t.Errorf("the revision 12 indexed within the grace period is expected to be kept")
+	}
+	for i := int64(1); i <= 10; i++ {
+		if !c.holds("s1", i) || !c.holds("s2", i) {
+			t.Errorf("the indexed revision %d is expected to be kept", i)
+		}
+	}
+	if _, err := c.dbs["s1"].Get("__rkv_latency_probe__"); err != nil {
+		t.Errorf("the probe key is expected to be kept, got %v", err)
+	}
+}
+
+func TestCollectNewOrphansAfterGracePeriod(t *testing.T) {
+	c := newCluster(t, 3)
+	oc := orphan.NewCollector(c.indexTree, c.stores, c.resolve, orphan.Options{GracePeriod: 50 * time.Millisecond})
+	if _, err := oc.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// an orphan first found by a round is kept however long ago it was written
+	time.Sleep(60 * time.Millisecond)
+	c.write(index.NewRevision(nil, 4, 0, consistent.Placement{}), "s1")
+	status, err := oc.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Deleted != 0 || status.Pending != 1 || !c.holds("s1", 4) {
+		t.Fatalf("a new orphan is expected to wait for the grace period: %+v", status)
+	}
+}
+
+func TestSkipStoresWithoutScan(t *testing.T) {
+	c := newCluster(t, 3)
+	c.dbs["s3"] = mock.NewFailingDatabase()
+	oc := orphan.NewCollector(c.indexTree, func() []string {
+		return []string{"s1", "s2", "s3"}
+	}, c.resolve, orphan.Options{})
+	status, err := oc.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Stores != 2 || status.Failed != 1 || status.LastError == "" {
+		t.Errorf("the store unable to scan is expected to be skipped: %+v", status)
+	}
+}
+
+func TestCollectValuesOutsideThePlacement(t *testing.T) {
+	c := newCluster(t, 3)
+	// the revision 4 is placed on s1, and its value was also left on s2, as by a move from s2 or a handoff
+	c.write(index.NewRevision(nil, 4, 0, consistent.Placement{}), "s1", "s2")
+	if err := c.indexTree.Put(context.Background(), []byte("k4"), index.NewRevision(c.indexTree.Placements(), 4, 0, consistent.NamedPlacement([]string{"s1"}, nil))); err != nil {
+		t.Fatal(err)
+	}
+	oc := orphan.NewCollector(c.indexTree, c.stores, c.resolve, orphan.Options{GracePeriod: 50 * time.Millisecond})
+	if _, err := oc.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	status, err := oc.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Deleted != 1 || c.holds("s2", 4) {
+		t.Fatalf("the value left outside the placement is expected to be deleted: %+v", status)
+	}
+	if !c.holds("s1", 4) {
+		t.Fatal("the value on the placement is expected to be kept")
+	}
+}
@@ -0,0 +1,85 @@
+package consistent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+var hashingTypes = []constants.ConsistentHashingType{constants.Ring, constants.Rendezvous, constants.Jump, constants.Maglev}
+
+func newHashing(hashingType constants.ConsistentHashingType, nodes int) consistent.ConsistentHashing {
+	h := consistent.Factory(hashingType)
+	for i := 0; i < nodes; i++ {
+		h.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	return h
+}
+
+func benchmarkLocate(b *testing.B, replicas int) {
+	for _, hashingType := range hashingTypes {
+		for _, nodes := range []int{10, 100, 1000} {
+			b.Run(fmt.Sprintf("%s/%d", hashingType, nodes), func(b *testing.B) {
+				h := newHashing(hashingType, nodes)
+				keys := make([][]byte, 1024)
+				for i := range keys {
+					keys[i] = []byte(fmt.Sprintf("key-%d", i))
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					h.LocateNodes(keys[i%len(keys)], replicas)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkLocateKey(b *testing.B) {
+	benchmarkLocate(b, 1)
+}
+
+func BenchmarkLocateThreeNodes(b *testing.B) {
+	benchmarkLocate(b, 3)
+}
+
+// BenchmarkBalance reports how much more keys than the mean the busiest node gets, and how many keys move
+// when a node is added, per the ideal 1/(n+1)
+func BenchmarkBalance(b *testing.B) {
+	const keys = 100000
+	for _, hashingType := range hashingTypes {
+		for _, nodes := range []int{10, 100} {
+			b.Run(fmt.Sprintf("%s/%d", hashingType, nodes), func(b *testing.B) {
+				var peak, movement float64
+				for n := 0; n < b.N; n++ {
+					h := newHashing(hashingType, nodes)
+					owners := make([]string, keys)
+					counts := make(map[string]int)
+					for i := range owners {
+						owners[i] = h.LocateKey([]byte(fmt.Sprintf("key-%d", i))).String()
+						counts[owners[i]]++
+					}
+					max := 0
+					for _, c := range counts {
+						if c > max {
+							max = c
+						}
+					}
+					peak = float64(max) / (float64(keys) / float64(nodes))
+
+					h.AddNode(testNode("new-node"))
+					moved := 0
+					for i, owner := range owners {
+						if h.LocateKey([]byte(fmt.Sprintf("key-%d", i))).String() != owner {
+							moved++
+						}
+					}
+					movement = float64(moved) / keys * float64(nodes+1)
+				}
+				b.ReportMetric(peak, "peak/mean")
+				b.ReportMetric(movement, "moved/ideal")
+			})
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package consistent
 
 import (
-	"strings"
+	"fmt"
 	"testing"
 
 	"github.com/regionless-storage-service/pkg/constants"
@@ -144,20 +144,30 @@ func TestGetSortedSyncStores(t *testing.T) {
 	if len(r) != 1 {
 		t.Fatalf("Remote node size shouldn't be %d", len(r))
 	}
-	nodes, err := h.GetNodes([]byte("1"))
+	p, err := h.GetPlacement([]byte("1"))
 	if err != nil {
 		t.Fatalf("Get unexpected error %v", err)
 	}
-	if len(nodes) != 2 {
-		t.Fatalf("Node size shouldn't be %d", len(nodes))
+	if len(p.Sync) != len(l) || len(p.Async) != 1 {
+		t.Fatalf("unexpected placement %v", p)
 	}
-	if nodes[1] != r[0].String() {
-		t.Fatalf("Remote node shouldn't be %s", nodes[1])
+	if p.Async[0].Node != r[0].String() || p.Async[0].Role != consistent.RoleAsync {
+		t.Fatalf("Remote node shouldn't be %+v", p.Async[0])
 	}
-	sortedSyncNodes := strings.Split(nodes[0], ",")
-	for i := 0; i < len(l); i++ {
-		if sortedSyncNodes[i] != l[i].String() {
-			t.Fatalf("stored node shouldn't be %s", sortedSyncNodes[i])
+	for i, replica := range p.Sync {
+		if replica.Node != l[i].String() {
+			t.Fatalf("stored node shouldn't be %s", replica.Node)
+		}
+		role := consistent.RoleFollower
+		if i == 0 {
+			role = consistent.RoleLeader
+		}
+		if replica.Role != role {
+			t.Fatalf("the sync replica %d is expected to be a %s, got %s", i, role, replica.Role)
+		}
+		// the sync replicas are one per zone, and know their zone
+		if replica.AvailabilityZone == "" || replica.Region != replica.AvailabilityZone.Region() {
+			t.Fatalf("the sync replica %+v is not located", replica)
 		}
 	}
 }
@@ -187,15 +197,15 @@ func TestNewSyncHashingManager(t *testing.T) {
 	if r != nil {
 		t.Fatalf("Remote nodes should be nil: %v", r)
 	}
-	n, err := h.GetNodes([]byte("1"))
+	p, err := h.GetPlacement([]byte("1"))
 	if err != nil {
 		t.Fatalf("Get unexpected error %v", err)
 	}
-	if len(n) != 1 {
-		t.Fatalf("Local nodes shouldn't be %d", len(n))
+	if len(p.Async) != 0 {
+		t.Fatalf("Remote nodes should be empty: %v", p)
 	}
-	if n[0] != l[0].String() {
-		t.Fatalf("Local nodes shouldn't be %d", len(n))
+	if fmt.Sprint(p.SyncNodes()) != fmt.Sprint([]string{l[0].String()}) {
+		t.Fatalf("Local nodes shouldn't be %v", p)
 	}
 }
 
@@ -218,14 +228,14 @@ func TestNewSyncHashingManagerGetMutipleNodes(t *testing.T) {
 		t.Fatalf("Local nodes shouldn't be %d", len(l))
 	}
 
-	n, err := h.GetNodes([]byte("1"))
+	p, err := h.GetPlacement([]byte("1"))
 	if err != nil {
 		t.Fatalf("Get unexpected error %v", err)
 	}
-	if len(n) != 1 {
-		t.Fatalf("Local nodes shouldn't be %d", len(n))
+	if fmt.Sprint(p.Nodes()) != fmt.Sprint([]string{l[0].String(), l[1].String()}) {
+		t.Fatalf("Local nodes shouldn't be %v", p)
 	}
-	if n[0] != l[0].String()+","+l[1].String() {
-		t.Fatalf("Local nodes shouldn't be %d", len(n))
+	if p.Sync[0].Role != consistent.RoleLeader || p.Sync[1].Role != consistent.RoleFollower {
+		t.Fatalf("unexpected roles %+v", p.Sync)
 	}
 }
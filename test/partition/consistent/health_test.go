@@ -0,0 +1,145 @@
+package consistent
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+func containsNode(nodes []string, name string) bool {
+	for _, n := range nodes {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUnhealthyStoreSubstitute(t *testing.T) {
+	localStores, remoteStores := regionalStores()
+	h := consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 3, remoteStores, 1)
+	home := placements(t, h)
+
+	h.SetUnhealthyStores([]string{"w1a-1", "e2a-1"})
+	substituted := 0
+	for i := 0; i < policyKeys; i++ {
+		p, err := h.GetPlacement([]byte(fmt.Sprintf("key-%d", i)))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if len(p.Sync) != 3 || len(p.Async) != 1 {
+			t.Fatalf("the key %d is expected to keep 3 sync and 1 async replicas, got %v", i, p)
+		}
+		if containsNode(p.Nodes(), "w1a-1") || containsNode(p.Nodes(), "e2a-1") {
+			t.Fatalf("the key %d is placed on an unhealthy store: %v", i, p)
+		}
+		for _, unhealthy := range []string{"w1a-1", "e2a-1"} {
+			if !containsNode(home[i], unhealthy) {
+				continue
+			}
+			substituted++
+			hinted := false
+			for _, r := range p.Hinted() {
+				hinted = hinted || r.HintFor == unhealthy
+			}
+			if !hinted {
+				t.Errorf("the key %d is expected to have a replica hinted for %s, got %v", i, unhealthy, p.Replicas())
+			}
+		}
+		if !containsNode(home[i], "w1a-1") && !containsNode(home[i], "e2a-1") && fmt.Sprint(p.Nodes()) != fmt.Sprint(home[i]) {
+			t.Errorf("the key %d does not use an unhealthy store but moved from %v to %v", i, home[i], p.Nodes())
+		}
+	}
+	if substituted == 0 {
+		t.Fatalf("no key is placed on the unhealthy stores")
+	}
+
+	// once the stores recover, the keys go back home without hints
+	h.SetUnhealthyStores(nil)
+	for i, nodes := range placements(t, h) {
+		if fmt.Sprint(nodes) != fmt.Sprint(home[i]) {
+			t.Errorf("the key %d is expected back on %v, got %v", i, home[i], nodes)
+		}
+	}
+}
+
+func TestZoneOutage(t *testing.T) {
+	localStores, remoteStores := regionalStores()
+	// a sync replica in each of the 4 zones
+	h := consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 4, remoteStores, 1)
+	h.SetUnhealthyStores([]string{"w1a-1", "w1a-2"})
+	for i := 0; i < policyKeys; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		p, err := h.GetPlacement(key)
+		if err != nil {
+			t.Fatalf("the writes are expected to keep succeeding during a zone outage, got %v", err)
+		}
+		if len(p.Sync) != 4 {
+			t.Fatalf("the key %d is expected to keep 4 sync replicas, got %v", i, p)
+		}
+		zones := make(map[string]int)
+		for _, r := range p.Sync {
+			if r.AvailabilityZone == constants.US_WEST_1A {
+				t.Fatalf("the key %d is placed in the zone out: %v", i, p)
+			}
+			zones[string(r.AvailabilityZone)]++
+		}
+		if len(zones) != 3 || len(p.Hinted()) != 1 {
+			t.Errorf("the key %d is expected on the 3 zones left with a single hinted replica, got %v", i, p.Replicas())
+		}
+		syncNodes, err := h.GetSyncNodes(key)
+		if err != nil || len(syncNodes) != 4 {
+			t.Errorf("unexpected sync nodes %v of the key %d: %v", syncNodes, i, err)
+		}
+	}
+}
+
+func TestSyncHashingUnhealthyStore(t *testing.T) {
+	nodes := []consistent.RkvNode{{Name: "s1"}, {Name: "s2"}, {Name: "s3"}, {Name: "s4"}}
+	h := consistent.NewSyncHashingManager(constants.Rendezvous, nodes, 2)
+	h.SetUnhealthyStores([]string{"s1"})
+	for i := 0; i < policyKeys; i++ {
+		p, err := h.GetPlacement([]byte(fmt.Sprintf("key-%d", i)))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if len(p.Sync) != 2 || containsNode(p.Nodes(), "s1") {
+			t.Fatalf("the key %d is expected on 2 healthy stores, got %v", i, p)
+		}
+		if hinted := p.Hinted(); len(hinted) > 0 && hinted[0].HintFor != "s1" {
+			t.Errorf("unexpected hint %+v of the key %d", hinted[0], i)
+		}
+	}
+}
+
+func TestHealthyPlacementOneReplicaPerZone(t *testing.T) {
+	localStores, remoteStores := regionalStores()
+	h := consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 3, remoteStores, 1)
+	h.SetPlacementPolicy(consistent.PlacementPolicy{ForbiddenRegions: []constants.Region{constants.US_EAST_1}})
+	for i := 0; i < policyKeys; i++ {
+		p, err := h.GetPlacement([]byte(fmt.Sprintf("key-%d", i)))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		zones := make(map[constants.AvailabilityZone]bool)
+		for _, r := range p.Sync {
+			if zones[r.AvailabilityZone] {
+				t.Fatalf("the sync replicas of the key %d share a zone while all the stores are healthy: %v", i, p)
+			}
+			zones[r.AvailabilityZone] = true
+		}
+		if len(p.Sync) != 3 || len(p.Hinted()) != 0 {
+			t.Fatalf("the key %d is expected on 3 zones without hints, got %v", i, p.Replicas())
+		}
+	}
+
+	// the 3 zones left cannot hold a sync replica each, and the stores are not doubled up in one of them
+	h = consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 4, remoteStores, 1)
+	h.SetPlacementPolicy(consistent.PlacementPolicy{ForbiddenRegions: []constants.Region{constants.US_EAST_1}})
+	if _, err := h.GetPlacement([]byte("key")); !errors.Is(err, consistent.ErrPolicyUnsatisfied) {
+		t.Fatalf("the policy is expected to be unsatisfied, got %v", err)
+	}
+}
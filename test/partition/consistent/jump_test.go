@@ -0,0 +1,69 @@
+package consistent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+func TestJumpLocateNodes(t *testing.T) {
+	jh := consistent.NewJumpHashing(testHash{})
+	key := []byte("TestKey")
+	if res := jh.LocateKey(key); res != nil {
+		t.Fatalf("This should be nil: %v", res)
+	}
+	for i := 0; i < 8; i++ {
+		jh.AddNode(testNode(fmt.Sprintf("127.0.0.1:808%d", i)))
+	}
+	if res := jh.LocateNodes(key, 9); res != nil {
+		t.Fatalf("This should be nil: %v", res)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		res := jh.LocateNodes(key, 8)
+		seen := make(map[string]bool)
+		for _, n := range res {
+			if seen[n.String()] {
+				t.Fatalf("the node %s is located twice for %s: %v", n, key, res)
+			}
+			seen[n.String()] = true
+		}
+		if len(res) != 8 || res[0].String() != jh.LocateKey(key).String() {
+			t.Fatalf("unexpected nodes %v for %s", res, key)
+		}
+	}
+}
+
+func TestJumpKeyMovement(t *testing.T) {
+	const keys = 20000
+	jh := consistent.NewJumpHashing(testHash{})
+	for i := 0; i < 10; i++ {
+		jh.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		before[key] = jh.LocateKey([]byte(key)).String()
+	}
+	jh.AddNode(testNode("node-10"))
+	moved := 0
+	for key, owner := range before {
+		if now := jh.LocateKey([]byte(key)).String(); now != owner {
+			moved++
+			if now != "node-10" {
+				t.Fatalf("the key %s moved from %s to %s instead of the new node", key, owner, now)
+			}
+		}
+	}
+	if ratio := float64(moved) / keys; ratio < 0.07 || ratio > 0.11 {
+		t.Fatalf("%.3f of the keys moved when adding a node to 10 nodes", ratio)
+	}
+
+	jh.RemoveNode(testNode("node-10"))
+	for key, owner := range before {
+		if now := jh.LocateKey([]byte(key)).String(); now != owner {
+			t.Fatalf("the key %s is expected back on %s after removing the last node, got %s", key, owner, now)
+		}
+	}
+}
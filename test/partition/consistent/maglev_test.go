@@ -0,0 +1,127 @@
+package consistent
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+func TestMaglevLocateNodes(t *testing.T) {
+	mh := consistent.NewMaglevHashing(testHash{})
+	key := []byte("TestKey")
+	if res := mh.LocateKey(key); res != nil {
+		t.Fatalf("This should be nil: %v", res)
+	}
+	for i := 0; i < 8; i++ {
+		mh.AddNode(testNode(fmt.Sprintf("127.0.0.1:808%d", i)))
+	}
+	if res := mh.LocateNodes(key, 9); res != nil {
+		t.Fatalf("This should be nil: %v", res)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		res := mh.LocateNodes(key, 3)
+		seen := make(map[string]bool)
+		for _, n := range res {
+			if seen[n.String()] {
+				t.Fatalf("the node %s is located twice for %s: %v", n, key, res)
+			}
+			seen[n.String()] = true
+		}
+		if len(res) != 3 || res[0].String() != mh.LocateKey(key).String() {
+			t.Fatalf("unexpected nodes %v for %s", res, key)
+		}
+	}
+}
+
+func TestMaglevBalanceAndMovement(t *testing.T) {
+	const keys = 30000
+	mh := consistent.NewMaglevHashingWithTableSize(testHash{}, 5003)
+	for i := 0; i < 10; i++ {
+		mh.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	before := make(map[string]string, keys)
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		before[key] = mh.LocateKey([]byte(key)).String()
+		counts[before[key]]++
+	}
+	for node, c := range counts {
+		if c < keys/10*8/10 || c > keys/10*12/10 {
+			t.Errorf("%s owns %d keys, expected about %d", node, c, keys/10)
+		}
+	}
+
+	mh.RemoveNode(testNode("node-3"))
+	moved := 0
+	for key, owner := range before {
+		now := mh.LocateKey([]byte(key)).String()
+		if now == "node-3" {
+			t.Fatalf("the key %s is still on the removed node", key)
+		}
+		if now != owner {
+			moved++
+		}
+	}
+	// the keys of the removed node move, and a few others as the table is rebuilt
+	if ratio := float64(moved) / keys; ratio < 0.08 || ratio > 0.2 {
+		t.Fatalf("%.3f of the keys moved when removing a node of 10 nodes", ratio)
+	}
+}
+
+func TestMaglevWeights(t *testing.T) {
+	const keys = 30000
+	mh := consistent.NewMaglevHashingWithTableSize(testHash{}, 5003)
+	mh.AddNode(weightedNode{name: "small", weight: 1})
+	mh.AddNode(weightedNode{name: "large", weight: 3})
+	large := 0
+	for i := 0; i < keys; i++ {
+		if mh.LocateKey([]byte(fmt.Sprintf("key-%d", i))).String() == "large" {
+			large++
+		}
+	}
+	if ratio := float64(large) / keys; ratio < 0.7 || ratio > 0.8 {
+		t.Fatalf("the node of weight 3 owns %.3f of the keys instead of 0.75", ratio)
+	}
+}
+
+// countingHash counts the tables built, each hashing the offset of every node
+type countingHash struct {
+	testHash
+	offsets *int32
+}
+
+func (ch countingHash) Hash(key []byte) uint64 {
+	if strings.HasPrefix(string(key), "offset:") {
+		atomic.AddInt32(ch.offsets, 1)
+	}
+	return ch.testHash.Hash(key)
+}
+
+func TestMaglevBuildsTheTableOncePerChange(t *testing.T) {
+	var offsets int32
+	mh := consistent.NewMaglevHashingWithTableSize(countingHash{offsets: &offsets}, 5003)
+	for i := 0; i < 20; i++ {
+		mh.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	if offsets != 0 {
+		t.Fatalf("the table is not expected to be built before a lookup, %d offsets were hashed", offsets)
+	}
+	for i := 0; i < 100; i++ {
+		if mh.LocateKey([]byte(fmt.Sprintf("key-%d", i))) == nil {
+			t.Fatalf("no node located for key-%d", i)
+		}
+	}
+	if offsets != 20 {
+		t.Fatalf("expected the table of the 20 nodes to be built once, %d offsets were hashed", offsets)
+	}
+
+	mh.RemoveNode(testNode("node-3"))
+	if res := mh.LocateNodes([]byte("key"), 3); len(res) != 3 || offsets != 20+19 {
+		t.Fatalf("expected the table to be built again after the removal, got %v after %d offsets", res, offsets)
+	}
+}
@@ -0,0 +1,208 @@
+package consistent
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+)
+
+const policyKeys = 2000
+
+func regionalStores() (map[constants.AvailabilityZone][]consistent.RkvNode, []consistent.RkvNode) {
+	localStores := map[constants.AvailabilityZone][]consistent.RkvNode{
+		constants.US_WEST_1A: {{Name: "w1a-1", Latency: 1}, {Name: "w1a-2", Latency: 2}},
+		constants.US_WEST_1B: {{Name: "w1b-1", Latency: 3}},
+		constants.US_WEST_2A: {{Name: "w2a-1", Latency: 20}, {Name: "w2a-2", Latency: 21}},
+		constants.US_EAST_1A: {{Name: "e1a-1", Latency: 60}},
+	}
+	remoteStores := []consistent.RkvNode{
+		{Name: "e2a-1", Latency: 200, AvailabilityZone: constants.US_EAST_2A},
+		{Name: "e2a-2", Latency: 210, AvailabilityZone: constants.US_EAST_2A},
+		{Name: "e1b-1", Latency: 150, AvailabilityZone: constants.US_EAST_1B},
+	}
+	return localStores, remoteStores
+}
+
+// regionOf reads the region from the test store names, e.g. us-west-1 for w1a-1
+func regionOf(name string) constants.Region {
+	direction := map[byte]string{'w': "us-west-", 'e': "us-east-"}[name[0]]
+	return constants.Region(direction + name[1:2])
+}
+
+func zoneOf(name string) string {
+	return name[:3]
+}
+
+func placements(t *testing.T, h consistent.HashingManager) [][]string {
+	res := make([][]string, 0, policyKeys)
+	for i := 0; i < policyKeys; i++ {
+		p, err := h.GetPlacement([]byte(fmt.Sprintf("key-%d", i)))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		res = append(res, p.Nodes())
+	}
+	return res
+}
+
+func TestPolicyForbiddenRegions(t *testing.T) {
+	localStores, remoteStores := regionalStores()
+	h := consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 2, remoteStores, 1)
+	h.SetPlacementPolicy(consistent.PlacementPolicy{ForbiddenRegions: []constants.Region{constants.US_EAST_1}})
+	for _, replicas := range placements(t, h) {
+		if len(replicas) != 3 {
+			t.Fatalf("3 replicas are expected, got %v", replicas)
+		}
+		for _, name := range replicas {
+			if regionOf(name) == constants.US_EAST_1 {
+				t.Fatalf("the replicas %v are in a forbidden region", replicas)
+			}
+		}
+	}
+}
+
+func TestPolicyRequiredAndMinRegions(t *testing.T) {
+	localStores, remoteStores := regionalStores()
+	h := consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 2, remoteStores, 1)
+	h.SetPlacementPolicy(consistent.PlacementPolicy{MinRegions: 3, RequiredRegions: []constants.Region{constants.US_WEST_2}})
+	for _, replicas := range placements(t, h) {
+		regions := make(map[constants.Region]bool)
+		for _, name := range replicas {
+			regions[regionOf(name)] = true
+		}
+		if !regions[constants.US_WEST_2] {
+			t.Fatalf("the replicas %v miss the required region", replicas)
+		}
+		if len(regions) < 3 {
+			t.Fatalf("the replicas %v span fewer than 3 regions", replicas)
+		}
+	}
+}
+
+func TestPolicyPreferredLeader(t *testing.T) {
+	localStores, remoteStores := regionalStores()
+	h := consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 2, remoteStores, 1)
+	h.SetPlacementPolicy(consistent.PlacementPolicy{PreferredLeaderRegion: constants.US_WEST_2})
+	led := 0
+	for i := 0; i < policyKeys; i++ {
+		syncNodes, err := h.GetSyncNodes([]byte(fmt.Sprintf("key-%d", i)))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for j, n := range syncNodes {
+			if regionOf(n.String()) == constants.US_WEST_2 {
+				if j != 0 {
+					t.Fatalf("the sync replica %s of the preferred region is not the leader of %v", n, syncNodes)
+				}
+				led++
+			}
+		}
+	}
+	if led == 0 {
+		t.Fatal("no key has a sync replica in the preferred region")
+	}
+}
+
+func TestPolicyMaxReplicasPerZone(t *testing.T) {
+	stores := []consistent.RkvNode{
+		{Name: "w1a-1", AvailabilityZone: constants.US_WEST_1A}, {Name: "w1a-2", AvailabilityZone: constants.US_WEST_1A},
+		{Name: "w1a-3", AvailabilityZone: constants.US_WEST_1A}, {Name: "w1b-1", AvailabilityZone: constants.US_WEST_1B},
+		{Name: "w1b-2", AvailabilityZone: constants.US_WEST_1B}, {Name: "w1c-1", AvailabilityZone: constants.US_WEST_1C},
+	}
+	h := consistent.NewSyncHashingManager(constants.Ring, stores, 3)
+	h.SetPlacementPolicy(consistent.PlacementPolicy{MaxReplicasPerZone: 1})
+	for _, replicas := range placements(t, h) {
+		zones := make(map[string]bool)
+		for _, name := range replicas {
+			if zones[zoneOf(name)] {
+				t.Fatalf("the replicas %v share a zone", replicas)
+			}
+			zones[zoneOf(name)] = true
+		}
+		if len(replicas) != 3 {
+			t.Fatalf("3 replicas are expected, got %v", replicas)
+		}
+	}
+}
+
+func TestPolicyUnsatisfiable(t *testing.T) {
+	localStores, remoteStores := regionalStores()
+	h := consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 2, remoteStores, 1)
+	h.SetPlacementPolicy(consistent.PlacementPolicy{RequiredRegions: []constants.Region{"eu-west-1"}})
+	if _, err := h.GetPlacement([]byte("key")); err == nil {
+		t.Fatal("error is expected when no store is in a required region")
+	}
+}
+
+func TestPolicyNotBindingKeepsPlacement(t *testing.T) {
+	localStores, remoteStores := regionalStores()
+	h := consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 2, remoteStores, 1)
+	before := placements(t, h)
+	// no store is in the forbidden region, so the policy moves no key
+	h.SetPlacementPolicy(consistent.PlacementPolicy{ForbiddenRegions: []constants.Region{"eu-west-1"}})
+	after := placements(t, h)
+	for i := range before {
+		if fmt.Sprint(before[i]) != fmt.Sprint(after[i]) {
+			t.Fatalf("the replicas of key-%d changed from %v to %v", i, before[i], after[i])
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	cases := []struct {
+		policy   consistent.PlacementPolicy
+		replicas int
+		valid    bool
+	}{
+		{consistent.PlacementPolicy{}, 3, true},
+		{consistent.PlacementPolicy{MinRegions: 3}, 3, true},
+		{consistent.PlacementPolicy{MinRegions: 4}, 3, false},
+		{consistent.PlacementPolicy{MaxReplicasPerZone: -1}, 3, false},
+		{consistent.PlacementPolicy{RequiredRegions: []constants.Region{constants.US_WEST_1, constants.US_WEST_2}}, 1, false},
+		{consistent.PlacementPolicy{RequiredRegions: []constants.Region{constants.US_WEST_1}, ForbiddenRegions: []constants.Region{constants.US_WEST_1}}, 3, false},
+		{consistent.PlacementPolicy{PreferredLeaderRegion: constants.US_WEST_1, ForbiddenRegions: []constants.Region{constants.US_WEST_1}}, 3, false},
+		{consistent.PlacementPolicy{Residency: []consistent.ResidencyRule{{Prefix: "a/"}}}, 3, false},
+		{consistent.PlacementPolicy{MinRegions: 2, Residency: []consistent.ResidencyRule{{Prefix: "a/", Regions: []constants.Region{constants.US_WEST_1}}}}, 3, false},
+		{consistent.PlacementPolicy{ForbiddenRegions: []constants.Region{constants.US_WEST_1}, Residency: []consistent.ResidencyRule{{Prefix: "a/", Regions: []constants.Region{constants.US_WEST_1}}}}, 3, false},
+		{consistent.PlacementPolicy{Residency: []consistent.ResidencyRule{{Prefix: "a/", Regions: []constants.Region{constants.US_WEST_1}}, {Prefix: "a/", Regions: []constants.Region{constants.US_WEST_2}}}}, 3, false},
+		{consistent.PlacementPolicy{Residency: []consistent.ResidencyRule{{Prefix: "a/", Regions: []constants.Region{constants.US_WEST_1}}}}, 3, true},
+	}
+	for i, c := range cases {
+		if err := c.policy.Validate(c.replicas); (err == nil) != c.valid {
+			t.Errorf("case %d: unexpected validation result %v", i, err)
+		}
+	}
+}
+
+func TestPolicyResidency(t *testing.T) {
+	localStores, remoteStores := regionalStores()
+	h := consistent.NewSyncAsyncHashingManager(constants.Rendezvous, localStores, 2, remoteStores, 1)
+	before := placements(t, h)
+	h.SetPlacementPolicy(consistent.PlacementPolicy{Residency: []consistent.ResidencyRule{
+		{Prefix: "tenant-a/", Regions: []constants.Region{constants.US_WEST_1, constants.US_EAST_1}},
+		{Prefix: "tenant-a/eu/", Regions: []constants.Region{"eu-west-1"}},
+	}})
+	// the keys without a rule keep their placement
+	if after := placements(t, h); fmt.Sprint(before) != fmt.Sprint(after) {
+		t.Fatal("the placement of the keys without a residency rule changed")
+	}
+	for i := 0; i < policyKeys; i++ {
+		p, err := h.GetPlacementForKey([]byte("tenant-a/key"), []byte(fmt.Sprintf("key-%d", i)))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for _, name := range p.Nodes() {
+			if r := regionOf(name); r != constants.US_WEST_1 && r != constants.US_EAST_1 {
+				t.Fatalf("the replica %s of a pinned key is in %s", name, r)
+			}
+		}
+	}
+	// the longest prefix applies, and no store is in its region
+	_, err := h.GetPlacementForKey([]byte("tenant-a/eu/key"), []byte("key-1"))
+	if !errors.Is(err, consistent.ErrPolicyUnsatisfied) {
+		t.Fatalf("the write of a key which cannot be placed is expected to be rejected, got %v", err)
+	}
+}
@@ -77,3 +77,136 @@ func TestRendezvousLocateNodes(t *testing.T) {
 		t.Fatalf("This shouldn't be %d", len(res))
 	}
 }
+
+func TestRendezvousTopKDistinct(t *testing.T) {
+	rdz := consistent.NewRendezvous(nil, testHash{})
+	for i := 0; i < 6; i++ {
+		rdz.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	rdz.AddNode(testNode("node-0"))
+	if len(rdz.GetNodes()) != 6 {
+		t.Fatalf("adding a node twice is expected to be ignored, got %d nodes", len(rdz.GetNodes()))
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		res := rdz.LocateNodes(key, 6)
+		seen := make(map[string]bool)
+		for _, n := range res {
+			if seen[n.String()] {
+				t.Fatalf("the node %s is located twice for %s: %v", n, key, res)
+			}
+			seen[n.String()] = true
+		}
+		if res[0].String() != rdz.LocateKey(key).String() {
+			t.Fatalf("the first replica %s is not the owner %s", res[0], rdz.LocateKey(key))
+		}
+		// the top-k is a prefix of the top-(k+1)
+		top3 := rdz.LocateNodes(key, 3)
+		for j := range top3 {
+			if top3[j].String() != res[j].String() {
+				t.Fatalf("the top 3 %v is not a prefix of the top 6 %v", top3, res)
+			}
+		}
+	}
+}
+
+func TestRendezvousDistribution(t *testing.T) {
+	const keys, nodes, replicas = 50000, 10, 3
+	rdz := consistent.NewRendezvous(nil, testHash{})
+	for i := 0; i < nodes; i++ {
+		rdz.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	// every position of the replicas is expected to be spread evenly, not only the first one
+	counts := make([]map[string]int, replicas)
+	for r := range counts {
+		counts[r] = make(map[string]int)
+	}
+	for i := 0; i < keys; i++ {
+		for r, n := range rdz.LocateNodes([]byte(fmt.Sprintf("key-%d", i)), replicas) {
+			counts[r][n.String()]++
+		}
+	}
+	expected := float64(keys) / nodes
+	for r := range counts {
+		for node, c := range counts[r] {
+			if float64(c) < 0.9*expected || float64(c) > 1.1*expected {
+				t.Errorf("%s is the replica %d of %d keys, expected about %.0f", node, r, c, expected)
+			}
+		}
+	}
+}
+
+func TestRendezvousWeights(t *testing.T) {
+	const keys = 40000
+	rdz := consistent.NewRendezvous(nil, testHash{})
+	rdz.AddNode(weightedNode{name: "small", weight: 1})
+	rdz.AddNode(weightedNode{name: "large", weight: 3})
+
+	large := 0
+	for i := 0; i < keys; i++ {
+		if rdz.LocateKey([]byte(fmt.Sprintf("key-%d", i))).String() == "large" {
+			large++
+		}
+	}
+	if ratio := float64(large) / keys; ratio < 0.72 || ratio > 0.78 {
+		t.Fatalf("the node of weight 3 owns %.3f of the keys instead of 0.75", ratio)
+	}
+}
+
+func TestRendezvousStability(t *testing.T) {
+	const keys, replicas = 20000, 3
+	rdz := consistent.NewRendezvous(nil, testHash{})
+	for i := 0; i < 10; i++ {
+		rdz.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	locate := func() map[string][]string {
+		placement := make(map[string][]string, keys)
+		for i := 0; i < keys; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			for _, n := range rdz.LocateNodes([]byte(key), replicas) {
+				placement[key] = append(placement[key], n.String())
+			}
+		}
+		return placement
+	}
+	contains := func(nodes []string, name string) bool {
+		for _, n := range nodes {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	before := locate()
+	rdz.AddNode(testNode("node-10"))
+	afterAdd := locate()
+	moved := 0
+	for key, nodes := range afterAdd {
+		if fmt.Sprint(nodes) == fmt.Sprint(before[key]) {
+			continue
+		}
+		moved++
+		if !contains(nodes, "node-10") {
+			t.Fatalf("the replicas of %s changed from %v to %v without the new node", key, before[key], nodes)
+		}
+	}
+	// a key changes when the new node is among its top 3, 3/11 of them
+	if ratio := float64(moved) / keys; ratio < 0.22 || ratio > 0.33 {
+		t.Fatalf("%.3f of the keys changed replicas when adding a node to 10 nodes", ratio)
+	}
+
+	rdz.RemoveNode(testNode("node-4"))
+	afterRemove := locate()
+	for key, nodes := range afterRemove {
+		if contains(nodes, "node-4") {
+			t.Fatalf("the key %s is still on the removed node", key)
+		}
+		if !contains(afterAdd[key], "node-4") && fmt.Sprint(nodes) != fmt.Sprint(afterAdd[key]) {
+			t.Fatalf("the replicas of %s changed from %v to %v without the removed node", key, afterAdd[key], nodes)
+		}
+	}
+	if len(rdz.GetNodes()) != 10 {
+		t.Fatalf("10 nodes are expected, got %d", len(rdz.GetNodes()))
+	}
+}
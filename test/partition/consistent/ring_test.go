@@ -90,3 +90,121 @@ func TestRingLocateNodes(t *testing.T) {
 		t.Fatalf("This shouldn't be %d", len(res))
 	}
 }
+
+type weightedNode struct {
+	name   string
+	weight int
+}
+
+func (wn weightedNode) String() string {
+	return wn.name
+}
+
+func (wn weightedNode) GetWeight() int {
+	return wn.weight
+}
+
+func locateAll(ring *consistent.RingHashing, keys int) map[string]string {
+	owners := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners[key] = ring.LocateKey([]byte(key)).String()
+	}
+	return owners
+}
+
+func TestRingKeyMovementOnAdd(t *testing.T) {
+	const keys = 20000
+	ring := consistent.NewRingHashing(testHash{})
+	for i := 0; i < 10; i++ {
+		ring.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	before := locateAll(ring, keys)
+	ring.AddNode(testNode("node-10"))
+	after := locateAll(ring, keys)
+
+	moved := 0
+	for key, owner := range after {
+		if owner != before[key] {
+			moved++
+			if owner != "node-10" {
+				t.Fatalf("the key %s moved from %s to %s instead of the new node", key, before[key], owner)
+			}
+		}
+	}
+	// ideally 1/11 of the keys move to the new node; modulo hashing would move 10/11 of them
+	if ratio := float64(moved) / keys; ratio < 0.05 || ratio > 0.15 {
+		t.Fatalf("%.3f of the keys moved when adding a node to 10 nodes", ratio)
+	}
+}
+
+func TestRingKeyMovementOnRemove(t *testing.T) {
+	const keys = 20000
+	ring := consistent.NewRingHashing(testHash{})
+	for i := 0; i < 10; i++ {
+		ring.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	before := locateAll(ring, keys)
+	ring.RemoveNode(testNode("node-3"))
+	after := locateAll(ring, keys)
+
+	moved := 0
+	for key, owner := range after {
+		if owner == "node-3" {
+			t.Fatalf("the key %s is still on the removed node", key)
+		}
+		if owner != before[key] {
+			moved++
+			if before[key] != "node-3" {
+				t.Fatalf("the key %s moved from %s which was not removed", key, before[key])
+			}
+		}
+	}
+	if ratio := float64(moved) / keys; ratio < 0.05 || ratio > 0.15 {
+		t.Fatalf("%.3f of the keys moved when removing a node of 10 nodes", ratio)
+	}
+	if len(ring.GetNodes()) != 9 {
+		t.Fatalf("9 nodes are expected, got %d", len(ring.GetNodes()))
+	}
+}
+
+func TestRingWeights(t *testing.T) {
+	const keys = 30000
+	ring := consistent.NewRingHashingWithVirtualNodes(testHash{}, 200)
+	ring.AddNode(weightedNode{name: "small", weight: 1})
+	ring.AddNode(weightedNode{name: "medium", weight: 1})
+	ring.AddNode(weightedNode{name: "large", weight: 2})
+
+	counts := make(map[string]int)
+	for _, owner := range locateAll(ring, keys) {
+		counts[owner]++
+	}
+	// the large node is expected to own half of the keys
+	if ratio := float64(counts["large"]) / keys; ratio < 0.4 || ratio > 0.6 {
+		t.Fatalf("the node of weight 2 owns %.3f of the keys: %v", ratio, counts)
+	}
+}
+
+func TestRingLocateDistinctNodes(t *testing.T) {
+	ring := consistent.NewRingHashing(testHash{})
+	for i := 0; i < 5; i++ {
+		ring.AddNode(testNode(fmt.Sprintf("node-%d", i)))
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		res := ring.LocateNodes(key, 5)
+		if len(res) != 5 {
+			t.Fatalf("5 nodes are expected, got %v", res)
+		}
+		seen := make(map[string]bool)
+		for _, n := range res {
+			if seen[n.String()] {
+				t.Fatalf("the node %s is located twice for %s: %v", n, key, res)
+			}
+			seen[n.String()] = true
+		}
+		if res[0].String() != ring.LocateKey(key).String() {
+			t.Fatalf("the first replica %s is not the owner %s", res[0], ring.LocateKey(key))
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package piping
+
+import (
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/piping"
+)
+
+func TestAckPolicyRequired(t *testing.T) {
+	tcs := []struct {
+		policy   piping.AckPolicy
+		replicas int
+		expected int
+	}{
+		{piping.AckPolicy{}, 3, 3},
+		{piping.AckPolicy{Policy: constants.AckAll}, 3, 3},
+		{piping.AckPolicy{Policy: constants.AckMajority}, 3, 2},
+		{piping.AckPolicy{Policy: constants.AckMajority}, 4, 3},
+		{piping.AckPolicy{Policy: constants.AckFirstN, Count: 1}, 3, 1},
+		{piping.AckPolicy{Policy: constants.AckFirstN, Count: 5}, 3, 3},
+	}
+	for _, tc := range tcs {
+		if required := tc.policy.Required(tc.replicas); required != tc.expected {
+			t.Fatalf("%v of %d replicas should require %d instead of %d", tc.policy, tc.replicas, tc.expected, required)
+		}
+	}
+}
+
+func TestAckPolicyValidate(t *testing.T) {
+	if err := (piping.AckPolicy{Policy: constants.AckFirstN}).Validate(); err == nil {
+		t.Fatal("firstN without a count should be rejected")
+	}
+	if err := (piping.AckPolicy{Policy: "quorum"}).Validate(); err == nil {
+		t.Fatal("an unknown policy should be rejected")
+	}
+	if err := (piping.AckPolicy{Policy: constants.AckMajority}).Validate(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -2,16 +2,24 @@ package piping
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/regionless-storage-service/pkg/consistent"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/database"
 	"github.com/regionless-storage-service/pkg/index"
+	pc "github.com/regionless-storage-service/pkg/partition/consistent"
 	"github.com/regionless-storage-service/pkg/piping"
+	"github.com/regionless-storage-service/pkg/replication"
 )
 
+// placements is the placement table of the revisions of the tests, which are not indexed
+var placements = index.NewPlacementTable()
+
 func TestWriteLINEARIZABLE(t *testing.T) {
 	cp := piping.NewChainPiping("mem", consistent.LINEARIZABLE, false)
-	rev := index.NewRevision(1, 0, []string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2", "3.3.3.3:3"})
+	rev := index.NewRevision(placements, 1, 0, pc.NamedPlacement([]string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2", "3.3.3.3:3"}, nil))
 
 	if err := cp.Write(context.TODO(), rev, "v"); err != nil {
 		t.Fatalf("fail to write with the error %v", err)
@@ -20,7 +28,7 @@ func TestWriteLINEARIZABLE(t *testing.T) {
 
 func TestDeleteLINEARIZABLE(t *testing.T) {
 	cp := piping.NewChainPiping("mem", consistent.LINEARIZABLE, false)
-	rev := index.NewRevision(1, 0, []string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2", "3.3.3.3:3"})
+	rev := index.NewRevision(placements, 1, 0, pc.NamedPlacement([]string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2", "3.3.3.3:3"}, nil))
 	if err := cp.Write(context.TODO(), rev, "v"); err != nil {
 		t.Fatalf("fail to write with the error %v", err)
 	}
@@ -31,7 +39,7 @@ func TestDeleteLINEARIZABLE(t *testing.T) {
 
 func TestReadLINEARIZABLE(t *testing.T) {
 	cp := piping.NewChainPiping("mem", consistent.LINEARIZABLE, false)
-	rev := index.NewRevision(1, 0, []string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2"})
+	rev := index.NewRevision(placements, 1, 0, pc.NamedPlacement([]string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2"}, nil))
 	if err := cp.Write(context.TODO(), rev, "v"); err != nil {
 		t.Fatalf("fail to write with the error %v", err)
 	}
@@ -44,7 +52,7 @@ func TestReadLINEARIZABLE(t *testing.T) {
 
 func TestWriteLINEARIZABLEConcurrently(t *testing.T) {
 	cp := piping.NewChainPiping("mem", consistent.LINEARIZABLE, true)
-	rev := index.NewRevision(1, 0, []string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2", "3.3.3.3:3"})
+	rev := index.NewRevision(placements, 1, 0, pc.NamedPlacement([]string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2", "3.3.3.3:3"}, nil))
 	if err := cp.Write(context.TODO(), rev, "v"); err != nil {
 		t.Fatalf("fail to write with the error %v", err)
 	}
@@ -52,7 +60,7 @@ func TestWriteLINEARIZABLEConcurrently(t *testing.T) {
 
 func TestDeleteLINEARIZABLEConcurrently(t *testing.T) {
 	cp := piping.NewChainPiping("mem", consistent.LINEARIZABLE, true)
-	rev := index.NewRevision(1, 0, []string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2", "3.3.3.3:3"})
+	rev := index.NewRevision(placements, 1, 0, pc.NamedPlacement([]string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2", "3.3.3.3:3"}, nil))
 	if err := cp.Write(context.TODO(), rev, "v"); err != nil {
 		t.Fatalf("fail to write with the error %v", err)
 	}
@@ -63,7 +71,7 @@ func TestDeleteLINEARIZABLEConcurrently(t *testing.T) {
 
 func TestReadLINEARIZABLEConcurrently(t *testing.T) {
 	cp := piping.NewChainPiping("mem", consistent.LINEARIZABLE, true)
-	rev := index.NewRevision(1, 0, []string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2"})
+	rev := index.NewRevision(placements, 1, 0, pc.NamedPlacement([]string{"0.0.0.0:0", "1.1.1.1:1", "2.2.2.2:2"}, nil))
 	if err := cp.Write(context.TODO(), rev, "v"); err != nil {
 		t.Fatalf("fail to write with the error %v", err)
 	}
@@ -73,3 +81,78 @@ func TestReadLINEARIZABLEConcurrently(t *testing.T) {
 		t.Fatalf("read a wrong value %s", val)
 	}
 }
+
+// recordingReplicator keeps the operations handed over to it without applying them
+type recordingReplicator struct {
+	ops []string
+}
+
+func (r *recordingReplicator) Enqueue(dest string, op replication.Op, key, value string) error {
+	r.ops = append(r.ops, fmt.Sprintf("%s %s %s=%s", dest, op, key, value))
+	return nil
+}
+
+func TestWriteChainWithAsyncReplicas(t *testing.T) {
+	replicator := &recordingReplicator{}
+	cp := piping.NewChainPipingWithReplicator("mem", consistent.LINEARIZABLE, false, replicator, piping.AckPolicy{Policy: constants.AckAll})
+	p := pc.NamedPlacement([]string{"chain-sync1", "chain-sync2"}, []string{"chain-async"})
+	rev := index.NewRevision(placements, 2, 0, p)
+	if err := cp.Write(context.TODO(), rev, "v"); err != nil {
+		t.Fatalf("fail to write with the error %v", err)
+	}
+	for _, name := range p.SyncNodes() {
+		if v, err := database.NewMemDatabase(name).Get(rev.String()); err != nil || v != "v" {
+			t.Fatalf("the replica %s has the value %q with the error %v", name, v, err)
+		}
+	}
+	// the async replica is not in the chain, its write is queued instead
+	if v, err := database.NewMemDatabase("chain-async").Get(rev.String()); err == nil {
+		t.Fatalf("the async replica is expected to be left to the replicator, it has the value %q", v)
+	}
+	if expected := []string{"chain-async put 2=v"}; fmt.Sprint(replicator.ops) != fmt.Sprint(expected) {
+		t.Fatalf("the operations %v are expected to be queued, got %v", expected, replicator.ops)
+	}
+	if val, err := cp.ReadTail(context.TODO(), rev); err != nil || val != "v" {
+		t.Fatalf("fail to read the tail %q with the error %v", val, err)
+	}
+
+	// without a replicator, the async replicas are rejected rather than chained
+	if err := piping.NewChainPiping("mem", consistent.LINEARIZABLE, false).Write(context.TODO(), index.NewRevision(placements, 3, 0, p), "v"); err == nil {
+		t.Fatal("the write to async replicas is expected to fail without a replicator")
+	}
+}
+
+func TestWriteConcurrentlyRollsBack(t *testing.T) {
+	names, _ := erasureStores("chain-rollback", 3)
+	defer kill(names[1])()
+	cp := piping.NewChainPipingWithAckPolicy(constants.DummyLatency, consistent.LINEARIZABLE, true, piping.AckPolicy{Policy: constants.AckAll})
+	rev := index.NewRevision(placements, 4, 0, pc.NamedPlacement(names, nil))
+	if err := cp.Write(context.TODO(), rev, "v"); err == nil {
+		t.Fatal("the write is expected to fail as a replica is down")
+	}
+	// the replicas which accepted the value give it back
+	for _, name := range []string{names[0], names[2]} {
+		if v, err := database.Storages[name].Get(rev.String()); err == nil {
+			t.Errorf("the replica %s is expected to be rolled back, it has the value %q", name, v)
+		}
+	}
+}
+
+func TestWriteChainRollsBackBeforeTheFailedNode(t *testing.T) {
+	names, _ := erasureStores("chain-stopped", 3)
+	rev := index.NewRevision(placements, 5, 0, pc.NamedPlacement(names, nil))
+	// the tail already holds the revision, as after an earlier write of it
+	database.Storages[names[2]].Put(rev.String(), "v")
+	defer kill(names[1])()
+	cp := piping.NewChainPipingWithAckPolicy(constants.DummyLatency, consistent.LINEARIZABLE, false, piping.AckPolicy{Policy: constants.AckAll})
+	if err := cp.Write(context.TODO(), rev, "v"); err == nil {
+		t.Fatal("the write is expected to fail as the middle of the chain is down")
+	}
+	if v, err := database.Storages[names[0]].Get(rev.String()); err == nil {
+		t.Errorf("the head is expected to be rolled back, it has the value %q", v)
+	}
+	// the chain never reached the tail, which keeps its value
+	if v, err := database.Storages[names[2]].Get(rev.String()); err != nil || v != "v" {
+		t.Errorf("the tail is expected to be left alone, it has the value %q with the error %v", v, err)
+	}
+}
@@ -0,0 +1,197 @@
+package piping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/index"
+	pc "github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/pkg/piping"
+	"github.com/regionless-storage-service/test/mock"
+)
+
+// killableDatabase fails while it is down; the shard writes left in flight by a write acknowledged early go
+// on while a test kills and revives the stores
+type killableDatabase struct {
+	mu   sync.Mutex
+	db   database.Database
+	down bool
+}
+
+func (k *killableDatabase) Put(key, value string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.down {
+		return "", errors.New("store unavailable")
+	}
+	return k.db.Put(key, value)
+}
+
+func (k *killableDatabase) Get(key string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.down {
+		return "", errors.New("store unavailable")
+	}
+	return k.db.Get(key)
+}
+
+func (k *killableDatabase) Delete(key string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.down {
+		return errors.New("store unavailable")
+	}
+	return k.db.Delete(key)
+}
+
+func (k *killableDatabase) Close() error {
+	return nil
+}
+
+func (k *killableDatabase) Latency() time.Duration {
+	return 0
+}
+
+func (k *killableDatabase) SetLatency(latency time.Duration) {
+}
+
+func (k *killableDatabase) setDown(down bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.down = down
+}
+
+// erasureStores registers n in-memory stores, the first ones synced and the last one async
+func erasureStores(prefix string, n int) ([]string, pc.Placement) {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%d", prefix, i)
+		database.Storages[names[i]] = &killableDatabase{db: mock.NewMockDatabase()}
+	}
+	return names, pc.NamedPlacement(names[:n-1], names[n-1:])
+}
+
+// kill takes the stores down, and returns a function bringing them back
+func kill(names ...string) func() {
+	for _, name := range names {
+		database.Storages[name].(*killableDatabase).setDown(true)
+	}
+	return func() {
+		for _, name := range names {
+			database.Storages[name].(*killableDatabase).setDown(false)
+		}
+	}
+}
+
+func TestErasureWriteRead(t *testing.T) {
+	names, p := erasureStores("ec-rw", 6)
+	ep, err := piping.NewErasurePiping(constants.DummyLatency, 4, 2, piping.AckPolicy{Policy: constants.AckAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := index.NewRevision(placements, 30, 0, p)
+	value := "a value of the cross-region store, coded in 4 data and 2 parity shards"
+	if err := ep.Write(context.TODO(), rev, value); err != nil {
+		t.Fatal(err)
+	}
+	// every store holds a shard, which is not the value
+	for _, name := range names {
+		if shard, err := database.Storages[name].Get(rev.String()); err != nil || shard == value {
+			t.Fatalf("a shard is expected on %s, got %q, %v", name, shard, err)
+		}
+	}
+
+	// the read is degraded by any 2 of the 6 stores down
+	for i := 0; i < len(names); i++ {
+		for j := i; j < len(names); j++ {
+			revive := kill(names[i], names[j])
+			v, err := ep.Read(context.TODO(), rev)
+			revive()
+			if err != nil || v != value {
+				t.Fatalf("the value is expected without %s and %s, got %q, %v", names[i], names[j], v, err)
+			}
+		}
+	}
+
+	// 3 stores down leave too few shards
+	revive := kill(names[0], names[3], names[5])
+	_, err = ep.Read(context.TODO(), rev)
+	revive()
+	if !errors.Is(err, piping.ErrUnavailable) {
+		t.Fatalf("the read is expected to be unavailable without 3 shards, got %v", err)
+	}
+
+	if err := ep.Delete(context.TODO(), rev); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ep.Read(context.TODO(), rev); !database.IsNotFound(err) {
+		t.Fatalf("the deleted revision is expected not to be found, got %v", err)
+	}
+}
+
+func TestErasureCorruptShard(t *testing.T) {
+	names, p := erasureStores("ec-corrupt", 5)
+	ep, err := piping.NewErasurePiping(constants.DummyLatency, 3, 2, piping.AckPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := index.NewRevision(placements, 31, 0, p)
+	if err := ep.Write(context.TODO(), rev, "value"); err != nil {
+		t.Fatal(err)
+	}
+	// a corrupt data shard and a lost one are made up for by the parity shards
+	database.Storages[names[0]].Put(rev.String(), "garbage")
+	database.Storages[names[1]].Delete(rev.String())
+	if v, err := ep.Read(context.TODO(), rev); err != nil || v != "value" {
+		t.Fatalf("the value is expected from the parity shards, got %q, %v", v, err)
+	}
+}
+
+func TestErasureWriteAcks(t *testing.T) {
+	names, p := erasureStores("ec-ack", 6)
+	rev := index.NewRevision(placements, 32, 0, p)
+
+	// with all the acks required, a store down fails the write and the shards written are rolled back
+	all, err := piping.NewErasurePiping(constants.DummyLatency, 4, 2, piping.AckPolicy{Policy: constants.AckAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revive := kill(names[2])
+	err = all.Write(context.TODO(), rev, "value")
+	revive()
+	if err == nil {
+		t.Fatal("the write is expected to fail without all the stores")
+	}
+	for _, name := range names {
+		if _, err := database.Storages[name].Get(rev.String()); !database.IsNotFound(err) {
+			t.Errorf("the shard on %s is expected to be rolled back, got %v", name, err)
+		}
+	}
+
+	// a majority is raised to the data shards, which 2 stores down still leave
+	majority, err := piping.NewErasurePiping(constants.DummyLatency, 4, 2, piping.AckPolicy{Policy: constants.AckMajority})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revive = kill(names[0], names[4])
+	err = majority.Write(context.TODO(), rev, "value")
+	revive()
+	if err != nil {
+		t.Fatalf("the write is expected to succeed with 4 shards, got %v", err)
+	}
+	if v, err := majority.Read(context.TODO(), rev); err != nil || v != "value" {
+		t.Fatalf("unexpected value %q, %v", v, err)
+	}
+
+	// the revisions not placed on a store per shard are rejected
+	if err := majority.Write(context.TODO(), index.NewRevision(placements, 33, 0, pc.NamedPlacement(names[:4], nil)), "value"); err == nil {
+		t.Error("a revision placed on 4 stores is not expected to be written as 6 shards")
+	}
+}
@@ -0,0 +1,135 @@
+package piping
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/consistent"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/index"
+	pc "github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/pkg/piping"
+	"github.com/regionless-storage-service/test/mock"
+)
+
+// slowDatabase delays the reads of the backend
+type slowDatabase struct {
+	database.Database
+	delay time.Duration
+}
+
+func (s slowDatabase) Get(key string) (string, error) {
+	time.Sleep(s.delay)
+	return s.Database.Get(key)
+}
+
+func TestReadFailover(t *testing.T) {
+	database.Storages["failover-bad"] = mock.NewFailingDatabase()
+	database.Storages["failover-ok"] = mock.NewMockDatabase()
+	database.Storages["failover-async"] = mock.NewMockDatabase()
+	rev := index.NewRevision(placements, 5, 0, pc.NamedPlacement([]string{"failover-bad", "failover-ok"}, []string{"failover-async"}))
+	database.Storages["failover-ok"].Put(rev.String(), "5")
+
+	sap := piping.NewSyncAsyncPipingWithConsistency(constants.DummyLatency, consistent.LINEARIZABLE)
+	if v, err := sap.Read(context.TODO(), rev); err != nil {
+		t.Fatalf("The read should fail over to the second sync node: %v", err)
+	} else if v != "5" {
+		t.Fatalf("The value shouldn't be %s", v)
+	}
+
+	database.Storages["failover-ok"].Delete(rev.String())
+	database.Storages["failover-async"].Put(rev.String(), "5")
+	if v, err := sap.Read(context.TODO(), rev); err != nil {
+		t.Fatalf("The read should fail over to the async node: %v", err)
+	} else if v != "5" {
+		t.Fatalf("The value shouldn't be %s", v)
+	}
+}
+
+func TestReadErrorClassification(t *testing.T) {
+	database.Storages["classify-bad"] = mock.NewFailingDatabase()
+	database.Storages["classify-empty1"] = mock.NewMockDatabase()
+	database.Storages["classify-empty2"] = mock.NewMockDatabase()
+	sap := piping.NewSyncAsyncPipingWithConsistency(constants.DummyLatency, consistent.LINEARIZABLE)
+
+	_, err := sap.Read(context.TODO(), index.NewRevision(placements, 6, 0, pc.NamedPlacement([]string{"classify-empty1", "classify-empty2"}, nil)))
+	if !database.IsNotFound(err) {
+		t.Fatalf("The revision should be not found instead of %v", err)
+	}
+	_, err = sap.Read(context.TODO(), index.NewRevision(placements, 6, 0, pc.NamedPlacement([]string{"classify-empty1", "classify-bad"}, nil)))
+	if !errors.Is(err, piping.ErrUnavailable) {
+		t.Fatalf("The replicas should be unavailable instead of %v", err)
+	}
+}
+
+func TestHedgedRead(t *testing.T) {
+	fast := mock.NewMockDatabase()
+	database.Storages["hedge-fast"] = fast
+	database.Storages["hedge-slow"] = slowDatabase{Database: mock.NewMockDatabase(), delay: time.Second}
+	fast.Put("7", "7")
+	sap := piping.NewSyncAsyncPipingWithConsistency(constants.DummyLatency, consistent.LINEARIZABLE)
+	sap.SetReadHedgePercentile(90)
+	warm := index.NewRevision(placements, 7, 0, pc.NamedPlacement([]string{"hedge-fast"}, nil))
+	for i := 0; i < 30; i++ {
+		if _, err := sap.Read(context.TODO(), warm); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	start := time.Now()
+	v, err := sap.Read(context.TODO(), index.NewRevision(placements, 7, 0, pc.NamedPlacement([]string{"hedge-slow", "hedge-fast"}, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if v != "7" {
+		t.Fatalf("The value shouldn't be %s", v)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("The hedged read should not wait for the slow replica. It took %v", elapsed)
+	}
+}
+
+func TestReadSkipsOpenCircuit(t *testing.T) {
+	database.EnableGuards(database.GuardOptions{FailureThreshold: 1, OpenTimeout: time.Minute})
+	bad := database.Guard("circuit-bad", slowDatabase{Database: mock.NewFailingDatabase(), delay: 300 * time.Millisecond})
+	ok := mock.NewMockDatabase()
+	database.RegisterDatabase("circuit-bad", bad)
+	database.RegisterDatabase("circuit-ok", ok)
+	defer database.Unregister("circuit-bad")
+	defer database.Unregister("circuit-ok")
+	rev := index.NewRevision(placements, 8, 0, pc.NamedPlacement([]string{"circuit-bad", "circuit-ok"}, nil))
+	ok.Put(rev.String(), "8")
+	if _, err := bad.Get(rev.String()); err == nil || database.Available("circuit-bad") {
+		t.Fatalf("The circuit of the failing store should be open")
+	}
+
+	sap := piping.NewSyncAsyncPipingWithConsistency(constants.DummyLatency, consistent.LINEARIZABLE)
+	start := time.Now()
+	if v, err := sap.Read(context.TODO(), rev); err != nil || v != "8" {
+		t.Fatalf("unexpected value %s, error %v", v, err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("The read should go to the replica with a closed circuit first. It took %v", elapsed)
+	}
+}
+
+func TestHedgedReadOutOfRangePercentile(t *testing.T) {
+	fast := mock.NewMockDatabase()
+	database.Storages["hedge-range"] = fast
+	database.Storages["hedge-range-next"] = mock.NewMockDatabase()
+	fast.Put("9", "9")
+	rev := index.NewRevision(placements, 9, 0, pc.NamedPlacement([]string{"hedge-range", "hedge-range-next"}, nil))
+	for _, p := range []float64{-10, 150} {
+		sap := piping.NewSyncAsyncPipingWithConsistency(constants.DummyLatency, consistent.LINEARIZABLE)
+		sap.SetReadHedgePercentile(p)
+		// the hedging delay is taken once the reads are enough of them
+		for i := 0; i < 30; i++ {
+			if v, err := sap.Read(context.TODO(), rev); err != nil || v != "9" {
+				t.Fatalf("unexpected value %s, error %v with the percentile %v", v, err, p)
+			}
+		}
+	}
+}
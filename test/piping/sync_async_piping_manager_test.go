@@ -3,15 +3,21 @@ package piping
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/regionless-storage-service/pkg/consistent"
 	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/database"
 	"github.com/regionless-storage-service/pkg/index"
+	pc "github.com/regionless-storage-service/pkg/partition/consistent"
 	"github.com/regionless-storage-service/pkg/piping"
+	"github.com/regionless-storage-service/pkg/replication"
+	"github.com/regionless-storage-service/test/mock"
 )
 
 func TestWrite(t *testing.T) {
 	sap := piping.NewSyncAsyncPiping(constants.Memory)
-	rev := index.NewRevision(1, 0, []string{"1.1.1.1:80"})
+	rev := index.NewRevision(placements, 1, 0, pc.NamedPlacement([]string{"1.1.1.1:80"}, nil))
 	if err := sap.Write(context.TODO(), rev, "1"); err != nil {
 		t.Fatalf("fail to write with the error %v", err)
 	}
@@ -19,7 +25,7 @@ func TestWrite(t *testing.T) {
 
 func TestRead(t *testing.T) {
 	sap := piping.NewSyncAsyncPiping(constants.Memory)
-	rev := index.NewRevision(1, 0, []string{"1.1.1.1:80"})
+	rev := index.NewRevision(placements, 1, 0, pc.NamedPlacement([]string{"1.1.1.1:80"}, nil))
 	if err := sap.Write(context.TODO(), rev, "1"); err != nil {
 		t.Fatalf("fail to write with the error %v", err)
 	}
@@ -32,7 +38,7 @@ func TestRead(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	sap := piping.NewSyncAsyncPiping(constants.Memory)
-	rev := index.NewRevision(1, 0, []string{"1.1.1.1:80"})
+	rev := index.NewRevision(placements, 1, 0, pc.NamedPlacement([]string{"1.1.1.1:80"}, nil))
 	if err := sap.Write(context.TODO(), rev, "1"); err != nil {
 		t.Fatalf("fail to write with the error %v", err)
 	}
@@ -40,3 +46,69 @@ func TestDelete(t *testing.T) {
 		t.Fatalf("fail to delete  with the error %v", err)
 	}
 }
+
+func TestReadSEQUENTIAL(t *testing.T) {
+	sap := piping.NewSyncAsyncPipingWithConsistency(constants.Memory, consistent.LINEARIZABLE)
+	rev := index.NewRevision(placements, 2, 0, pc.NamedPlacement([]string{"1.1.1.1:80", "1.1.1.2:80"}, nil))
+	ctx := consistent.WithConsistency(context.TODO(), consistent.SEQUENTIAL)
+	if err := sap.Write(context.TODO(), rev, "2"); err != nil {
+		t.Fatalf("fail to write with the error %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if v, err := sap.Read(ctx, rev); err != nil {
+			t.Fatalf("fail to read with the error %v", err)
+		} else if v != "2" {
+			t.Fatalf("The value shouldn't be %s", v)
+		}
+	}
+}
+
+func TestWriteWithReplicator(t *testing.T) {
+	rm, err := replication.NewManager(replication.Options{}, func(name string) (database.Database, error) {
+		return database.NewMemDatabase(name), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer rm.Close()
+	sap := piping.NewSyncAsyncPipingWithReplicator(constants.Memory, consistent.LINEARIZABLE, rm, piping.AckPolicy{})
+	rev := index.NewRevision(placements, 3, 0, pc.NamedPlacement([]string{"1.1.1.3:80"}, []string{"9.9.9.3:80"}))
+	if err := sap.Write(context.TODO(), rev, "3"); err != nil {
+		t.Fatalf("fail to write with the error %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rm.Wait(ctx); err != nil {
+		t.Fatalf("the replication is not done: %v", err)
+	}
+	if v, err := database.NewMemDatabase("9.9.9.3:80").Get(rev.String()); err != nil || v != "3" {
+		t.Fatalf("The async node value shouldn't be %s with error %v", v, err)
+	}
+}
+
+func TestWriteAckPolicy(t *testing.T) {
+	database.Storages["ack-ok1"] = mock.NewMockDatabase()
+	database.Storages["ack-ok2"] = mock.NewMockDatabase()
+	database.Storages["ack-bad"] = mock.NewFailingDatabase()
+	rev := index.NewRevision(placements, 4, 0, pc.NamedPlacement([]string{"ack-ok1", "ack-ok2", "ack-bad"}, nil))
+
+	all := piping.NewSyncAsyncPipingWithReplicator(constants.DummyLatency, consistent.LINEARIZABLE, nil, piping.AckPolicy{Policy: constants.AckAll})
+	if err := all.Write(context.TODO(), rev, "4"); err == nil {
+		t.Fatal("The write should fail since a sync node is down")
+	}
+	for _, name := range []string{"ack-ok1", "ack-ok2"} {
+		if _, err := database.Storages[name].Get(rev.String()); err == nil {
+			t.Fatalf("The failed write should have been rolled back on %s", name)
+		}
+	}
+
+	majority := piping.NewSyncAsyncPipingWithReplicator(constants.DummyLatency, consistent.LINEARIZABLE, nil, piping.AckPolicy{Policy: constants.AckMajority})
+	if err := majority.Write(context.TODO(), rev, "4"); err != nil {
+		t.Fatalf("The write should succeed with the majority: %v", err)
+	}
+
+	firstN := piping.NewSyncAsyncPipingWithReplicator(constants.DummyLatency, consistent.LINEARIZABLE, nil, piping.AckPolicy{Policy: constants.AckFirstN, Count: 3})
+	if err := firstN.Write(context.TODO(), rev, "4"); err == nil {
+		t.Fatal("The write should fail without 3 acknowledgements")
+	}
+}
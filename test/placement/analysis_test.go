@@ -0,0 +1,136 @@
+package placement
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/placement"
+)
+
+func newConfig(localReplicas int) *config.KVConfiguration {
+	return &config.KVConfiguration{
+		ConsistentHash:                        constants.Ring,
+		HashingManagerType:                    constants.SyncAsync,
+		StoreType:                             constants.DummyLatency,
+		BucketSize:                            10,
+		LocalReplicaNum:                       localReplicas,
+		RemoteReplicaNum:                      1,
+		RemoteStoreLatencyThresholdInMilliSec: 100,
+		Stores: []config.KVStore{
+			{AvailabilityZone: "az1", Name: "s1", ArtificialLatencyInMs: 1},
+			{AvailabilityZone: "az1", Name: "s2", ArtificialLatencyInMs: 1},
+			{AvailabilityZone: "az2", Name: "s3", ArtificialLatencyInMs: 1},
+			{AvailabilityZone: "az3", Name: "s4", ArtificialLatencyInMs: 1},
+			{AvailabilityZone: "az4", Name: "r1", ArtificialLatencyInMs: 200},
+			{AvailabilityZone: "az5", Name: "r2", ArtificialLatencyInMs: 200},
+		},
+		Topology: &config.Topology{Regions: []config.RegionSpec{
+			{Name: "dc1", Zones: []constants.AvailabilityZone{"az1", "az2", "az3"}},
+			{Name: "dc2", Zones: []constants.AvailabilityZone{"az4", "az5"}},
+		}},
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	conf := newConfig(2)
+	localStores, remoteStores := placement.SimulatedStores(conf)
+	report, err := placement.Analyze(conf, localStores, remoteStores, placement.Options{Buckets: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failures != 0 {
+		t.Fatalf("unexpected failures %d: %s", report.Failures, report.LastFailure)
+	}
+	if len(report.Stores) != 6 || len(report.Zones) != 5 {
+		t.Fatalf("unexpected stores %v or zones %v", report.Stores, report.Zones)
+	}
+	var share float64
+	for _, s := range report.Stores {
+		share += s.Share
+		if s.Remote != (s.Name[0] == 'r') {
+			t.Errorf("unexpected classification of %s", s.Name)
+		}
+		if s.Remote && s.Sync != 0 || !s.Remote && s.Async != 0 {
+			t.Errorf("unexpected load of %s: %+v", s.Name, s)
+		}
+	}
+	if share < 0.999 || share > 1.001 {
+		t.Fatalf("the shares of the stores add up to %f", share)
+	}
+	// the sync replicas are in distinct zones, and so is the remote one
+	if report.ZoneDiverse != 1 {
+		t.Fatalf("all the buckets are expected to span distinct zones, got %f", report.ZoneDiverse)
+	}
+	if report.ReplicaSets < 2 {
+		t.Fatalf("unexpected number of replica sets %d", report.ReplicaSets)
+	}
+
+	var out bytes.Buffer
+	report.Write(&out)
+	if !strings.Contains(out.String(), "s1") {
+		t.Fatalf("the store s1 is missing in the report:\n%s", out.String())
+	}
+}
+
+func TestAnalyzeExpectedShareByWeight(t *testing.T) {
+	conf := newConfig(2)
+	conf.Stores[0].Weight = 3
+	localStores, remoteStores := placement.SimulatedStores(conf)
+	report, err := placement.Analyze(conf, localStores, remoteStores, placement.Options{Buckets: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// s1 weighs 3 and the 5 other stores 1
+	for _, s := range report.Stores {
+		expected := 1.0 / 8
+		if s.Name == "s1" {
+			expected = 3.0 / 8
+		}
+		if s.Expected < expected-0.0001 || s.Expected > expected+0.0001 {
+			t.Errorf("the expected share of %s is %f instead of %f", s.Name, s.Expected, expected)
+		}
+	}
+}
+
+func TestAnalyzeLackOfZones(t *testing.T) {
+	conf := newConfig(4)
+	localStores, remoteStores := placement.SimulatedStores(conf)
+	report, err := placement.Analyze(conf, localStores, remoteStores, placement.Options{Buckets: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failures != 100 || report.FailureRate != 1 {
+		t.Fatalf("4 local replicas over 3 zones are expected to fail, got %d failures", report.Failures)
+	}
+}
+
+func TestAnalyzeChange(t *testing.T) {
+	conf := newConfig(2)
+	localStores, remoteStores := placement.SimulatedStores(conf)
+	report, err := placement.Analyze(conf, localStores, remoteStores, placement.Options{
+		Buckets:  10000,
+		AddStore: &config.KVStore{AvailabilityZone: "az2", Name: "s5", ArtificialLatencyInMs: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// s5 shares az2 with s3, so only the buckets placed on s3 may move, and about half of them
+	if report.Change == nil || report.Change.Moved == 0 || report.Change.MovedShare > 0.5 {
+		t.Fatalf("unexpected change %+v", report.Change)
+	}
+
+	report, err = placement.Analyze(conf, localStores, remoteStores, placement.Options{Buckets: 10000, RemoveStore: "r2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Change == nil || report.Change.MovedShare < 0.3 || report.Change.MovedShare > 0.7 {
+		t.Fatalf("about half of the buckets are expected to move off r2, got %+v", report.Change)
+	}
+
+	if _, err := placement.Analyze(conf, localStores, remoteStores, placement.Options{Buckets: 10, RemoveStore: "unknown"}); err == nil {
+		t.Fatalf("error is expected when removing an unknown store")
+	}
+}
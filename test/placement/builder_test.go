@@ -0,0 +1,37 @@
+package placement
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/placement"
+)
+
+func TestSyncHashingManagerStable(t *testing.T) {
+	conf := newConfig(2)
+	conf.ConsistentHash = constants.Jump
+	conf.HashingManagerType = constants.Sync
+	localStores, remoteStores := placement.SimulatedStores(conf)
+	nodes := func() []string {
+		hm := placement.NewHashingManager(conf, localStores, remoteStores)
+		res := make([]string, 0)
+		for i := 0; i < 100; i++ {
+			p, err := hm.GetPlacement([]byte(fmt.Sprintf("key-%d", i)))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			res = append(res, fmt.Sprint(p.Nodes()))
+		}
+		return res
+	}
+	first := nodes()
+	// the zones of the stores are walked in a random order, which must not move the keys
+	for build := 0; build < 20; build++ {
+		for i, n := range nodes() {
+			if n != first[i] {
+				t.Fatalf("the key-%d is placed on %s, then on %s", i, first[i], n)
+			}
+		}
+	}
+}
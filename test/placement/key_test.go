@@ -0,0 +1,77 @@
+package placement
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/pkg/placement"
+)
+
+func TestPrefix(t *testing.T) {
+	cases := []struct {
+		key, delimiter string
+		segments       int
+		prefix         string
+	}{
+		{"tenant/user/1", "/", 1, "tenant/"},
+		{"tenant/user/1", "/", 2, "tenant/user/"},
+		{"tenant/user/1", "/", 3, "tenant/user/1"},
+		{"tenant", "/", 1, "tenant"},
+		{"a::b::c", "::", 1, "a::"},
+	}
+	for _, c := range cases {
+		if prefix := placement.Prefix([]byte(c.key), []byte(c.delimiter), c.segments); string(prefix) != c.prefix {
+			t.Errorf("the prefix of %s is expected to be %s, got %s", c.key, c.prefix, prefix)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	conf := &config.KVConfiguration{BucketSize: 10}
+	rev1, rev2 := index.NewRevision(nil, 5, 0, consistent.Placement{}), index.NewRevision(nil, 25, 0, consistent.Placement{})
+	if bytes.Equal(placement.Key(conf, []byte("a/1"), rev1), placement.Key(conf, []byte("a/1"), rev2)) {
+		t.Fatal("the revisions of different buckets are expected to be placed apart in the revision mode")
+	}
+	conf.PlacementMode = constants.KeyPlacement
+	if !bytes.Equal(placement.Key(conf, []byte("a/1"), rev1), placement.Key(conf, []byte("a/1"), rev2)) {
+		t.Fatal("the revisions of a key are expected to be placed together in the key mode")
+	}
+	conf.PlacementMode = constants.PrefixPlacement
+	if !bytes.Equal(placement.Key(conf, []byte("a/1"), rev1), placement.Key(conf, []byte("a/2"), rev2)) {
+		t.Fatal("the keys of a prefix are expected to be placed together in the prefix mode")
+	}
+	conf.PlacementMode = "tenant"
+	if err := placement.ValidateMode(conf); err == nil {
+		t.Fatal("error is expected for an unknown placement mode")
+	}
+}
+
+func TestHistorySpread(t *testing.T) {
+	conf := newConfig(2)
+	localStores, remoteStores := placement.SimulatedStores(conf)
+	opts := placement.Options{Buckets: 1000, Keys: 200, Versions: 10}
+
+	report, err := placement.Analyze(conf, localStores, remoteStores, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.History.Stores <= 3 {
+		t.Fatalf("the history of a key is expected on more than its 3 replicas in the revision mode, got %.2f", report.History.Stores)
+	}
+
+	conf.PlacementMode = constants.KeyPlacement
+	report, err = placement.Analyze(conf, localStores, remoteStores, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.History.Stores != 3 || report.History.ReplicaSets != 1 {
+		t.Fatalf("the history of a key is expected on its 3 replicas in the key mode, got %+v", report.History)
+	}
+	if report.Failures != 0 {
+		t.Fatalf("unexpected failures %d", report.Failures)
+	}
+}
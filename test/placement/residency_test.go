@@ -0,0 +1,59 @@
+package placement
+
+import (
+	"context"
+	"testing"
+
+	"github.com/regionless-storage-service/pkg/config"
+	"github.com/regionless-storage-service/pkg/constants"
+	"github.com/regionless-storage-service/pkg/index"
+	"github.com/regionless-storage-service/pkg/partition/consistent"
+	"github.com/regionless-storage-service/pkg/placement"
+)
+
+func TestAuditResidency(t *testing.T) {
+	conf := &config.KVConfiguration{
+		StoreType: constants.DummyLatency,
+		Stores: []config.KVStore{
+			{AvailabilityZone: constants.US_WEST_1A, Name: "w1"},
+			{AvailabilityZone: constants.US_WEST_2A, Name: "w2"},
+			{AvailabilityZone: constants.US_EAST_1A, Name: "e1"},
+		},
+		PlacementPolicy: consistent.PlacementPolicy{
+			ForbiddenRegions: []constants.Region{constants.US_EAST_1},
+			Residency:        []consistent.ResidencyRule{{Prefix: "tenant-a/", Regions: []constants.Region{constants.US_WEST_1}}},
+		},
+	}
+	ctx := context.Background()
+	indexTree := index.NewTreeIndex()
+	puts := []struct {
+		key         string
+		sync, async []string
+	}{
+		{"tenant-a/1", []string{"w1"}, nil},
+		{"tenant-a/2", []string{"w1"}, []string{"w2"}},
+		{"tenant-a/3", []string{"gone"}, nil},
+		{"tenant-b/1", []string{"w1", "w2"}, []string{"gone"}},
+		{"tenant-b/2", []string{"w2"}, []string{"e1"}},
+	}
+	for i, p := range puts {
+		if err := indexTree.Put(ctx, []byte(p.key), index.NewRevision(indexTree.Placements(), int64(i+1), 0, consistent.NamedPlacement(p.sync, p.async))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	audit := placement.AuditResidency(ctx, conf, indexTree)
+	if audit.Revisions != 5 || audit.Pinned != 3 {
+		t.Fatalf("unexpected number of revisions %d or pinned ones %d", audit.Revisions, audit.Pinned)
+	}
+	// w2 is outside the region of tenant-a, a removed store cannot prove it is inside, and us-east-1 is forbidden
+	expected := map[string]string{"tenant-a/2": "w2", "tenant-a/3": "gone", "tenant-b/2": "e1"}
+	if len(audit.Violations) != len(expected) {
+		t.Fatalf("unexpected violations %+v", audit.Violations)
+	}
+	for _, v := range audit.Violations {
+		if expected[v.Key] != v.Node {
+			t.Fatalf("unexpected violation %+v", v)
+		}
+	}
+}
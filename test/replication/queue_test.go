@@ -0,0 +1,176 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/regionless-storage-service/pkg/database"
+	"github.com/regionless-storage-service/pkg/replication"
+	"github.com/regionless-storage-service/test/mock"
+)
+
+// flakyDatabase fails the first failures operations before passing them to the backend
+type flakyDatabase struct {
+	database.Database
+	mu       sync.Mutex
+	failures int
+}
+
+func (f *flakyDatabase) Put(key, value string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return "", errors.New("store unavailable")
+	}
+	return f.Database.Put(key, value)
+}
+
+func waitDrained(t *testing.T, m *replication.Manager) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Wait(ctx); err != nil {
+		t.Fatalf("the queues are not drained: %v", err)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	db := mock.NewMockDatabase()
+	m, err := replication.NewManager(replication.Options{}, func(name string) (database.Database, error) {
+		return db, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer m.Close()
+	if err := m.Enqueue("remote", replication.OpPut, "1", "v1"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	waitDrained(t, m)
+	if v, err := db.Get("1"); err != nil || v != "v1" {
+		t.Fatalf("The replicated value shouldn't be %s with error %v", v, err)
+	}
+	stats := m.Stats()
+	if len(stats) != 1 || stats[0].Replicated != 1 || stats[0].Pending != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	db := &flakyDatabase{Database: mock.NewMockDatabase(), failures: 3}
+	opts := replication.Options{InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	m, err := replication.NewManager(opts, func(name string) (database.Database, error) {
+		return db, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer m.Close()
+	m.Enqueue("remote", replication.OpPut, "1", "v1")
+	m.Enqueue("remote", replication.OpPut, "1", "v2")
+	waitDrained(t, m)
+	if v, _ := db.Get("1"); v != "v2" {
+		t.Fatalf("The writes are not applied in order. The value is %s", v)
+	}
+	if stats := m.Stats(); stats[0].Retries != 3 {
+		t.Fatalf("There should be 3 retries instead of %d", stats[0].Retries)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	opts := replication.Options{MaxPending: 1, InitialBackoff: time.Second}
+	m, err := replication.NewManager(opts, func(name string) (database.Database, error) {
+		return nil, errors.New("store unavailable")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer m.Close()
+	if err := m.Enqueue("remote", replication.OpPut, "1", "v1"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := m.Enqueue("remote", replication.OpPut, "2", "v2"); err != replication.ErrQueueFull {
+		t.Fatalf("The queue should be full instead of %v", err)
+	}
+	if err := m.Enqueue("other", replication.OpPut, "2", "v2"); err != nil {
+		t.Fatalf("The limit should be per destination: %v", err)
+	}
+}
+
+func TestRecoverAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	opts := replication.Options{Dir: dir, InitialBackoff: time.Second}
+	down, err := replication.NewManager(opts, func(name string) (database.Database, error) {
+		return nil, errors.New("store unavailable")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	down.Enqueue("127.0.0.1:6379", replication.OpPut, "1", "v1")
+	down.Enqueue("127.0.0.1:6379", replication.OpPut, "2", "v2")
+	down.Enqueue("127.0.0.1:6379", replication.OpDelete, "1", "")
+	if err := down.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	db := mock.NewMockDatabase()
+	up, err := replication.NewManager(opts, func(name string) (database.Database, error) {
+		if name != "127.0.0.1:6379" {
+			// the resolver runs on the worker of the queue, off the test goroutine
+			t.Errorf("unexpected destination %s", name)
+			return nil, errors.New("unexpected destination")
+		}
+		return db, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer up.Close()
+	waitDrained(t, up)
+	if _, err := db.Get("1"); err == nil {
+		t.Fatal("The key 1 should have been deleted after the replay")
+	}
+	if v, err := db.Get("2"); err != nil || v != "v2" {
+		t.Fatalf("The replayed value shouldn't be %s with error %v", v, err)
+	}
+	if stats := up.Stats(); stats[0].Replicated != 3 {
+		t.Fatalf("There should be 3 replicated entries instead of %d", stats[0].Replicated)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	dir := t.TempDir()
+	opts := replication.Options{Dir: dir, InitialBackoff: time.Second}
+	m, err := replication.NewManager(opts, func(name string) (database.Database, error) {
+		return nil, errors.New("store unavailable")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	m.Enqueue("removed", replication.OpPut, "1", "v1")
+	m.Enqueue("kept", replication.OpPut, "1", "v1")
+	if err := m.Remove("removed"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if stats := m.Stats(); len(stats) != 1 || stats[0].Destination != "kept" {
+		t.Fatalf("The backlog of the removed store should be dropped: %+v", stats)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the queue file of the removed store is not replayed on the next start
+	restarted, err := replication.NewManager(opts, func(name string) (database.Database, error) {
+		return nil, errors.New("store unavailable")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer restarted.Close()
+	if stats := restarted.Stats(); len(stats) != 1 || stats[0].Destination != "kept" || stats[0].Pending != 1 {
+		t.Fatalf("Only the backlog of the kept store should be replayed: %+v", stats)
+	}
+}